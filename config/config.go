@@ -1,28 +1,416 @@
 package config
 
 import (
+	"fmt"
 	"log"
 	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"wordpress-collab-tool/models"
 )
 
 type Config struct {
 	SSHUser     string
 	SSHHost     string
+	SSHPort     int
 	SSHPassword string
+
+	// SSHAuthMethod is one of "password", "key", "agent" or "certificate".
+	SSHAuthMethod      string
+	SSHPrivateKeyPath  string
+	SSHKeyPassphrase   string
+	SSHCertificatePath string
+	// DefaultHostCapacity is the number of sites the default host can hold.
+	DefaultHostCapacity int
+
+	// SSH connection pool settings.
+	SSHMaxSessions       int
+	SSHKeepaliveInterval time.Duration
+	SSHIdleTimeout       time.Duration
+
+	// SSH host key verification settings.
+	SSHKnownHostsPath string
+	SSHHostKeyMode    string // "strict" or "tofu"
+
+	// Timeouts bounds how long remote operations may run.
+	Timeouts Timeouts
+
+	// DeployLogLines is how many lines of deployment output are kept per site.
+	DeployLogLines int
+
+	// SiteLockMode decides what happens to an operation on a site that is
+	// busy with another one: "reject" answers 409 Conflict, "queue" waits.
+	SiteLockMode string
+
+	// JobsFile is where background jobs are recorded. Finished jobs are
+	// pruned beyond the newest JobsMaxFinished and after JobsRetention.
+	JobsFile        string
+	JobsMaxFinished int
+	JobsRetention   time.Duration
+
+	// SiteStore selects where sites are kept: "sqlite" (the default) or "json".
+	SiteStore string
+	// DatabasePath is the SQLite database file used by the sqlite stores.
+	DatabasePath string
+	// SitesFile is the file used by the json store, and the one imported
+	// into SQLite the first time the sqlite store is opened.
+	SitesFile string
+
+	// SecretsKey is the base64 master key that encrypts site secrets.
+	// SecretsPreviousKeys are older keys still accepted for decryption
+	// after a rotation. When SecretsKey is empty the keys are read from
+	// SecretsKeyFile, which is generated if it does not exist.
+	SecretsKey          string
+	SecretsPreviousKeys []string
+	SecretsKeyFile      string
+
+	// ActivitiesFile is the legacy activity log imported into the database.
+	ActivitiesFile string
+	// ActivityRetention and ActivityMaxEntries bound how long and how many
+	// activities are kept.
+	ActivityRetention  time.Duration
+	ActivityMaxEntries int
+	// ActivityDedupWindow is how long an activity keeps absorbing identical
+	// repeats into its count.
+	ActivityDedupWindow time.Duration
+
+	// JWTSecret signs the API tokens. When it is empty the key is read from
+	// JWTKeyFile, which is generated if it does not exist.
+	JWTSecret  string
+	JWTKeyFile string
+	// AdminUsername and AdminPassword create the first admin account when
+	// no user exists yet.
+	AdminUsername string
+	AdminPassword string
+	// LoginMaxAttempts consecutive failed sign-ins lock an account for
+	// LoginLockout. Zero disables the lockout.
+	LoginMaxAttempts int
+	LoginLockout     time.Duration
+	// Failed sign-ins from one client IP or for one username beyond
+	// LoginIPAttempts and LoginUserAttempts are answered with a delay that
+	// doubles from LoginBackoffBase up to LoginBackoffMax. Failures are
+	// forgotten after LoginThrottleWindow without any. Zero attempts
+	// disables that limit.
+	LoginIPAttempts     int
+	LoginUserAttempts   int
+	LoginBackoffBase    time.Duration
+	LoginBackoffMax     time.Duration
+	LoginThrottleWindow time.Duration
+	// TrustedProxies lists the addresses or CIDR ranges of the reverse
+	// proxies whose X-Forwarded-For header names the client. Requests from
+	// anywhere else are attributed to their remote address.
+	TrustedProxies []string
+	// AccessTokenTTL is how long an API token is valid. Clients renew it
+	// with a refresh token, which expires when it has not been used for
+	// RefreshTokenTTL.
+	AccessTokenTTL  time.Duration
+	RefreshTokenTTL time.Duration
+	// TOTPIssuer names the tool in authenticator apps.
+	TOTPIssuer string
+
+	// OIDCIssuer enables single sign-on with an OpenID Connect provider.
+	// OIDCRedirectURL is the address of the callback route as the browser
+	// reaches it, registered with the provider.
+	OIDCIssuer       string
+	OIDCClientID     string
+	OIDCClientSecret string
+	OIDCRedirectURL  string
+	OIDCScopes       []string
+	// OIDCUsernameClaim and OIDCGroupsClaim name the ID token claims that
+	// hold the account name and the user's groups.
+	OIDCUsernameClaim string
+	OIDCGroupsClaim   string
+	// OIDCGroupRoles maps provider groups to roles; users get the highest
+	// role among their groups, or OIDCDefaultRole when none matches. An
+	// empty default refuses them.
+	OIDCGroupRoles  map[string]string
+	OIDCDefaultRole string
+	// OIDCPostLoginURL is the frontend page the callback redirects to. When
+	// unset, the callback answers with the tokens itself.
+	OIDCPostLoginURL string
+}
+
+// Timeouts holds the per-operation time limits for remote work.
+type Timeouts struct {
+	// Dial covers the TCP connection and the SSH handshake.
+	Dial time.Duration
+	// Command applies to short operations such as plugin actions, restarts and stats.
+	Command time.Duration
+	// StatusCheck applies to the HTTP probe that reports whether a site is up.
+	StatusCheck time.Duration
+	// HealthCheck is how long a deployment waits for its containers to become healthy.
+	HealthCheck time.Duration
+	Deploy      time.Duration
+	Backup      time.Duration
+	Restore     time.Duration
+	// Delete covers removing a site's containers, volumes and files.
+	Delete time.Duration
+}
+
+// DefaultTimeouts returns the timeouts used when none are configured.
+func DefaultTimeouts() Timeouts {
+	return Timeouts{
+		Dial:        15 * time.Second,
+		Command:     2 * time.Minute,
+		StatusCheck: 10 * time.Second,
+		HealthCheck: 2 * time.Minute,
+		Deploy:      20 * time.Minute,
+		Backup:      30 * time.Minute,
+		Restore:     30 * time.Minute,
+		Delete:      10 * time.Minute,
+	}
 }
 
 func LoadConfig() *Config {
 	sshUser := os.Getenv("SSH_USER")
 	sshHost := os.Getenv("SSH_HOST")
 	sshPassword := os.Getenv("SSH_PASSWORD")
+	sshKey := os.Getenv("SSH_PRIVATE_KEY")
+	sshCert := os.Getenv("SSH_CERTIFICATE")
 
-	if sshUser == "" || sshHost == "" || sshPassword == "" {
-		log.Fatal("SSH_USER, SSH_HOST, and SSH_PASSWORD environment variables must be set")
+	authMethod := os.Getenv("SSH_AUTH_METHOD")
+	if authMethod == "" {
+		authMethod = defaultAuthMethod(sshPassword, sshKey, sshCert)
+	}
+
+	// SSH_HOST configures the "default" host. It is optional once hosts are
+	// managed through the host registry.
+	if sshHost != "" {
+		if sshUser == "" {
+			log.Fatal("SSH_USER must be set when SSH_HOST is set")
+		}
+		if err := validateAuthMethod(authMethod, sshPassword, sshKey, sshCert); err != "" {
+			log.Fatal(err)
+		}
 	}
 
 	return &Config{
 		SSHUser:     sshUser,
 		SSHHost:     sshHost,
+		SSHPort:     getEnvInt("SSH_PORT", 22),
 		SSHPassword: sshPassword,
+
+		SSHAuthMethod:       authMethod,
+		SSHPrivateKeyPath:   sshKey,
+		SSHKeyPassphrase:    os.Getenv("SSH_PRIVATE_KEY_PASSPHRASE"),
+		SSHCertificatePath:  sshCert,
+		DefaultHostCapacity: getEnvInt("SSH_HOST_CAPACITY", 10),
+
+		SSHMaxSessions:       getEnvInt("SSH_MAX_SESSIONS", 4),
+		SSHKeepaliveInterval: getEnvDuration("SSH_KEEPALIVE_INTERVAL", 30*time.Second),
+		SSHIdleTimeout:       getEnvDuration("SSH_IDLE_TIMEOUT", 10*time.Minute),
+
+		SSHKnownHostsPath: getEnv("SSH_KNOWN_HOSTS", "known_hosts"),
+		SSHHostKeyMode:    getEnv("SSH_HOST_KEY_MODE", "strict"),
+
+		Timeouts: loadTimeouts(),
+
+		DeployLogLines: getEnvInt("DEPLOY_LOG_LINES", 500),
+
+		SiteLockMode: getEnv("SITE_LOCK_MODE", "reject"),
+
+		JobsFile:        getEnv("JOBS_FILE", "jobs.json"),
+		JobsMaxFinished: getEnvInt("JOBS_MAX_FINISHED", 500),
+		JobsRetention:   getEnvDuration("JOBS_RETENTION", 30*24*time.Hour),
+
+		SiteStore:    getEnv("SITE_STORE", "sqlite"),
+		DatabasePath: getEnv("DATABASE_PATH", "data.db"),
+		SitesFile:    getEnv("SITES_FILE", "sites.json"),
+
+		SecretsKey:          os.Getenv("SECRETS_KEY"),
+		SecretsPreviousKeys: getEnvList("SECRETS_PREVIOUS_KEYS"),
+		SecretsKeyFile:      getEnv("SECRETS_KEY_FILE", "secrets.key"),
+
+		ActivitiesFile:      getEnv("ACTIVITIES_FILE", "activities.json"),
+		ActivityRetention:   getEnvDuration("ACTIVITY_RETENTION", 90*24*time.Hour),
+		ActivityMaxEntries:  getEnvInt("ACTIVITY_MAX_ENTRIES", 100000),
+		ActivityDedupWindow: getEnvDuration("ACTIVITY_DEDUP_WINDOW", 10*time.Minute),
+
+		JWTSecret:        os.Getenv("JWT_SECRET"),
+		JWTKeyFile:       getEnv("JWT_KEY_FILE", "jwt.key"),
+		AdminUsername:    getEnv("ADMIN_USERNAME", "admin"),
+		AdminPassword:    os.Getenv("ADMIN_PASSWORD"),
+		LoginMaxAttempts: getEnvLimit("LOGIN_MAX_ATTEMPTS", 5),
+		LoginLockout:     getEnvDuration("LOGIN_LOCKOUT_DURATION", 15*time.Minute),
+
+		LoginIPAttempts:     getEnvLimit("LOGIN_IP_ATTEMPTS", 20),
+		LoginUserAttempts:   getEnvLimit("LOGIN_USER_ATTEMPTS", 3),
+		LoginBackoffBase:    getEnvDuration("LOGIN_BACKOFF_BASE", time.Second),
+		LoginBackoffMax:     getEnvDuration("LOGIN_BACKOFF_MAX", 15*time.Minute),
+		LoginThrottleWindow: getEnvDuration("LOGIN_THROTTLE_WINDOW", time.Hour),
+
+		TrustedProxies: getEnvList("TRUSTED_PROXIES"),
+
+		AccessTokenTTL:  getEnvDuration("ACCESS_TOKEN_TTL", 15*time.Minute),
+		RefreshTokenTTL: getEnvDuration("REFRESH_TOKEN_TTL", 30*24*time.Hour),
+		TOTPIssuer:      getEnv("TOTP_ISSUER", "WordPress Collaboration Tool"),
+
+		OIDCIssuer:        os.Getenv("OIDC_ISSUER"),
+		OIDCClientID:      os.Getenv("OIDC_CLIENT_ID"),
+		OIDCClientSecret:  os.Getenv("OIDC_CLIENT_SECRET"),
+		OIDCRedirectURL:   os.Getenv("OIDC_REDIRECT_URL"),
+		OIDCScopes:        getEnvListDefault("OIDC_SCOPES", []string{"openid", "profile", "email"}),
+		OIDCUsernameClaim: getEnv("OIDC_USERNAME_CLAIM", "preferred_username"),
+		OIDCGroupsClaim:   getEnv("OIDC_GROUPS_CLAIM", "groups"),
+		OIDCGroupRoles:    getEnvMap("OIDC_GROUP_ROLES"),
+		OIDCDefaultRole:   os.Getenv("OIDC_DEFAULT_ROLE"),
+		OIDCPostLoginURL:  os.Getenv("OIDC_POST_LOGIN_URL"),
+	}
+}
+
+// loadTimeouts reads the operation timeouts, keeping the default for any that are unset.
+func loadTimeouts() Timeouts {
+	def := DefaultTimeouts()
+	return Timeouts{
+		Dial:        getEnvDuration("SSH_DIAL_TIMEOUT", def.Dial),
+		Command:     getEnvDuration("COMMAND_TIMEOUT", def.Command),
+		StatusCheck: getEnvDuration("STATUS_CHECK_TIMEOUT", def.StatusCheck),
+		HealthCheck: getEnvDuration("HEALTH_CHECK_TIMEOUT", def.HealthCheck),
+		Deploy:      getEnvDuration("DEPLOY_TIMEOUT", def.Deploy),
+		Backup:      getEnvDuration("BACKUP_TIMEOUT", def.Backup),
+		Restore:     getEnvDuration("RESTORE_TIMEOUT", def.Restore),
+		Delete:      getEnvDuration("DELETE_TIMEOUT", def.Delete),
+	}
+}
+
+// HasDefaultHost reports whether a default VPS is configured through the environment.
+func (c *Config) HasDefaultHost() bool {
+	return c.SSHHost != ""
+}
+
+// SSH returns the credentials for the VPS configured through the environment.
+func (c *Config) SSH() models.SSHConfig {
+	return models.SSHConfig{
+		User:            c.SSHUser,
+		Host:            c.SSHHost,
+		Port:            c.SSHPort,
+		AuthMethod:      c.SSHAuthMethod,
+		Password:        c.SSHPassword,
+		PrivateKeyPath:  c.SSHPrivateKeyPath,
+		Passphrase:      c.SSHKeyPassphrase,
+		CertificatePath: c.SSHCertificatePath,
+	}
+}
+
+// defaultAuthMethod picks an authentication method from the credentials that are set.
+func defaultAuthMethod(password, key, cert string) string {
+	switch {
+	case cert != "":
+		return models.SSHAuthCertificate
+	case key != "":
+		return models.SSHAuthKey
+	case password != "":
+		return models.SSHAuthPassword
+	default:
+		return models.SSHAuthAgent
+	}
+}
+
+// validateAuthMethod returns a message describing what is missing for method, or "" if it is usable.
+func validateAuthMethod(method, password, key, cert string) string {
+	switch method {
+	case models.SSHAuthPassword:
+		if password == "" {
+			return "SSH_PASSWORD must be set when SSH_AUTH_METHOD is \"password\""
+		}
+	case models.SSHAuthKey:
+		if key == "" {
+			return "SSH_PRIVATE_KEY must be set when SSH_AUTH_METHOD is \"key\""
+		}
+	case models.SSHAuthCertificate:
+		if key == "" || cert == "" {
+			return "SSH_PRIVATE_KEY and SSH_CERTIFICATE must be set when SSH_AUTH_METHOD is \"certificate\""
+		}
+	case models.SSHAuthAgent:
+		if os.Getenv("SSH_AUTH_SOCK") == "" {
+			return "SSH_AUTH_SOCK must be set to use ssh-agent authentication (or set SSH_PASSWORD or SSH_PRIVATE_KEY)"
+		}
+	default:
+		return fmt.Sprintf("unknown SSH_AUTH_METHOD %q (expected password, key, agent or certificate)", method)
+	}
+	return ""
+}
+
+// getEnv reads an environment variable, falling back to def when it is unset.
+func getEnv(key, def string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return def
+}
+
+// getEnvList reads a comma-separated environment variable, skipping empty entries.
+func getEnvList(key string) []string {
+	var values []string
+	for _, value := range strings.Split(os.Getenv(key), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// getEnvListDefault reads a comma-separated environment variable, falling back to def when it is unset.
+func getEnvListDefault(key string, def []string) []string {
+	if values := getEnvList(key); len(values) > 0 {
+		return values
+	}
+	return def
+}
+
+// getEnvMap reads a comma-separated list of key=value pairs, skipping entries without a key.
+func getEnvMap(key string) map[string]string {
+	values := make(map[string]string)
+	for _, entry := range getEnvList(key) {
+		k, v, _ := strings.Cut(entry, "=")
+		if k = strings.TrimSpace(k); k != "" {
+			values[k] = strings.TrimSpace(v)
+		}
+	}
+	return values
+}
+
+// getEnvInt reads an integer environment variable, falling back to def when it is unset or invalid.
+func getEnvInt(key string, def int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 {
+		log.Printf("WARNING: invalid value %q for %s, using default %d", value, key, def)
+		return def
+	}
+	return n
+}
+
+// getEnvLimit reads a limit from an integer environment variable where zero
+// disables the limit, falling back to def when it is unset, negative or invalid.
+func getEnvLimit(key string, def int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		log.Printf("WARNING: invalid value %q for %s, using default %d", value, key, def)
+		return def
+	}
+	return n
+}
+
+// getEnvDuration reads a duration environment variable (e.g. "30s"), falling back to def when it is unset or invalid.
+func getEnvDuration(key string, def time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		log.Printf("WARNING: invalid value %q for %s, using default %s", value, key, def)
+		return def
 	}
+	return d
 }
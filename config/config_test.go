@@ -0,0 +1,31 @@
+package config
+
+import "testing"
+
+func TestGetEnvLimitAcceptsZero(t *testing.T) {
+	for _, tc := range []struct {
+		value string
+		want  int
+	}{
+		{"", 5},
+		{"0", 0},
+		{"8", 8},
+		{"-1", 5},
+		{"many", 5},
+	} {
+		t.Setenv("TEST_LIMIT", tc.value)
+		if got := getEnvLimit("TEST_LIMIT", 5); got != tc.want {
+			t.Errorf("%q: expected %d, got %d", tc.value, tc.want, got)
+		}
+	}
+}
+
+func TestLoginLimitsZeroDisables(t *testing.T) {
+	for _, key := range []string{"LOGIN_MAX_ATTEMPTS", "LOGIN_IP_ATTEMPTS", "LOGIN_USER_ATTEMPTS"} {
+		t.Setenv(key, "0")
+	}
+	cfg := LoadConfig()
+	if cfg.LoginMaxAttempts != 0 || cfg.LoginIPAttempts != 0 || cfg.LoginUserAttempts != 0 {
+		t.Errorf("expected zero limits to be kept, got %d, %d and %d", cfg.LoginMaxAttempts, cfg.LoginIPAttempts, cfg.LoginUserAttempts)
+	}
+}
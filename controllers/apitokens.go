@@ -0,0 +1,113 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+	"wordpress-collab-tool/models"
+	"wordpress-collab-tool/services"
+	"wordpress-collab-tool/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ListOwnAPITokens lists the personal access tokens of the signed-in user.
+// Secrets are never included.
+func ListOwnAPITokens(c *gin.Context) {
+	listAPITokens(c, c.GetString("username"))
+}
+
+// CreateOwnAPIToken issues a personal access token for the signed-in user
+// with a name, the scopes it may use and an optional expiry. The response
+// is the only place the token's secret appears.
+func CreateOwnAPIToken(c *gin.Context) {
+	var payload struct {
+		Name      string     `json:"name" binding:"required"`
+		Scopes    []string   `json:"scopes" binding:"required"`
+		ExpiresAt *time.Time `json:"expiresAt"`
+	}
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request payload. 'name' and 'scopes' are required."})
+		return
+	}
+
+	user, err := services.Users.Get(c.GetString("username"))
+	if err != nil {
+		respondAPITokenError(c, err, "Failed to retrieve user.")
+		return
+	}
+	token, secret, err := services.CreateAPIToken(user, payload.Name, payload.Scopes, payload.ExpiresAt)
+	if err != nil {
+		respondAPITokenError(c, err, "Failed to create token.")
+		return
+	}
+
+	services.RecordActivity(c.Request.Context(), models.Activity{
+		Level:   "security",
+		Action:  "token.create",
+		Message: fmt.Sprintf("User '%s' created personal access token '%s' (%s) with scopes %s.", user.Username, token.Name, token.Prefix, strings.Join(token.Scopes, ", ")),
+		After:   services.AuditDetails(token),
+	})
+	c.JSON(http.StatusCreated, gin.H{"message": "Token created. Copy it now; it will not be shown again.", "token": secret, "apiToken": token})
+}
+
+// RevokeOwnAPIToken deletes one of the signed-in user's tokens.
+func RevokeOwnAPIToken(c *gin.Context) {
+	revokeAPIToken(c, c.GetString("username"))
+}
+
+// ListUserAPITokens lists the personal access tokens of any user.
+func ListUserAPITokens(c *gin.Context) {
+	username := c.Param("username")
+	if _, err := services.Users.Get(username); err != nil {
+		respondAPITokenError(c, err, "Failed to retrieve user.")
+		return
+	}
+	listAPITokens(c, username)
+}
+
+// RevokeUserAPIToken deletes a token of any user.
+func RevokeUserAPIToken(c *gin.Context) {
+	revokeAPIToken(c, c.Param("username"))
+}
+
+func listAPITokens(c *gin.Context, username string) {
+	tokens, err := services.ListAPITokens(username)
+	if err != nil {
+		respondAPITokenError(c, err, "Failed to retrieve tokens.")
+		return
+	}
+	c.JSON(http.StatusOK, tokens)
+}
+
+func revokeAPIToken(c *gin.Context, username string) {
+	token, err := services.RevokeAPIToken(username, c.Param("id"))
+	if err != nil {
+		respondAPITokenError(c, err, "Failed to revoke token.")
+		return
+	}
+	services.RecordActivity(c.Request.Context(), models.Activity{
+		Level:   "security",
+		Action:  "token.revoke",
+		Message: fmt.Sprintf("Personal access token '%s' (%s) of user '%s' revoked.", token.Name, token.Prefix, username),
+		Before:  services.AuditDetails(token),
+	})
+	c.JSON(http.StatusOK, gin.H{"message": "Token revoked successfully!"})
+}
+
+// respondAPITokenError maps personal access token errors to HTTP responses.
+func respondAPITokenError(c *gin.Context, err error, fallback string) {
+	switch {
+	case errors.Is(err, services.ErrAPITokenNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Token not found."})
+	case errors.Is(err, services.ErrUserNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found."})
+	case errors.Is(err, services.ErrInvalidInput):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input.", "details": err.Error()})
+	default:
+		utils.LogError("%s %v", fallback, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fallback})
+	}
+}
@@ -0,0 +1,80 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"wordpress-collab-tool/internal/fakes"
+	"wordpress-collab-tool/models"
+	"wordpress-collab-tool/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestPersonalAccessTokens(t *testing.T) {
+	setupSite(t, fakes.NewExecutor())
+	session := withUser(t, "ci", services.RoleEditor)
+	services.UpdateSite("blog", func(s *models.Site) { s.Owner = "ci" })
+
+	router := gin.New()
+	api := router.Group("/api", AuthMiddleware())
+	api.GET("/sites/:projectName/plugins", RequirePermission(services.PermPluginsRead), GetSitePlugins)
+	api.POST("/sites/:projectName/plugins/:pluginName", RequirePermission(services.PermPluginsWrite), InstallPlugin)
+	api.GET("/me/permissions", GetCurrentPermissions)
+	api.GET("/me/tokens", RequireSession(), ListOwnAPITokens)
+	api.POST("/me/tokens", RequireSession(), CreateOwnAPIToken)
+	api.DELETE("/me/tokens/:id", RequireSession(), RevokeOwnAPIToken)
+
+	// Tokens cannot be granted more than the user's role allows
+	w := serveJSON(router, http.MethodPost, "/api/me/tokens", session, gin.H{"name": "deploy", "scopes": []string{"sites:delete"}})
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("scope beyond the role: expected 400, got %d", w.Code)
+	}
+	w = serveJSON(router, http.MethodPost, "/api/me/tokens", session, gin.H{"name": "deploy", "scopes": []string{"plugins:read"}, "expiresAt": time.Now().Add(-time.Hour)})
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expiry in the past: expected 400, got %d", w.Code)
+	}
+
+	w = serveJSON(router, http.MethodPost, "/api/me/tokens", session, gin.H{"name": "deploy", "scopes": []string{"plugins:read"}, "expiresAt": time.Now().Add(24 * time.Hour)})
+	var created struct {
+		Token    string          `json:"token"`
+		APIToken models.APIToken `json:"apiToken"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &created)
+	if w.Code != http.StatusCreated || created.Token == "" || created.APIToken.ExpiresAt == nil {
+		t.Fatalf("create token: %d %s", w.Code, w.Body.String())
+	}
+	pat := created.Token
+
+	// The token acts for its user within its scopes only
+	if w := serveJSON(router, http.MethodGet, "/api/sites/blog/plugins", pat, nil); w.Code == http.StatusForbidden || w.Code == http.StatusUnauthorized {
+		t.Errorf("scoped read: %d %s", w.Code, w.Body.String())
+	}
+	if w := serveJSON(router, http.MethodPost, "/api/sites/blog/plugins/akismet", pat, nil); w.Code != http.StatusForbidden {
+		t.Errorf("write outside the scopes: expected 403, got %d", w.Code)
+	}
+	if w := serveJSON(router, http.MethodGet, "/api/me/permissions", pat, nil); !bytes.Contains(w.Body.Bytes(), []byte(`"permissions":["plugins:read"]`)) {
+		t.Errorf("token permissions: %s", w.Body.String())
+	}
+	if w := serveJSON(router, http.MethodPost, "/api/me/tokens", pat, gin.H{"name": "more", "scopes": []string{"plugins:read"}}); w.Code != http.StatusForbidden {
+		t.Errorf("creating a token with a token: expected 403, got %d", w.Code)
+	}
+
+	// Listings show when the token was used, never its secret
+	w = serveJSON(router, http.MethodGet, "/api/me/tokens", session, nil)
+	var tokens []models.APIToken
+	json.Unmarshal(w.Body.Bytes(), &tokens)
+	if len(tokens) != 1 || tokens[0].LastUsedAt == nil || bytes.Contains(w.Body.Bytes(), []byte(pat)) {
+		t.Fatalf("list tokens: %s", w.Body.String())
+	}
+
+	if w := serveJSON(router, http.MethodDelete, "/api/me/tokens/"+tokens[0].ID, session, nil); w.Code != http.StatusOK {
+		t.Fatalf("revoke token: %d %s", w.Code, w.Body.String())
+	}
+	if w := serveJSON(router, http.MethodGet, "/api/sites/blog/plugins", pat, nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("revoked token: expected 401, got %d", w.Code)
+	}
+}
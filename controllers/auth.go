@@ -0,0 +1,428 @@
+package controllers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"wordpress-collab-tool/models"
+	"wordpress-collab-tool/services"
+	"wordpress-collab-tool/utils"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+)
+
+// Login checks the credentials of a user and starts a session, answering
+// with a short-lived access token and the refresh token that renews it.
+// Users with two-factor authentication get a challenge instead, which
+// LoginTwoFactor completes.
+func Login(c *gin.Context) {
+	var credentials models.Credentials
+	if err := c.ShouldBindJSON(&credentials); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Sign-in activities are attributed to the account being signed in to
+	ctx := c.Request.Context()
+	info := services.AuditFrom(ctx)
+	info.Actor = credentials.Username
+	ctx = services.WithAudit(ctx, info)
+
+	user, err := services.Authenticate(ctx, credentials.Username, credentials.Password)
+	var locked *services.UserLockedError
+	var throttled *services.LoginThrottledError
+	switch {
+	case errors.As(err, &throttled):
+		respondTooManySignIns(c, gin.H{"retryAt": throttled.Until}, throttled.Until)
+		return
+	case errors.As(err, &locked):
+		services.LogActivityContext(ctx, "warning", "user.login", fmt.Sprintf("Sign-in to locked user '%s' refused.", credentials.Username), "")
+		respondTooManySignIns(c, gin.H{"retryAt": locked.Until}, locked.Until)
+		return
+	case errors.Is(err, services.ErrInvalidCredentials):
+		services.LogActivityContext(ctx, "warning", "user.login", fmt.Sprintf("Failed sign-in as '%s'.", credentials.Username), "")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials."})
+		return
+	case errors.Is(err, services.ErrUserDisabled):
+		services.LogActivityContext(ctx, "warning", "user.login", fmt.Sprintf("Sign-in to disabled user '%s' refused.", credentials.Username), "")
+		c.JSON(http.StatusForbidden, gin.H{"error": "Account is disabled."})
+		return
+	case err != nil:
+		utils.LogError("Failed to authenticate '%s': %v", credentials.Username, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not sign in."})
+		return
+	}
+
+	if user.TwoFactorEnabled {
+		challenge, err := services.StartLoginChallenge(user)
+		if err != nil {
+			utils.LogError("Failed to start a login challenge for '%s': %v", user.Username, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not sign in."})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"message":           "Enter the code from your authenticator app or a recovery code.",
+			"twoFactorRequired": true,
+			"challenge":         challenge.ID,
+			"expiresAt":         challenge.ExpiresAt,
+		})
+		return
+	}
+
+	tokens, err := services.StartSession(ctx, user)
+	if err != nil {
+		utils.LogError("Failed to start a session for '%s': %v", user.Username, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not generate token."})
+		return
+	}
+	services.LogActivityContext(ctx, "info", "user.login", fmt.Sprintf("User '%s' signed in.", user.Username), "")
+	c.JSON(http.StatusOK, tokenResponse("Login successful!", tokens))
+}
+
+// RefreshToken exchanges a refresh token for a new access token and a new
+// refresh token. Each refresh token works once: presenting a used one ends
+// the session it belongs to.
+func RefreshToken(c *gin.Context) {
+	var payload struct {
+		RefreshToken string `json:"refreshToken" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request payload. 'refreshToken' is required."})
+		return
+	}
+
+	ctx := c.Request.Context()
+	tokens, session, err := services.RefreshSession(ctx, payload.RefreshToken)
+	if session.Username != "" {
+		info := services.AuditFrom(ctx)
+		info.Actor = session.Username
+		ctx = services.WithAudit(ctx, info)
+	}
+	switch {
+	case errors.Is(err, services.ErrRefreshTokenReused):
+		services.LogActivityContext(ctx, "security", "session.reuse", fmt.Sprintf("A used refresh token of user '%s' was presented again; session %s ended.", session.Username, session.ID), "")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid refresh token."})
+		return
+	case errors.Is(err, services.ErrSessionNotFound), errors.Is(err, services.ErrSessionRevoked):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid refresh token."})
+		return
+	case err != nil:
+		utils.LogError("Failed to refresh a session: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not generate token."})
+		return
+	}
+	c.JSON(http.StatusOK, tokenResponse("Token refreshed.", tokens))
+}
+
+func tokenResponse(message string, tokens services.SessionTokens) gin.H {
+	return gin.H{
+		"message":          message,
+		"token":            tokens.Token,
+		"expiresAt":        tokens.ExpiresAt,
+		"refreshToken":     tokens.RefreshToken,
+		"refreshExpiresAt": tokens.RefreshExpiresAt,
+		"sessionId":        tokens.SessionID,
+	}
+}
+
+// respondTooManySignIns answers a sign-in that has to wait until the given
+// time, with the details of why.
+func respondTooManySignIns(c *gin.Context, details gin.H, until time.Time) {
+	c.Header("Retry-After", strconv.Itoa(int(time.Until(until).Seconds())+1))
+	details["error"] = "Too many failed sign-ins. Try again later."
+	c.JSON(http.StatusTooManyRequests, details)
+}
+
+// Logout ends the session of the access token in the Authorization header,
+// which stops working at once. Clients whose access token expired can send
+// their refresh token instead. Logging out of a session that already ended
+// succeeds.
+func Logout(c *gin.Context) {
+	var payload struct {
+		RefreshToken string `json:"refreshToken"`
+	}
+	// The body is optional
+	_ = c.ShouldBindJSON(&payload)
+
+	ctx := c.Request.Context()
+	if claims, err := parseToken(c.GetHeader("Authorization")); err == nil {
+		if err := services.EndSession(claims); err != nil {
+			utils.LogError("Failed to end session %s: %v", claims.SessionID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not log out."})
+			return
+		}
+		info := services.AuditFrom(ctx)
+		info.Actor = claims.Username
+		services.LogActivityContext(services.WithAudit(ctx, info), "info", "user.logout", fmt.Sprintf("User '%s' signed out.", claims.Username), "")
+	}
+	if payload.RefreshToken != "" {
+		session, err := services.EndSessionByRefreshToken(payload.RefreshToken)
+		if err != nil && !errors.Is(err, services.ErrSessionNotFound) && !errors.Is(err, services.ErrSessionRevoked) && !errors.Is(err, services.ErrRefreshTokenReused) {
+			utils.LogError("Failed to end a session: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not log out."})
+			return
+		}
+		if err == nil {
+			info := services.AuditFrom(ctx)
+			info.Actor = session.Username
+			services.LogActivityContext(services.WithAudit(ctx, info), "info", "user.logout", fmt.Sprintf("User '%s' signed out.", session.Username), "")
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Logout successful!"})
+}
+
+// parseToken checks the signature and expiry of the access token in an
+// Authorization header and returns its claims.
+func parseToken(header string) (*models.Claims, error) {
+	tokenString := strings.TrimPrefix(header, "Bearer ") // Remove "Bearer " prefix
+	if tokenString == "" {
+		return nil, errors.New("no token")
+	}
+
+	claims := &models.Claims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method %v", token.Header["alg"])
+		}
+		return services.JWTKey, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !token.Valid {
+		return nil, errors.New("invalid token")
+	}
+	return claims, nil
+}
+
+// AuthMiddleware authenticates requests using JWT or a personal access
+// token. Revoked tokens, tokens of sessions that ended and tokens of users
+// that were deleted or disabled, or that predate a password change, are
+// refused.
+func AuthMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetHeader("Authorization") == "" {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization header required"})
+			c.Abort()
+			return
+		}
+
+		if secret := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer "); strings.HasPrefix(secret, services.APITokenPrefix) {
+			authenticateAPIToken(c, secret)
+			return
+		}
+
+		claims, err := parseToken(c.GetHeader("Authorization"))
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+			c.Abort()
+			return
+		}
+
+		if err := services.CheckSession(claims); err != nil {
+			if !errors.Is(err, services.ErrSessionRevoked) {
+				utils.LogError("Failed to check session %s: %v", claims.SessionID, err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not verify token."})
+				c.Abort()
+				return
+			}
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+			c.Abort()
+			return
+		}
+
+		user, err := services.Users.Get(claims.Username)
+		if err != nil && !errors.Is(err, services.ErrUserNotFound) {
+			utils.LogError("Failed to read user '%s': %v", claims.Username, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not verify token."})
+			c.Abort()
+			return
+		}
+		if err != nil || user.Disabled || user.SessionVersion != claims.SessionVersion {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+			c.Abort()
+			return
+		}
+
+		// The stored role wins over the one in the token, so that a changed
+		// role applies at once
+		c.Set("claims", claims)
+		setUser(c, user)
+		c.Next()
+	}
+}
+
+// authenticateAPIToken authenticates a request made with a personal access
+// token. RequirePermission limits it to the token's scopes.
+func authenticateAPIToken(c *gin.Context, secret string) {
+	user, token, err := services.AuthenticateAPIToken(secret)
+	if errors.Is(err, services.ErrInvalidAPIToken) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+		c.Abort()
+		return
+	}
+	if err != nil {
+		utils.LogError("Failed to check a personal access token: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not verify token."})
+		c.Abort()
+		return
+	}
+	c.Set("apiToken", token)
+	setUser(c, user)
+	c.Next()
+}
+
+// setUser makes user the one the request is made by.
+func setUser(c *gin.Context, user models.User) {
+	c.Set("username", user.Username)
+	c.Set("role", user.Role)
+	info := services.AuditFrom(c.Request.Context())
+	info.Actor = user.Username
+	c.Request = c.Request.WithContext(services.WithAudit(c.Request.Context(), info))
+}
+
+// apiToken returns the personal access token the request was made with.
+func apiToken(c *gin.Context) (models.APIToken, bool) {
+	token, ok := c.Get("apiToken")
+	if !ok {
+		return models.APIToken{}, false
+	}
+	return token.(models.APIToken), true
+}
+
+// RequireSession refuses requests made with a personal access token. It
+// guards the routes that manage the account itself, so that a leaked token
+// cannot be used to take the account over.
+func RequireSession() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, ok := apiToken(c); ok {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Personal access tokens cannot be used for this endpoint."})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequirePermission refuses requests from users whose role lacks perm with
+// 403 Forbidden naming the missing permission. Requests made with a
+// personal access token also need perm among the token's scopes. On routes
+// of a site, the user's role on that site must grant perm too, and sites
+// the user is not a member of are reported as not found. It runs after
+// AuthMiddleware.
+func RequirePermission(perm services.Permission) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		role := c.GetString("role")
+		if !services.HasPermission(role, perm) {
+			denyPermission(c, perm)
+			return
+		}
+		if token, ok := apiToken(c); ok && !services.TokenAllows(token, perm) {
+			denyPermission(c, perm)
+			return
+		}
+
+		projectName := c.Param("projectName")
+		if projectName == "" || role == services.RoleAdmin {
+			c.Next()
+			return
+		}
+		site, err := services.Sites.Get(projectName)
+		if errors.Is(err, services.ErrSiteNotFound) {
+			// The handler reports the unknown site
+			c.Next()
+			return
+		}
+		if err != nil {
+			utils.LogError("Failed to read site '%s': %v", projectName, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve sites."})
+			c.Abort()
+			return
+		}
+		siteRole := services.SiteRole(site, c.GetString("username"))
+		if siteRole == "" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Site not found."})
+			c.Abort()
+			return
+		}
+		if !services.CanOnSite(role, siteRole, perm) {
+			denyPermission(c, perm)
+			return
+		}
+		c.Next()
+	}
+}
+
+func denyPermission(c *gin.Context, perm services.Permission) {
+	logActivity(c, "warning", "access.denied", fmt.Sprintf("User '%s' was denied %s %s: missing permission '%s'.", c.GetString("username"), c.Request.Method, c.FullPath(), perm), c.Param("projectName"))
+	c.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("Missing permission '%s'.", perm), "permission": perm})
+	c.Abort()
+}
+
+// siteVisible reports whether the user making the request can see site.
+func siteVisible(c *gin.Context, site models.Site) bool {
+	return services.SiteVisible(site, c.GetString("username"), c.GetString("role"))
+}
+
+// visibleSites returns the names of the sites the user making the request
+// can see, or nil when they can see every site.
+func visibleSites(c *gin.Context) ([]string, error) {
+	if c.GetString("role") == services.RoleAdmin {
+		return nil, nil
+	}
+	sites, err := services.Sites.List()
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for _, site := range sites {
+		if siteVisible(c, site) {
+			names = append(names, site.ProjectName)
+		}
+	}
+	return names, nil
+}
+
+// requestIDPattern limits the request ids accepted from clients, so that
+// arbitrary text does not end up in the audit log.
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// RequestContext tags each request with a request id, taken from a valid
+// X-Request-ID header or generated, echoes it in the response and attaches
+// the client's address and user agent to the request's context so that
+// activities are attributed to them.
+func RequestContext() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestID := c.GetHeader("X-Request-ID")
+		if !requestIDPattern.MatchString(requestID) {
+			requestID = newRequestID()
+		}
+		c.Header("X-Request-ID", requestID)
+		c.Request = c.Request.WithContext(services.WithAudit(c.Request.Context(), services.AuditInfo{
+			ClientIP:  c.ClientIP(),
+			UserAgent: c.Request.UserAgent(),
+			RequestID: requestID,
+		}))
+		c.Next()
+	}
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	return hex.EncodeToString(b)
+}
+
+// logActivity records an activity on behalf of the user making the request.
+func logActivity(c *gin.Context, level, action, message, projectName string) {
+	services.LogActivityContext(c.Request.Context(), level, action, message, projectName)
+}
@@ -0,0 +1,115 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"wordpress-collab-tool/models"
+	"wordpress-collab-tool/services"
+	"wordpress-collab-tool/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CloneWordPressSite creates a new site named by projectName on the host of
+// an existing site and copies the existing site's database and wp-content
+// into it. The clone gets its own port and database credentials and keeps
+// the source's admin account.
+func CloneWordPressSite(c *gin.Context) {
+	sourceName := c.Param("projectName")
+	if invalidInput(c, services.ValidateProjectName(sourceName)) {
+		return
+	}
+	var payload struct {
+		ProjectName string `json:"projectName" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request payload. 'projectName' is required."})
+		return
+	}
+	if invalidInput(c, services.ValidateProjectName(payload.ProjectName)) {
+		return
+	}
+
+	source, ok := sourceSite(c, sourceName)
+	if !ok {
+		return
+	}
+	queue := queueRequested(c)
+	clone, ok := newClone(c, source, payload.ProjectName, queue)
+	if !ok {
+		return
+	}
+	if !recordSite(c, clone, "site.clone", fmt.Sprintf("Clone '%s' of site '%s' initiated.", clone.ProjectName, sourceName)) {
+		return
+	}
+
+	job, err := services.StartJob(c.Request.Context(), "clone", clone.ProjectName, queue, func(ctx context.Context) error {
+		return reportClone(ctx, source, clone, services.CloneSite(ctx, source, clone))
+	})
+	if err != nil {
+		services.DropSiteRecord(clone.ProjectName)
+		if siteLocked(c, err) {
+			return
+		}
+		utils.LogError("Failed to start clone job for site '%s': %v", sourceName, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start clone."})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Site clone initiated successfully!", "url": clone.SiteURL, "host": clone.Host, "jobId": job.ID})
+}
+
+// sourceSite looks up the active site to copy. It responds and returns
+// false when there is none.
+func sourceSite(c *gin.Context, projectName string) (models.Site, bool) {
+	source, err := services.Sites.Get(projectName)
+	if errors.Is(err, services.ErrSiteNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Site not found."})
+		return models.Site{}, false
+	}
+	if err != nil {
+		utils.LogError("Failed to read site '%s': %v", projectName, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve sites."})
+		return models.Site{}, false
+	}
+	if source.Status != "active" {
+		c.JSON(http.StatusConflict, gin.H{"error": "Only active sites can be copied."})
+		return models.Site{}, false
+	}
+	return source, true
+}
+
+// newClone prepares the record of a copy of source called projectName, on
+// source's host since the copy is made from a backup on that host. It
+// responds and returns false when the copy cannot be placed.
+func newClone(c *gin.Context, source models.Site, projectName string, queue bool) (models.Site, bool) {
+	hostName := source.Host
+	if hostName == "" {
+		hostName = services.DefaultHostName
+	}
+	clone, ok := placeSite(c, projectName, hostName, queue)
+	if !ok {
+		return models.Site{}, false
+	}
+	clone.Plugins = source.Plugins
+	clone.AdminUsername = source.AdminUsername
+	clone.AdminPassword = source.AdminPassword
+	clone.Origin = &models.SiteOrigin{ProjectName: source.ProjectName, SiteURL: source.SiteURL}
+	return clone, true
+}
+
+// reportClone logs the outcome of a clone job and returns its error.
+func reportClone(ctx context.Context, source, clone models.Site, err error) error {
+	switch {
+	case errors.Is(err, context.Canceled):
+		services.LogActivityContext(ctx, "warning", "site.clone", fmt.Sprintf("Clone '%s' of site '%s' was cancelled.", clone.ProjectName, source.ProjectName), clone.ProjectName)
+	case err != nil:
+		utils.LogError("Failed to clone site '%s' into '%s': %v", source.ProjectName, clone.ProjectName, err)
+		services.LogActivityContext(ctx, "error", "site.clone", fmt.Sprintf("Clone '%s' of site '%s' failed: %v", clone.ProjectName, source.ProjectName, err), clone.ProjectName)
+	default:
+		services.LogActivityContext(ctx, "info", "site.clone", fmt.Sprintf("Site '%s' cloned into '%s' successfully!", source.ProjectName, clone.ProjectName), clone.ProjectName)
+	}
+	return err
+}
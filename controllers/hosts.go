@@ -0,0 +1,149 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"wordpress-collab-tool/models"
+	"wordpress-collab-tool/services"
+	"wordpress-collab-tool/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// hostResponse is a host as returned by the API: secrets are stripped and
+// the number of sites placed on it is included.
+type hostResponse struct {
+	models.Host
+	SiteCount int `json:"siteCount"`
+}
+
+func newHostResponse(host models.Host, counts map[string]int) hostResponse {
+	host.Credentials.Password = ""
+	host.Credentials.Passphrase = ""
+	return hostResponse{Host: host, SiteCount: counts[host.Name]}
+}
+
+// ListHosts lists every registered VPS host.
+func ListHosts(c *gin.Context) {
+	hosts, err := services.ListHosts()
+	if err != nil {
+		utils.LogError("Failed to read hosts: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve hosts."})
+		return
+	}
+
+	counts := services.CountSitesByHost(services.ListSitesOrEmpty())
+	response := make([]hostResponse, 0, len(hosts))
+	for _, h := range hosts {
+		response = append(response, newHostResponse(h, counts))
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// GetHost retrieves a single host.
+func GetHost(c *gin.Context) {
+	host, err := services.GetHost(c.Param("name"))
+	if err != nil {
+		respondHostError(c, err, "Failed to retrieve host.")
+		return
+	}
+
+	counts := services.CountSitesByHost(services.ListSitesOrEmpty())
+	c.JSON(http.StatusOK, newHostResponse(host, counts))
+}
+
+// CreateHost registers a new host.
+func CreateHost(c *gin.Context) {
+	var host models.Host
+	if err := c.ShouldBindJSON(&host); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	created, err := services.CreateHost(host)
+	if err != nil {
+		respondHostError(c, err, "Failed to save host.")
+		return
+	}
+
+	services.RecordActivity(c.Request.Context(), models.Activity{
+		Level:   "info",
+		Action:  "host.create",
+		Message: fmt.Sprintf("Host '%s' (%s) added.", created.Name, created.Address),
+		After:   services.AuditDetails(newHostResponse(created, nil).Host),
+	})
+	c.JSON(http.StatusCreated, newHostResponse(created, nil))
+}
+
+// UpdateHost updates an existing host.
+func UpdateHost(c *gin.Context) {
+	name := c.Param("name")
+
+	var host models.Host
+	if err := c.ShouldBindJSON(&host); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	before, err := services.GetHost(name)
+	if err != nil {
+		respondHostError(c, err, "Failed to save host.")
+		return
+	}
+	updated, err := services.UpdateHost(name, host)
+	if err != nil {
+		respondHostError(c, err, "Failed to save host.")
+		return
+	}
+
+	services.RecordActivity(c.Request.Context(), models.Activity{
+		Level:   "info",
+		Action:  "host.update",
+		Message: fmt.Sprintf("Host '%s' updated.", name),
+		Before:  services.AuditDetails(newHostResponse(before, nil).Host),
+		After:   services.AuditDetails(newHostResponse(updated, nil).Host),
+	})
+	counts := services.CountSitesByHost(services.ListSitesOrEmpty())
+	c.JSON(http.StatusOK, newHostResponse(updated, counts))
+}
+
+// DeleteHost removes a host that no longer runs any sites.
+func DeleteHost(c *gin.Context) {
+	name := c.Param("name")
+
+	before, err := services.GetHost(name)
+	if err != nil {
+		respondHostError(c, err, "Failed to delete host.")
+		return
+	}
+	if err := services.DeleteHost(name); err != nil {
+		respondHostError(c, err, "Failed to delete host.")
+		return
+	}
+
+	services.RecordActivity(c.Request.Context(), models.Activity{
+		Level:   "info",
+		Action:  "host.delete",
+		Message: fmt.Sprintf("Host '%s' removed.", name),
+		Before:  services.AuditDetails(newHostResponse(before, nil).Host),
+	})
+	c.JSON(http.StatusOK, gin.H{"message": "Host deleted successfully!"})
+}
+
+// respondHostError maps host registry errors to HTTP responses.
+func respondHostError(c *gin.Context, err error, fallback string) {
+	switch {
+	case errors.Is(err, services.ErrHostNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Host not found."})
+	case errors.Is(err, services.ErrHostExists):
+		c.JSON(http.StatusConflict, gin.H{"error": "A host with this name already exists."})
+	case errors.Is(err, services.ErrHostInUse):
+		c.JSON(http.StatusConflict, gin.H{"error": "Host still has sites. Delete or move them first."})
+	case errors.Is(err, services.ErrInvalidHost):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		utils.LogError("%s %v", fallback, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fallback})
+	}
+}
@@ -0,0 +1,132 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"wordpress-collab-tool/models"
+	"wordpress-collab-tool/services"
+	"wordpress-collab-tool/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ListJobs lists background jobs, newest first. The projectName, type and
+// state query parameters narrow the list. Users other than admins only see
+// the jobs of their sites.
+func ListJobs(c *gin.Context) {
+	jobs, err := services.ListJobs(services.JobFilter{
+		ProjectName: c.Query("projectName"),
+		Type:        c.Query("type"),
+		State:       c.Query("state"),
+	})
+	if err != nil {
+		utils.LogError("Failed to read jobs: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve jobs."})
+		return
+	}
+	projectNames, err := visibleSites(c)
+	if err != nil {
+		utils.LogError("Failed to list sites: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve jobs."})
+		return
+	}
+	if projectNames != nil {
+		jobs = slices.DeleteFunc(jobs, func(job models.Job) bool {
+			return !slices.Contains(projectNames, job.ProjectName)
+		})
+	}
+	c.JSON(http.StatusOK, jobs)
+}
+
+// jobAllowed reports whether the user making the request may use perm on the
+// site of job. Jobs of sites the user cannot see are reported as not found.
+func jobAllowed(c *gin.Context, job models.Job, perm services.Permission) bool {
+	role := c.GetString("role")
+	if role == services.RoleAdmin {
+		return true
+	}
+	site, err := services.Sites.Get(job.ProjectName)
+	siteRole := ""
+	if err == nil {
+		siteRole = services.SiteRole(site, c.GetString("username"))
+	}
+	if siteRole == "" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found."})
+		return false
+	}
+	if !services.CanOnSite(role, siteRole, perm) {
+		denyPermission(c, perm)
+		return false
+	}
+	return true
+}
+
+// GetJob returns a single job with its progress steps.
+func GetJob(c *gin.Context) {
+	job, err := services.GetJob(c.Param("id"))
+	if err != nil {
+		if errors.Is(err, services.ErrJobNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Job not found."})
+			return
+		}
+		utils.LogError("Failed to read jobs: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve job."})
+		return
+	}
+	if !jobAllowed(c, job, services.PermJobsRead) {
+		return
+	}
+	c.JSON(http.StatusOK, job)
+}
+
+// CancelJob cancels a queued or running job.
+func CancelJob(c *gin.Context) {
+	job, err := services.GetJob(c.Param("id"))
+	if err == nil && !jobAllowed(c, job, services.PermJobsCancel) {
+		return
+	}
+	job, err = services.CancelJob(c.Param("id"))
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrJobNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "Job not found."})
+		case errors.Is(err, services.ErrJobFinished):
+			c.JSON(http.StatusConflict, gin.H{"error": "Job has already finished.", "state": job.State})
+		default:
+			utils.LogError("Failed to cancel job: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel job."})
+		}
+		return
+	}
+
+	logActivity(c, "warning", "job.cancel", fmt.Sprintf("Cancellation requested for the %s of site '%s'.", job.Type, job.ProjectName), job.ProjectName)
+	c.JSON(http.StatusAccepted, gin.H{"message": "Cancellation requested.", "job": job})
+}
+
+// CancelSiteJobs cancels every queued or running job on a site.
+func CancelSiteJobs(c *gin.Context) {
+	projectName := c.Param("projectName")
+	if projectName == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Project name is required."})
+		return
+	}
+	if invalidInput(c, services.ValidateProjectName(projectName)) {
+		return
+	}
+
+	cancelled, err := services.CancelSiteJobs(projectName)
+	if err != nil {
+		utils.LogError("Failed to cancel jobs for site '%s': %v", projectName, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel jobs."})
+		return
+	}
+	if len(cancelled) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No job is running for this site."})
+		return
+	}
+
+	logActivity(c, "warning", "job.cancel", fmt.Sprintf("Cancellation requested for %d job(s) on site '%s'.", len(cancelled), projectName), projectName)
+	c.JSON(http.StatusAccepted, gin.H{"message": "Cancellation requested.", "jobs": cancelled})
+}
@@ -0,0 +1,49 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"wordpress-collab-tool/services"
+	"wordpress-collab-tool/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ListLockouts lists the locked accounts and the client IPs and usernames
+// whose sign-ins are slowed down after failures.
+func ListLockouts(c *gin.Context) {
+	users, err := services.ListLockedUsers()
+	if err != nil {
+		utils.LogError("Failed to list locked users: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve lockouts."})
+		return
+	}
+	accounts := make([]gin.H, 0, len(users))
+	for _, user := range users {
+		accounts = append(accounts, gin.H{"username": user.Username, "lockedUntil": user.LockedUntil})
+	}
+	c.JSON(http.StatusOK, gin.H{"accounts": accounts, "throttles": services.ListLoginThrottles()})
+}
+
+// UnlockUser lifts the lockout of an account before it expires.
+func UnlockUser(c *gin.Context) {
+	username := c.Param("username")
+	user, err := services.UnlockUser(username)
+	if err != nil {
+		respondUserError(c, err, "Failed to save user.")
+		return
+	}
+	logActivity(c, "security", "user.unlock", fmt.Sprintf("User '%s' unlocked.", username), "")
+	c.JSON(http.StatusOK, user)
+}
+
+// ClearIPThrottle forgets the failed sign-ins of a client IP.
+func ClearIPThrottle(c *gin.Context) {
+	ip := c.Param("ip")
+	if !services.ClearIPThrottle(ip) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No failed sign-ins are recorded for this IP."})
+		return
+	}
+	logActivity(c, "security", "login.unthrottle", fmt.Sprintf("Failed sign-ins from %s cleared.", ip), "")
+	c.JSON(http.StatusOK, gin.H{"message": "Sign-ins from this IP are no longer slowed down."})
+}
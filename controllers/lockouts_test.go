@@ -0,0 +1,92 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"wordpress-collab-tool/config"
+	"wordpress-collab-tool/internal/fakes"
+	"wordpress-collab-tool/models"
+	"wordpress-collab-tool/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// withLoginThrottle applies sign-in rate limits for one test. The zero
+// config disables them.
+func withLoginThrottle(t *testing.T, cfg config.Config) {
+	t.Helper()
+	services.InitLoginThrottle(&cfg)
+	t.Cleanup(func() { services.InitLoginThrottle(config.LoadConfig()) })
+}
+
+func TestLoginThrottle(t *testing.T) {
+	setupSite(t, fakes.NewExecutor())
+	admin := withUser(t, "root", services.RoleAdmin)
+	withUser(t, "dana", services.RoleViewer)
+	withLoginThrottle(t, config.Config{
+		LoginIPAttempts:     3,
+		LoginUserAttempts:   2,
+		LoginBackoffBase:    time.Minute,
+		LoginBackoffMax:     time.Hour,
+		LoginThrottleWindow: time.Hour,
+	})
+
+	router := gin.New()
+	router.Use(RequestContext())
+	router.POST("/api/login", Login)
+	api := router.Group("/api", AuthMiddleware())
+	api.GET("/lockouts", ListLockouts)
+	api.POST("/users/:username/unlock", UnlockUser)
+	api.DELETE("/lockouts/ips/:ip", ClearIPThrottle)
+
+	// Past the free attempts, even the right password has to wait
+	if _, code := login(t, router, "ghost", "wrong password"); code != http.StatusUnauthorized {
+		t.Fatalf("unknown user: expected 401, got %d", code)
+	}
+	for i := 0; i < 3; i++ {
+		if _, code := login(t, router, "dana", "wrong password"); code != http.StatusUnauthorized {
+			t.Fatalf("attempt %d: expected 401, got %d", i+1, code)
+		}
+	}
+	w := serveJSON(router, http.MethodPost, "/api/login", "", models.Credentials{Username: "dana", Password: testPassword})
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Fatalf("throttled username: %d %s", w.Code, w.Body.String())
+	}
+
+	// So is the client that failed, whatever account it tries
+	if _, code := login(t, router, "root", testPassword); code != http.StatusTooManyRequests {
+		t.Fatalf("throttled client: expected 429, got %d", code)
+	}
+
+	w = serveJSON(router, http.MethodGet, "/api/lockouts", admin, nil)
+	var lockouts struct {
+		Throttles []services.LoginThrottle `json:"throttles"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &lockouts)
+	if w.Code != http.StatusOK || len(lockouts.Throttles) != 3 || lockouts.Throttles[0].Kind != services.ThrottleIP {
+		t.Fatalf("lockouts: %d %s", w.Code, w.Body.String())
+	}
+	if logged, _, _ := services.Activities.Query(services.ActivityQuery{Action: "login.throttle"}); len(logged) != 2 {
+		t.Errorf("expected the username and the client to be logged, got %+v", logged)
+	}
+
+	// Admins lift the limits
+	if w := serveJSON(router, http.MethodDelete, "/api/lockouts/ips/"+lockouts.Throttles[0].Key, admin, nil); w.Code != http.StatusOK {
+		t.Fatalf("clear IP: %d %s", w.Code, w.Body.String())
+	}
+	if _, code := login(t, router, "root", testPassword); code != http.StatusOK {
+		t.Errorf("after clearing the client: expected 200, got %d", code)
+	}
+	if _, code := login(t, router, "dana", testPassword); code != http.StatusTooManyRequests {
+		t.Errorf("username still throttled: expected 429, got %d", code)
+	}
+	if w := serveJSON(router, http.MethodPost, "/api/users/dana/unlock", admin, nil); w.Code != http.StatusOK {
+		t.Fatalf("unlock: %d %s", w.Code, w.Body.String())
+	}
+	if _, code := login(t, router, "dana", testPassword); code != http.StatusOK {
+		t.Errorf("after unlocking: expected 200, got %d", code)
+	}
+}
@@ -0,0 +1,67 @@
+package controllers
+
+import (
+	"io"
+	"net/http"
+	"time"
+	"wordpress-collab-tool/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// sseKeepalive is how often an idle event stream receives a comment so that
+// proxies do not close it.
+const sseKeepalive = 15 * time.Second
+
+// StreamDeployLogs sends the output of a site's latest deployment as
+// Server-Sent Events. Retained lines are replayed first, then new lines follow
+// as "log" events until an "end" event carries the final status.
+func StreamDeployLogs(c *gin.Context) {
+	projectName := c.Param("projectName")
+	if projectName == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Project name is required."})
+		return
+	}
+	if invalidInput(c, services.ValidateProjectName(projectName)) {
+		return
+	}
+
+	stream, ok := services.DeployLog(projectName)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No deployment log for this site."})
+		return
+	}
+
+	backlog, lines, unsubscribe := stream.Subscribe()
+	defer unsubscribe()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	for _, line := range backlog {
+		c.SSEvent("log", line)
+	}
+	c.Writer.Flush()
+
+	keepalive := time.NewTicker(sseKeepalive)
+	defer keepalive.Stop()
+
+	for {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				status, _ := stream.Status()
+				c.SSEvent("end", gin.H{"status": status})
+				c.Writer.Flush()
+				return
+			}
+			c.SSEvent("log", line)
+		case <-keepalive.C:
+			io.WriteString(c.Writer, ": keepalive\n\n")
+		case <-c.Request.Context().Done():
+			return
+		}
+		c.Writer.Flush()
+	}
+}
@@ -0,0 +1,66 @@
+package controllers
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"wordpress-collab-tool/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+func newLogsRouter() *gin.Engine {
+	router := gin.New()
+	router.GET("/api/sites/:projectName/deploy/logs", StreamDeployLogs)
+	return router
+}
+
+func TestStreamDeployLogsReplaysFinishedDeployment(t *testing.T) {
+	logs := services.StartDeployLog("replayed")
+	logs.Infof("Starting containers.")
+	out := logs.Writer("stdout")
+	out.Write([]byte("Container replayed_db  Started\n"))
+	logs.Finish("succeeded")
+
+	w := serve(newLogsRouter(), http.MethodGet, "/api/sites/replayed/deploy/logs")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/event-stream") {
+		t.Errorf("unexpected content type %q", ct)
+	}
+
+	body := w.Body.String()
+	for _, want := range []string{"event:log", "Starting containers.", "Container replayed_db  Started", "event:end", `"status":"succeeded"`} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected %q in stream:\n%s", want, body)
+		}
+	}
+}
+
+func TestStreamDeployLogsFollowsRunningDeployment(t *testing.T) {
+	logs := services.StartDeployLog("live")
+	logs.Infof("Uploading docker-compose.yml.")
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		logs.Infof("Installing WordPress.")
+		logs.Finish("failed")
+	}()
+
+	body := serve(newLogsRouter(), http.MethodGet, "/api/sites/live/deploy/logs").Body.String()
+	first := strings.Index(body, "Uploading docker-compose.yml.")
+	second := strings.Index(body, "Installing WordPress.")
+	if first < 0 || second < first || !strings.Contains(body, `"status":"failed"`) {
+		t.Errorf("unexpected stream:\n%s", body)
+	}
+}
+
+func TestStreamDeployLogsUnknownSite(t *testing.T) {
+	w := serve(newLogsRouter(), http.MethodGet, "/api/sites/nothing-deployed/deploy/logs")
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404, got %d", w.Code)
+	}
+}
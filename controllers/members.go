@@ -0,0 +1,125 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"wordpress-collab-tool/models"
+	"wordpress-collab-tool/services"
+	"wordpress-collab-tool/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ListSiteMembers lists the owner and members of a site.
+func ListSiteMembers(c *gin.Context) {
+	site, err := services.Sites.Get(c.Param("projectName"))
+	if err != nil {
+		respondMemberError(c, err, "Failed to retrieve site.")
+		return
+	}
+	members := site.Members
+	if members == nil {
+		members = []models.SiteMember{}
+	}
+	c.JSON(http.StatusOK, gin.H{"owner": site.Owner, "members": members})
+}
+
+// AddSiteMember invites an existing user to a site with a role, or changes
+// the role of a member.
+func AddSiteMember(c *gin.Context) {
+	var payload struct {
+		Username string `json:"username" binding:"required"`
+		Role     string `json:"role" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request payload. 'username' and 'role' are required."})
+		return
+	}
+
+	projectName := c.Param("projectName")
+	before, after, err := services.AddSiteMember(projectName, payload.Username, payload.Role, c.GetString("username"))
+	if err != nil {
+		respondMemberError(c, err, "Failed to save site information.")
+		return
+	}
+
+	services.RecordActivity(c.Request.Context(), models.Activity{
+		Level:       "security",
+		Action:      "site.member.add",
+		Message:     fmt.Sprintf("User '%s' added to site '%s' as %s.", payload.Username, projectName, payload.Role),
+		ProjectName: projectName,
+		Before:      services.AuditDetails(before.Members),
+		After:       services.AuditDetails(after.Members),
+	})
+	c.JSON(http.StatusOK, gin.H{"owner": after.Owner, "members": after.Members})
+}
+
+// RemoveSiteMember removes a member from a site.
+func RemoveSiteMember(c *gin.Context) {
+	projectName := c.Param("projectName")
+	username := c.Param("username")
+	before, after, err := services.RemoveSiteMember(projectName, username)
+	if err != nil {
+		respondMemberError(c, err, "Failed to save site information.")
+		return
+	}
+
+	services.RecordActivity(c.Request.Context(), models.Activity{
+		Level:       "security",
+		Action:      "site.member.remove",
+		Message:     fmt.Sprintf("User '%s' removed from site '%s'.", username, projectName),
+		ProjectName: projectName,
+		Before:      services.AuditDetails(before.Members),
+		After:       services.AuditDetails(after.Members),
+	})
+	c.JSON(http.StatusOK, gin.H{"message": "Member removed successfully!"})
+}
+
+// TransferSiteOwnership makes another user the owner of a site. The previous
+// owner stays on as a maintainer.
+func TransferSiteOwnership(c *gin.Context) {
+	var payload struct {
+		Username string `json:"username" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request payload. 'username' is required."})
+		return
+	}
+
+	projectName := c.Param("projectName")
+	before, after, err := services.TransferSiteOwnership(projectName, payload.Username, c.GetString("username"))
+	if err != nil {
+		respondMemberError(c, err, "Failed to save site information.")
+		return
+	}
+
+	services.RecordActivity(c.Request.Context(), models.Activity{
+		Level:       "security",
+		Action:      "site.owner.transfer",
+		Message:     fmt.Sprintf("Ownership of site '%s' transferred from '%s' to '%s'.", projectName, before.Owner, after.Owner),
+		ProjectName: projectName,
+		Before:      services.AuditDetails(gin.H{"owner": before.Owner, "members": before.Members}),
+		After:       services.AuditDetails(gin.H{"owner": after.Owner, "members": after.Members}),
+	})
+	c.JSON(http.StatusOK, gin.H{"owner": after.Owner, "members": after.Members})
+}
+
+// respondMemberError maps site membership errors to HTTP responses.
+func respondMemberError(c *gin.Context, err error, fallback string) {
+	switch {
+	case errors.Is(err, services.ErrSiteNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Site not found."})
+	case errors.Is(err, services.ErrUserNotFound):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "User not found."})
+	case errors.Is(err, services.ErrMemberNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "User is not a member of this site."})
+	case errors.Is(err, services.ErrAlreadyOwner):
+		c.JSON(http.StatusConflict, gin.H{"error": "User already owns this site."})
+	case errors.Is(err, services.ErrInvalidInput):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input.", "details": err.Error()})
+	default:
+		utils.LogError("%s %v", fallback, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fallback})
+	}
+}
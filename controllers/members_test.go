@@ -0,0 +1,130 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"wordpress-collab-tool/internal/fakes"
+	"wordpress-collab-tool/models"
+	"wordpress-collab-tool/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+func newMembersRouter() *gin.Engine {
+	router := gin.New()
+	api := router.Group("/api", AuthMiddleware())
+	api.GET("/sites", RequirePermission(services.PermSitesRead), GetWordPressSites)
+	api.GET("/sites/:projectName/backups", RequirePermission(services.PermBackupsRead), ListBackups)
+	api.POST("/sites/:projectName/backups/restore", RequirePermission(services.PermBackupsRestore), RestoreBackup)
+	api.GET("/sites/:projectName/members", RequirePermission(services.PermSitesRead), ListSiteMembers)
+	api.POST("/sites/:projectName/members", RequirePermission(services.PermSiteMembers), AddSiteMember)
+	api.DELETE("/sites/:projectName/members/:username", RequirePermission(services.PermSiteMembers), RemoveSiteMember)
+	api.POST("/sites/:projectName/transfer", RequirePermission(services.PermSiteMembers), TransferSiteOwnership)
+	api.GET("/activities", RequirePermission(services.PermActivitiesRead), GetActivities)
+	return router
+}
+
+func TestSiteMembership(t *testing.T) {
+	setupSite(t, fakes.NewExecutor())
+	owner := withUser(t, "olga", services.RoleMaintainer)
+	member := withUser(t, "max", services.RoleMaintainer)
+	outsider := withUser(t, "otto", services.RoleMaintainer)
+	services.UpdateSite("blog", func(s *models.Site) { s.Owner = "olga" })
+	services.Sites.Create(models.Site{ProjectName: "shop", Owner: "otto", Status: "failed"})
+	services.LogActivity("info", "Backup of blog created", "blog")
+	services.LogActivity("info", "Backup of shop created", "shop")
+	router := newMembersRouter()
+
+	sitesOf := func(token string) []string {
+		var sites []models.Site
+		json.Unmarshal(serveJSON(router, http.MethodGet, "/api/sites", token, nil).Body.Bytes(), &sites)
+		names := []string{}
+		for _, s := range sites {
+			names = append(names, s.ProjectName)
+		}
+		return names
+	}
+	if names := sitesOf(owner); len(names) != 1 || names[0] != "blog" {
+		t.Errorf("owner sees %v", names)
+	}
+	if names := sitesOf(member); len(names) != 0 {
+		t.Errorf("non-member sees %v", names)
+	}
+	if w := serveJSON(router, http.MethodGet, "/api/sites/blog/members", outsider, nil); w.Code != http.StatusNotFound {
+		t.Errorf("outsider reading members: expected 404, got %d", w.Code)
+	}
+
+	// Members of a site cannot manage its members
+	w := serveJSON(router, http.MethodPost, "/api/sites/blog/members", owner, gin.H{"username": "max", "role": services.RoleViewer})
+	if w.Code != http.StatusOK {
+		t.Fatalf("invite: %d %s", w.Code, w.Body.String())
+	}
+	if w := serveJSON(router, http.MethodPost, "/api/sites/blog/members", member, gin.H{"username": "otto", "role": services.RoleViewer}); w.Code != http.StatusForbidden {
+		t.Errorf("member inviting: expected 403, got %d", w.Code)
+	}
+	if names := sitesOf(member); len(names) != 1 || names[0] != "blog" {
+		t.Errorf("member sees %v", names)
+	}
+
+	// The site role limits what a maintainer can do on the site
+	w = serveJSON(router, http.MethodPost, "/api/sites/blog/backups/restore", member, gin.H{"backupFile": "backup-2024-01-01-00-00-00.tar.gz"})
+	if w.Code != http.StatusForbidden {
+		t.Errorf("viewer member restoring: expected 403, got %d %s", w.Code, w.Body.String())
+	}
+
+	var activities []models.Activity
+	json.Unmarshal(serveJSON(router, http.MethodGet, "/api/activities", member, nil).Body.Bytes(), &activities)
+	for _, a := range activities {
+		if a.ProjectName != "blog" {
+			t.Errorf("member sees activity of another site: %+v", a)
+		}
+	}
+
+	// Transferring keeps the previous owner on as a maintainer
+	w = serveJSON(router, http.MethodPost, "/api/sites/blog/transfer", owner, gin.H{"username": "max"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("transfer: %d %s", w.Code, w.Body.String())
+	}
+	site, _ := services.Sites.Get("blog")
+	if site.Owner != "max" || services.SiteRole(site, "olga") != services.RoleMaintainer || len(site.Members) != 1 {
+		t.Errorf("unexpected site after transfer: owner %q members %+v", site.Owner, site.Members)
+	}
+	if w := serveJSON(router, http.MethodDelete, "/api/sites/blog/members/olga", member, nil); w.Code != http.StatusOK {
+		t.Errorf("new owner removing the old one: %d %s", w.Code, w.Body.String())
+	}
+	if w := serveJSON(router, http.MethodDelete, "/api/sites/blog/members/olga", member, nil); w.Code != http.StatusNotFound {
+		t.Errorf("removing a non-member: expected 404, got %d", w.Code)
+	}
+
+	for _, action := range []string{"site.member.add", "site.owner.transfer", "site.member.remove"} {
+		logged, _, _ := services.Activities.Query(services.ActivityQuery{Action: action})
+		if len(logged) != 1 || logged[0].ProjectName != "blog" || logged[0].After == nil {
+			t.Errorf("%s was not logged: %+v", action, logged)
+		}
+	}
+}
+
+func TestStagingPushNeedsAccessToStagingSite(t *testing.T) {
+	setupSite(t, fakes.NewExecutor())
+	owner := withUser(t, "olga", services.RoleMaintainer)
+	services.UpdateSite("blog", func(s *models.Site) { s.Owner, s.Staging = "olga", "blog-staging" })
+	services.Sites.Create(models.Site{ProjectName: "blog-staging", Owner: "otto", Status: "active", StagingOf: "blog"})
+
+	router := gin.New()
+	api := router.Group("/api", AuthMiddleware())
+	api.POST("/sites/:projectName/staging/push", RequirePermission(services.PermBackupsRestore), PushStagingSite)
+
+	w := serveJSON(router, http.MethodPost, "/api/sites/blog/staging/push", owner, gin.H{"mode": "db"})
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("push from a staging site the user cannot see: expected 403, got %d: %s", w.Code, w.Body.String())
+	}
+
+	services.UpdateSite("blog-staging", func(s *models.Site) {
+		s.Members = []models.SiteMember{{Username: "olga", Role: services.RoleViewer}}
+	})
+	if w := serveJSON(router, http.MethodPost, "/api/sites/blog/staging/push", owner, gin.H{"mode": "db"}); w.Code != http.StatusOK {
+		t.Errorf("push by a member of both sites: expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,143 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"wordpress-collab-tool/services"
+	"wordpress-collab-tool/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetOIDCStatus tells the login page whether to offer single sign-on.
+func GetOIDCStatus(c *gin.Context) {
+	if services.OIDC == nil {
+		c.JSON(http.StatusOK, gin.H{"enabled": false})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"enabled": true, "loginUrl": "/api/oidc/login"})
+}
+
+// OIDCLogin sends the browser to the identity provider.
+func OIDCLogin(c *gin.Context) {
+	authURL, err := services.BeginOIDCLogin(c.Request.Context())
+	if errors.Is(err, services.ErrOIDCDisabled) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Single sign-on is not configured."})
+		return
+	}
+	if err != nil {
+		utils.LogError("Failed to start single sign-on: %v", err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "The identity provider is unavailable."})
+		return
+	}
+	c.Redirect(http.StatusFound, authURL)
+}
+
+// OIDCCallback is where the identity provider sends the browser back. It
+// signs the user in and, when a post-login page is configured, redirects
+// there with a code that OIDCToken exchanges for the tokens.
+func OIDCCallback(c *gin.Context) {
+	if services.OIDC == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Single sign-on is not configured."})
+		return
+	}
+	ctx := c.Request.Context()
+	if providerError := c.Query("error"); providerError != "" {
+		services.LogActivityContext(ctx, "warning", "user.login", fmt.Sprintf("Single sign-on refused by the identity provider: %s.", providerError), "")
+		respondOIDCCallback(c, http.StatusUnauthorized, "The identity provider refused the sign-in.", nil)
+		return
+	}
+
+	user, err := services.CompleteOIDCLogin(ctx, c.Query("state"), c.Query("code"))
+	switch {
+	case errors.Is(err, services.ErrInvalidOIDCState):
+		respondOIDCCallback(c, http.StatusUnauthorized, "Sign-in expired. Start again.", nil)
+		return
+	case errors.Is(err, services.ErrInvalidIDToken):
+		services.LogActivityContext(ctx, "security", "user.login", fmt.Sprintf("Single sign-on with an invalid ID token refused: %v.", err), "")
+		respondOIDCCallback(c, http.StatusUnauthorized, "The identity provider's answer could not be verified.", nil)
+		return
+	case errors.Is(err, services.ErrNoMappedRole):
+		services.LogActivityContext(ctx, "warning", "user.login", "Single sign-on refused: none of the user's groups has a role.", "")
+		respondOIDCCallback(c, http.StatusForbidden, "Your account has no access to this panel.", nil)
+		return
+	case errors.Is(err, services.ErrOIDCAccountConflict):
+		services.LogActivityContext(ctx, "security", "user.login", "Single sign-on refused: the username belongs to a local account.", "")
+		respondOIDCCallback(c, http.StatusConflict, "A local account already uses your username.", nil)
+		return
+	case errors.Is(err, services.ErrUserDisabled):
+		respondOIDCCallback(c, http.StatusForbidden, "Account is disabled.", nil)
+		return
+	case errors.Is(err, services.ErrInvalidInput):
+		respondOIDCCallback(c, http.StatusForbidden, "Your username cannot be used in this panel.", nil)
+		return
+	case err != nil:
+		utils.LogError("Failed to complete single sign-on: %v", err)
+		respondOIDCCallback(c, http.StatusBadGateway, "Could not sign in with the identity provider.", nil)
+		return
+	}
+
+	info := services.AuditFrom(ctx)
+	info.Actor = user.Username
+	ctx = services.WithAudit(ctx, info)
+	tokens, err := services.StartSession(ctx, user)
+	if err != nil {
+		utils.LogError("Failed to start a session for '%s': %v", user.Username, err)
+		respondOIDCCallback(c, http.StatusInternalServerError, "Could not generate token.", nil)
+		return
+	}
+	services.LogActivityContext(ctx, "info", "user.login", fmt.Sprintf("User '%s' signed in with single sign-on.", user.Username), "")
+	respondOIDCCallback(c, http.StatusOK, "", &tokens)
+}
+
+// OIDCToken exchanges the code of a finished single sign-on for its tokens.
+func OIDCToken(c *gin.Context) {
+	var payload struct {
+		Code string `json:"code" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request payload. 'code' is required."})
+		return
+	}
+	tokens, err := services.RedeemLoginHandoff(payload.Code)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Sign-in expired. Start again."})
+		return
+	}
+	c.JSON(http.StatusOK, tokenResponse("Login successful!", tokens))
+}
+
+// respondOIDCCallback ends the callback: with the tokens or the error as
+// JSON, or by redirecting to the post-login page with a code or the error.
+func respondOIDCCallback(c *gin.Context, status int, message string, tokens *services.SessionTokens) {
+	postLogin := services.OIDC.PostLoginURL()
+	if postLogin == "" {
+		if tokens != nil {
+			c.JSON(http.StatusOK, tokenResponse("Login successful!", *tokens))
+		} else {
+			c.JSON(status, gin.H{"error": message})
+		}
+		return
+	}
+
+	query := url.Values{}
+	if tokens != nil {
+		code, err := services.NewLoginHandoff(*tokens)
+		if err != nil {
+			utils.LogError("Failed to hand off a single sign-on session: %v", err)
+			query.Set("error", "Could not sign in.")
+		} else {
+			query.Set("code", code)
+		}
+	} else {
+		query.Set("error", message)
+	}
+	separator := "?"
+	if strings.Contains(postLogin, "?") {
+		separator = "&"
+	}
+	c.Redirect(http.StatusFound, postLogin+separator+query.Encode())
+}
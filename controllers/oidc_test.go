@@ -0,0 +1,93 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"wordpress-collab-tool/config"
+	"wordpress-collab-tool/internal/fakes"
+	"wordpress-collab-tool/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestOIDCLogin(t *testing.T) {
+	setupSite(t, fakes.NewExecutor())
+	withUser(t, "root", services.RoleAdmin)
+	fake := fakes.NewOIDCProvider("panel")
+	defer fake.Close()
+	err := services.InitOIDC(&config.Config{
+		OIDCIssuer:        fake.Issuer(),
+		OIDCClientID:      "panel",
+		OIDCRedirectURL:   "https://panel.example/api/oidc/callback",
+		OIDCUsernameClaim: "preferred_username",
+		OIDCGroupsClaim:   "groups",
+		OIDCGroupRoles:    map[string]string{"wp-editors": services.RoleEditor},
+		OIDCPostLoginURL:  "https://panel.example/login",
+	})
+	if err != nil {
+		t.Fatalf("InitOIDC: %v", err)
+	}
+	defer func() { services.OIDC = nil }()
+	fake.SetUser(map[string]any{"sub": "u-1", "preferred_username": "kim", "groups": []string{"wp-editors"}})
+
+	router := gin.New()
+	router.GET("/api/oidc/login", OIDCLogin)
+	router.GET("/api/oidc/callback", OIDCCallback)
+	router.POST("/api/oidc/token", OIDCToken)
+	api := router.Group("/api", AuthMiddleware())
+	api.GET("/me", GetCurrentUser)
+
+	// The browser goes to the provider and comes back to the callback
+	w := serveJSON(router, http.MethodGet, "/api/oidc/login", "", nil)
+	if w.Code != http.StatusFound {
+		t.Fatalf("login: %d %s", w.Code, w.Body.String())
+	}
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Get(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatalf("authorize: %v", err)
+	}
+	resp.Body.Close()
+	callback, _ := url.Parse(resp.Header.Get("Location"))
+	req := httptest.NewRequest(http.MethodGet, "/api/oidc/callback?"+callback.RawQuery, nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	// The frontend receives a single-use code, never the tokens
+	landing, _ := url.Parse(w.Header().Get("Location"))
+	code := landing.Query().Get("code")
+	if w.Code != http.StatusFound || landing.Host != "panel.example" || code == "" {
+		t.Fatalf("callback: %d %s", w.Code, w.Header().Get("Location"))
+	}
+	w = serveJSON(router, http.MethodPost, "/api/oidc/token", "", gin.H{"code": code})
+	var tokens struct {
+		Token string `json:"token"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &tokens)
+	if w.Code != http.StatusOK || tokens.Token == "" {
+		t.Fatalf("token: %d %s", w.Code, w.Body.String())
+	}
+	if w := serveJSON(router, http.MethodPost, "/api/oidc/token", "", gin.H{"code": code}); w.Code != http.StatusUnauthorized {
+		t.Errorf("redeeming the code twice: expected 401, got %d", w.Code)
+	}
+
+	w = serveJSON(router, http.MethodGet, "/api/me", tokens.Token, nil)
+	var me struct {
+		Username string `json:"username"`
+		Role     string `json:"role"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &me)
+	if me.Username != "kim" || me.Role != services.RoleEditor {
+		t.Errorf("signed-in user: %s", w.Body.String())
+	}
+
+	// A forged callback lands on the login page with an error
+	w = serveJSON(router, http.MethodGet, "/api/oidc/callback?state=forged&code=x", "", nil)
+	if landing, _ := url.Parse(w.Header().Get("Location")); w.Code != http.StatusFound || landing.Query().Get("error") == "" {
+		t.Errorf("forged callback: %d %s", w.Code, w.Header().Get("Location"))
+	}
+}
@@ -0,0 +1,102 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"wordpress-collab-tool/models"
+	"wordpress-collab-tool/services"
+	"wordpress-collab-tool/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// sessionResponse is a session as listed to its user, marking the one the
+// request was made from.
+type sessionResponse struct {
+	models.Session
+	Current bool `json:"current"`
+}
+
+// ListOwnSessions lists the active sessions of the signed-in user with the
+// device and address each was last used from.
+func ListOwnSessions(c *gin.Context) {
+	listSessions(c, c.GetString("username"))
+}
+
+// RevokeOwnSession signs the signed-in user out of one of their sessions.
+func RevokeOwnSession(c *gin.Context) {
+	username := c.GetString("username")
+	session, err := services.RevokeSession(username, c.Param("id"))
+	if err != nil {
+		respondSessionError(c, err, "Failed to revoke session.")
+		return
+	}
+	logActivity(c, "security", "session.revoke", fmt.Sprintf("User '%s' ended their session %s (%s, %s).", username, session.ID, session.ClientIP, session.UserAgent), "")
+	c.JSON(http.StatusOK, gin.H{"message": "Session revoked successfully!"})
+}
+
+// LogoutEverywhere signs the signed-in user out of every session,
+// including the one the request was made from.
+func LogoutEverywhere(c *gin.Context) {
+	username := c.GetString("username")
+	ended, err := services.EndAllSessions(username)
+	if err != nil {
+		respondSessionError(c, err, "Failed to end sessions.")
+		return
+	}
+	logActivity(c, "security", "session.revoke_all", fmt.Sprintf("User '%s' signed out everywhere, ending %d session(s).", username, ended), "")
+	c.JSON(http.StatusOK, gin.H{"message": "Logged out everywhere.", "sessions": ended})
+}
+
+// ListUserSessions lists the active sessions of any user.
+func ListUserSessions(c *gin.Context) {
+	username := c.Param("username")
+	if _, err := services.Users.Get(username); err != nil {
+		respondUserError(c, err, "Failed to retrieve user.")
+		return
+	}
+	listSessions(c, username)
+}
+
+// LogoutUserEverywhere signs a user out of every session.
+func LogoutUserEverywhere(c *gin.Context) {
+	username := c.Param("username")
+	ended, err := services.EndAllSessions(username)
+	if err != nil {
+		respondSessionError(c, err, "Failed to end sessions.")
+		return
+	}
+	logActivity(c, "security", "session.revoke_all", fmt.Sprintf("User '%s' was signed out everywhere, ending %d session(s).", username, ended), "")
+	c.JSON(http.StatusOK, gin.H{"message": "User logged out everywhere.", "sessions": ended})
+}
+
+func listSessions(c *gin.Context, username string) {
+	sessions, err := services.ListSessions(username)
+	if err != nil {
+		respondSessionError(c, err, "Failed to retrieve sessions.")
+		return
+	}
+	current := ""
+	if claims, ok := c.Get("claims"); ok {
+		current = claims.(*models.Claims).SessionID
+	}
+	response := make([]sessionResponse, 0, len(sessions))
+	for _, session := range sessions {
+		response = append(response, sessionResponse{Session: session, Current: session.ID == current})
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// respondSessionError maps session errors to HTTP responses.
+func respondSessionError(c *gin.Context, err error, fallback string) {
+	switch {
+	case errors.Is(err, services.ErrSessionNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Session not found."})
+	case errors.Is(err, services.ErrUserNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found."})
+	default:
+		utils.LogError("%s %v", fallback, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fallback})
+	}
+}
@@ -0,0 +1,109 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"wordpress-collab-tool/internal/fakes"
+	"wordpress-collab-tool/models"
+	"wordpress-collab-tool/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+func newSessionRouter() *gin.Engine {
+	router := gin.New()
+	router.Use(RequestContext())
+	router.POST("/api/login", Login)
+	router.POST("/api/refresh", RefreshToken)
+	router.POST("/api/logout", Logout)
+	api := router.Group("/api", AuthMiddleware())
+	api.GET("/me", GetCurrentUser)
+	api.GET("/me/sessions", ListOwnSessions)
+	api.DELETE("/me/sessions/:id", RevokeOwnSession)
+	api.POST("/logout/all", LogoutEverywhere)
+	return router
+}
+
+func loginTokens(t *testing.T, router *gin.Engine, username string) services.SessionTokens {
+	t.Helper()
+	w := serveJSON(router, http.MethodPost, "/api/login", "", models.Credentials{Username: username, Password: testPassword})
+	var tokens services.SessionTokens
+	if err := json.Unmarshal(w.Body.Bytes(), &tokens); err != nil || w.Code != http.StatusOK || tokens.RefreshToken == "" {
+		t.Fatalf("login: %d %s", w.Code, w.Body.String())
+	}
+	return tokens
+}
+
+func TestSessions(t *testing.T) {
+	setupSite(t, fakes.NewExecutor())
+	withUser(t, "alice", services.RoleEditor)
+	router := newSessionRouter()
+
+	laptop := loginTokens(t, router, "alice")
+	phone := loginTokens(t, router, "alice")
+
+	// Refreshing replaces both tokens; the old refresh token is spent
+	w := serveJSON(router, http.MethodPost, "/api/refresh", "", gin.H{"refreshToken": laptop.RefreshToken})
+	var refreshed services.SessionTokens
+	json.Unmarshal(w.Body.Bytes(), &refreshed)
+	if w.Code != http.StatusOK || refreshed.Token == "" || refreshed.RefreshToken == laptop.RefreshToken {
+		t.Fatalf("refresh: %d %s", w.Code, w.Body.String())
+	}
+	if w := serveJSON(router, http.MethodGet, "/api/me", refreshed.Token, nil); w.Code != http.StatusOK {
+		t.Errorf("refreshed token: expected 200, got %d", w.Code)
+	}
+
+	// The sessions list shows every device, the one of withUser included,
+	// and marks the current one
+	w = serveJSON(router, http.MethodGet, "/api/me/sessions", refreshed.Token, nil)
+	var sessions []struct {
+		ID       string `json:"id"`
+		ClientIP string `json:"clientIp"`
+		Current  bool   `json:"current"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &sessions)
+	if w.Code != http.StatusOK || len(sessions) != 3 {
+		t.Fatalf("list sessions: %d %s", w.Code, w.Body.String())
+	}
+	for _, session := range sessions {
+		if session.Current != (session.ID == laptop.SessionID) {
+			t.Errorf("session %s: current = %v", session.ID, session.Current)
+		}
+		if session.ID == phone.SessionID && session.ClientIP == "" {
+			t.Errorf("session %s has no client address", session.ID)
+		}
+	}
+
+	// Revoking the phone's session signs it out at once
+	if w := serveJSON(router, http.MethodDelete, "/api/me/sessions/"+phone.SessionID, refreshed.Token, nil); w.Code != http.StatusOK {
+		t.Fatalf("revoke session: %d %s", w.Code, w.Body.String())
+	}
+	if w := serveJSON(router, http.MethodGet, "/api/me", phone.Token, nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("revoked session's token: expected 401, got %d", w.Code)
+	}
+	if w := serveJSON(router, http.MethodPost, "/api/refresh", "", gin.H{"refreshToken": phone.RefreshToken}); w.Code != http.StatusUnauthorized {
+		t.Errorf("revoked session's refresh token: expected 401, got %d", w.Code)
+	}
+
+	// Logging out revokes the access token before it expires
+	if w := serveJSON(router, http.MethodPost, "/api/logout", refreshed.Token, nil); w.Code != http.StatusOK {
+		t.Fatalf("logout: %d %s", w.Code, w.Body.String())
+	}
+	if w := serveJSON(router, http.MethodGet, "/api/me", refreshed.Token, nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("token after logout: expected 401, got %d", w.Code)
+	}
+
+	// Logging out everywhere ends the remaining sessions
+	first, second := loginTokens(t, router, "alice"), loginTokens(t, router, "alice")
+	w = serveJSON(router, http.MethodPost, "/api/logout/all", first.Token, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("logout everywhere: %d %s", w.Code, w.Body.String())
+	}
+	for _, tokens := range []services.SessionTokens{first, second} {
+		if w := serveJSON(router, http.MethodGet, "/api/me", tokens.Token, nil); w.Code != http.StatusUnauthorized {
+			t.Errorf("token after logging out everywhere: expected 401, got %d", w.Code)
+		}
+	}
+}
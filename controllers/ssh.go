@@ -0,0 +1,92 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"wordpress-collab-tool/models"
+	"wordpress-collab-tool/services"
+	"wordpress-collab-tool/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetSSHPoolStats reports the state of the shared SSH connection pool.
+func GetSSHPoolStats(c *gin.Context) {
+	if services.Pool == nil {
+		c.JSON(http.StatusOK, []models.SSHPoolStats{})
+		return
+	}
+	c.JSON(http.StatusOK, services.Pool.Stats())
+}
+
+// ListHostKeys lists pinned and pending SSH host keys.
+func ListHostKeys(c *gin.Context) {
+	if services.HostKeys == nil {
+		c.JSON(http.StatusOK, gin.H{"mode": services.HostKeyModeStrict, "keys": []models.HostKey{}})
+		return
+	}
+
+	keys, err := services.HostKeys.List()
+	if err != nil {
+		utils.LogError("Failed to list host keys: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list host keys."})
+		return
+	}
+	if keys == nil {
+		keys = []models.HostKey{}
+	}
+	c.JSON(http.StatusOK, gin.H{"mode": services.HostKeys.Mode(), "keys": keys})
+}
+
+// ApproveHostKey pins the pending host key for a host and unblocks it.
+func ApproveHostKey(c *gin.Context) {
+	host := c.Param("host")
+
+	var payload struct {
+		Fingerprint string `json:"fingerprint"`
+	}
+	if err := c.ShouldBindJSON(&payload); err != nil || payload.Fingerprint == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request payload. 'fingerprint' is required."})
+		return
+	}
+
+	if services.HostKeys == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No pending host key for this host."})
+		return
+	}
+
+	key, err := services.HostKeys.Approve(host, payload.Fingerprint)
+	if err != nil {
+		if errors.Is(err, services.ErrNoPendingKey) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "No pending host key for this host."})
+			return
+		}
+		utils.LogError("Failed to approve host key for %s: %v", host, err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	logActivity(c, "security", "hostkey.approve", fmt.Sprintf("Host key %s %s approved for '%s'.", key.Type, key.Fingerprint, key.Host), "")
+	c.JSON(http.StatusOK, key)
+}
+
+// RevokeHostKey removes all pinned host keys for a host.
+func RevokeHostKey(c *gin.Context) {
+	host := c.Param("host")
+
+	if services.HostKeys == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No host keys pinned for this host."})
+		return
+	}
+
+	removed, err := services.HostKeys.Revoke(host)
+	if err != nil {
+		utils.LogError("Failed to revoke host key for %s: %v", host, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke host key."})
+		return
+	}
+
+	logActivity(c, "security", "hostkey.revoke", fmt.Sprintf("Host keys for '%s' revoked.", host), "")
+	c.JSON(http.StatusOK, gin.H{"message": "Host key revoked successfully!", "removed": removed})
+}
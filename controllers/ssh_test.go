@@ -0,0 +1,50 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	"wordpress-collab-tool/models"
+	"wordpress-collab-tool/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestListHostKeysShape(t *testing.T) {
+	original := services.HostKeys
+	t.Cleanup(func() { services.HostKeys = original })
+
+	router := gin.New()
+	router.GET("/api/hostkeys", ListHostKeys)
+
+	store, err := services.NewHostKeyStore(filepath.Join(t.TempDir(), "known_hosts"), services.HostKeyModeTOFU)
+	if err != nil {
+		t.Fatalf("NewHostKeyStore: %v", err)
+	}
+	for _, tc := range []struct {
+		name  string
+		store *services.HostKeyStore
+		mode  string
+	}{
+		{"without a store", nil, services.HostKeyModeStrict},
+		{"with a store", store, services.HostKeyModeTOFU},
+	} {
+		services.HostKeys = tc.store
+		w := serve(router, http.MethodGet, "/api/hostkeys")
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: expected 200, got %d: %s", tc.name, w.Code, w.Body.String())
+		}
+		var body struct {
+			Mode string            `json:"mode"`
+			Keys *[]models.HostKey `json:"keys"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Fatalf("%s: response is not an object: %s", tc.name, w.Body.String())
+		}
+		if body.Mode != tc.mode || body.Keys == nil || len(*body.Keys) != 0 {
+			t.Errorf("%s: unexpected response %s", tc.name, w.Body.String())
+		}
+	}
+}
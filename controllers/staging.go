@@ -0,0 +1,219 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"wordpress-collab-tool/models"
+	"wordpress-collab-tool/services"
+	"wordpress-collab-tool/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CreateStagingSite creates the staging site of a production site as a
+// clone of it, named projectName or "<site>-staging" by default.
+func CreateStagingSite(c *gin.Context) {
+	liveName := c.Param("projectName")
+	if invalidInput(c, services.ValidateProjectName(liveName)) {
+		return
+	}
+	var payload struct {
+		ProjectName string `json:"projectName"`
+	}
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&payload); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request payload."})
+			return
+		}
+	}
+	if payload.ProjectName == "" {
+		payload.ProjectName = liveName + "-staging"
+	}
+	if invalidInput(c, services.ValidateProjectName(payload.ProjectName)) {
+		return
+	}
+
+	live, ok := sourceSite(c, liveName)
+	if !ok {
+		return
+	}
+	if live.StagingOf != "" {
+		c.JSON(http.StatusConflict, gin.H{"error": "A staging site cannot have a staging site of its own."})
+		return
+	}
+	if live.Staging != "" {
+		c.JSON(http.StatusConflict, gin.H{"error": "Site already has a staging site.", "staging": live.Staging})
+		return
+	}
+	queue := queueRequested(c)
+	staging, ok := newClone(c, live, payload.ProjectName, queue)
+	if !ok {
+		return
+	}
+	staging.StagingOf = liveName
+	if !recordSite(c, staging, "site.staging", fmt.Sprintf("Staging site '%s' of site '%s' initiated.", staging.ProjectName, liveName)) {
+		return
+	}
+
+	job, err := services.StartJob(c.Request.Context(), "clone", staging.ProjectName, queue, func(ctx context.Context) error {
+		return reportClone(ctx, live, staging, services.CreateStaging(ctx, live, staging))
+	})
+	if err != nil {
+		services.DropSiteRecord(staging.ProjectName)
+		if siteLocked(c, err) {
+			return
+		}
+		utils.LogError("Failed to start staging job for site '%s': %v", liveName, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start staging site creation."})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Staging site creation initiated successfully!", "projectName": staging.ProjectName, "url": staging.SiteURL, "jobId": job.ID})
+}
+
+// PushStagingSite promotes the staging site of a production site to it:
+// wp-content, the database or both, optionally limited to or sparing some
+// tables. The production site is backed up first.
+func PushStagingSite(c *gin.Context) {
+	liveName := c.Param("projectName")
+	if invalidInput(c, services.ValidateProjectName(liveName)) {
+		return
+	}
+	var opts services.PushOptions
+	if err := c.ShouldBindJSON(&opts); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request payload. 'mode' is required."})
+		return
+	}
+	if invalidInput(c, opts.Validate()) {
+		return
+	}
+
+	live, ok := sourceSite(c, liveName)
+	if !ok {
+		return
+	}
+	if live.Staging == "" {
+		c.JSON(http.StatusConflict, gin.H{"error": "Site has no staging site."})
+		return
+	}
+	staging, err := services.Sites.Get(live.Staging)
+	if err != nil || staging.Status != "active" {
+		c.JSON(http.StatusConflict, gin.H{"error": "Staging site is not active.", "staging": live.Staging})
+		return
+	}
+	if !stagingReadable(c, staging) {
+		return
+	}
+
+	job, err := services.StartJob(c.Request.Context(), "push", liveName, queueRequested(c), func(ctx context.Context) error {
+		push, err := services.PushStaging(ctx, liveName, opts)
+		switch {
+		case errors.Is(err, services.ErrPushRestoreFailed):
+			utils.LogError("Failed to push staging site '%s' to '%s': %v", live.Staging, liveName, err)
+			services.LogActivityContext(ctx, "error", "staging.push", fmt.Sprintf("Push of '%s' to site '%s' failed, and restoring backup '%s' failed too: %v", live.Staging, liveName, push.Backup, err), liveName)
+		case errors.Is(err, services.ErrPushReverted) && errors.Is(err, context.Canceled):
+			services.LogActivityContext(ctx, "warning", "staging.push", fmt.Sprintf("Push of '%s' to site '%s' was cancelled and reverted from backup '%s'.", live.Staging, liveName, push.Backup), liveName)
+		case errors.Is(err, services.ErrPushReverted):
+			utils.LogError("Failed to push staging site '%s' to '%s': %v", live.Staging, liveName, err)
+			services.LogActivityContext(ctx, "error", "staging.push", fmt.Sprintf("Push of '%s' to site '%s' failed and was reverted from backup '%s': %v", live.Staging, liveName, push.Backup, err), liveName)
+		case errors.Is(err, context.Canceled):
+			services.LogActivityContext(ctx, "warning", "staging.push", fmt.Sprintf("Push of '%s' to site '%s' was cancelled.", live.Staging, liveName), liveName)
+		case err != nil && push.Backup != "":
+			utils.LogError("Failed to record the push of staging site '%s' to '%s': %v", live.Staging, liveName, err)
+			services.LogActivityContext(ctx, "error", "staging.push", fmt.Sprintf("Staging site '%s' was pushed to '%s', but the push could not be recorded: %v", live.Staging, liveName, err), liveName)
+		case err != nil:
+			utils.LogError("Failed to push staging site '%s' to '%s': %v", live.Staging, liveName, err)
+			services.LogActivityContext(ctx, "error", "staging.push", fmt.Sprintf("Push of '%s' to site '%s' failed: %v", live.Staging, liveName, err), liveName)
+		default:
+			services.LogActivityContext(ctx, "info", "staging.push", fmt.Sprintf("Staging site '%s' pushed to '%s' (%s); backup '%s' was taken first.", live.Staging, liveName, push.Mode, push.Backup), liveName)
+		}
+		return err
+	})
+	if err != nil {
+		if siteLocked(c, err) {
+			return
+		}
+		utils.LogError("Failed to start push job for site '%s': %v", liveName, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start push."})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Staging push initiated successfully!", "staging": live.Staging, "jobId": job.ID})
+}
+
+// RevertStagingPush restores a production site from the backup taken
+// before the last push of its staging site.
+func RevertStagingPush(c *gin.Context) {
+	liveName := c.Param("projectName")
+	if invalidInput(c, services.ValidateProjectName(liveName)) {
+		return
+	}
+
+	live, err := services.Sites.Get(liveName)
+	if errors.Is(err, services.ErrSiteNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Site not found."})
+		return
+	}
+	if err != nil {
+		utils.LogError("Failed to read site '%s': %v", liveName, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve sites."})
+		return
+	}
+	if live.LastPush == nil || live.LastPush.RevertedAt != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "There is no push to revert."})
+		return
+	}
+	if live.Staging != "" {
+		staging, err := services.Sites.Get(live.Staging)
+		if err == nil && !stagingReadable(c, staging) {
+			return
+		}
+	}
+
+	backupFile := live.LastPush.Backup
+	job, err := services.StartJob(c.Request.Context(), "revert", liveName, queueRequested(c), func(ctx context.Context) error {
+		_, err := services.RevertPush(ctx, liveName)
+		switch {
+		case errors.Is(err, context.Canceled):
+			services.LogActivityContext(ctx, "warning", "staging.revert", fmt.Sprintf("Revert of the last push to site '%s' was cancelled.", liveName), liveName)
+		case err != nil:
+			utils.LogError("Failed to revert the last push to site '%s': %v", liveName, err)
+			services.LogActivityContext(ctx, "error", "staging.revert", fmt.Sprintf("Revert of the last push to site '%s' failed: %v", liveName, err), liveName)
+		default:
+			services.LogActivityContext(ctx, "info", "staging.revert", fmt.Sprintf("Last push to site '%s' reverted from backup '%s'.", liveName, backupFile), liveName)
+		}
+		return err
+	})
+	if err != nil {
+		if siteLocked(c, err) {
+			return
+		}
+		utils.LogError("Failed to start revert job for site '%s': %v", liveName, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start revert."})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Push revert initiated successfully!", "backupFile": backupFile, "jobId": job.ID})
+}
+
+// stagingReadable reports whether the user making the request may read
+// staging, whose contents a push brings to its live site. Access to the
+// live site alone is not enough.
+func stagingReadable(c *gin.Context, staging models.Site) bool {
+	role := c.GetString("role")
+	if role == services.RoleAdmin {
+		return true
+	}
+	if token, ok := apiToken(c); ok && !services.TokenAllows(token, services.PermSitesRead) {
+		denyPermission(c, services.PermSitesRead)
+		return false
+	}
+	siteRole := services.SiteRole(staging, c.GetString("username"))
+	if !services.CanOnSite(role, siteRole, services.PermSitesRead) {
+		denyPermission(c, services.PermSitesRead)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,199 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"wordpress-collab-tool/services"
+	"wordpress-collab-tool/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// LoginTwoFactor completes a sign-in started by Login with a TOTP code or a
+// recovery code, and starts the session.
+func LoginTwoFactor(c *gin.Context) {
+	var payload struct {
+		Challenge string `json:"challenge" binding:"required"`
+		Code      string `json:"code" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request payload. 'challenge' and 'code' are required."})
+		return
+	}
+
+	ctx := c.Request.Context()
+	user, usedRecovery, err := services.CompleteLoginChallenge(ctx, payload.Challenge, payload.Code)
+	var locked *services.UserLockedError
+	var throttled *services.LoginThrottledError
+	switch {
+	case errors.Is(err, services.ErrInvalidChallenge):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Sign-in expired. Enter your password again."})
+		return
+	case errors.As(err, &throttled):
+		respondTooManySignIns(c, gin.H{"retryAt": throttled.Until}, throttled.Until)
+		return
+	case errors.As(err, &locked):
+		services.LogActivityContext(ctx, "warning", "user.login", fmt.Sprintf("Sign-in to locked user '%s' refused.", locked.Username), "")
+		respondTooManySignIns(c, gin.H{"retryAt": locked.Until}, locked.Until)
+		return
+	case errors.Is(err, services.ErrInvalidCode):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid two-factor code."})
+		return
+	case errors.Is(err, services.ErrUserDisabled):
+		c.JSON(http.StatusForbidden, gin.H{"error": "Account is disabled."})
+		return
+	case err != nil:
+		utils.LogError("Failed to complete a login challenge: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not sign in."})
+		return
+	}
+
+	info := services.AuditFrom(ctx)
+	info.Actor = user.Username
+	ctx = services.WithAudit(ctx, info)
+	if usedRecovery {
+		services.LogActivityContext(ctx, "security", "user.2fa.recovery", fmt.Sprintf("User '%s' signed in with a recovery code; %d remain.", user.Username, len(user.TwoFactor.RecoveryCodes)), "")
+	}
+
+	tokens, err := services.StartSession(ctx, user)
+	if err != nil {
+		utils.LogError("Failed to start a session for '%s': %v", user.Username, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not generate token."})
+		return
+	}
+	services.LogActivityContext(ctx, "info", "user.login", fmt.Sprintf("User '%s' signed in with two-factor authentication.", user.Username), "")
+	c.JSON(http.StatusOK, tokenResponse("Login successful!", tokens))
+}
+
+// GetOwnTwoFactor reports whether two-factor authentication is enabled for
+// the signed-in user and how many recovery codes they have left.
+func GetOwnTwoFactor(c *gin.Context) {
+	user, err := services.Users.Get(c.GetString("username"))
+	if err != nil {
+		respondUserError(c, err, "Failed to retrieve user.")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"enabled":                user.TwoFactorEnabled,
+		"pending":                user.TwoFactor.PendingSecret != nil && !user.TwoFactorEnabled,
+		"recoveryCodesRemaining": len(user.TwoFactor.RecoveryCodes),
+	})
+}
+
+// EnrollTOTP starts two-factor enrolment for the signed-in user, who
+// confirms their password. The response carries the secret and the
+// otpauth:// URI to show as a QR code.
+func EnrollTOTP(c *gin.Context) {
+	var payload struct {
+		Password string `json:"password" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request payload. 'password' is required."})
+		return
+	}
+
+	enrollment, err := services.BeginTOTPEnrollment(c.GetString("username"), payload.Password)
+	if err != nil {
+		respondTwoFactorError(c, err, "Failed to start two-factor enrolment.")
+		return
+	}
+	c.JSON(http.StatusOK, enrollment)
+}
+
+// ConfirmTOTP enables two-factor authentication with a code from the
+// newly enrolled app and returns the recovery codes, which are not shown
+// again.
+func ConfirmTOTP(c *gin.Context) {
+	var payload struct {
+		Code string `json:"code" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request payload. 'code' is required."})
+		return
+	}
+
+	username := c.GetString("username")
+	codes, err := services.ConfirmTOTPEnrollment(username, payload.Code)
+	if err != nil {
+		respondTwoFactorError(c, err, "Failed to enable two-factor authentication.")
+		return
+	}
+	logActivity(c, "security", "user.2fa.enable", fmt.Sprintf("User '%s' enabled two-factor authentication.", username), "")
+	c.JSON(http.StatusOK, gin.H{"message": "Two-factor authentication enabled. Store the recovery codes somewhere safe.", "recoveryCodes": codes})
+}
+
+// DisableOwnTwoFactor turns two-factor authentication off for the
+// signed-in user, who confirms their password and a code.
+func DisableOwnTwoFactor(c *gin.Context) {
+	var payload struct {
+		Password string `json:"password" binding:"required"`
+		Code     string `json:"code" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request payload. 'password' and 'code' are required."})
+		return
+	}
+
+	username := c.GetString("username")
+	if _, err := services.DisableTwoFactor(username, payload.Password, payload.Code); err != nil {
+		respondTwoFactorError(c, err, "Failed to disable two-factor authentication.")
+		return
+	}
+	logActivity(c, "security", "user.2fa.disable", fmt.Sprintf("User '%s' disabled two-factor authentication.", username), "")
+	c.JSON(http.StatusOK, gin.H{"message": "Two-factor authentication disabled."})
+}
+
+// RegenerateRecoveryCodes replaces the signed-in user's recovery codes
+// after checking a code.
+func RegenerateRecoveryCodes(c *gin.Context) {
+	var payload struct {
+		Code string `json:"code" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request payload. 'code' is required."})
+		return
+	}
+
+	username := c.GetString("username")
+	codes, err := services.RegenerateRecoveryCodes(username, payload.Code)
+	if err != nil {
+		respondTwoFactorError(c, err, "Failed to generate recovery codes.")
+		return
+	}
+	logActivity(c, "security", "user.2fa.recovery_codes", fmt.Sprintf("User '%s' generated new recovery codes.", username), "")
+	c.JSON(http.StatusOK, gin.H{"recoveryCodes": codes})
+}
+
+// ResetUserTwoFactor turns two-factor authentication off for a user who
+// lost access to their authenticator app.
+func ResetUserTwoFactor(c *gin.Context) {
+	username := c.Param("username")
+	if _, err := services.ResetTwoFactor(username); err != nil {
+		respondTwoFactorError(c, err, "Failed to reset two-factor authentication.")
+		return
+	}
+	logActivity(c, "security", "user.2fa.reset", fmt.Sprintf("Two-factor authentication of user '%s' reset.", username), "")
+	c.JSON(http.StatusOK, gin.H{"message": "Two-factor authentication reset."})
+}
+
+// respondTwoFactorError maps two-factor errors to HTTP responses.
+func respondTwoFactorError(c *gin.Context, err error, fallback string) {
+	switch {
+	case errors.Is(err, services.ErrInvalidCredentials):
+		c.JSON(http.StatusForbidden, gin.H{"error": "Password is incorrect."})
+	case errors.Is(err, services.ErrInvalidCode):
+		c.JSON(http.StatusForbidden, gin.H{"error": "Invalid two-factor code."})
+	case errors.Is(err, services.ErrTwoFactorEnabled):
+		c.JSON(http.StatusConflict, gin.H{"error": "Two-factor authentication is already enabled."})
+	case errors.Is(err, services.ErrTwoFactorNotEnabled):
+		c.JSON(http.StatusConflict, gin.H{"error": "Two-factor authentication is not enabled."})
+	case errors.Is(err, services.ErrNoPendingEnrollment):
+		c.JSON(http.StatusConflict, gin.H{"error": "Start the enrolment first."})
+	case errors.Is(err, services.ErrUserNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found."})
+	default:
+		utils.LogError("%s %v", fallback, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fallback})
+	}
+}
@@ -0,0 +1,99 @@
+package controllers
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"wordpress-collab-tool/internal/fakes"
+	"wordpress-collab-tool/models"
+	"wordpress-collab-tool/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// totpAt computes the code an authenticator app shows for secret, offset
+// steps from now.
+func totpAt(secret string, offset int64) string {
+	key, _ := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(time.Now().Unix()/30+offset))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+	offsetByte := sum[len(sum)-1] & 0x0f
+	return fmt.Sprintf("%06d", binary.BigEndian.Uint32(sum[offsetByte:offsetByte+4])&0x7fffffff%1000000)
+}
+
+func TestTwoFactorLogin(t *testing.T) {
+	setupSite(t, fakes.NewExecutor())
+	session := withUser(t, "gina", services.RoleEditor)
+	admin := withUser(t, "root", services.RoleAdmin)
+
+	router := gin.New()
+	router.POST("/api/login", Login)
+	router.POST("/api/login/2fa", LoginTwoFactor)
+	api := router.Group("/api", AuthMiddleware())
+	api.GET("/me/2fa", RequireSession(), GetOwnTwoFactor)
+	api.POST("/me/2fa/totp", RequireSession(), EnrollTOTP)
+	api.POST("/me/2fa/totp/confirm", RequireSession(), ConfirmTOTP)
+	api.DELETE("/users/:username/2fa", RequirePermission(services.PermUsersWrite), ResetUserTwoFactor)
+
+	if w := serveJSON(router, http.MethodPost, "/api/me/2fa/totp", session, gin.H{"password": "wrong"}); w.Code != http.StatusForbidden {
+		t.Errorf("enrol with a wrong password: expected 403, got %d", w.Code)
+	}
+	w := serveJSON(router, http.MethodPost, "/api/me/2fa/totp", session, gin.H{"password": testPassword})
+	var enrollment services.TOTPEnrollment
+	json.Unmarshal(w.Body.Bytes(), &enrollment)
+	if w.Code != http.StatusOK || enrollment.Secret == "" || enrollment.URI == "" {
+		t.Fatalf("enrol: %d %s", w.Code, w.Body.String())
+	}
+	w = serveJSON(router, http.MethodPost, "/api/me/2fa/totp/confirm", session, gin.H{"code": totpAt(enrollment.Secret, 0)})
+	var confirmed struct {
+		RecoveryCodes []string `json:"recoveryCodes"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &confirmed)
+	if w.Code != http.StatusOK || len(confirmed.RecoveryCodes) == 0 {
+		t.Fatalf("confirm: %d %s", w.Code, w.Body.String())
+	}
+
+	// The password alone answers with a challenge, not a token
+	w = serveJSON(router, http.MethodPost, "/api/login", "", models.Credentials{Username: "gina", Password: testPassword})
+	var challenge struct {
+		Token             string `json:"token"`
+		TwoFactorRequired bool   `json:"twoFactorRequired"`
+		Challenge         string `json:"challenge"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &challenge)
+	if w.Code != http.StatusOK || !challenge.TwoFactorRequired || challenge.Challenge == "" || challenge.Token != "" {
+		t.Fatalf("first step: %d %s", w.Code, w.Body.String())
+	}
+	if w := serveJSON(router, http.MethodPost, "/api/login/2fa", "", gin.H{"challenge": challenge.Challenge, "code": "000000"}); w.Code != http.StatusUnauthorized {
+		t.Errorf("wrong code: expected 401, got %d", w.Code)
+	}
+	w = serveJSON(router, http.MethodPost, "/api/login/2fa", "", gin.H{"challenge": challenge.Challenge, "code": totpAt(enrollment.Secret, 1)})
+	var tokens struct {
+		Token string `json:"token"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &tokens)
+	if w.Code != http.StatusOK || tokens.Token == "" {
+		t.Fatalf("second step: %d %s", w.Code, w.Body.String())
+	}
+	if w := serveJSON(router, http.MethodGet, "/api/me/2fa", tokens.Token, nil); w.Code != http.StatusOK {
+		t.Errorf("session from the second step: %d %s", w.Code, w.Body.String())
+	}
+
+	// An admin reset lets the user in with the password again
+	if w := serveJSON(router, http.MethodDelete, "/api/users/gina/2fa", admin, nil); w.Code != http.StatusOK {
+		t.Fatalf("reset: %d %s", w.Code, w.Body.String())
+	}
+	if token, code := login(t, router, "gina", testPassword); code != http.StatusOK || token == "" {
+		t.Errorf("sign-in after reset: %d", code)
+	}
+}
@@ -0,0 +1,262 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"wordpress-collab-tool/models"
+	"wordpress-collab-tool/services"
+	"wordpress-collab-tool/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ListUsers lists every user account.
+func ListUsers(c *gin.Context) {
+	users, err := services.Users.List()
+	if err != nil {
+		utils.LogError("Failed to list users: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve users."})
+		return
+	}
+	c.JSON(http.StatusOK, users)
+}
+
+// GetUser retrieves a single user account.
+func GetUser(c *gin.Context) {
+	user, err := services.Users.Get(c.Param("username"))
+	if err != nil {
+		respondUserError(c, err, "Failed to retrieve user.")
+		return
+	}
+	c.JSON(http.StatusOK, user)
+}
+
+// GetCurrentUser retrieves the account of the signed-in user.
+func GetCurrentUser(c *gin.Context) {
+	user, err := services.Users.Get(c.GetString("username"))
+	if err != nil {
+		respondUserError(c, err, "Failed to retrieve user.")
+		return
+	}
+	c.JSON(http.StatusOK, user)
+}
+
+// CreateUser creates a user account. The role defaults to viewer.
+func CreateUser(c *gin.Context) {
+	var payload struct {
+		models.Credentials
+		Role string `json:"role"`
+	}
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if payload.Role == "" {
+		payload.Role = services.RoleViewer
+	}
+
+	user, err := services.CreateUser(payload.Username, payload.Password, payload.Role)
+	if err != nil {
+		respondUserError(c, err, "Failed to save user.")
+		return
+	}
+
+	services.RecordActivity(c.Request.Context(), models.Activity{
+		Level:   "security",
+		Action:  "user.create",
+		Message: fmt.Sprintf("User '%s' created.", user.Username),
+		After:   services.AuditDetails(user),
+	})
+	c.JSON(http.StatusCreated, user)
+}
+
+// DeleteUser removes a user account. Users cannot delete themselves.
+func DeleteUser(c *gin.Context) {
+	username := c.Param("username")
+	if username == c.GetString("username") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "You cannot delete your own account."})
+		return
+	}
+
+	before, err := services.Users.Get(username)
+	if err == nil {
+		err = services.DeleteUser(username)
+	}
+	if err != nil {
+		respondUserError(c, err, "Failed to delete user.")
+		return
+	}
+
+	services.RecordActivity(c.Request.Context(), models.Activity{
+		Level:   "security",
+		Action:  "user.delete",
+		Message: fmt.Sprintf("User '%s' deleted.", username),
+		Before:  services.AuditDetails(before),
+	})
+	c.JSON(http.StatusOK, gin.H{"message": "User deleted successfully!"})
+}
+
+// DisableUser disables a user account and signs it out. Users cannot
+// disable themselves.
+func DisableUser(c *gin.Context) {
+	setUserDisabled(c, true)
+}
+
+// EnableUser re-enables a disabled user account.
+func EnableUser(c *gin.Context) {
+	setUserDisabled(c, false)
+}
+
+func setUserDisabled(c *gin.Context, disabled bool) {
+	username := c.Param("username")
+	if disabled && username == c.GetString("username") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "You cannot disable your own account."})
+		return
+	}
+
+	user, err := services.SetUserDisabled(username, disabled)
+	if err != nil {
+		respondUserError(c, err, "Failed to save user.")
+		return
+	}
+
+	action, verb := "user.enable", "enabled"
+	if disabled {
+		action, verb = "user.disable", "disabled"
+	}
+	logActivity(c, "security", action, fmt.Sprintf("User '%s' %s.", username, verb), "")
+	c.JSON(http.StatusOK, user)
+}
+
+// SetUserRole changes the role of a user. Users cannot change their own
+// role.
+func SetUserRole(c *gin.Context) {
+	var payload struct {
+		Role string `json:"role" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request payload. 'role' is required."})
+		return
+	}
+
+	username := c.Param("username")
+	if username == c.GetString("username") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "You cannot change your own role."})
+		return
+	}
+
+	before, err := services.Users.Get(username)
+	if err != nil {
+		respondUserError(c, err, "Failed to save user.")
+		return
+	}
+	user, err := services.SetUserRole(username, payload.Role)
+	if err != nil {
+		respondUserError(c, err, "Failed to save user.")
+		return
+	}
+
+	services.RecordActivity(c.Request.Context(), models.Activity{
+		Level:   "security",
+		Action:  "user.role",
+		Message: fmt.Sprintf("Role of user '%s' changed from %s to %s.", username, before.Role, user.Role),
+		Before:  services.AuditDetails(gin.H{"role": before.Role}),
+		After:   services.AuditDetails(gin.H{"role": user.Role}),
+	})
+	c.JSON(http.StatusOK, user)
+}
+
+// ListRoles lists the roles and the permissions each one grants.
+func ListRoles(c *gin.Context) {
+	roles := []gin.H{}
+	for _, role := range []string{services.RoleViewer, services.RoleEditor, services.RoleMaintainer, services.RoleAdmin} {
+		roles = append(roles, gin.H{"role": role, "permissions": services.RolePermissions(role)})
+	}
+	c.JSON(http.StatusOK, roles)
+}
+
+// GetCurrentPermissions lists the permissions of the signed-in user. For
+// a personal access token, they are limited to its scopes.
+func GetCurrentPermissions(c *gin.Context) {
+	role := c.GetString("role")
+	permissions := services.RolePermissions(role)
+	if token, ok := apiToken(c); ok {
+		permissions = slices.DeleteFunc(permissions, func(p services.Permission) bool { return !services.TokenAllows(token, p) })
+	}
+	c.JSON(http.StatusOK, gin.H{"role": role, "permissions": permissions})
+}
+
+// ResetUserPassword sets a new password for a user, unlocks the account and
+// signs it out everywhere.
+func ResetUserPassword(c *gin.Context) {
+	var payload struct {
+		Password string `json:"password" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request payload. 'password' is required."})
+		return
+	}
+
+	username := c.Param("username")
+	if _, err := services.SetPassword(username, payload.Password); err != nil {
+		respondUserError(c, err, "Failed to save user.")
+		return
+	}
+
+	logActivity(c, "security", "user.password.reset", fmt.Sprintf("Password of user '%s' reset.", username), "")
+	c.JSON(http.StatusOK, gin.H{"message": "Password reset successfully!"})
+}
+
+// ChangeOwnPassword changes the password of the signed-in user after
+// checking the current one. Every session ends; the response carries the
+// tokens of a new one for this client.
+func ChangeOwnPassword(c *gin.Context) {
+	var payload struct {
+		CurrentPassword string `json:"currentPassword" binding:"required"`
+		NewPassword     string `json:"newPassword" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request payload. 'currentPassword' and 'newPassword' are required."})
+		return
+	}
+
+	username := c.GetString("username")
+	user, err := services.ChangePassword(username, payload.CurrentPassword, payload.NewPassword)
+	if errors.Is(err, services.ErrInvalidCredentials) {
+		logActivity(c, "warning", "user.password.change", fmt.Sprintf("User '%s' gave a wrong current password.", username), "")
+		c.JSON(http.StatusForbidden, gin.H{"error": "Current password is incorrect."})
+		return
+	}
+	if err != nil {
+		respondUserError(c, err, "Failed to save user.")
+		return
+	}
+
+	tokens, err := services.StartSession(c.Request.Context(), user)
+	if err != nil {
+		utils.LogError("Failed to start a session for '%s': %v", username, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not generate token."})
+		return
+	}
+	logActivity(c, "security", "user.password.change", fmt.Sprintf("User '%s' changed their password.", username), "")
+	c.JSON(http.StatusOK, tokenResponse("Password changed successfully!", tokens))
+}
+
+// respondUserError maps user store errors to HTTP responses.
+func respondUserError(c *gin.Context, err error, fallback string) {
+	switch {
+	case errors.Is(err, services.ErrUserNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found."})
+	case errors.Is(err, services.ErrUserExists):
+		c.JSON(http.StatusConflict, gin.H{"error": "A user with this name already exists."})
+	case errors.Is(err, services.ErrUserOwnsSites):
+		c.JSON(http.StatusConflict, gin.H{"error": "User still owns sites. Transfer them first.", "details": err.Error()})
+	case errors.Is(err, services.ErrInvalidInput):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input.", "details": err.Error()})
+	default:
+		utils.LogError("%s %v", fallback, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fallback})
+	}
+}
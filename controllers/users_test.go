@@ -0,0 +1,249 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"wordpress-collab-tool/config"
+	"wordpress-collab-tool/internal/fakes"
+	"wordpress-collab-tool/models"
+	"wordpress-collab-tool/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// testPassword is the password of the accounts created by withUser.
+const testPassword = "correct horse battery"
+
+// withUser points the user, session and token stores at a fresh database,
+// creates username in it with role and returns a token for it.
+func withUser(t *testing.T, username, role string) string {
+	t.Helper()
+	if services.Users == nil {
+		path := filepath.Join(t.TempDir(), "users.db")
+		store, err := services.OpenSQLiteUserStore(path)
+		if err != nil {
+			t.Fatalf("OpenSQLiteUserStore: %v", err)
+		}
+		sessions, err := services.OpenSQLiteSessionStore(path)
+		if err != nil {
+			t.Fatalf("OpenSQLiteSessionStore: %v", err)
+		}
+		apiTokens, err := services.OpenSQLiteAPITokenStore(path)
+		if err != nil {
+			t.Fatalf("OpenSQLiteAPITokenStore: %v", err)
+		}
+		services.Users, services.Sessions, services.APITokens = store, sessions, apiTokens
+		services.JWTKey = []byte("test signing key")
+		key := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{7}, 32))
+		if err := services.InitTwoFactor(&config.Config{SecretsKey: key, TOTPIssuer: "Test"}); err != nil {
+			t.Fatalf("InitTwoFactor: %v", err)
+		}
+		t.Cleanup(func() {
+			services.Users, services.Sessions, services.APITokens = nil, nil, nil
+			store.Close()
+			sessions.Close()
+			apiTokens.Close()
+		})
+	}
+	user, err := services.CreateUser(username, testPassword, role)
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	tokens, err := services.StartSession(context.Background(), user)
+	if err != nil {
+		t.Fatalf("StartSession: %v", err)
+	}
+	return tokens.Token
+}
+
+func newUserRouter() *gin.Engine {
+	router := gin.New()
+	router.POST("/api/login", Login)
+	api := router.Group("/api", AuthMiddleware())
+	api.GET("/me", GetCurrentUser)
+	api.PUT("/me/password", ChangeOwnPassword)
+	api.POST("/users", CreateUser)
+	api.DELETE("/users/:username", DeleteUser)
+	api.POST("/users/:username/disable", DisableUser)
+	api.POST("/users/:username/password", ResetUserPassword)
+	return router
+}
+
+func serveJSON(router *gin.Engine, method, path, token string, body any) *httptest.ResponseRecorder {
+	data, _ := json.Marshal(body)
+	req := httptest.NewRequest(method, path, bytes.NewReader(data))
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func login(t *testing.T, router *gin.Engine, username, password string) (string, int) {
+	t.Helper()
+	w := serveJSON(router, http.MethodPost, "/api/login", "", models.Credentials{Username: username, Password: password})
+	var response struct {
+		Token string `json:"token"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &response)
+	return response.Token, w.Code
+}
+
+func TestLoginAndUserManagement(t *testing.T) {
+	setupSite(t, fakes.NewExecutor())
+	adminToken := withUser(t, "admin", services.RoleAdmin)
+	router := newUserRouter()
+
+	if _, code := login(t, router, "admin", "password"); code != http.StatusUnauthorized {
+		t.Errorf("old hardcoded password: expected 401, got %d", code)
+	}
+	if _, code := login(t, router, "nobody", testPassword); code != http.StatusUnauthorized {
+		t.Errorf("unknown user: expected 401, got %d", code)
+	}
+
+	w := serveJSON(router, http.MethodPost, "/api/users", adminToken, models.Credentials{Username: "bob", Password: "short"})
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("weak password: expected 400, got %d: %s", w.Code, w.Body.String())
+	}
+	w = serveJSON(router, http.MethodPost, "/api/users", adminToken, models.Credentials{Username: "bob", Password: "bob's first password"})
+	if w.Code != http.StatusCreated || bytes.Contains(w.Body.Bytes(), []byte("$2a$")) {
+		t.Fatalf("create: %d %s", w.Code, w.Body.String())
+	}
+
+	bobToken, code := login(t, router, "bob", "bob's first password")
+	if code != http.StatusOK || bobToken == "" {
+		t.Fatalf("bob could not sign in: %d", code)
+	}
+
+	// Changing the password signs out the old token and issues a new one
+	w = serveJSON(router, http.MethodPut, "/api/me/password", bobToken, gin.H{"currentPassword": "wrong", "newPassword": "bob's second password"})
+	if w.Code != http.StatusForbidden {
+		t.Errorf("wrong current password: expected 403, got %d", w.Code)
+	}
+	w = serveJSON(router, http.MethodPut, "/api/me/password", bobToken, gin.H{"currentPassword": "bob's first password", "newPassword": "bob's second password"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("change password: %d %s", w.Code, w.Body.String())
+	}
+	var changed struct {
+		Token string `json:"token"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &changed)
+	if w := serveJSON(router, http.MethodGet, "/api/me", bobToken, nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("token from before the change: expected 401, got %d", w.Code)
+	}
+	if w := serveJSON(router, http.MethodGet, "/api/me", changed.Token, nil); w.Code != http.StatusOK {
+		t.Errorf("new token: expected 200, got %d", w.Code)
+	}
+
+	// Disabling signs the user out and refuses new sign-ins
+	if w := serveJSON(router, http.MethodPost, "/api/users/admin/disable", adminToken, nil); w.Code != http.StatusBadRequest {
+		t.Errorf("self-disable: expected 400, got %d", w.Code)
+	}
+	if w := serveJSON(router, http.MethodPost, "/api/users/bob/disable", adminToken, nil); w.Code != http.StatusOK {
+		t.Fatalf("disable: %d %s", w.Code, w.Body.String())
+	}
+	if w := serveJSON(router, http.MethodGet, "/api/me", changed.Token, nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("disabled user's token: expected 401, got %d", w.Code)
+	}
+	if _, code := login(t, router, "bob", "bob's second password"); code != http.StatusForbidden {
+		t.Errorf("disabled user: expected 403, got %d", code)
+	}
+
+	if w := serveJSON(router, http.MethodDelete, "/api/users/bob", adminToken, nil); w.Code != http.StatusOK {
+		t.Errorf("delete: %d %s", w.Code, w.Body.String())
+	}
+	if w := serveJSON(router, http.MethodDelete, "/api/users/bob", adminToken, nil); w.Code != http.StatusNotFound {
+		t.Errorf("delete again: expected 404, got %d", w.Code)
+	}
+}
+
+func TestLoginLockout(t *testing.T) {
+	setupSite(t, fakes.NewExecutor())
+	adminToken := withUser(t, "admin", services.RoleAdmin)
+	withUser(t, "carol", services.RoleViewer)
+	withLoginThrottle(t, config.Config{})
+	router := newUserRouter()
+
+	for i := 0; i < 5; i++ {
+		if _, code := login(t, router, "carol", "wrong password"); code != http.StatusUnauthorized {
+			t.Fatalf("attempt %d: expected 401, got %d", i+1, code)
+		}
+	}
+	// A locked account answers like a throttled caller, whatever the password
+	for _, password := range []string{testPassword, "wrong password"} {
+		w := serveJSON(router, http.MethodPost, "/api/login", "", models.Credentials{Username: "carol", Password: password})
+		var body map[string]any
+		json.Unmarshal(w.Body.Bytes(), &body)
+		if _, ok := body["lockedUntil"]; w.Code != http.StatusTooManyRequests || body["retryAt"] == nil || ok {
+			t.Fatalf("locked account: expected 429 with retryAt, got %d %s", w.Code, w.Body.String())
+		}
+	}
+	activities, _, _ := services.Activities.Query(services.ActivityQuery{Action: "user.lock"})
+	if len(activities) != 1 || activities[0].Actor != "carol" {
+		t.Errorf("lockout was not logged: %+v", activities)
+	}
+
+	// An admin password reset unlocks the account
+	if w := serveJSON(router, http.MethodPost, "/api/users/carol/password", adminToken, gin.H{"password": "carol's new password"}); w.Code != http.StatusOK {
+		t.Fatalf("reset: %d %s", w.Code, w.Body.String())
+	}
+	if _, code := login(t, router, "carol", "carol's new password"); code != http.StatusOK {
+		t.Errorf("after reset: expected 200, got %d", code)
+	}
+}
+
+func TestRoutesRequirePermissions(t *testing.T) {
+	setupSite(t, fakes.NewExecutor())
+	viewer := withUser(t, "vera", services.RoleViewer)
+	editor := withUser(t, "ed", services.RoleEditor)
+	admin := withUser(t, "ada", services.RoleAdmin)
+	services.UpdateSite("blog", func(s *models.Site) {
+		s.Members = []models.SiteMember{{Username: "vera", Role: services.RoleEditor}, {Username: "ed", Role: services.RoleEditor}}
+	})
+
+	router := gin.New()
+	api := router.Group("/api", AuthMiddleware())
+	api.GET("/sites/:projectName/plugins", RequirePermission(services.PermPluginsRead), GetSitePlugins)
+	api.POST("/sites/:projectName/plugins/:pluginName", RequirePermission(services.PermPluginsWrite), InstallPlugin)
+	api.PUT("/users/:username/role", RequirePermission(services.PermUsersWrite), SetUserRole)
+	api.GET("/me/permissions", GetCurrentPermissions)
+
+	w := serveJSON(router, http.MethodPost, "/api/sites/blog/plugins/akismet", viewer, nil)
+	if w.Code != http.StatusForbidden || !bytes.Contains(w.Body.Bytes(), []byte(`"permission":"plugins:write"`)) {
+		t.Fatalf("viewer installing a plugin: %d %s", w.Code, w.Body.String())
+	}
+	if w := serveJSON(router, http.MethodGet, "/api/sites/blog/plugins", viewer, nil); w.Code == http.StatusForbidden {
+		t.Errorf("viewer listing plugins was refused: %s", w.Body.String())
+	}
+	if w := serveJSON(router, http.MethodPost, "/api/sites/blog/plugins/akismet", editor, nil); w.Code != http.StatusOK {
+		t.Errorf("editor installing a plugin: %d %s", w.Code, w.Body.String())
+	}
+	denied, _, _ := services.Activities.Query(services.ActivityQuery{Action: "access.denied"})
+	if len(denied) != 1 || denied[0].Actor != "vera" || denied[0].ProjectName != "blog" {
+		t.Errorf("denial was not logged: %+v", denied)
+	}
+
+	// A new role applies to tokens issued before the change
+	if w := serveJSON(router, http.MethodPut, "/api/users/vera/role", admin, gin.H{"role": "owner"}); w.Code != http.StatusBadRequest {
+		t.Errorf("unknown role: expected 400, got %d", w.Code)
+	}
+	if w := serveJSON(router, http.MethodPut, "/api/users/vera/role", admin, gin.H{"role": services.RoleEditor}); w.Code != http.StatusOK {
+		t.Fatalf("set role: %d %s", w.Code, w.Body.String())
+	}
+	if w := serveJSON(router, http.MethodPost, "/api/sites/blog/plugins/akismet", viewer, nil); w.Code != http.StatusOK {
+		t.Errorf("promoted user installing a plugin: %d %s", w.Code, w.Body.String())
+	}
+	w = serveJSON(router, http.MethodGet, "/api/me/permissions", viewer, nil)
+	if !bytes.Contains(w.Body.Bytes(), []byte(`"role":"editor"`)) {
+		t.Errorf("unexpected permissions: %s", w.Body.String())
+	}
+}
@@ -1,93 +1,101 @@
 package controllers
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
 	"math/rand"
 	"net/http"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
-	"wordpress-collab-tool/config" // Import config
 	"wordpress-collab-tool/models"
 	"wordpress-collab-tool/services"
 	"wordpress-collab-tool/utils"
 
-	"github.com/dgrijalva/jwt-go"
 	"github.com/gin-gonic/gin"
 )
 
-var jwtKey = []byte("your_secret_key") // TODO: Load from config/environment variable
+// invalidInput responds with 400 Bad Request when err is a validation failure
+// and reports whether it did.
+func invalidInput(c *gin.Context, err error) bool {
+	if err == nil {
+		return false
+	}
+	c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input.", "details": err.Error()})
+	return true
+}
 
-// Login handles user login and JWT token generation.
-func Login(c *gin.Context) {
-	var user models.User
-	if err := c.ShouldBindJSON(&user); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-		return
+// timedOut responds with 504 Gateway Timeout when err comes from a remote
+// operation that ran out of time and reports whether it did.
+func timedOut(c *gin.Context, err error) bool {
+	if !errors.Is(err, context.DeadlineExceeded) {
+		return false
 	}
+	c.JSON(http.StatusGatewayTimeout, gin.H{"error": "Remote operation timed out."})
+	return true
+}
 
-	// For now, a simple hardcoded check. Replace with proper authentication.
-	if user.Username != "admin" || user.Password != "password" {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials."})
-		return
+// siteLocked responds with 409 Conflict when err reports that another
+// operation holds the site's lock and reports whether it did.
+func siteLocked(c *gin.Context, err error) bool {
+	var locked *services.SiteLockedError
+	if !errors.As(err, &locked) {
+		return false
 	}
-	expirationTime := time.Now().Add(8 * time.Hour)
-	claims := &models.Claims{
-		Username: user.Username,
-		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: expirationTime.Unix(),
-		},
+	response := gin.H{
+		"error":     "Another operation is running on this site.",
+		"operation": locked.Holder.Operation,
+		"since":     locked.Holder.Since,
 	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString(jwtKey)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not generate token."})
-		return
+	if locked.Holder.JobID != "" {
+		response["jobId"] = locked.Holder.JobID
 	}
-	c.JSON(http.StatusOK, gin.H{"message": "Login successful!", "token": tokenString})
+	c.JSON(http.StatusConflict, response)
+	return true
 }
 
-// Logout handles user logout (client-side token removal).
-func Logout(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{"message": "Logout successful!"})
+// queueRequested reports whether an operation on a busy site should wait for
+// the site instead of being rejected. The queue query parameter overrides the
+// configured default.
+func queueRequested(c *gin.Context) bool {
+	if queue, err := strconv.ParseBool(c.Query("queue")); err == nil {
+		return queue
+	}
+	return services.QueueByDefault()
 }
 
-// AuthMiddleware authenticates requests using JWT.
-func AuthMiddleware() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		tokenString := c.GetHeader("Authorization")
-		if tokenString == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization header required"})
-			c.Abort()
-			return
-		}
-
-		tokenString = strings.TrimPrefix(tokenString, "Bearer ") // Remove "Bearer " prefix
-
-		claims := &models.Claims{}
-
-		token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-			return jwtKey, nil
-		})
-
-		if err != nil || !token.Valid {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
-			c.Abort()
-			return
+// lockSite takes the lock of a site for a synchronous operation. If the site
+// is busy it responds with 409 Conflict, or waits for it when queueing was
+// requested. The returned function releases the lock; it is nil when a
+// response has already been written.
+func lockSite(c *gin.Context, projectName, operation string) func() {
+	var unlock func()
+	var err error
+	if queueRequested(c) {
+		unlock, err = services.LockSite(c.Request.Context(), projectName, operation, "")
+	} else {
+		unlock, err = services.TryLockSite(projectName, operation, "")
+	}
+	if err != nil {
+		if !siteLocked(c, err) {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Request ended while waiting for the site to become available."})
 		}
-
-		c.Next()
+		return nil
 	}
+	return unlock
 }
 
-func generateUniquePort(sites []models.Site, minPort, maxPort int) int {
+// generateUniquePort picks a port that is not used by any site on the same host.
+func generateUniquePort(sites []models.Site, hostName string, minPort, maxPort int) int {
 	rand.Seed(time.Now().UnixNano())
 	for {
 		port := rand.Intn(maxPort-minPort+1) + minPort
 		isUsed := false
 		for _, site := range sites {
-			if site.WPPort == port {
+			sameHost := site.Host == hostName || (site.Host == "" && hostName == services.DefaultHostName)
+			if sameHost && site.WPPort == port {
 				isUsed = true
 				break
 			}
@@ -115,65 +123,213 @@ func CreateWordPressSite(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Project name, admin username, and admin password are required."})
 		return
 	}
+	if invalidInput(c, services.ValidateProjectName(projectName)) ||
+		invalidInput(c, services.ValidateAdminUsername(adminUsername)) ||
+		invalidInput(c, services.ValidateAdminPassword(adminPassword)) {
+		return
+	}
+	for _, plugin := range selectedPlugins {
+		if invalidInput(c, services.ValidatePluginSlug(plugin)) {
+			return
+		}
+	}
+
+	queue := queueRequested(c)
+	newSite, ok := placeSite(c, projectName, c.Request.FormValue("host"), queue)
+	if !ok {
+		return
+	}
+	newSite.Plugins = selectedPlugins
+	newSite.AdminUsername = adminUsername
+	newSite.AdminPassword = adminPassword
+	// Keep what a failed deployment created, to debug it and retry
+	if retain, _ := strconv.ParseBool(c.Request.FormValue("retainOnFailure")); retain {
+		newSite.Deployment = &models.Deployment{RetainOnFailure: true}
+	}
+	if !recordSite(c, newSite, "site.create", fmt.Sprintf("Site '%s' creation initiated.", projectName)) {
+		return
+	}
 
-	sites, err := services.ReadSites()
+	job, err := services.StartJob(c.Request.Context(), "deploy", projectName, queue, func(ctx context.Context) error {
+		err := services.DeployWordPressSite(ctx, newSite, newSite.Plugins, newSite.AdminUsername, newSite.AdminPassword)
+		return reportDeployment(ctx, projectName, err)
+	})
 	if err != nil {
-		utils.LogError("Failed to read sites: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save site information."})
+		services.DropSiteRecord(projectName)
+		if siteLocked(c, err) {
+			return
+		}
+		utils.LogError("Failed to start deployment job for site '%s': %v", projectName, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start deployment."})
 		return
 	}
 
-	wpPort := generateUniquePort(sites, 8100, 9000)
+	c.JSON(http.StatusOK, gin.H{"message": "WordPress deployment initiated successfully!", "url": newSite.SiteURL, "host": newSite.Host, "jobId": job.ID})
+}
 
-	dbName := fmt.Sprintf("%s_db", projectName)
-	dbPassword := services.GenerateRandomPassword(16)
+// placeSite prepares the record of a new site on the named host, or on the
+// least-loaded one, with its own port and database credentials. It
+// responds and returns false when the site cannot be placed.
+func placeSite(c *gin.Context, projectName, hostName string, queue bool) (models.Site, bool) {
+	// Refuse early when the name is still busy, e.g. with the deletion of an
+	// earlier site, rather than recording a site that cannot be deployed yet
+	if holder, locked := services.SiteLockState(projectName); locked && !queue {
+		siteLocked(c, &services.SiteLockedError{ProjectName: projectName, Holder: holder})
+		return models.Site{}, false
+	}
 
-	newSite := models.Site{
-		ProjectName:   projectName,
-		WPPort:        wpPort,
-		DBName:        dbName,
-		DBPassword:    dbPassword,
-		SiteURL:       fmt.Sprintf("http://%s:%d", config.LoadConfig().SSHHost, wpPort), // Use SSHHost from config
-		Plugins:       selectedPlugins,
-		Status:        "creating",
-		AdminUsername: adminUsername,
-		AdminPassword: adminPassword,
+	sites, err := services.Sites.List()
+	if err != nil {
+		utils.LogError("Failed to list sites: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save site information."})
+		return models.Site{}, false
 	}
 
-	sites = append(sites, newSite)
+	host, err := services.PickHost(hostName, sites)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrHostNotFound):
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Host not found."})
+		case errors.Is(err, services.ErrNoHostCapacity):
+			c.JSON(http.StatusConflict, gin.H{"error": "No host has capacity for a new site.", "details": err.Error()})
+		default:
+			utils.LogError("Failed to pick host: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve hosts."})
+		}
+		return models.Site{}, false
+	}
+
+	wpPort := generateUniquePort(sites, host.Name, 8100, 9000)
+	return models.Site{
+		ProjectName: projectName,
+		WPPort:      wpPort,
+		DBName:      fmt.Sprintf("%s_db", projectName),
+		DBPassword:  services.GenerateRandomPassword(16),
+		SiteURL:     fmt.Sprintf("http://%s:%d", host.Address, wpPort),
+		Status:      "creating",
+		Host:        host.Name,
+		Owner:       c.GetString("username"),
+	}, true
+}
 
-	if err := services.WriteSites(sites); err != nil {
-		utils.LogError("Failed to write sites: %v", err)
+// recordSite stores the record of a new site and records the activity. It
+// responds and returns false when the site cannot be stored.
+func recordSite(c *gin.Context, site models.Site, action, message string) bool {
+	if _, err := services.Sites.Create(site); err != nil {
+		if errors.Is(err, services.ErrSiteExists) {
+			c.JSON(http.StatusConflict, gin.H{"error": "A site with this name already exists."})
+			return false
+		}
+		utils.LogError("Failed to create site: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save site information."})
+		return false
+	}
+	services.RecordActivity(c.Request.Context(), models.Activity{
+		Level:       "info",
+		Action:      action,
+		Message:     message,
+		ProjectName: site.ProjectName,
+		After:       services.AuditDetails(site.Redacted()),
+	})
+	return true
+}
+
+// reportDeployment logs the outcome of a deployment job and returns its
+// error. The deployment itself marks the site active, or keeps it failed
+// for a retry, or rolls it back. Plugins that failed to install leave the
+// site active and the job succeeded, with a warning.
+func reportDeployment(ctx context.Context, projectName string, err error) error {
+	switch {
+	case errors.Is(err, services.ErrPluginInstall):
+		utils.LogError("Site '%s' was created, but: %v", projectName, err)
+		services.LogActivityContext(ctx, "warning", "site.create", fmt.Sprintf("Site '%s' created, but some plugins could not be installed.", projectName), projectName)
+		return nil
+	case errors.Is(err, context.Canceled):
+		services.LogActivityContext(ctx, "warning", "site.create", fmt.Sprintf("Site '%s' creation was cancelled.", projectName), projectName)
+	case err != nil:
+		utils.LogError("Failed to deploy WordPress site '%s': %v", projectName, err)
+		services.LogActivityContext(ctx, "error", "site.create", fmt.Sprintf("Site '%s' creation failed: %v", projectName, err), projectName)
+	default:
+		services.LogActivityContext(ctx, "info", "site.create", fmt.Sprintf("Site '%s' created successfully!", projectName), projectName)
+	}
+	return err
+}
+
+// RetryDeployment resumes a failed deployment that kept what it created at
+// the step that failed. The optional retainOnFailure field decides what a
+// further failure keeps; it defaults to the site's current setting.
+func RetryDeployment(c *gin.Context) {
+	projectName := c.Param("projectName")
+	if invalidInput(c, services.ValidateProjectName(projectName)) {
 		return
 	}
+	var payload struct {
+		RetainOnFailure *bool `json:"retainOnFailure"`
+	}
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&payload); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request payload."})
+			return
+		}
+	}
 
-	services.LogActivity("info", fmt.Sprintf("Site '%s' creation initiated.", projectName), projectName)
-
-	go func() {
-		err := services.DeployWordPressSite(newSite, newSite.Plugins, newSite.AdminUsername, newSite.AdminPassword)
+	site, err := services.Sites.Get(projectName)
+	if errors.Is(err, services.ErrSiteNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Site not found."})
+		return
+	}
+	if err != nil {
+		utils.LogError("Failed to read site '%s': %v", projectName, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve sites."})
+		return
+	}
+	if !services.Retryable(site) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Only a failed deployment that kept its resources can be retried."})
+		return
+	}
+	if payload.RetainOnFailure != nil {
+		_, err := services.UpdateSite(projectName, func(s *models.Site) {
+			if s.Deployment != nil {
+				s.Deployment.RetainOnFailure = *payload.RetainOnFailure
+			}
+		})
 		if err != nil {
-			utils.LogError("Failed to deploy WordPress site '%s': %v", newSite.ProjectName, err)
-			services.UpdateSiteStatus(newSite.ProjectName, "failed")
-			services.LogActivity("error", fmt.Sprintf("Site '%s' creation failed: %v", newSite.ProjectName, err), newSite.ProjectName)
-			// TODO: Implement cleanup if deployment fails
+			utils.LogError("Failed to update site '%s': %v", projectName, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save site information."})
 			return
 		}
-		services.UpdateSiteStatus(newSite.ProjectName, "active")
-		services.LogActivity("info", fmt.Sprintf("Site '%s' created successfully!", newSite.ProjectName), newSite.ProjectName)
-	}()
+	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "WordPress deployment initiated successfully!", "url": newSite.SiteURL})
+	job, err := services.StartJob(c.Request.Context(), "deploy", projectName, queueRequested(c), func(ctx context.Context) error {
+		return reportDeployment(ctx, projectName, services.RetryDeployment(ctx, projectName))
+	})
+	if err != nil {
+		if siteLocked(c, err) {
+			return
+		}
+		utils.LogError("Failed to start deployment job for site '%s': %v", projectName, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start deployment."})
+		return
+	}
+	logActivity(c, "info", "site.deploy", fmt.Sprintf("Deployment of site '%s' resumed at step '%s'.", projectName, site.Deployment.FailedStep), projectName)
+	c.JSON(http.StatusOK, gin.H{"message": "Deployment resumed.", "step": site.Deployment.FailedStep, "jobId": job.ID})
 }
 
-// GetWordPressSites retrieves a list of all WordPress sites.
+// GetWordPressSites retrieves the WordPress sites the user can see: every
+// site for admins, the sites they own or are a member of for everyone else.
 func GetWordPressSites(c *gin.Context) {
-	sites, err := services.ReadSites()
+	all, err := services.Sites.List()
 	if err != nil {
-		services.LogActivity("error", "Failed to retrieve sites: Error reading sites file.", "")
+		logActivity(c, "error", "site.list", "Failed to retrieve sites: Error reading site store.", "")
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve sites."})
 		return
 	}
+	sites := []models.Site{}
+	for _, site := range all {
+		if siteVisible(c, site) {
+			sites = append(sites, site)
+		}
+	}
 
 	var wg sync.WaitGroup
 	for i := range sites {
@@ -182,18 +338,21 @@ func GetWordPressSites(c *gin.Context) {
 			wg.Add(1)
 			go func(i int) {
 				defer wg.Done()
-				status := services.GetSiteStatus(sites[i]) // Use service function
-				sites[i].Status = status
+				status := services.GetSiteStatus(c.Request.Context(), sites[i]) // Use service function
+				site, err := services.RecordSiteStatus(sites[i], status)
+				if err != nil {
+					utils.LogError("Error saving status of site '%s': %v", sites[i].ProjectName, err)
+					// Continue with the request even if saving fails
+				}
+				sites[i] = site
 			}(i)
 		}
 	}
 	wg.Wait()
 
-	if err := services.WriteSites(sites); err != nil {
-		utils.LogError("Error writing sites after status update: %v", err)
-		// Continue with the request even if writing fails
+	for i := range sites {
+		sites[i] = sites[i].Redacted()
 	}
-
 	c.JSON(http.StatusOK, sites)
 }
 
@@ -204,36 +363,65 @@ func GetWordPressSite(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Project name is required."})
 		return
 	}
+	if invalidInput(c, services.ValidateProjectName(projectName)) {
+		return
+	}
 
-	sites, err := services.ReadSites()
+	site, err := services.Sites.Get(projectName)
+	if errors.Is(err, services.ErrSiteNotFound) {
+		logActivity(c, "error", "site.read", fmt.Sprintf("Failed to retrieve site '%s': Site not found.", projectName), projectName)
+		c.JSON(http.StatusNotFound, gin.H{"error": "Site not found."})
+		return
+	}
 	if err != nil {
-		services.LogActivity("error", fmt.Sprintf("Failed to retrieve site '%s': Error reading sites file.", projectName), projectName)
+		logActivity(c, "error", "site.read", fmt.Sprintf("Failed to retrieve site '%s': Error reading site store.", projectName), projectName)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve sites."})
 		return
 	}
 
-	var foundSite *models.Site
-	for i := range sites {
-		if sites[i].ProjectName == projectName {
-			status := services.GetSiteStatus(sites[i]) // Use service function
-			sites[i].Status = status
-			foundSite = &sites[i]
-			break
-		}
+	status := services.GetSiteStatus(c.Request.Context(), site) // Use service function
+	site, err = services.RecordSiteStatus(site, status)
+	if err != nil {
+		utils.LogError("Error saving status of site '%s': %v", projectName, err)
+		// Continue with the request even if saving fails
 	}
 
-	if foundSite == nil {
-		services.LogActivity("error", fmt.Sprintf("Failed to retrieve site '%s': Site not found.", projectName), projectName)
-		c.JSON(http.StatusNotFound, gin.H{"error": "Site not found."})
+	// Report the operation holding the site's lock, if any
+	var lock *models.SiteLock
+	if holder, locked := services.SiteLockState(projectName); locked {
+		lock = &holder
+	}
+	c.JSON(http.StatusOK, struct {
+		models.Site
+		Lock *models.SiteLock `json:"lock"`
+	}{site.Redacted(), lock})
+}
+
+// RevealSiteSecrets returns the database and admin passwords of a site,
+// which are left out of every other response. Each reveal is logged.
+func RevealSiteSecrets(c *gin.Context) {
+	projectName := c.Param("projectName")
+	if projectName == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Project name is required."})
+		return
+	}
+	if invalidInput(c, services.ValidateProjectName(projectName)) {
 		return
 	}
 
-	if err := services.WriteSites(sites); err != nil {
-		utils.LogError("Error writing sites after status update: %v", err)
-		// Continue with the request even if writing fails
+	site, err := services.Sites.Get(projectName)
+	if errors.Is(err, services.ErrSiteNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Site not found."})
+		return
+	}
+	if err != nil {
+		utils.LogError("Failed to read secrets of site '%s': %v", projectName, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve site secrets."})
+		return
 	}
 
-	c.JSON(http.StatusOK, foundSite)
+	logActivity(c, "security", "site.secrets.reveal", fmt.Sprintf("Secrets of site '%s' were revealed to '%s' from %s.", projectName, c.GetString("username"), c.ClientIP()), projectName)
+	c.JSON(http.StatusOK, models.SiteSecrets{DBPassword: site.DBPassword, AdminPassword: site.AdminPassword})
 }
 
 // DeleteWordPressSite deletes a WordPress site.
@@ -243,54 +431,71 @@ func DeleteWordPressSite(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Project name is required."})
 		return
 	}
-
-	sites, err := services.ReadSites()
-	if err != nil {
-		services.LogActivity("error", fmt.Sprintf("Failed to delete site '%s': Error reading sites file.", projectName), projectName)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve sites."})
+	if invalidInput(c, services.ValidateProjectName(projectName)) {
 		return
 	}
 
-	var siteToDelete models.Site
-	found := false
-	updatedSites := []models.Site{}
-	for _, site := range sites {
-		if site.ProjectName == projectName {
-			siteToDelete = site
-			found = true
-		} else {
-			updatedSites = append(updatedSites, site)
-		}
+	unlock := lockSite(c, projectName, "delete")
+	if unlock == nil {
+		return
 	}
+	defer unlock()
 
-	if !found {
+	siteToDelete, err := services.Sites.Get(projectName)
+	if errors.Is(err, services.ErrSiteNotFound) {
 		c.JSON(http.StatusOK, gin.H{"message": "Site already deleted."})
 		return
 	}
-
-	cfg := config.LoadConfig()
-	client, err := services.GetSSHClient(cfg)
 	if err != nil {
-		utils.LogError("Failed to connect to VPS: %v", err)
-		services.LogActivity("error", fmt.Sprintf("Failed to delete site '%s': Failed to connect to VPS.", projectName), projectName)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to VPS."})
+		logActivity(c, "error", "site.delete", fmt.Sprintf("Failed to delete site '%s': Error reading site store.", projectName), projectName)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve sites."})
 		return
 	}
-	defer client.Close()
 
-	services.CleanupSite(client, projectName, siteToDelete.WPPort)
+	removed, err := services.DeleteSite(c.Request.Context(), siteToDelete)
+	if err != nil {
+		if timedOut(c, err) {
+			logActivity(c, "error", "site.delete", fmt.Sprintf("Failed to delete site '%s': Timed out after removing %s.", projectName, removedParts(removed)), projectName)
+			return
+		}
+		if errors.Is(err, services.ErrConnect) {
+			utils.LogError("Failed to connect to VPS: %v", err)
+			logActivity(c, "error", "site.delete", fmt.Sprintf("Failed to delete site '%s': Failed to connect to VPS.", projectName), projectName)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to VPS."})
+			return
+		}
+		utils.LogError("Failed to remove site '%s' from its host: %v", projectName, err)
+		logActivity(c, "error", "site.delete", fmt.Sprintf("Failed to delete site '%s': Removed %s before failing: %v", projectName, removedParts(removed), err), projectName)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove the site from its host. Retry the delete.", "removed": removed})
+		return
+	}
 
-	if err := services.WriteSites(updatedSites); err != nil {
-		utils.LogError("Failed to write sites: %v", err)
-		services.LogActivity("error", fmt.Sprintf("Failed to delete site '%s': Failed to update site information.", projectName), projectName)
+	if err := services.Sites.Delete(projectName); err != nil && !errors.Is(err, services.ErrSiteNotFound) {
+		utils.LogError("Failed to delete site record: %v", err)
+		logActivity(c, "error", "site.delete", fmt.Sprintf("Failed to delete site '%s': Failed to update site information.", projectName), projectName)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete site information."})
 		return
 	}
+	services.UnlinkStaging(siteToDelete)
 
-	services.LogActivity("info", fmt.Sprintf("Site '%s' deleted successfully!", projectName), projectName)
+	services.RecordActivity(c.Request.Context(), models.Activity{
+		Level:       "info",
+		Action:      "site.delete",
+		Message:     fmt.Sprintf("Site '%s' deleted successfully!", projectName),
+		ProjectName: projectName,
+		Before:      services.AuditDetails(siteToDelete.Redacted()),
+	})
 	c.JSON(http.StatusOK, gin.H{"message": "Site deleted successfully!"})
 }
 
+// removedParts describes the parts of a site DeleteSite removed.
+func removedParts(removed []string) string {
+	if len(removed) == 0 {
+		return "nothing"
+	}
+	return strings.Join(removed, " and ")
+}
+
 // RestartWordPressSite restarts a WordPress site.
 func RestartWordPressSite(c *gin.Context) {
 	projectName := c.Param("projectName")
@@ -298,48 +503,46 @@ func RestartWordPressSite(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Project name is required."})
 		return
 	}
-
-	sites, err := services.ReadSites()
-	if err != nil {
-		services.LogActivity("error", fmt.Sprintf("Failed to restart site '%s': Error reading sites file.", projectName), projectName)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve sites."})
+	if invalidInput(c, services.ValidateProjectName(projectName)) {
 		return
 	}
 
-	found := false
-	for _, site := range sites {
-		if site.ProjectName == projectName {
-			found = true
-			break
-		}
+	unlock := lockSite(c, projectName, "restart")
+	if unlock == nil {
+		return
 	}
+	defer unlock()
 
-	if !found {
-		services.LogActivity("error", fmt.Sprintf("Failed to restart site '%s': Site not found.", projectName), projectName)
+	site, err := services.Sites.Get(projectName)
+	if errors.Is(err, services.ErrSiteNotFound) {
+		logActivity(c, "error", "site.restart", fmt.Sprintf("Failed to restart site '%s': Site not found.", projectName), projectName)
 		c.JSON(http.StatusNotFound, gin.H{"error": "Site not found."})
 		return
 	}
-
-	cfg := config.LoadConfig()
-	client, err := services.GetSSHClient(cfg)
 	if err != nil {
-		utils.LogError("Failed to connect to VPS: %v", err)
-		services.LogActivity("error", fmt.Sprintf("Failed to restart site '%s': Failed to connect to VPS.", projectName), projectName)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to VPS."})
+		logActivity(c, "error", "site.restart", fmt.Sprintf("Failed to restart site '%s': Error reading site store.", projectName), projectName)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve sites."})
 		return
 	}
-	defer client.Close()
 
-	remotePath := fmt.Sprintf("/var/www/%s", projectName)
-	_, _, err = services.RunSSHCommand(client, fmt.Sprintf("cd %s && docker-compose restart", remotePath))
-	if err != nil {
+	if err := services.RestartSite(c.Request.Context(), site); err != nil {
+		if timedOut(c, err) {
+			logActivity(c, "error", "site.restart", fmt.Sprintf("Failed to restart site '%s': Timed out.", projectName), projectName)
+			return
+		}
+		if errors.Is(err, services.ErrConnect) {
+			utils.LogError("Failed to connect to VPS: %v", err)
+			logActivity(c, "error", "site.restart", fmt.Sprintf("Failed to restart site '%s': Failed to connect to VPS.", projectName), projectName)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to VPS."})
+			return
+		}
 		utils.LogError("SSH command failed: %v", err)
-		services.LogActivity("error", fmt.Sprintf("Failed to restart site '%s': Remote command failed.", projectName), projectName)
+		logActivity(c, "error", "site.restart", fmt.Sprintf("Failed to restart site '%s': Remote command failed.", projectName), projectName)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restart remote site."})
 		return
 	}
 
-	services.LogActivity("info", fmt.Sprintf("Site '%s' restarted successfully!", projectName), projectName)
+	logActivity(c, "info", "site.restart", fmt.Sprintf("Site '%s' restarted successfully!", projectName), projectName)
 	c.JSON(http.StatusOK, gin.H{"message": "Site restarted successfully!"})
 }
 
@@ -350,17 +553,33 @@ func CreateBackup(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Project name is required."})
 		return
 	}
+	if invalidInput(c, services.ValidateProjectName(projectName)) {
+		return
+	}
 
-	go func() {
-		err := services.CreateBackup(projectName)
+	job, err := services.StartJob(c.Request.Context(), "backup", projectName, queueRequested(c), func(ctx context.Context) error {
+		err := services.CreateBackup(ctx, projectName)
+		if errors.Is(err, context.Canceled) {
+			services.LogActivityContext(ctx, "warning", "backup.create", fmt.Sprintf("Backup for site '%s' was cancelled.", projectName), projectName)
+			return err
+		}
 		if err != nil {
 			utils.LogError("Failed to create backup for site '%s': %v", projectName, err)
 			// Optionally, log this failure as an activity
-			services.LogActivity("error", fmt.Sprintf("Backup failed for site '%s': %v", projectName, err), projectName)
+			services.LogActivityContext(ctx, "error", "backup.create", fmt.Sprintf("Backup failed for site '%s': %v", projectName, err), projectName)
+		}
+		return err
+	})
+	if err != nil {
+		if siteLocked(c, err) {
+			return
 		}
-	}()
+		utils.LogError("Failed to start backup job for site '%s': %v", projectName, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start backup."})
+		return
+	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Backup creation initiated successfully!"})
+	c.JSON(http.StatusOK, gin.H{"message": "Backup creation initiated successfully!", "jobId": job.ID})
 }
 
 // ListBackups lists the backups for a WordPress site.
@@ -370,9 +589,19 @@ func ListBackups(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Project name is required."})
 		return
 	}
+	if invalidInput(c, services.ValidateProjectName(projectName)) {
+		return
+	}
 
-	backups, err := services.ListBackups(projectName)
+	backups, err := services.ListBackups(c.Request.Context(), projectName)
 	if err != nil {
+		if timedOut(c, err) {
+			return
+		}
+		if errors.Is(err, services.ErrSiteNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Site not found."})
+			return
+		}
 		utils.LogError("Failed to list backups for site '%s': %v", projectName, err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list backups.", "details": err.Error()})
 		return
@@ -388,6 +617,9 @@ func RestoreBackup(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Project name is required."})
 		return
 	}
+	if invalidInput(c, services.ValidateProjectName(projectName)) {
+		return
+	}
 
 	var payload struct {
 		BackupFile string `json:"backupFile"`
@@ -403,16 +635,32 @@ func RestoreBackup(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Backup file name is required."})
 		return
 	}
+	if invalidInput(c, services.ValidateBackupFile(backupFile)) {
+		return
+	}
 
-	go func() {
-		err := services.RestoreBackup(projectName, backupFile)
+	job, err := services.StartJob(c.Request.Context(), "restore", projectName, queueRequested(c), func(ctx context.Context) error {
+		err := services.RestoreBackup(ctx, projectName, backupFile)
+		if errors.Is(err, context.Canceled) {
+			services.LogActivityContext(ctx, "warning", "backup.restore", fmt.Sprintf("Restore of site '%s' was cancelled.", projectName), projectName)
+			return err
+		}
 		if err != nil {
 			utils.LogError("Failed to restore backup for site '%s': %v", projectName, err)
-			services.LogActivity("error", fmt.Sprintf("Restore failed for site '%s': %v", projectName, err), projectName)
+			services.LogActivityContext(ctx, "error", "backup.restore", fmt.Sprintf("Restore failed for site '%s': %v", projectName, err), projectName)
+		}
+		return err
+	})
+	if err != nil {
+		if siteLocked(c, err) {
+			return
 		}
-	}()
+		utils.LogError("Failed to start restore job for site '%s': %v", projectName, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start restore."})
+		return
+	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Backup restoration initiated successfully!"})
+	c.JSON(http.StatusOK, gin.H{"message": "Backup restoration initiated successfully!", "jobId": job.ID})
 }
 
 // GetSitePlugins retrieves a list of plugins for a site.
@@ -422,56 +670,43 @@ func GetSitePlugins(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Project name is required."})
 		return
 	}
-
-	sites, err := services.ReadSites()
-	if err != nil {
-		services.LogActivity("error", fmt.Sprintf("Failed to get plugins for site '%s': Error reading sites file.", projectName), projectName)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve sites."})
+	if invalidInput(c, services.ValidateProjectName(projectName)) {
 		return
 	}
 
-	found := false
-	for _, site := range sites {
-		if site.ProjectName == projectName {
-			found = true
-			break
-		}
-	}
-
-	if !found {
-		services.LogActivity("error", fmt.Sprintf("Failed to get plugins for site '%s': Site not found.", projectName), projectName)
+	site, err := services.Sites.Get(projectName)
+	if errors.Is(err, services.ErrSiteNotFound) {
+		logActivity(c, "error", "plugin.list", fmt.Sprintf("Failed to get plugins for site '%s': Site not found.", projectName), projectName)
 		c.JSON(http.StatusNotFound, gin.H{"error": "Site not found."})
 		return
 	}
-
-	cfg := config.LoadConfig()
-	client, err := services.GetSSHClient(cfg)
 	if err != nil {
-		utils.LogError("Failed to connect to VPS: %v", err)
-		services.LogActivity("error", fmt.Sprintf("Failed to get plugins for site '%s': Failed to connect to VPS.", projectName), projectName)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to VPS."})
+		logActivity(c, "error", "plugin.list", fmt.Sprintf("Failed to get plugins for site '%s': Error reading site store.", projectName), projectName)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve sites."})
 		return
 	}
-	defer client.Close()
 
-	remotePath := fmt.Sprintf("/var/www/%s", projectName)
-	stdout, stderr, err := services.RunSSHCommand(client, fmt.Sprintf("cd %s && docker compose -f %s/docker-compose.yml exec -T %s_cli wp plugin list --format=json", remotePath, remotePath, projectName))
+	plugins, err := services.ListPlugins(c.Request.Context(), site)
 	if err != nil {
-		utils.LogError("SSH command failed: %v, output: %s", err, stdout+stderr)
-		services.LogActivity("error", fmt.Sprintf("Failed to list plugins for site '%s': Remote command failed.", projectName), projectName)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list plugins."})
-		return
-	}
-
-	var plugins []map[string]interface{}
-	if err := json.Unmarshal([]byte(stdout), &plugins); err != nil {
-		utils.LogError("Failed to unmarshal plugins: %v. Raw output: %s", err, stdout)
-		services.LogActivity("error", fmt.Sprintf("Failed to list plugins for site '%s': Failed to parse plugin list.", projectName), projectName)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse plugin list."})
+		switch {
+		case errors.Is(err, context.DeadlineExceeded):
+			logActivity(c, "error", "plugin.list", fmt.Sprintf("Failed to list plugins for site '%s': Timed out.", projectName), projectName)
+			timedOut(c, err)
+		case errors.Is(err, services.ErrConnect):
+			utils.LogError("Failed to connect to VPS: %v", err)
+			logActivity(c, "error", "plugin.list", fmt.Sprintf("Failed to get plugins for site '%s': Failed to connect to VPS.", projectName), projectName)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to VPS."})
+		case errors.Is(err, services.ErrInvalidOutput):
+			logActivity(c, "error", "plugin.list", fmt.Sprintf("Failed to list plugins for site '%s': Failed to parse plugin list.", projectName), projectName)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse plugin list."})
+		default:
+			logActivity(c, "error", "plugin.list", fmt.Sprintf("Failed to list plugins for site '%s': Remote command failed.", projectName), projectName)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list plugins."})
+		}
 		return
 	}
 
-	services.LogActivity("info", fmt.Sprintf("Plugins listed for site '%s'.", projectName), projectName)
+	logActivity(c, "info", "plugin.list", fmt.Sprintf("Plugins listed for site '%s'.", projectName), projectName)
 	c.JSON(http.StatusOK, plugins)
 }
 
@@ -484,48 +719,45 @@ func InstallPlugin(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Project name and plugin name are required."})
 		return
 	}
-
-	sites, err := services.ReadSites()
-	if err != nil {
-		services.LogActivity("error", fmt.Sprintf("Failed to install plugin '%s' on site '%s': Error reading sites file.", pluginName, projectName), projectName)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve sites."})
+	if invalidInput(c, services.ValidateProjectName(projectName)) || invalidInput(c, services.ValidatePluginSlug(pluginName)) {
 		return
 	}
 
-	found := false
-	for _, site := range sites {
-		if site.ProjectName == projectName {
-			found = true
-			break
-		}
+	unlock := lockSite(c, projectName, "plugin install")
+	if unlock == nil {
+		return
 	}
+	defer unlock()
 
-	if !found {
-		services.LogActivity("error", fmt.Sprintf("Failed to install plugin '%s' on site '%s': Site not found.", pluginName, projectName), projectName)
+	site, err := services.Sites.Get(projectName)
+	if errors.Is(err, services.ErrSiteNotFound) {
+		logActivity(c, "error", "plugin.install", fmt.Sprintf("Failed to install plugin '%s' on site '%s': Site not found.", pluginName, projectName), projectName)
 		c.JSON(http.StatusNotFound, gin.H{"error": "Site not found."})
 		return
 	}
-
-	cfg := config.LoadConfig()
-	client, err := services.GetSSHClient(cfg)
 	if err != nil {
-		utils.LogError("Failed to connect to VPS: %v", err)
-		services.LogActivity("error", fmt.Sprintf("Failed to install plugin '%s' on site '%s': Failed to connect to VPS.", pluginName, projectName), projectName)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to VPS."})
+		logActivity(c, "error", "plugin.install", fmt.Sprintf("Failed to install plugin '%s' on site '%s': Error reading site store.", pluginName, projectName), projectName)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve sites."})
 		return
 	}
-	defer client.Close()
 
-	remotePath := fmt.Sprintf("/var/www/%s", projectName)
-	stdout, stderr, err := services.RunSSHCommand(client, fmt.Sprintf("cd %s && docker compose -f %s/docker-compose.yml exec -T %s_cli wp plugin install %s --activate", remotePath, remotePath, projectName, pluginName))
-	if err != nil {
-		utils.LogError("SSH command failed: %v, output: %s", err, stdout+stderr)
-		services.LogActivity("error", fmt.Sprintf("Failed to install plugin '%s' on site '%s': Remote command failed.", pluginName, projectName), projectName)
+	if err := services.RunPluginCommand(c.Request.Context(), site, "install", pluginName); err != nil {
+		if timedOut(c, err) {
+			logActivity(c, "error", "plugin.install", fmt.Sprintf("Failed to install plugin '%s' on site '%s': Timed out.", pluginName, projectName), projectName)
+			return
+		}
+		if errors.Is(err, services.ErrConnect) {
+			utils.LogError("Failed to connect to VPS: %v", err)
+			logActivity(c, "error", "plugin.install", fmt.Sprintf("Failed to install plugin '%s' on site '%s': Failed to connect to VPS.", pluginName, projectName), projectName)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to VPS."})
+			return
+		}
+		logActivity(c, "error", "plugin.install", fmt.Sprintf("Failed to install plugin '%s' on site '%s': Remote command failed.", pluginName, projectName), projectName)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to install plugin."})
 		return
 	}
 
-	services.LogActivity("info", fmt.Sprintf("Plugin '%s' installed successfully on site '%s'.", pluginName, projectName), projectName)
+	logActivity(c, "info", "plugin.install", fmt.Sprintf("Plugin '%s' installed successfully on site '%s'.", pluginName, projectName), projectName)
 	c.JSON(http.StatusOK, gin.H{"message": fmt.Sprintf("Plugin %s installed successfully!", pluginName)})
 }
 
@@ -538,48 +770,45 @@ func ActivatePlugin(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Project name and plugin name are required."})
 		return
 	}
-
-	sites, err := services.ReadSites()
-	if err != nil {
-		services.LogActivity("error", fmt.Sprintf("Failed to activate plugin '%s' on site '%s': Error reading sites file.", pluginName, projectName), projectName)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve sites."})
+	if invalidInput(c, services.ValidateProjectName(projectName)) || invalidInput(c, services.ValidatePluginSlug(pluginName)) {
 		return
 	}
 
-	found := false
-	for _, site := range sites {
-		if site.ProjectName == projectName {
-			found = true
-			break
-		}
+	unlock := lockSite(c, projectName, "plugin activate")
+	if unlock == nil {
+		return
 	}
+	defer unlock()
 
-	if !found {
-		services.LogActivity("error", fmt.Sprintf("Failed to activate plugin '%s' on site '%s': Site not found.", pluginName, projectName), projectName)
+	site, err := services.Sites.Get(projectName)
+	if errors.Is(err, services.ErrSiteNotFound) {
+		logActivity(c, "error", "plugin.activate", fmt.Sprintf("Failed to activate plugin '%s' on site '%s': Site not found.", pluginName, projectName), projectName)
 		c.JSON(http.StatusNotFound, gin.H{"error": "Site not found."})
 		return
 	}
-
-	cfg := config.LoadConfig()
-	client, err := services.GetSSHClient(cfg)
 	if err != nil {
-		utils.LogError("Failed to connect to VPS: %v", err)
-		services.LogActivity("error", fmt.Sprintf("Failed to activate plugin '%s' on site '%s': Failed to connect to VPS.", pluginName, projectName), projectName)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to VPS."})
+		logActivity(c, "error", "plugin.activate", fmt.Sprintf("Failed to activate plugin '%s' on site '%s': Error reading site store.", pluginName, projectName), projectName)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve sites."})
 		return
 	}
-	defer client.Close()
 
-	remotePath := fmt.Sprintf("/var/www/%s", projectName)
-	stdout, stderr, err := services.RunSSHCommand(client, fmt.Sprintf("cd %s && docker compose -f %s/docker-compose.yml exec -T %s_cli wp plugin activate %s", remotePath, remotePath, projectName, pluginName))
-	if err != nil {
-		utils.LogError("SSH command failed: %v, output: %s", err, stdout+stderr)
-		services.LogActivity("error", fmt.Sprintf("Failed to activate plugin '%s' on site '%s': Remote command failed.", pluginName, projectName), projectName)
+	if err := services.RunPluginCommand(c.Request.Context(), site, "activate", pluginName); err != nil {
+		if timedOut(c, err) {
+			logActivity(c, "error", "plugin.activate", fmt.Sprintf("Failed to activate plugin '%s' on site '%s': Timed out.", pluginName, projectName), projectName)
+			return
+		}
+		if errors.Is(err, services.ErrConnect) {
+			utils.LogError("Failed to connect to VPS: %v", err)
+			logActivity(c, "error", "plugin.activate", fmt.Sprintf("Failed to activate plugin '%s' on site '%s': Failed to connect to VPS.", pluginName, projectName), projectName)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to VPS."})
+			return
+		}
+		logActivity(c, "error", "plugin.activate", fmt.Sprintf("Failed to activate plugin '%s' on site '%s': Remote command failed.", pluginName, projectName), projectName)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to activate plugin."})
 		return
 	}
 
-	services.LogActivity("info", fmt.Sprintf("Plugin '%s' activated successfully on site '%s'.", pluginName, projectName), projectName)
+	logActivity(c, "info", "plugin.activate", fmt.Sprintf("Plugin '%s' activated successfully on site '%s'.", pluginName, projectName), projectName)
 	c.JSON(http.StatusOK, gin.H{"message": fmt.Sprintf("Plugin %s activated successfully!", pluginName)})
 }
 
@@ -592,48 +821,45 @@ func DeactivatePlugin(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Project name and plugin name are required."})
 		return
 	}
-
-	sites, err := services.ReadSites()
-	if err != nil {
-		services.LogActivity("error", fmt.Sprintf("Failed to deactivate plugin '%s' on site '%s': Error reading sites file.", pluginName, projectName), projectName)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve sites."})
+	if invalidInput(c, services.ValidateProjectName(projectName)) || invalidInput(c, services.ValidatePluginSlug(pluginName)) {
 		return
 	}
 
-	found := false
-	for _, site := range sites {
-		if site.ProjectName == projectName {
-			found = true
-			break
-		}
+	unlock := lockSite(c, projectName, "plugin deactivate")
+	if unlock == nil {
+		return
 	}
+	defer unlock()
 
-	if !found {
-		services.LogActivity("error", fmt.Sprintf("Failed to deactivate plugin '%s' on site '%s': Site not found.", pluginName, projectName), projectName)
+	site, err := services.Sites.Get(projectName)
+	if errors.Is(err, services.ErrSiteNotFound) {
+		logActivity(c, "error", "plugin.deactivate", fmt.Sprintf("Failed to deactivate plugin '%s' on site '%s': Site not found.", pluginName, projectName), projectName)
 		c.JSON(http.StatusNotFound, gin.H{"error": "Site not found."})
 		return
 	}
-
-	cfg := config.LoadConfig()
-	client, err := services.GetSSHClient(cfg)
 	if err != nil {
-		utils.LogError("Failed to connect to VPS: %v", err)
-		services.LogActivity("error", fmt.Sprintf("Failed to deactivate plugin '%s' on site '%s': Failed to connect to VPS.", pluginName, projectName), projectName)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to VPS."})
+		logActivity(c, "error", "plugin.deactivate", fmt.Sprintf("Failed to deactivate plugin '%s' on site '%s': Error reading site store.", pluginName, projectName), projectName)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve sites."})
 		return
 	}
-	defer client.Close()
 
-	remotePath := fmt.Sprintf("/var/www/%s", projectName)
-	stdout, stderr, err := services.RunSSHCommand(client, fmt.Sprintf("cd %s && docker compose -f %s/docker-compose.yml exec -T %s_cli wp plugin deactivate %s", remotePath, remotePath, projectName, pluginName))
-	if err != nil {
-		utils.LogError("SSH command failed: %v, output: %s", err, stdout+stderr)
-		services.LogActivity("error", fmt.Sprintf("Failed to deactivate plugin '%s' on site '%s': Remote command failed.", pluginName, projectName), projectName)
+	if err := services.RunPluginCommand(c.Request.Context(), site, "deactivate", pluginName); err != nil {
+		if timedOut(c, err) {
+			logActivity(c, "error", "plugin.deactivate", fmt.Sprintf("Failed to deactivate plugin '%s' on site '%s': Timed out.", pluginName, projectName), projectName)
+			return
+		}
+		if errors.Is(err, services.ErrConnect) {
+			utils.LogError("Failed to connect to VPS: %v", err)
+			logActivity(c, "error", "plugin.deactivate", fmt.Sprintf("Failed to deactivate plugin '%s' on site '%s': Failed to connect to VPS.", pluginName, projectName), projectName)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to VPS."})
+			return
+		}
+		logActivity(c, "error", "plugin.deactivate", fmt.Sprintf("Failed to deactivate plugin '%s' on site '%s': Remote command failed.", pluginName, projectName), projectName)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to deactivate plugin."})
 		return
 	}
 
-	services.LogActivity("info", fmt.Sprintf("Plugin '%s' deactivated successfully on site '%s'.", pluginName, projectName), projectName)
+	logActivity(c, "info", "plugin.deactivate", fmt.Sprintf("Plugin '%s' deactivated successfully on site '%s'.", pluginName, projectName), projectName)
 	c.JSON(http.StatusOK, gin.H{"message": fmt.Sprintf("Plugin %s deactivated successfully!", pluginName)})
 }
 
@@ -646,98 +872,188 @@ func DeletePlugin(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Project name and plugin name are required."})
 		return
 	}
-
-	sites, err := services.ReadSites()
-	if err != nil {
-		services.LogActivity("error", fmt.Sprintf("Failed to delete plugin '%s' from site '%s': Error reading sites file.", pluginName, projectName), projectName)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve sites."})
+	if invalidInput(c, services.ValidateProjectName(projectName)) || invalidInput(c, services.ValidatePluginSlug(pluginName)) {
 		return
 	}
 
-	found := false
-	for _, site := range sites {
-		if site.ProjectName == projectName {
-			found = true
-			break
-		}
+	unlock := lockSite(c, projectName, "plugin delete")
+	if unlock == nil {
+		return
 	}
+	defer unlock()
 
-	if !found {
-		services.LogActivity("error", fmt.Sprintf("Failed to delete plugin '%s' from site '%s': Site not found.", pluginName, projectName), projectName)
+	site, err := services.Sites.Get(projectName)
+	if errors.Is(err, services.ErrSiteNotFound) {
+		logActivity(c, "error", "plugin.delete", fmt.Sprintf("Failed to delete plugin '%s' from site '%s': Site not found.", pluginName, projectName), projectName)
 		c.JSON(http.StatusNotFound, gin.H{"error": "Site not found."})
 		return
 	}
-
-	cfg := config.LoadConfig()
-	client, err := services.GetSSHClient(cfg)
 	if err != nil {
-		utils.LogError("Failed to connect to VPS: %v", err)
-		services.LogActivity("error", fmt.Sprintf("Failed to delete plugin '%s' from site '%s': Failed to connect to VPS.", pluginName, projectName), projectName)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to VPS."})
+		logActivity(c, "error", "plugin.delete", fmt.Sprintf("Failed to delete plugin '%s' from site '%s': Error reading site store.", pluginName, projectName), projectName)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve sites."})
 		return
 	}
-	defer client.Close()
 
-	remotePath := fmt.Sprintf("/var/www/%s", projectName)
-	stdout, stderr, err := services.RunSSHCommand(client, fmt.Sprintf("cd %s && docker compose -f %s/docker-compose.yml exec -T %s_cli wp plugin delete %s", remotePath, remotePath, projectName, pluginName))
-	if err != nil {
-		utils.LogError("SSH command failed: %v, output: %s", err, stdout+stderr)
-		services.LogActivity("error", fmt.Sprintf("Failed to delete plugin '%s' from site '%s': Remote command failed.", pluginName, projectName), projectName)
+	if err := services.RunPluginCommand(c.Request.Context(), site, "delete", pluginName); err != nil {
+		if timedOut(c, err) {
+			logActivity(c, "error", "plugin.delete", fmt.Sprintf("Failed to delete plugin '%s' from site '%s': Timed out.", pluginName, projectName), projectName)
+			return
+		}
+		if errors.Is(err, services.ErrConnect) {
+			utils.LogError("Failed to connect to VPS: %v", err)
+			logActivity(c, "error", "plugin.delete", fmt.Sprintf("Failed to delete plugin '%s' from site '%s': Failed to connect to VPS.", pluginName, projectName), projectName)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to VPS."})
+			return
+		}
+		logActivity(c, "error", "plugin.delete", fmt.Sprintf("Failed to delete plugin '%s' from site '%s': Remote command failed.", pluginName, projectName), projectName)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to uninstall plugin."})
 		return
 	}
 
-	services.LogActivity("info", fmt.Sprintf("Plugin '%s' uninstalled successfully from site '%s'.", pluginName, projectName), projectName)
+	logActivity(c, "info", "plugin.delete", fmt.Sprintf("Plugin '%s' uninstalled successfully from site '%s'.", pluginName, projectName), projectName)
 	c.JSON(http.StatusOK, gin.H{"message": fmt.Sprintf("Plugin %s uninstalled successfully!", pluginName)})
 }
 
 // GetVPSStats retrieves VPS resource usage.
 func GetVPSStats(c *gin.Context) {
-	cfg := config.LoadConfig()
-	client, err := services.GetSSHClient(cfg)
-	if err != nil {
-		utils.LogError("Failed to connect to VPS: %v", err)
-		services.LogActivity("error", fmt.Sprintf("Failed to connect to VPS: %v", err), "")
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to VPS."})
-		return
+	hostName := c.Param("name")
+	if hostName == "" {
+		hostName = c.Query("host")
 	}
-	defer client.Close()
 
-	// Get CPU usage
-	cpuCmd := "top -bn1 | grep '%Cpu(s)' | awk '{print $2 + $4}'"
-	stdoutCPU, stderrCPU, err := services.RunSSHCommand(client, cpuCmd)
+	host, err := services.GetHost(hostName)
 	if err != nil {
-		utils.LogError("SSH command failed for CPU: %v, stdout: %s, stderr: %s", err, stdoutCPU, stderrCPU)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get CPU stats."})
+		if errors.Is(err, services.ErrHostNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Host not found."})
+			return
+		}
+		utils.LogError("Failed to read hosts: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve hosts."})
 		return
 	}
-	cpuUsage := strings.TrimSpace(stdoutCPU)
 
-	// Get RAM usage
-	ramCmd := "free -m | grep Mem | awk '{print $3/$2 * 100.0}'"
-	stdoutRAM, stderrRAM, err := services.RunSSHCommand(client, ramCmd)
+	cpuUsage, ramUsage, err := services.GetHostStats(c.Request.Context(), host)
 	if err != nil {
-		utils.LogError("SSH command failed for RAM: %v, stdout: %s, stderr: %s", err, stdoutRAM, stderrRAM)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get RAM stats."})
+		if timedOut(c, err) {
+			return
+		}
+		if errors.Is(err, services.ErrConnect) {
+			utils.LogError("Failed to connect to VPS: %v", err)
+			logActivity(c, "error", "host.stats", fmt.Sprintf("Failed to connect to VPS: %v", err), "")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to VPS."})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get VPS stats."})
 		return
 	}
-	ramUsage := strings.TrimSpace(stdoutRAM)
 
 	// services.LogActivity("VPS stats requested.")
 	c.JSON(http.StatusOK, gin.H{
+		"host":      host.Name,
 		"cpu_usage": cpuUsage,
 		"ram_usage": ramUsage,
 	})
 }
 
-// GetActivities retrieves a list of all activities.
+// activityQuery reads the activity filters shared by GetActivities and
+// ExportAudit. It responds with 400 Bad Request and returns false when a
+// filter is invalid.
+func activityQuery(c *gin.Context) (services.ActivityQuery, bool) {
+	query := services.ActivityQuery{
+		ProjectName: c.Query("projectName"),
+		Level:       c.Query("level"),
+		Search:      c.Query("q"),
+		Actor:       c.Query("actor"),
+		Action:      c.Query("action"),
+		RequestID:   c.Query("requestId"),
+		Cursor:      c.Query("cursor"),
+	}
+	for param, dest := range map[string]*time.Time{"since": &query.Since, "until": &query.Until} {
+		if value := c.Query(param); value != "" {
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid '%s' time, expected RFC 3339.", param)})
+				return query, false
+			}
+			*dest = t
+		}
+	}
+	return query, true
+}
+
+// GetActivities lists activities, newest first. The projectName, level,
+// actor, action, requestId, since, until (RFC 3339), q (text search) and limit query
+// parameters narrow the list. When more activities follow, the X-Next-Cursor
+// header carries the cursor to pass back as the cursor parameter for the next
+// page. Users other than admins only see the activities of their sites.
 func GetActivities(c *gin.Context) {
-	activities, err := services.ReadActivities()
+	query, ok := activityQuery(c)
+	if !ok {
+		return
+	}
+	projectNames, err := visibleSites(c)
 	if err != nil {
+		utils.LogError("Failed to list sites: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve activities."})
+		return
+	}
+	query.ProjectNames = projectNames
+	if value := c.Query("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit."})
+			return
+		}
+		query.Limit = limit
+	}
+
+	if services.Activities == nil {
+		c.JSON(http.StatusOK, []models.Activity{})
+		return
+	}
+	activities, next, err := services.Activities.Query(query)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidCursor) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cursor."})
+			return
+		}
 		utils.LogError("Failed to read activities: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve activities."})
 		return
 	}
 
+	if next != "" {
+		c.Header("X-Next-Cursor", next)
+	}
 	c.JSON(http.StatusOK, activities)
 }
+
+// ExportAudit downloads every activity matching the GetActivities filters as
+// CSV or, with format=jsonl, as JSON Lines.
+func ExportAudit(c *gin.Context) {
+	format := c.DefaultQuery("format", services.ExportCSV)
+	contentType := map[string]string{
+		services.ExportCSV:   "text/csv; charset=utf-8",
+		services.ExportJSONL: "application/x-ndjson",
+	}[format]
+	if contentType == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unsupported format, expected csv or jsonl."})
+		return
+	}
+	query, ok := activityQuery(c)
+	if !ok {
+		return
+	}
+
+	filename := fmt.Sprintf("audit-%s.%s", time.Now().UTC().Format("20060102T150405Z"), format)
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	c.Status(http.StatusOK)
+	count, err := services.ExportActivities(c.Writer, format, query)
+	if err != nil {
+		// The response has started, so the download is cut short instead
+		utils.LogError("Failed to export activities after %d entries: %v", count, err)
+		return
+	}
+	logActivity(c, "security", "audit.export", fmt.Sprintf("Audit log exported as %s (%d entries).", format, count), query.ProjectName)
+}
@@ -0,0 +1,567 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"wordpress-collab-tool/config"
+	"wordpress-collab-tool/internal/fakes"
+	"wordpress-collab-tool/models"
+	"wordpress-collab-tool/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "controllers-test")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "failed to create temp dir:", err)
+		os.Exit(1)
+	}
+	os.Chdir(dir)
+
+	os.Setenv("SSH_HOST", "vps.test")
+	os.Setenv("SSH_USER", "deploy")
+	os.Setenv("SSH_PASSWORD", "secret")
+	services.InitHosts(config.LoadConfig())
+	gin.SetMode(gin.TestMode)
+
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// setupSite stores a single site and routes executor requests to fake.
+func setupSite(t *testing.T, fake *fakes.Executor) {
+	t.Helper()
+	site := models.Site{ProjectName: "blog", WPPort: 8200, DBName: "blog_db", Status: "active"}
+	originalSites := services.Sites
+	services.Sites = services.NewJSONSiteStore(filepath.Join(t.TempDir(), "sites.json"))
+	if _, err := services.Sites.Create(site); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	activities, err := services.OpenSQLiteActivityStore(filepath.Join(t.TempDir(), "activities.db"), time.Minute)
+	if err != nil {
+		t.Fatalf("OpenSQLiteActivityStore: %v", err)
+	}
+	originalActivities := services.Activities
+	services.Activities = activities
+
+	original := services.NewExecutor
+	services.NewExecutor = func(ctx context.Context, host models.Host) (services.Executor, error) {
+		return fake, nil
+	}
+	t.Cleanup(func() {
+		waitForJobs(t)
+		services.NewExecutor = original
+		services.Sites = originalSites
+		services.Activities = originalActivities
+		activities.Close()
+		os.Remove("jobs.json")
+	})
+}
+
+// waitForJobs waits until every job the test started has finished and
+// released the sites it locked, so that none of them still uses the stores
+// and executor the test installed once they are restored.
+func waitForJobs(t *testing.T) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		if jobsSettled() {
+			return
+		}
+	}
+	t.Error("background jobs were still running at the end of the test")
+}
+
+func jobsSettled() bool {
+	jobs, err := services.ListJobs(services.JobFilter{})
+	if err != nil {
+		return false
+	}
+	for _, job := range jobs {
+		if !job.Finished() {
+			return false
+		}
+		if _, locked := services.SiteLockState(job.ProjectName); locked {
+			return false
+		}
+	}
+	_, locked := services.SiteLockState("blog")
+	return !locked
+}
+
+// asAdmin stands in for AuthMiddleware in tests of handlers that look at
+// the caller's role.
+func asAdmin(c *gin.Context) {
+	c.Set("username", "admin")
+	c.Set("role", services.RoleAdmin)
+}
+
+func newPluginRouter() *gin.Engine {
+	router := gin.New()
+	router.GET("/api/sites/:projectName/plugins", GetSitePlugins)
+	router.POST("/api/sites/:projectName/plugins/:pluginName", InstallPlugin)
+	router.POST("/api/sites/:projectName/plugins/:pluginName/activate", ActivatePlugin)
+	router.DELETE("/api/sites/:projectName/plugins/:pluginName", DeletePlugin)
+	return router
+}
+
+func serve(router *gin.Engine, method, path string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(method, path, nil)
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestGetSitePlugins(t *testing.T) {
+	fake := fakes.NewExecutor().On("wp plugin list --format=json", fakes.Result{
+		Stdout: `[{"name":"akismet","status":"active"},{"name":"hello","status":"inactive"}]`,
+	})
+	setupSite(t, fake)
+
+	w := serve(newPluginRouter(), http.MethodGet, "/api/sites/blog/plugins")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var plugins []map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &plugins); err != nil {
+		t.Fatalf("invalid response: %v", err)
+	}
+	if len(plugins) != 2 || plugins[0]["name"] != "akismet" {
+		t.Errorf("unexpected plugins: %v", plugins)
+	}
+}
+
+func TestGetSitePluginsUnknownSite(t *testing.T) {
+	fake := fakes.NewExecutor()
+	setupSite(t, fake)
+
+	w := serve(newPluginRouter(), http.MethodGet, "/api/sites/missing/plugins")
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404, got %d", w.Code)
+	}
+	if len(fake.Commands()) != 0 {
+		t.Errorf("no command should run for an unknown site, ran %v", fake.Commands())
+	}
+}
+
+func TestInstallPlugin(t *testing.T) {
+	fake := fakes.NewExecutor()
+	setupSite(t, fake)
+
+	w := serve(newPluginRouter(), http.MethodPost, "/api/sites/blog/plugins/akismet")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if !fake.Ran("exec -T blog_cli wp plugin install akismet --activate") {
+		t.Errorf("unexpected commands: %v", fake.Commands())
+	}
+}
+
+func TestActivatePluginRemoteFailure(t *testing.T) {
+	fake := fakes.NewExecutor().On("wp plugin activate", fakes.Result{Stderr: "Plugin not found", ExitCode: 1})
+	setupSite(t, fake)
+
+	w := serve(newPluginRouter(), http.MethodPost, "/api/sites/blog/plugins/missing/activate")
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("expected 500, got %d", w.Code)
+	}
+
+	activities, _, _ := services.Activities.Query(services.ActivityQuery{})
+	if len(activities) == 0 || !strings.Contains(activities[0].Message, "Remote command failed") {
+		t.Errorf("expected a failure activity, got %+v", activities)
+	}
+}
+
+func TestDeletePlugin(t *testing.T) {
+	fake := fakes.NewExecutor()
+	setupSite(t, fake)
+
+	w := serve(newPluginRouter(), http.MethodDelete, "/api/sites/blog/plugins/hello")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if !fake.Ran("wp plugin delete hello") {
+		t.Errorf("unexpected commands: %v", fake.Commands())
+	}
+}
+
+func TestInstallPluginRejectsInvalidSlug(t *testing.T) {
+	fake := fakes.NewExecutor()
+	setupSite(t, fake)
+
+	w := serve(newPluginRouter(), http.MethodPost, "/api/sites/blog/plugins/akismet%3Bid")
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d: %s", w.Code, w.Body.String())
+	}
+	if len(fake.Commands()) != 0 {
+		t.Errorf("no command should run for an invalid plugin, ran %v", fake.Commands())
+	}
+}
+
+func TestBackupJobCanBeCancelled(t *testing.T) {
+	fake := fakes.NewExecutor().On("mariadb-dump", fakes.Result{Delay: time.Minute})
+	setupSite(t, fake)
+
+	router := gin.New()
+	router.Use(asAdmin)
+	router.POST("/api/sites/:projectName/backups", CreateBackup)
+	router.GET("/api/jobs/:id", GetJob)
+	router.POST("/api/jobs/:id/cancel", CancelJob)
+
+	w := serve(router, http.MethodPost, "/api/sites/blog/backups")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var started struct {
+		JobID string `json:"jobId"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &started); err != nil || started.JobID == "" {
+		t.Fatalf("response has no job id: %s", w.Body.String())
+	}
+
+	if w := serve(router, http.MethodPost, "/api/jobs/"+started.JobID+"/cancel"); w.Code != http.StatusAccepted {
+		t.Fatalf("expected 202, got %d: %s", w.Code, w.Body.String())
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		var job models.Job
+		w := serve(router, http.MethodGet, "/api/jobs/"+started.JobID)
+		json.Unmarshal(w.Body.Bytes(), &job)
+		if job.State == models.JobCancelled {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("job was not cancelled: %s", w.Body.String())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if w := serve(router, http.MethodPost, "/api/jobs/"+started.JobID+"/cancel"); w.Code != http.StatusConflict {
+		t.Errorf("cancelling a finished job: expected 409, got %d", w.Code)
+	}
+	if w := serve(router, http.MethodGet, "/api/jobs/unknown"); w.Code != http.StatusNotFound {
+		t.Errorf("unknown job: expected 404, got %d", w.Code)
+	}
+}
+
+func TestOperationsOnBusySiteConflict(t *testing.T) {
+	fake := fakes.NewExecutor().On("mariadb-dump", fakes.Result{Delay: time.Minute})
+	setupSite(t, fake)
+
+	router := gin.New()
+	router.POST("/api/sites/:projectName/backups", CreateBackup)
+	router.POST("/api/sites/:projectName/restart", RestartWordPressSite)
+	router.POST("/api/sites/:projectName/cancel", CancelSiteJobs)
+
+	if w := serve(router, http.MethodPost, "/api/sites/blog/backups"); w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w := serve(router, http.MethodPost, "/api/sites/blog/restart")
+	if w.Code != http.StatusConflict {
+		t.Fatalf("expected 409, got %d: %s", w.Code, w.Body.String())
+	}
+	var conflict struct {
+		Operation string    `json:"operation"`
+		Since     time.Time `json:"since"`
+		JobID     string    `json:"jobId"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &conflict)
+	if conflict.Operation != "backup" || conflict.Since.IsZero() || conflict.JobID == "" {
+		t.Errorf("conflict does not describe the holder: %s", w.Body.String())
+	}
+
+	// A queued restart runs once the backup is cancelled
+	done := make(chan *httptest.ResponseRecorder)
+	go func() { done <- serve(router, http.MethodPost, "/api/sites/blog/restart?queue=true") }()
+	time.Sleep(20 * time.Millisecond)
+	if w := serve(router, http.MethodPost, "/api/sites/blog/cancel"); w.Code != http.StatusAccepted {
+		t.Fatalf("cancel: expected 202, got %d: %s", w.Code, w.Body.String())
+	}
+	select {
+	case w := <-done:
+		if w.Code != http.StatusOK {
+			t.Errorf("queued restart: expected 200, got %d: %s", w.Code, w.Body.String())
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("queued restart did not run")
+	}
+}
+
+func TestSiteSecretsAreRedactedUntilRevealed(t *testing.T) {
+	setupSite(t, fakes.NewExecutor())
+	site, _ := services.Sites.Get("blog")
+	site.DBPassword = "db-secret"
+	site.AdminPassword = "admin-secret"
+	site.Status = "failed" // skips the live status probe
+	services.Sites.Update(site)
+
+	router := gin.New()
+	router.GET("/api/sites", GetWordPressSites)
+	router.POST("/api/sites/:projectName/secrets/reveal", RevealSiteSecrets)
+
+	w := serve(router, http.MethodGet, "/api/sites")
+	if w.Code != http.StatusOK || strings.Contains(w.Body.String(), "secret") {
+		t.Fatalf("site list exposes secrets: %d %s", w.Code, w.Body.String())
+	}
+
+	w = serve(router, http.MethodPost, "/api/sites/blog/secrets/reveal")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var secrets models.SiteSecrets
+	json.Unmarshal(w.Body.Bytes(), &secrets)
+	if secrets.DBPassword != "db-secret" || secrets.AdminPassword != "admin-secret" {
+		t.Errorf("unexpected secrets: %+v", secrets)
+	}
+	activities, _, _ := services.Activities.Query(services.ActivityQuery{})
+	if len(activities) == 0 || activities[0].Level != "security" || !strings.Contains(activities[0].Message, "revealed") {
+		t.Errorf("reveal was not logged: %+v", activities)
+	}
+
+	if w := serve(router, http.MethodPost, "/api/sites/unknown/secrets/reveal"); w.Code != http.StatusNotFound {
+		t.Errorf("unknown site: expected 404, got %d", w.Code)
+	}
+}
+
+func TestGetActivitiesFiltersAndPaginates(t *testing.T) {
+	setupSite(t, fakes.NewExecutor())
+	for i := 0; i < 3; i++ {
+		services.LogActivity("info", fmt.Sprintf("Backup %d created", i), "blog")
+	}
+	services.LogActivity("error", "Backup failed", "shop")
+
+	router := gin.New()
+	router.Use(asAdmin)
+	router.GET("/api/activities", GetActivities)
+
+	w := serve(router, http.MethodGet, "/api/activities?projectName=blog&limit=2")
+	var page []models.Activity
+	json.Unmarshal(w.Body.Bytes(), &page)
+	next := w.Header().Get("X-Next-Cursor")
+	if w.Code != http.StatusOK || len(page) != 2 || page[0].Message != "Backup 2 created" || next == "" {
+		t.Fatalf("unexpected first page: %d %s (cursor %q)", w.Code, w.Body.String(), next)
+	}
+
+	w = serve(router, http.MethodGet, "/api/activities?projectName=blog&limit=2&cursor="+next)
+	json.Unmarshal(w.Body.Bytes(), &page)
+	if len(page) != 1 || page[0].Message != "Backup 0 created" || w.Header().Get("X-Next-Cursor") != "" {
+		t.Errorf("unexpected last page: %s", w.Body.String())
+	}
+
+	w = serve(router, http.MethodGet, "/api/activities?level=error&q=FAILED")
+	json.Unmarshal(w.Body.Bytes(), &page)
+	if len(page) != 1 || page[0].ProjectName != "shop" {
+		t.Errorf("unexpected search result: %s", w.Body.String())
+	}
+
+	for _, query := range []string{"cursor=abc", "since=yesterday", "limit=-1"} {
+		if w := serve(router, http.MethodGet, "/api/activities?"+query); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", query, w.Code)
+		}
+	}
+}
+
+func TestActivitiesRecordActorAndRequest(t *testing.T) {
+	setupSite(t, fakes.NewExecutor())
+	token := withUser(t, "alice", services.RoleAdmin)
+
+	router := gin.New()
+	router.Use(RequestContext())
+	api := router.Group("/api", AuthMiddleware())
+	api.POST("/sites/:projectName/secrets/reveal", RevealSiteSecrets)
+	api.GET("/audit/export", ExportAudit)
+
+	request := func(method, path, requestID string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("User-Agent", "audit-test")
+		if requestID != "" {
+			req.Header.Set("X-Request-ID", requestID)
+		}
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := request(http.MethodPost, "/api/sites/blog/secrets/reveal", "req-42")
+	if w.Code != http.StatusOK || w.Header().Get("X-Request-ID") != "req-42" {
+		t.Fatalf("unexpected response: %d %q", w.Code, w.Header().Get("X-Request-ID"))
+	}
+	if w := request(http.MethodPost, "/api/sites/blog/secrets/reveal", "not a valid id"); w.Header().Get("X-Request-ID") == "not a valid id" {
+		t.Error("invalid request id was echoed")
+	}
+
+	activities, _, _ := services.Activities.Query(services.ActivityQuery{RequestID: "req-42"})
+	if len(activities) != 1 {
+		t.Fatalf("expected one activity for the request, got %+v", activities)
+	}
+	if got := activities[0]; got.Actor != "alice" || got.Action != "site.secrets.reveal" || got.UserAgent != "audit-test" || got.ClientIP == "" {
+		t.Errorf("activity is missing audit fields: %+v", got)
+	}
+
+	w = request(http.MethodGet, "/api/audit/export?format=csv&actor=alice&action=site.secrets.reveal", "")
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/csv") ||
+		!strings.Contains(w.Header().Get("Content-Disposition"), "attachment") {
+		t.Fatalf("unexpected export response: %d %v", w.Code, w.Header())
+	}
+	if lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n"); len(lines) != 3 || !strings.Contains(lines[2], ",req-42,") {
+		t.Errorf("unexpected export: %s", w.Body.String())
+	}
+
+	if w := request(http.MethodGet, "/api/audit/export?format=xml", ""); w.Code != http.StatusBadRequest {
+		t.Errorf("unknown format: expected 400, got %d", w.Code)
+	}
+}
+
+func TestRetryDeployment(t *testing.T) {
+	fake := fakes.NewExecutor().On("docker inspect", fakes.Result{Stdout: "healthy\n"})
+	setupSite(t, fake)
+
+	router := gin.New()
+	router.Use(asAdmin)
+	router.POST("/api/sites/:projectName/retry", RetryDeployment)
+
+	if w := serve(router, http.MethodPost, "/api/sites/blog/retry"); w.Code != http.StatusConflict {
+		t.Fatalf("active site: expected 409, got %d: %s", w.Code, w.Body.String())
+	}
+
+	_, err := services.UpdateSite("blog", func(site *models.Site) {
+		site.Status = "failed"
+		site.AdminUsername = "admin"
+		site.AdminPassword = "adminpass"
+		site.Deployment = &models.Deployment{RetainOnFailure: true, FailedStep: "install", Attempts: 1}
+	})
+	if err != nil {
+		t.Fatalf("UpdateSite: %v", err)
+	}
+	w := serve(router, http.MethodPost, "/api/sites/blog/retry")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"step":"install"`) {
+		t.Fatalf("expected 200 resuming at install, got %d: %s", w.Code, w.Body.String())
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		site, _ := services.GetSite("blog")
+		if site.Status == "active" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("retried deployment did not finish: status %q, %+v", site.Status, site.Deployment)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if fake.Ran("up -d") || !fake.Ran("wp core install") {
+		t.Errorf("retry did not resume at the failed step:\n%s", strings.Join(fake.Commands(), "\n"))
+	}
+}
+
+func TestCloneWordPressSite(t *testing.T) {
+	fake := fakes.NewExecutor().On("docker inspect", fakes.Result{Stdout: "healthy\n"})
+	setupSite(t, fake)
+
+	router := gin.New()
+	router.Use(asAdmin)
+	router.POST("/api/sites/:projectName/clone", CloneWordPressSite)
+
+	if w := serveJSON(router, http.MethodPost, "/api/sites/blog/clone", "", gin.H{"projectName": "Not Valid"}); w.Code != http.StatusBadRequest {
+		t.Errorf("invalid name: expected 400, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := serveJSON(router, http.MethodPost, "/api/sites/ghost/clone", "", gin.H{"projectName": "copy"}); w.Code != http.StatusNotFound {
+		t.Errorf("unknown source: expected 404, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := serveJSON(router, http.MethodPost, "/api/sites/blog/clone", "", gin.H{"projectName": "blog"}); w.Code != http.StatusConflict {
+		t.Errorf("existing name: expected 409, got %d: %s", w.Code, w.Body.String())
+	}
+
+	_, err := services.UpdateSite("blog", func(site *models.Site) {
+		site.SiteURL = "http://vps.test:8200"
+		site.AdminUsername = "admin"
+		site.AdminPassword = "adminpass"
+	})
+	if err != nil {
+		t.Fatalf("UpdateSite: %v", err)
+	}
+	w := serveJSON(router, http.MethodPost, "/api/sites/blog/clone", "", gin.H{"projectName": "copy"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	clone, err := services.GetSite("copy")
+	if err != nil {
+		t.Fatalf("GetSite: %v", err)
+	}
+	if clone.WPPort == 8200 || clone.DBName != "copy_db" || clone.DBPassword == "" || clone.Origin == nil || clone.Origin.ProjectName != "blog" {
+		t.Errorf("clone not provisioned as its own site: %+v", clone)
+	}
+
+	// Without a compose template the deployment fails and the clone goes
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, err := services.GetSite("copy"); err != nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("failed clone was not removed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !fake.Ran("mariadb-dump -u root blog_db") {
+		t.Errorf("source was not backed up:\n%s", strings.Join(fake.Commands(), "\n"))
+	}
+}
+
+func TestStagingSite(t *testing.T) {
+	setupSite(t, fakes.NewExecutor())
+
+	router := gin.New()
+	router.Use(asAdmin)
+	router.POST("/api/sites/:projectName/staging", CreateStagingSite)
+	router.POST("/api/sites/:projectName/staging/push", PushStagingSite)
+	router.POST("/api/sites/:projectName/staging/revert", RevertStagingPush)
+
+	if w := serveJSON(router, http.MethodPost, "/api/sites/blog/staging/push", "", gin.H{"mode": "everything"}); w.Code != http.StatusBadRequest {
+		t.Errorf("invalid mode: expected 400, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := serveJSON(router, http.MethodPost, "/api/sites/blog/staging/push", "", gin.H{"mode": "db", "excludeTables": []string{"wp_options`"}}); w.Code != http.StatusBadRequest {
+		t.Errorf("invalid table: expected 400, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := serveJSON(router, http.MethodPost, "/api/sites/ghost/staging/push", "", gin.H{"mode": "db"}); w.Code != http.StatusNotFound {
+		t.Errorf("unknown site: expected 404, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := serveJSON(router, http.MethodPost, "/api/sites/blog/staging/push", "", gin.H{"mode": "db"}); w.Code != http.StatusConflict {
+		t.Errorf("no staging site: expected 409, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := serve(router, http.MethodPost, "/api/sites/blog/staging/revert"); w.Code != http.StatusConflict {
+		t.Errorf("nothing pushed: expected 409, got %d: %s", w.Code, w.Body.String())
+	}
+
+	if _, err := services.UpdateSite("blog", func(site *models.Site) { site.Staging = "blog-staging" }); err != nil {
+		t.Fatalf("UpdateSite: %v", err)
+	}
+	w := serve(router, http.MethodPost, "/api/sites/blog/staging")
+	if w.Code != http.StatusConflict || !strings.Contains(w.Body.String(), "blog-staging") {
+		t.Errorf("existing staging site: expected 409 naming it, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := serveJSON(router, http.MethodPost, "/api/sites/blog/staging/push", "", gin.H{"mode": "both"}); w.Code != http.StatusConflict {
+		t.Errorf("missing staging site: expected 409, got %d: %s", w.Code, w.Body.String())
+	}
+
+	if _, err := services.UpdateSite("blog", func(site *models.Site) { site.Staging, site.StagingOf = "", "shop" }); err != nil {
+		t.Fatalf("UpdateSite: %v", err)
+	}
+	if w := serve(router, http.MethodPost, "/api/sites/blog/staging"); w.Code != http.StatusConflict {
+		t.Errorf("staging of a staging site: expected 409, got %d: %s", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,226 @@
+// Package fakes holds test doubles shared by the tests of several packages.
+// It is only imported from tests.
+package fakes
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Result is the canned outcome of a command run by an Executor.
+type Result struct {
+	Stdout   string
+	Stderr   string
+	ExitCode int
+	// Err, when set, is returned as-is instead of an exit status error.
+	Err error
+	// Delay makes the command take this long unless its context is done first.
+	Delay time.Duration
+}
+
+// Executor is a scriptable services.Executor that records commands instead
+// of running them. Commands that match no rule succeed with empty output.
+type Executor struct {
+	mu       sync.Mutex
+	rules    []*rule
+	commands []string
+	files    map[string][]byte
+	closed   bool
+}
+
+type rule struct {
+	substring string
+	results   []Result
+	calls     int
+}
+
+// NewExecutor returns an empty Executor.
+func NewExecutor() *Executor {
+	return &Executor{files: make(map[string][]byte)}
+}
+
+// On scripts the results for commands containing substring. Successive
+// matching calls consume results in order and the last one repeats. Rules
+// added later take precedence over earlier ones.
+func (f *Executor) On(substring string, results ...Result) *Executor {
+	if len(results) == 0 {
+		results = []Result{{}}
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.rules = append(f.rules, &rule{substring: substring, results: results})
+	return f
+}
+
+// SetFile stores content that Download will return for path.
+func (f *Executor) SetFile(path string, content []byte) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.files[path] = content
+}
+
+// File returns the content uploaded to path.
+func (f *Executor) File(path string) ([]byte, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	content, ok := f.files[path]
+	return content, ok
+}
+
+// Commands returns every command run so far, in order.
+func (f *Executor) Commands() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.commands...)
+}
+
+// Ran reports whether any command containing substring was run.
+func (f *Executor) Ran(substring string) bool {
+	return len(f.CommandsMatching(substring)) > 0
+}
+
+// CommandsMatching returns the commands containing substring.
+func (f *Executor) CommandsMatching(substring string) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var matched []string
+	for _, cmd := range f.commands {
+		if strings.Contains(cmd, substring) {
+			matched = append(matched, cmd)
+		}
+	}
+	return matched
+}
+
+// Closed reports whether Close has been called.
+func (f *Executor) Closed() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.closed
+}
+
+// Run records command and returns its scripted result.
+func (f *Executor) Run(ctx context.Context, command string) (string, string, error) {
+	result, err := f.run(ctx, command)
+	if err != nil {
+		return "", "", err
+	}
+	return result.Stdout, result.Stderr, resultError(result)
+}
+
+// Stream records command and writes its scripted output to stdout and stderr.
+func (f *Executor) Stream(ctx context.Context, command string, stdout, stderr io.Writer) error {
+	result, err := f.run(ctx, command)
+	if err != nil {
+		return err
+	}
+	if stdout != nil {
+		io.WriteString(stdout, result.Stdout)
+	}
+	if stderr != nil {
+		io.WriteString(stderr, result.Stderr)
+	}
+	return resultError(result)
+}
+
+// Upload stores content in memory under remotePath.
+func (f *Executor) Upload(ctx context.Context, remotePath string, content io.Reader) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	data, err := io.ReadAll(content)
+	if err != nil {
+		return err
+	}
+	f.SetFile(remotePath, data)
+	return nil
+}
+
+// Download writes the content stored under remotePath to w.
+func (f *Executor) Download(ctx context.Context, remotePath string, w io.Writer) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	content, ok := f.File(remotePath)
+	if !ok {
+		return fmt.Errorf("failed to open remote file: %w", os.ErrNotExist)
+	}
+	_, err := w.Write(content)
+	return err
+}
+
+// Close marks the executor as closed.
+func (f *Executor) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.closed = true
+	return nil
+}
+
+// run records command and waits out its delay. Like a real session, nothing is
+// run once ctx is done, and a delayed command is abandoned when ctx is cancelled.
+func (f *Executor) run(ctx context.Context, command string) (Result, error) {
+	if err := ctx.Err(); err != nil {
+		return Result{}, fmt.Errorf("SSH command cancelled: %w", err)
+	}
+	result := f.record(command)
+	if result.Delay > 0 {
+		timer := time.NewTimer(result.Delay)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			return Result{}, fmt.Errorf("SSH command cancelled: %w", ctx.Err())
+		}
+	}
+	return result, nil
+}
+
+func (f *Executor) record(command string) Result {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.commands = append(f.commands, command)
+
+	for i := len(f.rules) - 1; i >= 0; i-- {
+		rule := f.rules[i]
+		if !strings.Contains(command, rule.substring) {
+			continue
+		}
+		idx := rule.calls
+		if idx >= len(rule.results) {
+			idx = len(rule.results) - 1
+		}
+		rule.calls++
+		return rule.results[idx]
+	}
+	return Result{}
+}
+
+func resultError(result Result) error {
+	if result.Err != nil {
+		return result.Err
+	}
+	if result.ExitCode != 0 {
+		return fmt.Errorf("SSH command failed: %w", &exitError{code: result.ExitCode})
+	}
+	return nil
+}
+
+// exitError reports a non-zero exit status the way *ssh.ExitError does.
+type exitError struct {
+	code int
+}
+
+func (e *exitError) Error() string {
+	return fmt.Sprintf("process exited with status %d", e.code)
+}
+
+// ExitStatus returns the exit status of the command.
+func (e *exitError) ExitStatus() int {
+	return e.code
+}
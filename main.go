@@ -4,15 +4,72 @@ import (
 	"log"
 	"wordpress-collab-tool/config"
 	"wordpress-collab-tool/server"
+	"wordpress-collab-tool/services"
 )
 
 func main() {
 	// Load configuration
 	cfg := config.LoadConfig()
-	_ = cfg // Use cfg to avoid "declared and not used" error for now. Will be used later.
+
+	// Open the site store, importing sites.json on first use
+	if err := services.InitSiteStore(cfg); err != nil {
+		log.Fatalf("Failed to open site store: %v", err)
+	}
+
+	if err := services.InitActivityStore(cfg); err != nil {
+		log.Fatalf("Failed to open activity log: %v", err)
+	}
+
+	// Open the user accounts, creating the first admin from ADMIN_PASSWORD
+	if err := services.InitUserStore(cfg); err != nil {
+		log.Fatalf("Failed to open user store: %v", err)
+	}
+	services.InitLoginThrottle(cfg)
+	if err := services.InitJWTKey(cfg); err != nil {
+		log.Fatalf("Failed to load JWT signing key: %v", err)
+	}
+	if err := services.InitSessionStore(cfg); err != nil {
+		log.Fatalf("Failed to open session store: %v", err)
+	}
+	if err := services.InitAPITokenStore(cfg); err != nil {
+		log.Fatalf("Failed to open personal access token store: %v", err)
+	}
+	if err := services.InitTwoFactor(cfg); err != nil {
+		log.Fatalf("Failed to load two-factor keys: %v", err)
+	}
+	// Re-encrypt secrets sealed with a previous master key
+	if err := services.RotateSecrets(); err != nil {
+		log.Fatalf("Failed to re-encrypt secrets: %v", err)
+	}
+	if err := services.InitOIDC(cfg); err != nil {
+		log.Fatalf("Failed to configure single sign-on: %v", err)
+	}
+
+	// Hosts registered through the API, plus the one from the SSH_* variables
+	services.InitHosts(cfg)
+
+	// Share one SSH connection pool across all requests
+	if err := services.InitHostKeys(cfg); err != nil {
+		log.Fatalf("Failed to load SSH host keys: %v", err)
+	}
+	services.InitSSHPool(cfg)
+	services.InitTimeouts(cfg)
+	services.InitLogStreams(cfg)
+	services.InitSiteLocks(cfg)
+	services.InitJobs(cfg)
+
+	// Jobs still marked as running were cut short by the last shutdown
+	if interrupted, err := services.RecoverInterruptedJobs(); err != nil {
+		log.Printf("WARNING: failed to check for interrupted jobs: %v", err)
+	} else if len(interrupted) > 0 {
+		log.Printf("Marked %d job(s) interrupted by the last shutdown as failed", len(interrupted))
+	}
 
 	// Setup Gin router
-	router := server.SetupRouter()
+	router, err := server.SetupRouter(cfg)
+	if err != nil {
+		log.Fatalf("Failed to set up the router: %v", err)
+	}
 
 	// Setup routes
 	server.SetupRoutes(router)
@@ -1,19 +1,114 @@
 package models
 
-import "github.com/dgrijalva/jwt-go"
+import (
+	"encoding/json"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
 
 // Site represents a WordPress site.
 type Site struct {
 	ProjectName   string   `json:"projectName"`
 	WPPort        int      `json:"wpPort"`
 	DBName        string   `json:"dbName"`
-	DBPassword    string   `json:"dbPassword"`
+	DBPassword    string   `json:"dbPassword,omitempty"`
 	SiteURL       string   `json:"siteURL"`
 	Plugins       []string `json:"plugins"`
 	Status        string   `json:"status"`
 	AdminUsername string   `json:"adminUsername"`
-	AdminPassword string   `json:"adminPassword"`
+	AdminPassword string   `json:"adminPassword,omitempty"`
 	LastChecked   string   `json:"lastChecked"`
+	// Host is the name of the VPS the site runs on. Empty means the default host.
+	Host string `json:"host,omitempty"`
+	// Owner is the user who created the site or had it transferred to them.
+	// Sites without an owner are only visible to admins.
+	Owner string `json:"owner,omitempty"`
+	// Members are the other users who may work on the site.
+	Members []SiteMember `json:"members,omitempty"`
+	// Version is incremented by the site store on every update.
+	Version int64 `json:"version"`
+	// Sealed holds the encrypted DBPassword and AdminPassword while the site
+	// is at rest; it is never set on sites returned by the site store.
+	Sealed *SealedSecrets `json:"sealedSecrets,omitempty"`
+	// Deployment is set while the site's deployment has not succeeded yet.
+	Deployment *Deployment `json:"deployment,omitempty"`
+	// Origin is set on sites cloned from another site.
+	Origin *SiteOrigin `json:"origin,omitempty"`
+	// Staging names the staging site of a live site; StagingOf names the
+	// live site of a staging site.
+	Staging   string `json:"staging,omitempty"`
+	StagingOf string `json:"stagingOf,omitempty"`
+	// LastPush describes the last push of the staging site to this site.
+	LastPush *StagingPush `json:"lastPush,omitempty"`
+}
+
+// StagingPush describes a push of a staging site to its live site. Backup
+// is the backup of the live site taken just before, which reverts the push.
+type StagingPush struct {
+	// Mode is "files", "db" or "both".
+	Mode          string     `json:"mode"`
+	IncludeTables []string   `json:"includeTables,omitempty"`
+	ExcludeTables []string   `json:"excludeTables,omitempty"`
+	Backup        string     `json:"backup"`
+	PushedBy      string     `json:"pushedBy,omitempty"`
+	PushedAt      time.Time  `json:"pushedAt"`
+	RevertedAt    *time.Time `json:"revertedAt,omitempty"`
+}
+
+// SiteOrigin records the site a clone was copied from. Backup is the
+// archive of the source the copy was made from, set once the copy is done.
+type SiteOrigin struct {
+	ProjectName string     `json:"projectName"`
+	SiteURL     string     `json:"siteURL"`
+	Backup      string     `json:"backup,omitempty"`
+	ClonedAt    *time.Time `json:"clonedAt,omitempty"`
+}
+
+// Deployment describes an unfinished deployment of a site. A failed
+// deployment is rolled back unless RetainOnFailure is set; then what it
+// created is kept for debugging and a retry resumes at FailedStep.
+type Deployment struct {
+	RetainOnFailure bool       `json:"retainOnFailure"`
+	FailedStep      string     `json:"failedStep,omitempty"`
+	Error           string     `json:"error,omitempty"`
+	Attempts        int        `json:"attempts"`
+	FailedAt        *time.Time `json:"failedAt,omitempty"`
+}
+
+// Redacted returns a copy of the site without its secrets, for API responses.
+func (s Site) Redacted() Site {
+	s.DBPassword = ""
+	s.AdminPassword = ""
+	s.Sealed = nil
+	return s
+}
+
+// SiteMember grants a user a role on a single site.
+type SiteMember struct {
+	Username string `json:"username"`
+	// Role is viewer, editor or maintainer.
+	Role    string    `json:"role"`
+	AddedBy string    `json:"addedBy,omitempty"`
+	AddedAt time.Time `json:"addedAt"`
+}
+
+// SiteSecrets are the secret fields of a site.
+type SiteSecrets struct {
+	DBPassword    string `json:"dbPassword"`
+	AdminPassword string `json:"adminPassword"`
+}
+
+// SealedSecrets is the envelope-encrypted form of SiteSecrets. The secrets
+// are encrypted with a random data key, which is in turn encrypted with the
+// master key identified by KeyID. Both values are base64-encoded nonce and
+// AES-GCM ciphertext.
+type SealedSecrets struct {
+	KeyID      string `json:"keyId"`
+	WrappedKey string `json:"wrappedKey"`
+	Ciphertext string `json:"ciphertext"`
 }
 
 // Config holds the variables for the docker-compose template.
@@ -25,29 +120,243 @@ type Config struct {
 	DBPassword  string
 }
 
-// SSHConfig holds the SSH connection details for the VPS.
+// SSH authentication methods supported by SSHConfig.
+const (
+	SSHAuthPassword    = "password"
+	SSHAuthKey         = "key"
+	SSHAuthAgent       = "agent"
+	SSHAuthCertificate = "certificate"
+)
+
+// SSHConfig holds the SSH connection details and credentials for a VPS.
 type SSHConfig struct {
-	User     string
-	Host     string
-	Password string
+	User       string `json:"user"`
+	Host       string `json:"host,omitempty"`
+	Port       int    `json:"port,omitempty"`
+	AuthMethod string `json:"authMethod"` // "password", "key", "agent" or "certificate"
+	Password   string `json:"password,omitempty"`
+	// PrivateKeyPath is used by the key and certificate methods.
+	PrivateKeyPath string `json:"privateKeyPath,omitempty"`
+	Passphrase     string `json:"passphrase,omitempty"`
+	// CertificatePath points to the OpenSSH certificate signed for the private key.
+	CertificatePath string `json:"certificatePath,omitempty"`
+}
+
+// Address returns the host:port to dial.
+func (c SSHConfig) Address() string {
+	port := c.Port
+	if port == 0 {
+		port = 22
+	}
+	return net.JoinHostPort(c.Host, strconv.Itoa(port))
 }
 
-// User represents a user for authentication.
+// Host is a VPS registered with the control panel.
+type Host struct {
+	Name    string `json:"name"`
+	Address string `json:"address"`
+	Port    int    `json:"port"`
+	// Credentials holds the user and authentication settings; its Host and
+	// Port fields are ignored in favour of Address and Port.
+	Credentials SSHConfig         `json:"credentials"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	// Capacity is the maximum number of sites placed on the host.
+	Capacity int `json:"capacity"`
+}
+
+// SSH returns the connection details for the host.
+func (h Host) SSH() SSHConfig {
+	cfg := h.Credentials
+	cfg.Host = h.Address
+	cfg.Port = h.Port
+	return cfg
+}
+
+// User is an account that can sign in to the tool. The password hash is
+// never serialised.
 type User struct {
-	Username string `json:"username"`
-	Password string `json:"password"`
+	Username     string `json:"username"`
+	PasswordHash string `json:"-"`
+	// Role is one of viewer, editor, maintainer or admin.
+	Role     string `json:"role"`
+	Disabled bool   `json:"disabled"`
+	// Provider is "oidc" for accounts created by single sign-on, which
+	// have no password. Subject identifies them at the provider.
+	Provider string `json:"provider,omitempty"`
+	Subject  string `json:"subject,omitempty"`
+	// FailedLogins counts consecutive failed sign-ins; reaching the
+	// configured limit locks the account until LockedUntil.
+	FailedLogins int        `json:"failedLogins"`
+	LockedUntil  *time.Time `json:"lockedUntil,omitempty"`
+	// SessionVersion is embedded in issued tokens. Incrementing it, as a
+	// password change does, invalidates every token issued before.
+	SessionVersion    int        `json:"-"`
+	PasswordChangedAt time.Time  `json:"passwordChangedAt"`
+	LastLoginAt       *time.Time `json:"lastLoginAt,omitempty"`
+	// TwoFactorEnabled requires a TOTP or recovery code after the password
+	// on sign-in. The settings themselves are never serialised.
+	TwoFactorEnabled bool      `json:"twoFactorEnabled"`
+	TwoFactor        TwoFactor `json:"-"`
+	CreatedAt        time.Time `json:"createdAt"`
+	UpdatedAt        time.Time `json:"updatedAt"`
+	Version          int64     `json:"version"`
+}
+
+// TwoFactor holds a user's TOTP settings. The secrets are sealed with the
+// secrets master key.
+type TwoFactor struct {
+	Secret *SealedSecrets `json:"secret,omitempty"`
+	// PendingSecret is the secret of an enrolment that was not confirmed
+	// with a code yet.
+	PendingSecret *SealedSecrets `json:"pendingSecret,omitempty"`
+	// RecoveryCodes are the SHA-256 hashes of the unused recovery codes.
+	RecoveryCodes []string `json:"recoveryCodes,omitempty"`
+	// LastStep is the time step of the last accepted code. Codes of that
+	// step or earlier are refused, so each code works once.
+	LastStep int64 `json:"lastStep,omitempty"`
+}
+
+// Credentials is a username and password, as sent to sign in or to create
+// a user.
+type Credentials struct {
+	Username string `json:"username" binding:"required"`
+	Password string `json:"password" binding:"required"`
 }
 
-// Claims represents the JWT claims.
+// Claims represents the JWT claims. The token id (jti) and the session id
+// let a token be revoked before it expires.
 type Claims struct {
-	Username string `json:"username"`
+	Username       string `json:"username"`
+	Role           string `json:"role"`
+	SessionVersion int    `json:"sv"`
+	SessionID      string `json:"sid"`
 	jwt.StandardClaims
 }
 
+// Session is a sign-in of a user on one device. It lasts as long as its
+// refresh token keeps being used; each use replaces the refresh token.
+type Session struct {
+	ID         string     `json:"id"`
+	Username   string     `json:"username"`
+	ClientIP   string     `json:"clientIp,omitempty"`
+	UserAgent  string     `json:"userAgent,omitempty"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	LastUsedAt time.Time  `json:"lastUsedAt"`
+	ExpiresAt  time.Time  `json:"expiresAt"`
+	RevokedAt  *time.Time `json:"revokedAt,omitempty"`
+	// SessionVersion is the user's session version when the session
+	// started; the session ends when the user's changes.
+	SessionVersion int `json:"-"`
+}
+
+// APIToken is a personal access token. It acts for its user, limited to its
+// scopes, and is stored as a hash; Prefix identifies it in listings.
+type APIToken struct {
+	ID         string     `json:"id"`
+	Username   string     `json:"username"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty"`
+	ExpiresAt  *time.Time `json:"expiresAt,omitempty"`
+}
+
 // Activity represents a log entry for an action.
 type Activity struct {
+	ID          int64  `json:"id,omitempty"`
 	Message     string `json:"message"`
 	Timestamp   string `json:"timestamp"`
 	Level       string `json:"level"` // e.g., "info", "error"
 	ProjectName string `json:"projectName,omitempty"`
-}
\ No newline at end of file
+	// Count is how many identical activities were folded into this one,
+	// the last of them at LastSeen.
+	Count    int    `json:"count,omitempty"`
+	LastSeen string `json:"lastSeen,omitempty"`
+
+	// Action names what was done, such as "site.delete" or "backup.restore".
+	Action string `json:"action,omitempty"`
+	// Actor is the user who made the request; empty for system activities.
+	Actor     string `json:"actor,omitempty"`
+	ClientIP  string `json:"clientIp,omitempty"`
+	UserAgent string `json:"userAgent,omitempty"`
+	RequestID string `json:"requestId,omitempty"`
+	// Before and After describe the affected object around the change.
+	Before json.RawMessage `json:"before,omitempty"`
+	After  json.RawMessage `json:"after,omitempty"`
+}
+
+// SSHPoolStats reports the state of the SSH connection pool for one host.
+type SSHPoolStats struct {
+	Host        string `json:"host"`
+	Open        int    `json:"open"`
+	Idle        int    `json:"idle"`
+	InUse       int    `json:"inUse"`
+	MaxSessions int    `json:"maxSessions"`
+	Dials       int    `json:"dials"`
+	Reconnects  int    `json:"reconnects"`
+}
+
+// HostKey is an SSH host key that is either pinned in known_hosts or
+// waiting for approval.
+type HostKey struct {
+	Host        string `json:"host"`
+	Type        string `json:"type"`
+	Fingerprint string `json:"fingerprint"`
+	Status      string `json:"status"` // "pinned" or "pending"
+	Blocked     bool   `json:"blocked"`
+	SeenAt      string `json:"seenAt,omitempty"`
+}
+
+// Job states.
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+	JobCancelled = "cancelled"
+)
+
+// Job is a long-running operation on a site, such as a deployment, backup or
+// restore, that runs in the background and is persisted across restarts.
+type Job struct {
+	ID          string     `json:"id"`
+	Type        string     `json:"type"` // "deploy", "backup" or "restore"
+	ProjectName string     `json:"projectName"`
+	State       string     `json:"state"`
+	Steps       []JobStep  `json:"steps"`
+	Error       string     `json:"error,omitempty"`
+	CreatedBy   string     `json:"createdBy,omitempty"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	StartedAt   *time.Time `json:"startedAt,omitempty"`
+	FinishedAt  *time.Time `json:"finishedAt,omitempty"`
+}
+
+// Finished reports whether the job has reached a final state.
+func (j Job) Finished() bool {
+	return j.State == JobSucceeded || j.State == JobFailed || j.State == JobCancelled
+}
+
+// JobStep records the progress of one stage of a job.
+type JobStep struct {
+	Name       string     `json:"name"`
+	State      string     `json:"state"`
+	StartedAt  time.Time  `json:"startedAt"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+}
+
+// LogLine is one line of live operation output.
+type LogLine struct {
+	Time   time.Time `json:"time"`
+	Stream string    `json:"stream"` // "stdout", "stderr" or "info"
+	Text   string    `json:"text"`
+}
+
+// SiteLock describes the operation currently holding a site's lock.
+type SiteLock struct {
+	Operation string    `json:"operation"`
+	JobID     string    `json:"jobId,omitempty"`
+	Since     time.Time `json:"since"`
+	// Queued is the number of operations waiting for the lock.
+	Queued int `json:"queued"`
+}
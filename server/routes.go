@@ -3,32 +3,100 @@ package server
 import (
 	"github.com/gin-gonic/gin"
 	"wordpress-collab-tool/controllers" // Assuming controllers package will be at this path
+	"wordpress-collab-tool/services"
 )
 
 func SetupRoutes(router *gin.Engine) {
 	// Public routes
 	router.POST("/api/login", controllers.Login)
+	router.POST("/api/login/2fa", controllers.LoginTwoFactor)
+	router.GET("/api/oidc", controllers.GetOIDCStatus)
+	router.GET("/api/oidc/login", controllers.OIDCLogin)
+	router.GET("/api/oidc/callback", controllers.OIDCCallback)
+	router.POST("/api/oidc/token", controllers.OIDCToken)
+	router.POST("/api/refresh", controllers.RefreshToken)
 	router.POST("/api/logout", controllers.Logout)
 
-	// Authenticated routes
+	// Authenticated routes. Each one requires the permission named before
+	// its handler; services.RolePermissions lists what each role grants.
+	// Routes marked session manage the account and refuse personal access
+	// tokens.
 	auth := router.Group("/api")
 	auth.Use(controllers.AuthMiddleware()) // Assuming AuthMiddleware is in controllers
+	can := controllers.RequirePermission
+	session := controllers.RequireSession()
 	{
-		auth.POST("/sites", controllers.CreateWordPressSite)
-		auth.GET("/sites", controllers.GetWordPressSites)
-		auth.GET("/sites/:projectName", controllers.GetWordPressSite)
-		auth.DELETE("/sites/:projectName", controllers.DeleteWordPressSite)
-		auth.POST("/sites/:projectName/restart", controllers.RestartWordPressSite)
-		auth.POST("/sites/:projectName/backups", controllers.CreateBackup)
-		auth.GET("/sites/:projectName/backups", controllers.ListBackups)
-		auth.POST("/sites/:projectName/backups/restore", controllers.RestoreBackup)
-		auth.GET("/sites/:projectName/plugins", controllers.GetSitePlugins)
-		auth.POST("/sites/:projectName/plugins/:pluginName", controllers.InstallPlugin)
-		auth.DELETE(" /sites/:projectName/plugins/:pluginName", controllers.DeletePlugin)
-		auth.POST("/sites/:projectName/plugins/:pluginName/activate", controllers.ActivatePlugin)
-		auth.POST("/sites/:projectName/plugins/:pluginName/deactivate", controllers.DeactivatePlugin)
-		auth.GET("/vps/stats", controllers.GetVPSStats)
-		auth.GET("/activities", controllers.GetActivities)
-		
+		auth.POST("/sites", can(services.PermSitesWrite), controllers.CreateWordPressSite)
+		auth.GET("/sites", can(services.PermSitesRead), controllers.GetWordPressSites)
+		auth.GET("/sites/:projectName", can(services.PermSitesRead), controllers.GetWordPressSite)
+		auth.DELETE("/sites/:projectName", can(services.PermSitesDelete), controllers.DeleteWordPressSite)
+		auth.POST("/sites/:projectName/restart", can(services.PermSitesWrite), controllers.RestartWordPressSite)
+		auth.POST("/sites/:projectName/retry", can(services.PermSitesWrite), controllers.RetryDeployment)
+		auth.POST("/sites/:projectName/clone", can(services.PermSitesWrite), controllers.CloneWordPressSite)
+		auth.POST("/sites/:projectName/staging", can(services.PermSitesWrite), controllers.CreateStagingSite)
+		auth.POST("/sites/:projectName/staging/push", can(services.PermBackupsRestore), controllers.PushStagingSite)
+		auth.POST("/sites/:projectName/staging/revert", can(services.PermBackupsRestore), controllers.RevertStagingPush)
+		auth.POST("/sites/:projectName/secrets/reveal", can(services.PermSiteSecretsRead), controllers.RevealSiteSecrets)
+		auth.GET("/sites/:projectName/members", can(services.PermSitesRead), controllers.ListSiteMembers)
+		auth.POST("/sites/:projectName/members", can(services.PermSiteMembers), controllers.AddSiteMember)
+		auth.DELETE("/sites/:projectName/members/:username", can(services.PermSiteMembers), controllers.RemoveSiteMember)
+		auth.POST("/sites/:projectName/transfer", can(services.PermSiteMembers), controllers.TransferSiteOwnership)
+		auth.POST("/sites/:projectName/cancel", can(services.PermJobsCancel), controllers.CancelSiteJobs)
+		auth.GET("/sites/:projectName/deploy/logs", can(services.PermSitesRead), controllers.StreamDeployLogs)
+		auth.POST("/sites/:projectName/backups", can(services.PermBackupsWrite), controllers.CreateBackup)
+		auth.GET("/sites/:projectName/backups", can(services.PermBackupsRead), controllers.ListBackups)
+		auth.POST("/sites/:projectName/backups/restore", can(services.PermBackupsRestore), controllers.RestoreBackup)
+		auth.GET("/sites/:projectName/plugins", can(services.PermPluginsRead), controllers.GetSitePlugins)
+		auth.POST("/sites/:projectName/plugins/:pluginName", can(services.PermPluginsWrite), controllers.InstallPlugin)
+		auth.DELETE("/sites/:projectName/plugins/:pluginName", can(services.PermPluginsWrite), controllers.DeletePlugin)
+		auth.POST("/sites/:projectName/plugins/:pluginName/activate", can(services.PermPluginsWrite), controllers.ActivatePlugin)
+		auth.POST("/sites/:projectName/plugins/:pluginName/deactivate", can(services.PermPluginsWrite), controllers.DeactivatePlugin)
+		auth.GET("/vps/stats", can(services.PermHostsRead), controllers.GetVPSStats)
+		auth.GET("/ssh/pool", can(services.PermHostsRead), controllers.GetSSHPoolStats)
+		auth.GET("/hosts", can(services.PermHostsRead), controllers.ListHosts)
+		auth.POST("/hosts", can(services.PermHostsWrite), controllers.CreateHost)
+		auth.GET("/hosts/:name", can(services.PermHostsRead), controllers.GetHost)
+		auth.PUT("/hosts/:name", can(services.PermHostsWrite), controllers.UpdateHost)
+		auth.DELETE("/hosts/:name", can(services.PermHostsWrite), controllers.DeleteHost)
+		auth.GET("/hosts/:name/stats", can(services.PermHostsRead), controllers.GetVPSStats)
+		auth.GET("/hostkeys", can(services.PermHostsRead), controllers.ListHostKeys)
+		auth.POST("/hostkeys/:host/approve", can(services.PermHostKeysWrite), controllers.ApproveHostKey)
+		auth.DELETE("/hostkeys/:host", can(services.PermHostKeysWrite), controllers.RevokeHostKey)
+		auth.GET("/activities", can(services.PermActivitiesRead), controllers.GetActivities)
+		auth.GET("/audit/export", can(services.PermAuditExport), controllers.ExportAudit)
+		auth.GET("/jobs", can(services.PermJobsRead), controllers.ListJobs)
+		auth.GET("/jobs/:id", can(services.PermJobsRead), controllers.GetJob)
+		auth.POST("/jobs/:id/cancel", can(services.PermJobsCancel), controllers.CancelJob)
+		auth.GET("/me", controllers.GetCurrentUser)
+		auth.GET("/me/permissions", controllers.GetCurrentPermissions)
+		auth.PUT("/me/password", session, controllers.ChangeOwnPassword)
+		auth.GET("/me/sessions", session, controllers.ListOwnSessions)
+		auth.DELETE("/me/sessions/:id", session, controllers.RevokeOwnSession)
+		auth.POST("/logout/all", session, controllers.LogoutEverywhere)
+		auth.GET("/me/tokens", session, controllers.ListOwnAPITokens)
+		auth.POST("/me/tokens", session, controllers.CreateOwnAPIToken)
+		auth.DELETE("/me/tokens/:id", session, controllers.RevokeOwnAPIToken)
+		auth.GET("/me/2fa", session, controllers.GetOwnTwoFactor)
+		auth.POST("/me/2fa/totp", session, controllers.EnrollTOTP)
+		auth.POST("/me/2fa/totp/confirm", session, controllers.ConfirmTOTP)
+		auth.POST("/me/2fa/disable", session, controllers.DisableOwnTwoFactor)
+		auth.POST("/me/2fa/recovery-codes", session, controllers.RegenerateRecoveryCodes)
+		auth.GET("/roles", can(services.PermUsersRead), controllers.ListRoles)
+		auth.GET("/users", can(services.PermUsersRead), controllers.ListUsers)
+		auth.POST("/users", can(services.PermUsersWrite), controllers.CreateUser)
+		auth.GET("/users/:username", can(services.PermUsersRead), controllers.GetUser)
+		auth.DELETE("/users/:username", can(services.PermUsersWrite), controllers.DeleteUser)
+		auth.PUT("/users/:username/role", can(services.PermUsersWrite), controllers.SetUserRole)
+		auth.POST("/users/:username/disable", can(services.PermUsersWrite), controllers.DisableUser)
+		auth.POST("/users/:username/enable", can(services.PermUsersWrite), controllers.EnableUser)
+		auth.POST("/users/:username/password", can(services.PermUsersWrite), controllers.ResetUserPassword)
+		auth.GET("/users/:username/sessions", can(services.PermUsersRead), controllers.ListUserSessions)
+		auth.POST("/users/:username/logout", can(services.PermUsersWrite), controllers.LogoutUserEverywhere)
+		auth.GET("/users/:username/tokens", can(services.PermUsersRead), controllers.ListUserAPITokens)
+		auth.DELETE("/users/:username/tokens/:id", can(services.PermUsersWrite), controllers.RevokeUserAPIToken)
+		auth.DELETE("/users/:username/2fa", can(services.PermUsersWrite), controllers.ResetUserTwoFactor)
+		auth.POST("/users/:username/unlock", can(services.PermUsersWrite), controllers.UnlockUser)
+		auth.GET("/lockouts", can(services.PermUsersRead), controllers.ListLockouts)
+		auth.DELETE("/lockouts/ips/:ip", can(services.PermUsersWrite), controllers.ClearIPThrottle)
 	}
 }
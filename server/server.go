@@ -1,24 +1,34 @@
 package server
 
 import (
+	"fmt"
 	"time"
+	"wordpress-collab-tool/config"
+	"wordpress-collab-tool/controllers"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 )
 
-func SetupRouter() *gin.Engine {
+// SetupRouter creates the router with its global middleware. Client IPs,
+// which the sign-in throttle and the audit log rely on, are only taken
+// from X-Forwarded-For when the request comes from a trusted proxy.
+func SetupRouter(cfg *config.Config) (*gin.Engine, error) {
 	router := gin.Default()
+	if err := router.SetTrustedProxies(cfg.TrustedProxies); err != nil {
+		return nil, fmt.Errorf("invalid TRUSTED_PROXIES: %w", err)
+	}
 
 	// Add CORS middleware
 	router.Use(cors.New(cors.Config{
 		AllowOrigins:     []string{"http://localhost:3000"},
 		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization"},
-		ExposeHeaders:    []string{"Content-Length"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization", "X-Request-ID"},
+		ExposeHeaders:    []string{"Content-Length", "X-Next-Cursor", "X-Request-ID", "Content-Disposition"},
 		AllowCredentials: true,
 		MaxAge:           12 * time.Hour,
 	}))
+	router.Use(controllers.RequestContext())
 
-	return router
+	return router, nil
 }
@@ -0,0 +1,87 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"wordpress-collab-tool/config"
+	"wordpress-collab-tool/controllers"
+	"wordpress-collab-tool/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// newLoginRouter sets up the router with cfg, two failed sign-ins allowed
+// per client IP and an empty user store.
+func newLoginRouter(t *testing.T, cfg *config.Config) *gin.Engine {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	services.InitLoginThrottle(&config.Config{
+		LoginIPAttempts:     2,
+		LoginBackoffBase:    time.Minute,
+		LoginBackoffMax:     time.Hour,
+		LoginThrottleWindow: time.Hour,
+	})
+	store, err := services.OpenSQLiteUserStore(filepath.Join(t.TempDir(), "users.db"))
+	if err != nil {
+		t.Fatalf("OpenSQLiteUserStore: %v", err)
+	}
+	original := services.Users
+	services.Users = store
+	t.Cleanup(func() {
+		services.Users = original
+		store.Close()
+		services.InitLoginThrottle(config.LoadConfig())
+	})
+
+	router, err := SetupRouter(cfg)
+	if err != nil {
+		t.Fatalf("SetupRouter: %v", err)
+	}
+	router.POST("/api/login", controllers.Login)
+	return router
+}
+
+// loginFrom signs in as an unknown user with the given X-Forwarded-For.
+func loginFrom(router *gin.Engine, forwardedFor string) int {
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/api/login", strings.NewReader(`{"username":"ghost","password":"not-the-password"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Forwarded-For", forwardedFor)
+	router.ServeHTTP(w, req)
+	return w.Code
+}
+
+func TestForwardedForIgnoredFromUntrustedClients(t *testing.T) {
+	router := newLoginRouter(t, &config.Config{})
+
+	// Past the limit the next failure starts the wait, whatever the header says
+	for _, client := range []string{"198.51.100.1", "198.51.100.2", "198.51.100.3"} {
+		if code := loginFrom(router, client); code != http.StatusUnauthorized {
+			t.Fatalf("expected 401 until the limit is passed, got %d", code)
+		}
+	}
+	if code := loginFrom(router, "198.51.100.4"); code != http.StatusTooManyRequests {
+		t.Errorf("a spoofed X-Forwarded-For reset the per-IP limit: expected 429, got %d", code)
+	}
+}
+
+func TestForwardedForHonouredFromTrustedProxies(t *testing.T) {
+	// httptest requests come from 192.0.2.1
+	router := newLoginRouter(t, &config.Config{TrustedProxies: []string{"192.0.2.0/24"}})
+
+	for _, client := range []string{"198.51.100.1", "198.51.100.2", "198.51.100.3", "198.51.100.4"} {
+		if code := loginFrom(router, client); code != http.StatusUnauthorized {
+			t.Errorf("client %s behind the proxy: expected 401, got %d", client, code)
+		}
+	}
+}
+
+func TestSetupRouterRejectsInvalidProxies(t *testing.T) {
+	if _, err := SetupRouter(&config.Config{TrustedProxies: []string{"not-an-address"}}); err == nil {
+		t.Error("expected an error for an invalid proxy address")
+	}
+}
@@ -0,0 +1,94 @@
+package services
+
+import (
+	"context"
+	"time"
+
+	"wordpress-collab-tool/config"
+	"wordpress-collab-tool/models"
+	"wordpress-collab-tool/utils"
+)
+
+// Default and maximum page sizes for activity queries.
+const (
+	defaultActivityLimit = 100
+	maxActivityLimit     = 1000
+)
+
+// activityRetentionInterval is how often old activities are pruned.
+const activityRetentionInterval = time.Hour
+
+// ActivityStore keeps the activity log.
+type ActivityStore interface {
+	// Append records an activity. An activity identical to a recent one is
+	// folded into it by incrementing its count instead.
+	Append(activity models.Activity) error
+	// Query returns matching activities, newest first, and the cursor of the
+	// next page, which is empty on the last page.
+	Query(query ActivityQuery) ([]models.Activity, string, error)
+	// Prune removes activities older than maxAge and the oldest activities
+	// beyond maxEntries. Zero disables either limit.
+	Prune(maxAge time.Duration, maxEntries int) (int, error)
+	Close() error
+}
+
+// ActivityQuery selects activities. Empty fields match every activity.
+type ActivityQuery struct {
+	ProjectName string
+	// ProjectNames, when not nil, limits the activities to those of the
+	// listed sites.
+	ProjectNames []string
+	Level        string
+	// Since and Until bound when the activity happened.
+	Since time.Time
+	Until time.Time
+	// Search matches a substring of the message, ignoring case.
+	Search string
+	// Actor, Action and RequestID match the audit fields exactly.
+	Actor     string
+	Action    string
+	RequestID string
+	// Cursor continues a previous query from where its page ended.
+	Cursor string
+	Limit  int
+}
+
+// Activities is the activity log. Activities are only written to the
+// server log until InitActivityStore runs.
+var Activities ActivityStore
+
+// InitActivityStore opens the activity log in the SQLite database, imports
+// the entries of an existing activities.json the first time, and starts
+// pruning entries beyond the configured retention.
+func InitActivityStore(cfg *config.Config) error {
+	store, err := OpenSQLiteActivityStore(cfg.DatabasePath, cfg.ActivityDedupWindow)
+	if err != nil {
+		return err
+	}
+	imported, err := store.ImportJSON(cfg.ActivitiesFile)
+	if err != nil {
+		store.Close()
+		return err
+	}
+	if imported > 0 {
+		utils.LogInfo("Imported %d activities from %s into %s", imported, cfg.ActivitiesFile, cfg.DatabasePath)
+	}
+	Activities = store
+
+	go func() {
+		for {
+			if pruned, err := store.Prune(cfg.ActivityRetention, cfg.ActivityMaxEntries); err != nil {
+				utils.LogError("Failed to prune activities: %v", err)
+			} else if pruned > 0 {
+				utils.LogInfo("Pruned %d activities beyond the retention limits", pruned)
+			}
+			time.Sleep(activityRetentionInterval)
+		}
+	}()
+	return nil
+}
+
+// LogActivity records a system activity, one not made on behalf of a user.
+func LogActivity(level, message, projectName string) {
+	RecordActivity(context.Background(), models.Activity{Level: level, Message: message, ProjectName: projectName})
+}
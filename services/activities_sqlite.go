@@ -0,0 +1,319 @@
+package services
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"wordpress-collab-tool/models"
+	"wordpress-collab-tool/utils"
+)
+
+// ErrInvalidCursor is returned for a pagination cursor that was not issued by Query.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// SQLiteActivityStore keeps the activity log in an embedded SQLite database.
+type SQLiteActivityStore struct {
+	db *sql.DB
+	// dedupWindow is how long after its last occurrence an activity still
+	// absorbs identical ones.
+	dedupWindow time.Duration
+}
+
+var activityMigrations = []migration{
+	{
+		name: "create activities",
+		statements: []string{
+			`CREATE TABLE activities (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				level TEXT NOT NULL,
+				message TEXT NOT NULL,
+				project_name TEXT NOT NULL DEFAULT '',
+				timestamp TEXT NOT NULL,
+				occurred_at INTEGER NOT NULL,
+				last_seen_at INTEGER NOT NULL,
+				count INTEGER NOT NULL DEFAULT 1
+			)`,
+			`CREATE INDEX activities_project ON activities (project_name, id)`,
+			`CREATE INDEX activities_repeat ON activities (level, project_name, message)`,
+			`CREATE INDEX activities_last_seen ON activities (last_seen_at)`,
+		},
+	},
+	{
+		name: "add activity audit fields",
+		statements: []string{
+			`ALTER TABLE activities ADD COLUMN action TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE activities ADD COLUMN actor TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE activities ADD COLUMN client_ip TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE activities ADD COLUMN user_agent TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE activities ADD COLUMN request_id TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE activities ADD COLUMN before TEXT`,
+			`ALTER TABLE activities ADD COLUMN after TEXT`,
+			`DROP INDEX activities_repeat`,
+			`CREATE INDEX activities_repeat ON activities (level, project_name, actor, action, message)`,
+			`CREATE INDEX activities_actor ON activities (actor, id)`,
+			`CREATE INDEX activities_action ON activities (action, id)`,
+		},
+	},
+}
+
+// OpenSQLiteActivityStore opens the activity log in the database at path.
+func OpenSQLiteActivityStore(path string, dedupWindow time.Duration) (*SQLiteActivityStore, error) {
+	db, err := openSQLite(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := migrate(db, activityMigrations); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &SQLiteActivityStore{db: db, dedupWindow: dedupWindow}, nil
+}
+
+// Append records an activity, folding it into the latest identical one if
+// that happened within the deduplication window. Activities are identical
+// when their level, site, actor, action and message match. Security
+// activities and activities describing a change are always recorded
+// individually.
+func (s *SQLiteActivityStore) Append(activity models.Activity) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to record activity: %w", err)
+	}
+	if err := s.append(tx, activity, activityTime(activity)); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to record activity: %w", err)
+	}
+	return nil
+}
+
+func (s *SQLiteActivityStore) append(tx *sql.Tx, activity models.Activity, at time.Time) error {
+	if activity.Level != "security" && activity.Before == nil && activity.After == nil && s.dedupWindow > 0 {
+		var id, lastSeen int64
+		err := tx.QueryRow(`SELECT id, last_seen_at FROM activities
+			WHERE level = ? AND project_name = ? AND actor = ? AND action = ? AND message = ?
+			ORDER BY id DESC LIMIT 1`,
+			activity.Level, activity.ProjectName, activity.Actor, activity.Action, activity.Message).Scan(&id, &lastSeen)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("failed to look up repeated activity: %w", err)
+		}
+		if err == nil && at.Sub(time.UnixMilli(lastSeen)) <= s.dedupWindow {
+			if _, err := tx.Exec(`UPDATE activities SET count = count + 1, last_seen_at = MAX(last_seen_at, ?) WHERE id = ?`, at.UnixMilli(), id); err != nil {
+				return fmt.Errorf("failed to count repeated activity: %w", err)
+			}
+			return nil
+		}
+	}
+
+	_, err := tx.Exec(`INSERT INTO activities (level, message, project_name, timestamp, occurred_at, last_seen_at,
+			action, actor, client_ip, user_agent, request_id, before, after)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		activity.Level, activity.Message, activity.ProjectName, activity.Timestamp, at.UnixMilli(), at.UnixMilli(),
+		activity.Action, activity.Actor, activity.ClientIP, activity.UserAgent, activity.RequestID,
+		nullableJSON(activity.Before), nullableJSON(activity.After))
+	if err != nil {
+		return fmt.Errorf("failed to record activity: %w", err)
+	}
+	return nil
+}
+
+// Query returns matching activities, newest first.
+func (s *SQLiteActivityStore) Query(query ActivityQuery) ([]models.Activity, string, error) {
+	var where []string
+	var args []any
+	if query.ProjectName != "" {
+		where = append(where, "project_name = ?")
+		args = append(args, query.ProjectName)
+	}
+	if query.Level != "" {
+		where = append(where, "level = ?")
+		args = append(args, query.Level)
+	}
+	if !query.Since.IsZero() {
+		where = append(where, "last_seen_at >= ?")
+		args = append(args, query.Since.UnixMilli())
+	}
+	if !query.Until.IsZero() {
+		where = append(where, "occurred_at <= ?")
+		args = append(args, query.Until.UnixMilli())
+	}
+	if query.Actor != "" {
+		where = append(where, "actor = ?")
+		args = append(args, query.Actor)
+	}
+	if query.Action != "" {
+		where = append(where, "action = ?")
+		args = append(args, query.Action)
+	}
+	if query.ProjectNames != nil {
+		where = append(where, "project_name IN (SELECT value FROM json_each(?))")
+		names, _ := json.Marshal(query.ProjectNames)
+		args = append(args, string(names))
+	}
+	if query.RequestID != "" {
+		where = append(where, "request_id = ?")
+		args = append(args, query.RequestID)
+	}
+	if query.Search != "" {
+		where = append(where, `message LIKE ? ESCAPE '\'`)
+		args = append(args, "%"+escapeLike(query.Search)+"%")
+	}
+	if query.Cursor != "" {
+		before, err := strconv.ParseInt(query.Cursor, 10, 64)
+		if err != nil || before <= 0 {
+			return nil, "", fmt.Errorf("%w: %q", ErrInvalidCursor, query.Cursor)
+		}
+		where = append(where, "id < ?")
+		args = append(args, before)
+	}
+
+	limit := query.Limit
+	if limit <= 0 {
+		limit = defaultActivityLimit
+	}
+	if limit > maxActivityLimit {
+		limit = maxActivityLimit
+	}
+
+	stmt := `SELECT id, level, message, project_name, timestamp, last_seen_at, count,
+		action, actor, client_ip, user_agent, request_id, before, after FROM activities`
+	if len(where) > 0 {
+		stmt += " WHERE " + strings.Join(where, " AND ")
+	}
+	stmt += " ORDER BY id DESC LIMIT ?"
+	// Fetch one extra row to learn whether another page follows
+	args = append(args, limit+1)
+
+	rows, err := s.db.Query(stmt, args...)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to query activities: %w", err)
+	}
+	defer rows.Close()
+
+	activities := []models.Activity{}
+	for rows.Next() {
+		var a models.Activity
+		var lastSeen int64
+		var before, after sql.NullString
+		if err := rows.Scan(&a.ID, &a.Level, &a.Message, &a.ProjectName, &a.Timestamp, &lastSeen, &a.Count,
+			&a.Action, &a.Actor, &a.ClientIP, &a.UserAgent, &a.RequestID, &before, &after); err != nil {
+			return nil, "", fmt.Errorf("failed to query activities: %w", err)
+		}
+		if before.Valid {
+			a.Before = json.RawMessage(before.String)
+		}
+		if after.Valid {
+			a.After = json.RawMessage(after.String)
+		}
+		if a.Count > 1 {
+			a.LastSeen = time.UnixMilli(lastSeen).Format(time.RFC3339)
+		}
+		activities = append(activities, a)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", fmt.Errorf("failed to query activities: %w", err)
+	}
+
+	next := ""
+	if len(activities) > limit {
+		activities = activities[:limit]
+		next = strconv.FormatInt(activities[limit-1].ID, 10)
+	}
+	return activities, next, nil
+}
+
+// Prune removes activities last seen before maxAge ago and the oldest
+// activities beyond maxEntries.
+func (s *SQLiteActivityStore) Prune(maxAge time.Duration, maxEntries int) (int, error) {
+	pruned := 0
+	if maxAge > 0 {
+		result, err := s.db.Exec(`DELETE FROM activities WHERE last_seen_at < ?`, time.Now().Add(-maxAge).UnixMilli())
+		if err != nil {
+			return 0, fmt.Errorf("failed to prune activities: %w", err)
+		}
+		n, _ := result.RowsAffected()
+		pruned += int(n)
+	}
+	if maxEntries > 0 {
+		result, err := s.db.Exec(`DELETE FROM activities WHERE id <= (
+			SELECT id FROM activities ORDER BY id DESC LIMIT 1 OFFSET ?)`, maxEntries)
+		if err != nil {
+			return pruned, fmt.Errorf("failed to prune activities: %w", err)
+		}
+		n, _ := result.RowsAffected()
+		pruned += int(n)
+	}
+	return pruned, nil
+}
+
+// Close closes the database.
+func (s *SQLiteActivityStore) Close() error {
+	return s.db.Close()
+}
+
+// ImportJSON appends the entries of an activities.json file, folding
+// repeats as Append does, and renames the file with an ".imported" suffix so
+// that the import happens only once. It returns how many entries were read.
+func (s *SQLiteActivityStore) ImportJSON(path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to read activities file: %w", err)
+	}
+	var activities []models.Activity
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &activities); err != nil {
+			return 0, fmt.Errorf("failed to unmarshal activities data: %w", err)
+		}
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to import activities: %w", err)
+	}
+	for _, activity := range activities {
+		if err := s.append(tx, activity, activityTime(activity)); err != nil {
+			tx.Rollback()
+			return 0, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to import activities: %w", err)
+	}
+
+	if err := os.Rename(path, path+importedSuffix); err != nil {
+		utils.LogError("Imported activities from %s but failed to rename it: %v", path, err)
+	}
+	return len(activities), nil
+}
+
+// activityTime returns when an activity happened, or now if its timestamp is unreadable.
+func activityTime(activity models.Activity) time.Time {
+	if t, err := time.Parse(time.RFC3339, activity.Timestamp); err == nil {
+		return t
+	}
+	return time.Now()
+}
+
+// nullableJSON stores empty details as NULL.
+func nullableJSON(data json.RawMessage) any {
+	if len(data) == 0 {
+		return nil
+	}
+	return string(data)
+}
+
+// escapeLike escapes the LIKE wildcards in s.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
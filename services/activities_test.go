@@ -0,0 +1,239 @@
+package services
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"wordpress-collab-tool/models"
+)
+
+func openActivities(t *testing.T, dedupWindow time.Duration) *SQLiteActivityStore {
+	t.Helper()
+	store, err := OpenSQLiteActivityStore(filepath.Join(t.TempDir(), "data.db"), dedupWindow)
+	if err != nil {
+		t.Fatalf("OpenSQLiteActivityStore: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func activityAt(at time.Time, level, message, project string) models.Activity {
+	return models.Activity{Level: level, Message: message, ProjectName: project, Timestamp: at.Format(time.RFC3339)}
+}
+
+func TestActivityStoreFoldsRepeats(t *testing.T) {
+	store := openActivities(t, 10*time.Minute)
+	start := time.Now().Add(-time.Hour).Truncate(time.Second)
+
+	for i := 0; i < 3; i++ {
+		store.Append(activityAt(start.Add(time.Duration(i)*time.Minute), "error", "Failed to list plugins", "blog"))
+	}
+	// Past the window, on another site and as a security event: all separate
+	store.Append(activityAt(start.Add(30*time.Minute), "error", "Failed to list plugins", "blog"))
+	store.Append(activityAt(start.Add(31*time.Minute), "error", "Failed to list plugins", "shop"))
+	store.Append(activityAt(start.Add(32*time.Minute), "security", "Secrets revealed", "blog"))
+	store.Append(activityAt(start.Add(33*time.Minute), "security", "Secrets revealed", "blog"))
+
+	activities, _, err := store.Query(ActivityQuery{})
+	if err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+	if len(activities) != 5 {
+		t.Fatalf("expected 5 entries, got %d: %+v", len(activities), activities)
+	}
+	folded := activities[4]
+	if folded.Count != 3 || folded.LastSeen != start.Add(2*time.Minute).Format(time.RFC3339) {
+		t.Errorf("unexpected folded entry: %+v", folded)
+	}
+	if activities[0].Count != 1 || activities[0].LastSeen != "" {
+		t.Errorf("single entry reports repeats: %+v", activities[0])
+	}
+}
+
+func TestActivityStoreQuery(t *testing.T) {
+	store := openActivities(t, 0)
+	start := time.Now().Add(-time.Hour).Truncate(time.Second)
+	store.Append(activityAt(start, "info", "Site 'blog' created", "blog"))
+	store.Append(activityAt(start.Add(time.Minute), "error", "Backup failed for site 'blog'", "blog"))
+	store.Append(activityAt(start.Add(2*time.Minute), "info", "Site 'shop' created", "shop"))
+	store.Append(activityAt(start.Add(3*time.Minute), "info", "Plugin 100%_done installed", "shop"))
+
+	tests := []struct {
+		name  string
+		query ActivityQuery
+		want  []string
+	}{
+		{"project", ActivityQuery{ProjectName: "blog"}, []string{"Backup failed for site 'blog'", "Site 'blog' created"}},
+		{"level", ActivityQuery{Level: "error"}, []string{"Backup failed for site 'blog'"}},
+		{"search ignores case", ActivityQuery{Search: "CREATED"}, []string{"Site 'shop' created", "Site 'blog' created"}},
+		{"search is literal", ActivityQuery{Search: "100%_"}, []string{"Plugin 100%_done installed"}},
+		{"wildcards do not match", ActivityQuery{Search: "%"}, []string{"Plugin 100%_done installed"}},
+		{"time range", ActivityQuery{Since: start.Add(30 * time.Second), Until: start.Add(2 * time.Minute)}, []string{"Site 'shop' created", "Backup failed for site 'blog'"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			activities, _, err := store.Query(tt.query)
+			if err != nil {
+				t.Fatalf("Query: %v", err)
+			}
+			var got []string
+			for _, a := range activities {
+				got = append(got, a.Message)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestActivityStorePaginates(t *testing.T) {
+	store := openActivities(t, 0)
+	for i := 0; i < 5; i++ {
+		store.Append(activityAt(time.Now(), "info", fmt.Sprintf("event %d", i), "blog"))
+	}
+
+	var got []string
+	cursor := ""
+	for pages := 0; ; pages++ {
+		if pages > 3 {
+			t.Fatal("pagination did not end")
+		}
+		activities, next, err := store.Query(ActivityQuery{Cursor: cursor, Limit: 2})
+		if err != nil {
+			t.Fatalf("Query: %v", err)
+		}
+		for _, a := range activities {
+			got = append(got, a.Message)
+		}
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+	if want := "[event 4 event 3 event 2 event 1 event 0]"; fmt.Sprint(got) != want {
+		t.Errorf("got %v, want %s", got, want)
+	}
+
+	if _, _, err := store.Query(ActivityQuery{Cursor: "bogus"}); !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("expected ErrInvalidCursor, got %v", err)
+	}
+}
+
+func TestActivityStorePrunes(t *testing.T) {
+	store := openActivities(t, 0)
+	now := time.Now()
+	store.Append(activityAt(now.Add(-100*24*time.Hour), "info", "ancient", ""))
+	for i := 0; i < 4; i++ {
+		store.Append(activityAt(now, "info", fmt.Sprintf("recent %d", i), ""))
+	}
+
+	pruned, err := store.Prune(90*24*time.Hour, 3)
+	if err != nil || pruned != 2 {
+		t.Fatalf("Prune = %d, %v; want 2", pruned, err)
+	}
+	activities, _, _ := store.Query(ActivityQuery{})
+	if len(activities) != 3 || activities[2].Message != "recent 1" {
+		t.Errorf("unexpected activities after pruning: %+v", activities)
+	}
+}
+
+func TestActivityStoreImportsJSON(t *testing.T) {
+	store := openActivities(t, 10*time.Minute)
+	path := filepath.Join(t.TempDir(), "activities.json")
+	legacy := `[
+  {"message": "Failed to list plugins", "timestamp": "2025-07-01T10:00:00Z", "level": "error", "projectName": "blog"},
+  {"message": "Failed to list plugins", "timestamp": "2025-07-01T10:01:00Z", "level": "error", "projectName": "blog"},
+  {"message": "Site 'blog' created successfully!", "timestamp": "2025-07-01T10:02:00Z", "level": "info", "projectName": "blog"}
+]`
+	os.WriteFile(path, []byte(legacy), 0644)
+
+	imported, err := store.ImportJSON(path)
+	if err != nil || imported != 3 {
+		t.Fatalf("ImportJSON = %d, %v; want 3", imported, err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("activities.json was not renamed after import")
+	}
+
+	activities, _, _ := store.Query(ActivityQuery{})
+	if len(activities) != 2 || activities[1].Count != 2 || activities[0].Timestamp != "2025-07-01T10:02:00Z" {
+		t.Errorf("unexpected imported activities: %+v", activities)
+	}
+}
+
+func TestActivityAuditFieldsRoundTrip(t *testing.T) {
+	withActivities(t)
+	ctx := WithAudit(context.Background(), AuditInfo{Actor: "alice", ClientIP: "203.0.113.7", UserAgent: "curl/8", RequestID: "req-1"})
+	RecordActivity(ctx, models.Activity{
+		Level:   "info",
+		Action:  "host.update",
+		Message: "Host 'vps1' updated.",
+		Before:  AuditDetails(map[string]int{"maxSites": 5}),
+		After:   AuditDetails(map[string]int{"maxSites": 10}),
+	})
+	LogActivityContext(context.Background(), "info", "site.create", "Site 'blog' creation initiated.", "blog")
+
+	activities, _, err := Activities.Query(ActivityQuery{Actor: "alice"})
+	if err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+	if len(activities) != 1 {
+		t.Fatalf("expected one activity by alice, got %+v", activities)
+	}
+	got := activities[0]
+	if got.Action != "host.update" || got.ClientIP != "203.0.113.7" || got.UserAgent != "curl/8" || got.RequestID != "req-1" {
+		t.Errorf("audit fields were not kept: %+v", got)
+	}
+	if string(got.Before) != `{"maxSites":5}` || string(got.After) != `{"maxSites":10}` {
+		t.Errorf("unexpected before/after: %s %s", got.Before, got.After)
+	}
+
+	if activities, _, _ := Activities.Query(ActivityQuery{Action: "site.create"}); len(activities) != 1 || activities[0].Actor != "" {
+		t.Errorf("unexpected action filter result: %+v", activities)
+	}
+}
+
+func TestExportActivities(t *testing.T) {
+	withActivities(t)
+	ctx := WithAudit(context.Background(), AuditInfo{Actor: "alice"})
+	for i := 0; i < 3; i++ {
+		LogActivityContext(ctx, "info", "backup.create", fmt.Sprintf("Backup %d, \"nightly\"", i), "blog")
+	}
+
+	var csvOut strings.Builder
+	n, err := ExportActivities(&csvOut, ExportCSV, ActivityQuery{ProjectName: "blog", Limit: 1})
+	if err != nil || n != 3 {
+		t.Fatalf("CSV export: %d %v", n, err)
+	}
+	records, err := csv.NewReader(strings.NewReader(csvOut.String())).ReadAll()
+	if err != nil {
+		t.Fatalf("invalid CSV: %v\n%s", err, csvOut.String())
+	}
+	if len(records) != 4 || records[0][0] != "id" || records[1][6] != "alice" || records[1][11] != `Backup 2, "nightly"` {
+		t.Errorf("unexpected CSV: %q", records)
+	}
+
+	var jsonlOut strings.Builder
+	if n, err := ExportActivities(&jsonlOut, ExportJSONL, ActivityQuery{}); err != nil || n != 3 {
+		t.Fatalf("JSONL export: %d %v", n, err)
+	}
+	lines := strings.Split(strings.TrimSpace(jsonlOut.String()), "\n")
+	var last models.Activity
+	if len(lines) != 3 || json.Unmarshal([]byte(lines[2]), &last) != nil || last.Message != `Backup 0, "nightly"` {
+		t.Errorf("unexpected JSONL: %s", jsonlOut.String())
+	}
+
+	if _, err := ExportActivities(io.Discard, "xml", ActivityQuery{}); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+}
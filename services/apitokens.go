@@ -0,0 +1,186 @@
+package services
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"wordpress-collab-tool/config"
+	"wordpress-collab-tool/models"
+	"wordpress-collab-tool/utils"
+)
+
+// Errors returned by APITokenStore implementations and the token functions.
+var (
+	ErrAPITokenNotFound = errors.New("token not found")
+	ErrInvalidAPIToken  = errors.New("invalid token")
+)
+
+// APITokenPrefix starts every personal access token, which tells them
+// apart from session tokens and makes leaked ones easy to search for.
+const APITokenPrefix = "wpct_"
+
+// apiTokenTouchInterval limits how often the last use of a token is
+// written, so that busy pipelines do not write on every request.
+const apiTokenTouchInterval = time.Minute
+
+// APITokenStore persists personal access tokens.
+type APITokenStore interface {
+	// Create stores a new token under the hash of its secret.
+	Create(token models.APIToken, hash string) error
+	// GetByHash returns the token whose secret has hash, or ErrAPITokenNotFound.
+	GetByHash(hash string) (models.APIToken, error)
+	// List returns the tokens of a user, oldest first.
+	List(username string) ([]models.APIToken, error)
+	// Delete removes one of a user's tokens and returns it, or returns
+	// ErrAPITokenNotFound.
+	Delete(username, id string) (models.APIToken, error)
+	// DeleteUser removes every token of a user.
+	DeleteUser(username string) (int, error)
+	// Touch records when a token was last used.
+	Touch(id string, at time.Time) error
+	Close() error
+}
+
+// APITokens holds the personal access tokens. It is nil until
+// InitAPITokenStore runs.
+var APITokens APITokenStore
+
+// InitAPITokenStore opens the personal access tokens in the SQLite database.
+func InitAPITokenStore(cfg *config.Config) error {
+	store, err := OpenSQLiteAPITokenStore(cfg.DatabasePath)
+	if err != nil {
+		return err
+	}
+	APITokens = store
+	return nil
+}
+
+// ValidateScopes checks that scopes name known permissions that role
+// grants. A token cannot do more than its user.
+func ValidateScopes(scopes []string, role string) error {
+	if len(scopes) == 0 {
+		return fmt.Errorf("%w: at least one scope is required", ErrInvalidInput)
+	}
+	for _, scope := range scopes {
+		perm := Permission(scope)
+		if !HasPermission(RoleAdmin, perm) {
+			return fmt.Errorf("%w: unknown scope '%s'", ErrInvalidInput, scope)
+		}
+		if !HasPermission(role, perm) {
+			return fmt.Errorf("%w: your role does not grant '%s'", ErrInvalidInput, scope)
+		}
+	}
+	return nil
+}
+
+// CreateAPIToken issues a personal access token for user limited to
+// scopes. expiresAt may be nil for a token that does not expire. The
+// secret is returned only here; the store keeps its hash.
+func CreateAPIToken(user models.User, name string, scopes []string, expiresAt *time.Time) (models.APIToken, string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > 100 {
+		return models.APIToken{}, "", fmt.Errorf("%w: name must be 1 to 100 characters long", ErrInvalidInput)
+	}
+	if err := ValidateScopes(scopes, user.Role); err != nil {
+		return models.APIToken{}, "", err
+	}
+	now := time.Now().UTC()
+	if expiresAt != nil && !expiresAt.After(now) {
+		return models.APIToken{}, "", fmt.Errorf("%w: expiry must be in the future", ErrInvalidInput)
+	}
+
+	id, err := randomID()
+	if err != nil {
+		return models.APIToken{}, "", err
+	}
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return models.APIToken{}, "", fmt.Errorf("failed to generate token: %w", err)
+	}
+	secret := APITokenPrefix + base64.RawURLEncoding.EncodeToString(b)
+
+	slices.Sort(scopes)
+	token := models.APIToken{
+		ID:        id,
+		Username:  user.Username,
+		Name:      name,
+		Prefix:    secret[:len(APITokenPrefix)+6],
+		Scopes:    slices.Compact(scopes),
+		CreatedAt: now,
+	}
+	if expiresAt != nil {
+		t := expiresAt.UTC()
+		token.ExpiresAt = &t
+	}
+	if err := APITokens.Create(token, hashToken(secret)); err != nil {
+		return models.APIToken{}, "", err
+	}
+	return token, secret, nil
+}
+
+// AuthenticateAPIToken returns the token with the given secret and its
+// user. Unknown and expired tokens, and tokens of disabled users, return
+// ErrInvalidAPIToken.
+func AuthenticateAPIToken(secret string) (models.User, models.APIToken, error) {
+	token, err := APITokens.GetByHash(hashToken(secret))
+	if errors.Is(err, ErrAPITokenNotFound) {
+		return models.User{}, models.APIToken{}, ErrInvalidAPIToken
+	}
+	if err != nil {
+		return models.User{}, models.APIToken{}, err
+	}
+	now := time.Now()
+	if token.ExpiresAt != nil && !now.Before(*token.ExpiresAt) {
+		return models.User{}, models.APIToken{}, fmt.Errorf("%w: token '%s' expired", ErrInvalidAPIToken, token.ID)
+	}
+
+	user, err := Users.Get(token.Username)
+	if errors.Is(err, ErrUserNotFound) {
+		return models.User{}, models.APIToken{}, ErrInvalidAPIToken
+	}
+	if err != nil {
+		return models.User{}, models.APIToken{}, err
+	}
+	if user.Disabled {
+		return models.User{}, models.APIToken{}, fmt.Errorf("%w: %w", ErrInvalidAPIToken, ErrUserDisabled)
+	}
+
+	if token.LastUsedAt == nil || now.Sub(*token.LastUsedAt) >= apiTokenTouchInterval {
+		if err := APITokens.Touch(token.ID, now); err != nil {
+			// A stale timestamp is no reason to refuse the request
+			utils.LogError("Failed to record use of token %s: %v", token.ID, err)
+		}
+	}
+	return user, token, nil
+}
+
+// TokenAllows reports whether the scopes of a token include perm.
+func TokenAllows(token models.APIToken, perm Permission) bool {
+	return slices.Contains(token.Scopes, string(perm))
+}
+
+// ListAPITokens returns the tokens of a user.
+func ListAPITokens(username string) ([]models.APIToken, error) {
+	return APITokens.List(username)
+}
+
+// RevokeAPIToken deletes one of username's tokens and returns it. Tokens of
+// other users are reported as not found.
+func RevokeAPIToken(username, id string) (models.APIToken, error) {
+	return APITokens.Delete(username, id)
+}
+
+// deleteUserAPITokens removes the tokens of a deleted user. It does nothing
+// before InitAPITokenStore runs.
+func deleteUserAPITokens(username string) error {
+	if APITokens == nil {
+		return nil
+	}
+	_, err := APITokens.DeleteUser(username)
+	return err
+}
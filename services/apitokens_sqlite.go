@@ -0,0 +1,165 @@
+package services
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"wordpress-collab-tool/models"
+)
+
+// SQLiteAPITokenStore keeps personal access tokens in an embedded SQLite
+// database. Only hashes of the tokens are stored.
+type SQLiteAPITokenStore struct {
+	db *sql.DB
+}
+
+var apiTokenMigrations = []migration{
+	{
+		name: "create api tokens",
+		statements: []string{
+			`CREATE TABLE api_tokens (
+				id TEXT PRIMARY KEY,
+				username TEXT NOT NULL,
+				name TEXT NOT NULL,
+				token_hash TEXT NOT NULL UNIQUE,
+				prefix TEXT NOT NULL,
+				scopes TEXT NOT NULL,
+				created_at INTEGER NOT NULL,
+				last_used_at INTEGER,
+				expires_at INTEGER
+			)`,
+			`CREATE INDEX api_tokens_username ON api_tokens (username, created_at)`,
+		},
+	},
+}
+
+// OpenSQLiteAPITokenStore opens the personal access tokens in the database
+// at path.
+func OpenSQLiteAPITokenStore(path string) (*SQLiteAPITokenStore, error) {
+	db, err := openSQLite(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := migrate(db, apiTokenMigrations); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &SQLiteAPITokenStore{db: db}, nil
+}
+
+const apiTokenColumns = `id, username, name, prefix, scopes, created_at, last_used_at, expires_at`
+
+// Create stores a new token under the hash of its secret.
+func (s *SQLiteAPITokenStore) Create(token models.APIToken, hash string) error {
+	scopes, err := json.Marshal(token.Scopes)
+	if err != nil {
+		return fmt.Errorf("failed to encode token scopes: %w", err)
+	}
+	var expiresAt sql.NullInt64
+	if token.ExpiresAt != nil {
+		expiresAt = sql.NullInt64{Int64: token.ExpiresAt.UnixMilli(), Valid: true}
+	}
+	if _, err := s.db.Exec(`INSERT INTO api_tokens (`+apiTokenColumns+`, token_hash) VALUES (?, ?, ?, ?, ?, ?, NULL, ?, ?)`,
+		token.ID, token.Username, token.Name, token.Prefix, string(scopes), token.CreatedAt.UnixMilli(), expiresAt, hash); err != nil {
+		return fmt.Errorf("failed to create token: %w", err)
+	}
+	return nil
+}
+
+// GetByHash returns the token whose secret has hash, or ErrAPITokenNotFound.
+func (s *SQLiteAPITokenStore) GetByHash(hash string) (models.APIToken, error) {
+	token, err := scanAPIToken(s.db.QueryRow(`SELECT `+apiTokenColumns+` FROM api_tokens WHERE token_hash = ?`, hash))
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.APIToken{}, ErrAPITokenNotFound
+	}
+	return token, err
+}
+
+// List returns the tokens of a user, oldest first.
+func (s *SQLiteAPITokenStore) List(username string) ([]models.APIToken, error) {
+	rows, err := s.db.Query(`SELECT `+apiTokenColumns+` FROM api_tokens WHERE username = ? ORDER BY created_at, id`, username)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tokens: %w", err)
+	}
+	defer rows.Close()
+
+	tokens := []models.APIToken{}
+	for rows.Next() {
+		token, err := scanAPIToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, token)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list tokens: %w", err)
+	}
+	return tokens, nil
+}
+
+// Delete removes one of a user's tokens, or returns ErrAPITokenNotFound.
+func (s *SQLiteAPITokenStore) Delete(username, id string) (models.APIToken, error) {
+	token, err := scanAPIToken(s.db.QueryRow(`SELECT `+apiTokenColumns+` FROM api_tokens WHERE id = ? AND username = ?`, id, username))
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.APIToken{}, fmt.Errorf("%w: '%s'", ErrAPITokenNotFound, id)
+	}
+	if err != nil {
+		return models.APIToken{}, err
+	}
+	if _, err := s.db.Exec(`DELETE FROM api_tokens WHERE id = ?`, id); err != nil {
+		return models.APIToken{}, fmt.Errorf("failed to delete token: %w", err)
+	}
+	return token, nil
+}
+
+// DeleteUser removes every token of a user and returns how many there were.
+func (s *SQLiteAPITokenStore) DeleteUser(username string) (int, error) {
+	result, err := s.db.Exec(`DELETE FROM api_tokens WHERE username = ?`, username)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete tokens: %w", err)
+	}
+	n, _ := result.RowsAffected()
+	return int(n), nil
+}
+
+// Touch records that a token was used at.
+func (s *SQLiteAPITokenStore) Touch(id string, at time.Time) error {
+	if _, err := s.db.Exec(`UPDATE api_tokens SET last_used_at = ? WHERE id = ?`, at.UnixMilli(), id); err != nil {
+		return fmt.Errorf("failed to record token use: %w", err)
+	}
+	return nil
+}
+
+// Close closes the database.
+func (s *SQLiteAPITokenStore) Close() error {
+	return s.db.Close()
+}
+
+func scanAPIToken(row rowScanner) (models.APIToken, error) {
+	var token models.APIToken
+	var scopes string
+	var createdAt int64
+	var lastUsedAt, expiresAt sql.NullInt64
+	if err := row.Scan(&token.ID, &token.Username, &token.Name, &token.Prefix, &scopes, &createdAt, &lastUsedAt, &expiresAt); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.APIToken{}, err
+		}
+		return models.APIToken{}, fmt.Errorf("failed to read token: %w", err)
+	}
+	if err := json.Unmarshal([]byte(scopes), &token.Scopes); err != nil {
+		return models.APIToken{}, fmt.Errorf("failed to decode token scopes: %w", err)
+	}
+	token.CreatedAt = time.UnixMilli(createdAt).UTC()
+	if lastUsedAt.Valid {
+		t := time.UnixMilli(lastUsedAt.Int64).UTC()
+		token.LastUsedAt = &t
+	}
+	if expiresAt.Valid {
+		t := time.UnixMilli(expiresAt.Int64).UTC()
+		token.ExpiresAt = &t
+	}
+	return token, nil
+}
@@ -0,0 +1,85 @@
+package services
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// withAPITokens points the personal access token store at a fresh database.
+func withAPITokens(t *testing.T) *SQLiteAPITokenStore {
+	t.Helper()
+	store, err := OpenSQLiteAPITokenStore(filepath.Join(t.TempDir(), "tokens.db"))
+	if err != nil {
+		t.Fatalf("OpenSQLiteAPITokenStore: %v", err)
+	}
+	original := APITokens
+	APITokens = store
+	t.Cleanup(func() {
+		APITokens = original
+		store.Close()
+	})
+	return store
+}
+
+func TestAPITokens(t *testing.T) {
+	withUsers(t)
+	store := withAPITokens(t)
+	user, err := CreateUser("ci", "ci's password", RoleEditor)
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+
+	token, secret, err := CreateAPIToken(user, "pipeline", []string{"sites:read", "backups:write", "sites:read"}, nil)
+	if err != nil {
+		t.Fatalf("CreateAPIToken: %v", err)
+	}
+	if !strings.HasPrefix(secret, APITokenPrefix) || !strings.HasPrefix(secret, token.Prefix) || len(token.Scopes) != 2 {
+		t.Fatalf("unexpected token %+v", token)
+	}
+
+	// Only the hash of the secret is stored
+	var stored int
+	store.db.QueryRow(`SELECT COUNT(*) FROM api_tokens WHERE token_hash = ?`, secret).Scan(&stored)
+	if stored != 0 {
+		t.Error("the secret was stored in clear")
+	}
+
+	got, authToken, err := AuthenticateAPIToken(secret)
+	if err != nil || got.Username != "ci" || !TokenAllows(authToken, PermBackupsWrite) || TokenAllows(authToken, PermPluginsWrite) {
+		t.Fatalf("AuthenticateAPIToken = %+v, %+v, %v", got, authToken, err)
+	}
+	if tokens, _ := ListAPITokens("ci"); len(tokens) != 1 || tokens[0].LastUsedAt == nil {
+		t.Errorf("last use was not recorded: %+v", tokens)
+	}
+	if _, _, err := AuthenticateAPIToken(secret + "x"); !errors.Is(err, ErrInvalidAPIToken) {
+		t.Errorf("wrong secret: %v", err)
+	}
+
+	// Tokens stop working when they expire and when their user is disabled
+	expired := token
+	expired.ID = "expired"
+	past := time.Now().Add(-time.Minute)
+	expired.ExpiresAt = &past
+	if err := store.Create(expired, hashToken("wpct_expired")); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if _, _, err := AuthenticateAPIToken("wpct_expired"); !errors.Is(err, ErrInvalidAPIToken) {
+		t.Errorf("expired token: %v", err)
+	}
+	if _, err := SetUserDisabled("ci", true); err != nil {
+		t.Fatalf("SetUserDisabled: %v", err)
+	}
+	if _, _, err := AuthenticateAPIToken(secret); !errors.Is(err, ErrInvalidAPIToken) {
+		t.Errorf("token of a disabled user: %v", err)
+	}
+
+	if _, err := RevokeAPIToken("someone", token.ID); !errors.Is(err, ErrAPITokenNotFound) {
+		t.Errorf("revoking another user's token: %v", err)
+	}
+	if _, _, err := CreateAPIToken(user, "admin", []string{"users:write"}, nil); !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("scope beyond the role: %v", err)
+	}
+}
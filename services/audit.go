@@ -0,0 +1,144 @@
+package services
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"wordpress-collab-tool/models"
+	"wordpress-collab-tool/utils"
+)
+
+// AuditInfo describes who is behind a request, for the activity log.
+type AuditInfo struct {
+	Actor     string
+	ClientIP  string
+	UserAgent string
+	RequestID string
+}
+
+type auditKey struct{}
+
+// WithAudit returns a context carrying info. Activities recorded with the
+// context, including by jobs started from it, are attributed to info.
+func WithAudit(ctx context.Context, info AuditInfo) context.Context {
+	return context.WithValue(ctx, auditKey{}, info)
+}
+
+// AuditFrom returns the audit information carried by ctx, if any.
+func AuditFrom(ctx context.Context) AuditInfo {
+	info, _ := ctx.Value(auditKey{}).(AuditInfo)
+	return info
+}
+
+// LogActivityContext records an activity on behalf of the request or job ctx belongs to.
+func LogActivityContext(ctx context.Context, level, action, message, projectName string) {
+	RecordActivity(ctx, models.Activity{Level: level, Action: action, Message: message, ProjectName: projectName})
+}
+
+// RecordActivity fills in the timestamp and the audit information carried by
+// ctx and records activity.
+func RecordActivity(ctx context.Context, activity models.Activity) {
+	info := AuditFrom(ctx)
+	activity.Actor = info.Actor
+	activity.ClientIP = info.ClientIP
+	activity.UserAgent = info.UserAgent
+	activity.RequestID = info.RequestID
+	if activity.Timestamp == "" {
+		activity.Timestamp = time.Now().Format(time.RFC3339)
+	}
+
+	if Activities == nil {
+		utils.LogInfo("Activity [%s] %s", activity.Level, activity.Message)
+		return
+	}
+	if err := Activities.Append(activity); err != nil {
+		utils.LogError("Failed to record activity: %v", err)
+	}
+}
+
+// AuditDetails encodes v for the Before and After fields of an activity.
+// Callers strip secrets from v first.
+func AuditDetails(v any) json.RawMessage {
+	if v == nil {
+		return nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		utils.LogError("Failed to encode audit details: %v", err)
+		return nil
+	}
+	return data
+}
+
+// auditColumns are the CSV columns written by ExportActivities.
+var auditColumns = []string{"id", "timestamp", "lastSeen", "count", "level", "action", "actor", "clientIp", "userAgent", "requestId", "projectName", "message", "before", "after"}
+
+// Export formats supported by ExportActivities.
+const (
+	ExportCSV   = "csv"
+	ExportJSONL = "jsonl"
+)
+
+// ExportActivities writes every activity matching query to w, newest first,
+// as CSV with a header row or as JSON Lines. query.Cursor and query.Limit are
+// ignored.
+func ExportActivities(w io.Writer, format string, query ActivityQuery) (int, error) {
+	var writeActivity func(models.Activity) error
+	var flush func() error
+	switch format {
+	case ExportCSV:
+		cw := csv.NewWriter(w)
+		if err := cw.Write(auditColumns); err != nil {
+			return 0, err
+		}
+		writeActivity = func(a models.Activity) error {
+			return cw.Write([]string{
+				strconv.FormatInt(a.ID, 10), a.Timestamp, a.LastSeen, strconv.Itoa(max(a.Count, 1)),
+				a.Level, a.Action, a.Actor, a.ClientIP, a.UserAgent, a.RequestID, a.ProjectName,
+				a.Message, string(a.Before), string(a.After),
+			})
+		}
+		flush = func() error {
+			cw.Flush()
+			return cw.Error()
+		}
+	case ExportJSONL:
+		enc := json.NewEncoder(w)
+		writeActivity = func(a models.Activity) error { return enc.Encode(a) }
+		flush = func() error { return nil }
+	default:
+		return 0, fmt.Errorf("unsupported export format %q", format)
+	}
+
+	if Activities == nil {
+		return 0, flush()
+	}
+
+	written := 0
+	query.Cursor = ""
+	query.Limit = maxActivityLimit
+	for {
+		page, next, err := Activities.Query(query)
+		if err != nil {
+			return written, err
+		}
+		for _, activity := range page {
+			if err := writeActivity(activity); err != nil {
+				return written, err
+			}
+			written++
+		}
+		if err := flush(); err != nil {
+			return written, err
+		}
+		if next == "" {
+			return written, nil
+		}
+		query.Cursor = next
+	}
+}
@@ -0,0 +1,109 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"wordpress-collab-tool/models"
+	"wordpress-collab-tool/utils"
+)
+
+// CloneSite copies source into clone, a new site already recorded on the
+// same host. It backs up source, deploys clone from the template without
+// installing WordPress, restores the backup into it and rewrites source's
+// URL to clone's. A clone that fails is removed again, record included.
+func CloneSite(ctx context.Context, source, clone models.Site) error {
+	if err := validateDeployInput(clone, nil, clone.AdminUsername, clone.AdminPassword); err != nil {
+		DropSiteRecord(clone.ProjectName)
+		return err
+	}
+	backupFile, err := backUpLocked(ctx, source.ProjectName, "clone")
+	if err != nil {
+		DropSiteRecord(clone.ProjectName)
+		return fmt.Errorf("failed to back up site '%s': %w", source.ProjectName, err)
+	}
+
+	// The deployment rolls itself back if it fails
+	if err := DeployWordPressSite(ctx, clone, nil, clone.AdminUsername, clone.AdminPassword); err != nil {
+		return err
+	}
+	UpdateSiteStatus(clone.ProjectName, "creating")
+
+	if err := copySite(ctx, source, clone, backupFile); err != nil {
+		FailJobStep(ctx)
+		if undoErr := undoDeployment(ctx, clone); undoErr != nil {
+			utils.LogError("Failed to remove the failed clone '%s': %v", clone.ProjectName, undoErr)
+			UpdateSiteStatus(clone.ProjectName, "failed")
+		}
+		return err
+	}
+
+	now := time.Now().UTC()
+	_, err = UpdateSite(clone.ProjectName, func(site *models.Site) {
+		site.Status = "active"
+		site.LastChecked = now.Format(time.RFC3339)
+		if site.Origin != nil {
+			site.Origin.Backup = backupFile
+			site.Origin.ClonedAt = &now
+		}
+	})
+	return err
+}
+
+// DropSiteRecord removes the record of a new site that was never deployed.
+func DropSiteRecord(projectName string) {
+	if err := Sites.Delete(projectName); err != nil && !errors.Is(err, ErrSiteNotFound) {
+		utils.LogError("Failed to drop the record of site '%s': %v", projectName, err)
+	}
+}
+
+// backUpLocked backs up a site while holding its lock for operation, so
+// that no other job changes it midway, and returns the archive name.
+func backUpLocked(ctx context.Context, projectName, operation string) (string, error) {
+	unlock, err := LockSite(ctx, projectName, operation, currentJobID(ctx))
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+	return createBackup(ctx, projectName)
+}
+
+// copySite restores the backupFile of source into clone and points the
+// copied content at clone's URL.
+func copySite(ctx context.Context, source, clone models.Site, backupFile string) error {
+	backupPath := filepath.Join(fmt.Sprintf("/var/www/backups/%s", source.ProjectName), backupFile)
+	if err := restoreBackup(ctx, clone.ProjectName, backupPath); err != nil {
+		return fmt.Errorf("failed to copy site '%s': %w", source.ProjectName, err)
+	}
+	return ReplaceSiteURL(ctx, clone, source.SiteURL, clone.SiteURL)
+}
+
+// ReplaceSiteURL rewrites every occurrence of from to to in the database of
+// a site, leaving post GUIDs alone.
+func ReplaceSiteURL(ctx context.Context, site models.Site, from, to string) error {
+	if from == to {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeouts.Command)
+	defer cancel()
+
+	executor, _, err := ExecutorForSite(ctx, site)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrConnect, err)
+	}
+	defer executor.Close()
+
+	JobStep(ctx, "Rewrite URLs")
+	utils.LogInfo("Replacing '%s' with '%s' on site '%s'", from, to, site.ProjectName)
+	remotePath := fmt.Sprintf("/var/www/%s", site.ProjectName)
+	replaceCmd := ComposeCmd(remotePath, "exec", "-T", "--user", "www-data", site.ProjectName+"_cli",
+		"wp", "search-replace", from, to, "--all-tables-with-prefix", "--skip-columns=guid", "--report-changed-only")
+	_, stderr, err := executor.Run(ctx, replaceCmd)
+	if err != nil {
+		return fmt.Errorf("%w: failed to replace URLs: %w, stderr: %s", ErrRemoteCommand, err, stderr)
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package services
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"wordpress-collab-tool/internal/fakes"
+	"wordpress-collab-tool/models"
+)
+
+// cloneFake answers the commands of a backup, deployment and restore.
+func cloneFake() *fakes.Executor {
+	return healthyFake().
+		On("_db_backup_", fakes.Result{Stdout: "/var/www/copy/restore_temp/blog_db_backup_1.sql\n"}).
+		On("_files_backup_", fakes.Result{Stdout: "/var/www/copy/restore_temp/blog_files_backup_1.tar.gz\n"})
+}
+
+func testClone() models.Site {
+	clone := testSite()
+	clone.ProjectName = "copy"
+	clone.WPPort = 8300
+	clone.DBName = "copy_db"
+	clone.SiteURL = "http://vps.test:8300"
+	clone.Origin = &models.SiteOrigin{ProjectName: "blog", SiteURL: "http://vps.test:8200"}
+	return clone
+}
+
+func TestCloneSite(t *testing.T) {
+	source := testSite()
+	source.Status = "active"
+	clone := testClone()
+	withSites(t, source, clone)
+	fake := cloneFake()
+	useFakeExecutor(t, fake)
+
+	if err := CloneSite(t.Context(), source, clone); err != nil {
+		t.Fatalf("CloneSite: %v", err)
+	}
+
+	for _, want := range []string{
+		"mariadb-dump -u root blog_db",
+		"docker compose -f /var/www/copy/docker-compose.yml up -d",
+		"tar -xzf /var/www/backups/blog/backup-",
+		"mariadb -u root copy_db",
+		"wp search-replace http://vps.test:8200 http://vps.test:8300",
+	} {
+		if !fake.Ran(want) {
+			t.Errorf("expected a command containing %q, ran:\n%s", want, strings.Join(fake.Commands(), "\n"))
+		}
+	}
+	if fake.Ran("wp core install") {
+		t.Error("clone was installed as a fresh WordPress site before the copy")
+	}
+	if compose, _ := fake.File("/var/www/copy/docker-compose.yml"); !strings.Contains(string(compose), "8300:80") {
+		t.Errorf("clone not deployed on its own port:\n%s", compose)
+	}
+
+	stored, err := GetSite("copy")
+	if err != nil {
+		t.Fatalf("GetSite: %v", err)
+	}
+	if stored.Status != "active" || stored.Origin == nil || stored.Origin.ProjectName != "blog" ||
+		!strings.HasPrefix(stored.Origin.Backup, "backup-") || stored.Origin.ClonedAt == nil {
+		t.Errorf("clone does not record its origin: status %q, %+v", stored.Status, stored.Origin)
+	}
+}
+
+func TestFailedCloneIsNotRetryable(t *testing.T) {
+	source := testSite()
+	source.Status = "active"
+	clone := testClone()
+	clone.Deployment = &models.Deployment{RetainOnFailure: true}
+	withSites(t, source, clone)
+	useFakeExecutor(t, cloneFake().On("up -d", fakes.Result{Stderr: "port is already allocated", ExitCode: 1}))
+
+	if err := CloneSite(t.Context(), source, clone); err == nil {
+		t.Fatal("expected the clone to fail")
+	}
+	stored, err := GetSite("copy")
+	if err != nil {
+		t.Fatalf("GetSite: %v", err)
+	}
+	if stored.Status != "failed" || Retryable(stored) {
+		t.Errorf("expected a failed clone that cannot be retried, got status %q and %+v", stored.Status, stored.Deployment)
+	}
+}
+
+func TestCloneSiteRemovesFailedClone(t *testing.T) {
+	source := testSite()
+	source.Status = "active"
+	clone := testClone()
+	withSites(t, source, clone)
+	fake := cloneFake().On("wp search-replace", fakes.Result{Stderr: "Error: database error", ExitCode: 1})
+	useFakeExecutor(t, fake)
+
+	if err := CloneSite(t.Context(), source, clone); err == nil {
+		t.Fatal("expected the clone to fail")
+	}
+	if !fake.Ran("/var/www/copy/docker-compose.yml down -v") || !fake.Ran("sudo rm -rf /var/www/copy") {
+		t.Errorf("failed clone was not removed:\n%s", strings.Join(fake.Commands(), "\n"))
+	}
+	if fake.Ran("/var/www/blog/docker-compose.yml down") || fake.Ran("rm -rf /var/www/blog") {
+		t.Error("source site was touched by the rollback")
+	}
+	if _, err := GetSite("copy"); !errors.Is(err, ErrSiteNotFound) {
+		t.Errorf("expected the clone record to be dropped, got %v", err)
+	}
+	if _, err := GetSite("blog"); err != nil {
+		t.Errorf("source site: %v", err)
+	}
+}
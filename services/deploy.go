@@ -0,0 +1,462 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+
+	"wordpress-collab-tool/models"
+	"wordpress-collab-tool/utils"
+)
+
+// Errors returned by DeployWordPressSite.
+var (
+	// ErrNotRetryable is returned when retrying a deployment that did not
+	// fail or was rolled back.
+	ErrNotRetryable = errors.New("deployment cannot be retried")
+	// ErrPluginInstall is returned by a deployment that succeeded although
+	// some of its plugins could not be installed.
+	ErrPluginInstall = errors.New("some plugins could not be installed")
+)
+
+// deployStep is one stage of a deployment. undo compensates for run, also
+// when run failed halfway, so it must be safe to call more than once.
+type deployStep struct {
+	name  string
+	label string
+	run   func(ctx context.Context, d *deployment) error
+	undo  func(ctx context.Context, d *deployment) error
+	// skip reports whether the step has nothing to do for this deployment.
+	skip func(d *deployment) bool
+	// optional steps only warn when they fail; the deployment goes on.
+	optional bool
+}
+
+// deploySteps are the stages of a deployment in order. The site record,
+// created before the deployment starts, comes first: a rollback drops it
+// after undoing these steps.
+var deploySteps = []deployStep{
+	{name: "directory", label: "Create site directory", run: createSiteDirectory, undo: removeSiteDirectory},
+	{name: "compose", label: "Upload docker-compose.yml", run: uploadComposeFile},
+	{name: "containers", label: "Start containers", run: startContainers, undo: removeContainers},
+	{name: "health", label: "Wait for containers", run: waitForContainers},
+	{name: "install", label: "Install WordPress", run: installWordPress, skip: func(d *deployment) bool { return d.site.Origin != nil }},
+	{name: "plugins", label: "Install plugins", run: installPlugins, skip: func(d *deployment) bool { return len(d.plugins) == 0 }, optional: true},
+}
+
+// deployStepIndex returns the index of the step called name, or -1.
+func deployStepIndex(name string) int {
+	for i, step := range deploySteps {
+		if step.name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// deployment is the state shared by the steps of one deployment attempt.
+type deployment struct {
+	site          models.Site
+	plugins       []string
+	adminUsername string
+	adminPassword string
+	remotePath    string
+	logs          *LogStream
+	executor      Executor
+	host          models.Host
+	retain        bool
+	attempts      int
+	// next is the index of the step that failed, or would have run next.
+	next int
+	// warnings are the errors of optional steps that failed.
+	warnings []error
+}
+
+// DeployWordPressSite handles the full deployment process of a WordPress site.
+// Its progress and command output are published on the site's deploy log.
+//
+// A deployment that failed before is resumed at the step that failed. If
+// it fails again, what it created is kept when the site's deployment asks
+// to retain it; otherwise the completed steps are undone in reverse and
+// the site record is dropped. Plugins that cannot be installed do not fail
+// the deployment: the site is marked active and ErrPluginInstall returned.
+func DeployWordPressSite(ctx context.Context, site models.Site, selectedPlugins []string, adminUsername, adminPassword string) error {
+	if err := validateDeployInput(site, selectedPlugins, adminUsername, adminPassword); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeouts.Deploy)
+	defer cancel()
+
+	d := &deployment{
+		site:          site,
+		plugins:       selectedPlugins,
+		adminUsername: adminUsername,
+		adminPassword: adminPassword,
+		remotePath:    fmt.Sprintf("/var/www/%s", site.ProjectName),
+		logs:          StartDeployLog(site.ProjectName),
+		attempts:      1,
+	}
+	if site.Deployment != nil {
+		d.retain = site.Deployment.RetainOnFailure
+		d.attempts = site.Deployment.Attempts + 1
+		d.next = max(deployStepIndex(site.Deployment.FailedStep), 0)
+	}
+	defer func() {
+		if d.executor != nil {
+			d.executor.Close()
+		}
+	}()
+
+	err := d.run(ctx)
+	switch {
+	case err == nil && len(d.warnings) > 0:
+		d.succeed()
+		d.logs.Infof("Deployment finished with warnings.")
+		d.logs.Finish("succeeded")
+		return fmt.Errorf("%w: %w", ErrPluginInstall, errors.Join(d.warnings...))
+	case err == nil:
+		d.succeed()
+		d.logs.Infof("Deployment finished.")
+		d.logs.Finish("succeeded")
+	case errors.Is(err, context.Canceled):
+		d.logs.Infof("Deployment cancelled.")
+		d.fail(ctx, err)
+		d.logs.Finish("cancelled")
+	default:
+		d.logs.Infof("Deployment failed: %v", err)
+		d.fail(ctx, err)
+		d.logs.Finish("failed")
+	}
+	return err
+}
+
+// RetryDeployment resumes the failed deployment of a site at the step that
+// failed, with the plugins and admin account it was started with.
+func RetryDeployment(ctx context.Context, projectName string) error {
+	site, err := GetSite(projectName)
+	if err != nil {
+		return err
+	}
+	if !Retryable(site) {
+		return fmt.Errorf("%w: site '%s' is %s", ErrNotRetryable, projectName, site.Status)
+	}
+	site, err = UpdateSite(projectName, func(s *models.Site) {
+		s.Status = "creating"
+	})
+	if err != nil {
+		return err
+	}
+	return DeployWordPressSite(ctx, site, site.Plugins, site.AdminUsername, site.AdminPassword)
+}
+
+// Retryable reports whether the site's deployment failed and can be
+// resumed. Clones are not: a retry would only deploy a blank site, without
+// the copy of their source.
+func Retryable(site models.Site) bool {
+	return site.Status == "failed" && site.Origin == nil && site.Deployment != nil && site.Deployment.FailedStep != ""
+}
+
+// run connects to the site's host and runs the steps from d.next on.
+func (d *deployment) run(ctx context.Context) error {
+	if d.next > 0 {
+		d.logs.Infof("Resuming the deployment at step '%s'.", deploySteps[d.next].label)
+	}
+
+	JobStep(ctx, "Connect to host")
+	d.logs.Infof("Connecting to the host of site '%s'.", d.site.ProjectName)
+	if err := d.connect(ctx); err != nil {
+		return err
+	}
+
+	for ; d.next < len(deploySteps); d.next++ {
+		step := deploySteps[d.next]
+		if step.skip != nil && step.skip(d) {
+			continue
+		}
+		JobStep(ctx, step.label)
+		if err := step.run(ctx, d); err != nil {
+			if !step.optional || ctx.Err() != nil {
+				return err
+			}
+			FailJobStep(ctx)
+			d.logs.Infof("Step '%s' failed, continuing: %v", step.label, err)
+			d.warnings = append(d.warnings, err)
+		}
+	}
+	return nil
+}
+
+func (d *deployment) connect(ctx context.Context) error {
+	executor, host, err := ExecutorForSite(ctx, d.site)
+	if err != nil {
+		return fmt.Errorf("failed to connect to VPS: %w", err)
+	}
+	d.executor, d.host = executor, host
+	return nil
+}
+
+// succeed marks the site active.
+func (d *deployment) succeed() {
+	_, err := UpdateSite(d.site.ProjectName, func(site *models.Site) {
+		site.Status = "active"
+		site.LastChecked = time.Now().Format(time.RFC3339)
+		site.Deployment = nil
+	})
+	if err != nil {
+		utils.LogError("Failed to update status of site '%s': %v", d.site.ProjectName, err)
+	}
+}
+
+// fail keeps what the deployment created for a retry, or rolls it back.
+// A rollback that cannot undo every step keeps the site failed, to be
+// retried from the start or deleted.
+func (d *deployment) fail(ctx context.Context, cause error) {
+	FailJobStep(ctx)
+	failed := deploySteps[min(d.next, len(deploySteps)-1)]
+	now := time.Now().UTC()
+	state := models.Deployment{
+		RetainOnFailure: d.retain,
+		FailedStep:      failed.name,
+		Error:           cause.Error(),
+		Attempts:        d.attempts,
+		FailedAt:        &now,
+	}
+
+	if d.retain {
+		d.logs.Infof("Keeping what the deployment created; retry it to resume at step '%s'.", failed.label)
+		d.record(state)
+		LogActivityContext(ctx, "warning", "site.deploy", fmt.Sprintf("Deployment of site '%s' failed at step '%s'; its resources were kept.", d.site.ProjectName, state.FailedStep), d.site.ProjectName)
+		return
+	}
+
+	if err := d.rollback(ctx); err != nil {
+		utils.LogError("Rollback of site '%s' incomplete: %v", d.site.ProjectName, err)
+		state.FailedStep = deploySteps[0].name
+		d.record(state)
+		LogActivityContext(ctx, "error", "site.rollback", fmt.Sprintf("Rollback of site '%s' incomplete: %v", d.site.ProjectName, err), d.site.ProjectName)
+		return
+	}
+	LogActivityContext(ctx, "warning", "site.rollback", fmt.Sprintf("Site '%s' rolled back after its deployment failed.", d.site.ProjectName), d.site.ProjectName)
+}
+
+// record stores the state of the failed deployment on the site.
+func (d *deployment) record(state models.Deployment) {
+	_, err := UpdateSite(d.site.ProjectName, func(site *models.Site) {
+		site.Status = "failed"
+		site.LastChecked = time.Now().Format(time.RFC3339)
+		site.Deployment = &state
+	})
+	if err != nil {
+		utils.LogError("Failed to record the failed deployment of site '%s': %v", d.site.ProjectName, err)
+	}
+}
+
+// rollback undoes the steps up to and including the one that failed, in
+// reverse, and then drops the site record. It runs even if ctx has already
+// been cancelled.
+func (d *deployment) rollback(ctx context.Context) error {
+	JobStep(ctx, "Roll back")
+	ctx, cancel := cleanupContext(ctx)
+	defer cancel()
+	d.logs.Infof("Rolling back the deployment.")
+
+	if d.executor == nil {
+		if err := d.connect(ctx); err != nil {
+			return err
+		}
+	}
+
+	var errs []error
+	for i := min(d.next, len(deploySteps)-1); i >= 0; i-- {
+		step := deploySteps[i]
+		if step.undo == nil {
+			continue
+		}
+		if err := step.undo(ctx, d); err != nil {
+			d.logs.Infof("Could not undo step '%s': %v", step.label, err)
+			errs = append(errs, fmt.Errorf("%s: %w", step.name, err))
+		}
+	}
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+
+	d.logs.Infof("Dropping the record of site '%s'.", d.site.ProjectName)
+	if err := Sites.Delete(d.site.ProjectName); err != nil && !errors.Is(err, ErrSiteNotFound) {
+		return fmt.Errorf("failed to drop site record: %w", err)
+	}
+	return nil
+}
+
+// undoDeployment removes a site that was deployed in full, as the rollback
+// of a failed deployment would: its containers and volumes, its directory
+// and its record.
+func undoDeployment(ctx context.Context, site models.Site) error {
+	d := &deployment{
+		site:       site,
+		remotePath: fmt.Sprintf("/var/www/%s", site.ProjectName),
+		logs:       StartDeployLog(site.ProjectName),
+		next:       len(deploySteps) - 1,
+	}
+	defer func() {
+		if d.executor != nil {
+			d.executor.Close()
+		}
+	}()
+	err := d.rollback(ctx)
+	d.logs.Finish("failed")
+	return err
+}
+
+func createSiteDirectory(ctx context.Context, d *deployment) error {
+	sshUser := d.host.Credentials.User
+	utils.LogInfo("Creating remote directory: %s", d.remotePath)
+	d.logs.Infof("Creating %s.", d.remotePath)
+	stdout, stderr, err := runLogged(ctx, d.executor, d.logs, Cmd("sudo", "install", "-d", "-o", sshUser, "-g", sshUser, d.remotePath))
+	if err != nil {
+		return fmt.Errorf("failed to create and set ownership of remote directory: %w, stdout: %s, stderr: %s", err, stdout, stderr)
+	}
+	utils.LogInfo("Remote directory created successfully.")
+	return nil
+}
+
+func removeSiteDirectory(ctx context.Context, d *deployment) error {
+	d.logs.Infof("Removing %s.", d.remotePath)
+	stdout, stderr, err := runLogged(ctx, d.executor, d.logs, Cmd("sudo", "rm", "-rf", d.remotePath))
+	if err != nil {
+		return fmt.Errorf("failed to remove remote directory: %w, stdout: %s, stderr: %s", err, stdout, stderr)
+	}
+	return nil
+}
+
+func uploadComposeFile(ctx context.Context, d *deployment) error {
+	// Generate the docker-compose.yml file on the local machine
+	tmpl, err := template.ParseFiles("templates/template.yml")
+	if err != nil {
+		return fmt.Errorf("failed to parse template: %w", err)
+	}
+
+	var tpl bytes.Buffer
+	templateConfig := models.Config{
+		ProjectName: d.site.ProjectName,
+		WPPort:      d.site.WPPort,
+		DBName:      d.site.DBName,
+		DBPassword:  d.site.DBPassword,
+	}
+	if err := tmpl.Execute(&tpl, templateConfig); err != nil {
+		return fmt.Errorf("failed to execute template: %w", err)
+	}
+
+	utils.LogInfo("Uploading docker-compose.yml to %s", d.remotePath)
+	d.logs.Infof("Uploading docker-compose.yml.")
+	if err := d.executor.Upload(ctx, filepath.Join(d.remotePath, "docker-compose.yml"), &tpl); err != nil {
+		return fmt.Errorf("failed to upload docker-compose.yml: %w", err)
+	}
+	utils.LogInfo("docker-compose.yml uploaded successfully.")
+
+	// Check if the file exists and has the correct permissions
+	stdout, stderr, err := runLogged(ctx, d.executor, d.logs, Cmd("ls", "-l", d.remotePath))
+	if err != nil {
+		utils.LogError("Failed to list files in remote directory: %v, stdout: %s, stderr: %s", err, stdout, stderr)
+	} else {
+		utils.LogInfo("Files in remote directory: %s", stdout)
+	}
+	return nil
+}
+
+func startContainers(ctx context.Context, d *deployment) error {
+	utils.LogInfo("Executing command: cd %s && docker compose up -d", d.remotePath)
+	d.logs.Infof("Starting containers.")
+	stdout, stderr, err := runLogged(ctx, d.executor, d.logs, ComposeCmd(d.remotePath, "up", "-d"))
+	if err != nil {
+		return fmt.Errorf("failed to run docker compose up: %w, stdout: %s, stderr: %s", err, stdout, stderr)
+	}
+	utils.LogInfo("docker compose up -d command executed. stdout: %s, stderr: %s", stdout, stderr)
+	// Give docker-compose a moment to start
+	return sleepContext(ctx, containerStartDelay)
+}
+
+// removeContainers stops the site's containers and removes their volumes,
+// which hold the database.
+func removeContainers(ctx context.Context, d *deployment) error {
+	d.logs.Infof("Removing containers and volumes.")
+	stdout, stderr, err := runLogged(ctx, d.executor, d.logs, ComposeCmd(d.remotePath, "down", "-v", "--remove-orphans"))
+	if err != nil {
+		return fmt.Errorf("failed to run docker compose down: %w, stdout: %s, stderr: %s", err, stdout, stderr)
+	}
+	return nil
+}
+
+func waitForContainers(ctx context.Context, d *deployment) error {
+	projectName := d.site.ProjectName
+	utils.LogInfo("Waiting for WordPress container to be ready for site '%s'...", projectName)
+	if err := waitForContainerHealthy(ctx, d.executor, d.logs, projectName, "_wordpress", d.remotePath); err != nil {
+		return fmt.Errorf("WordPress container did not become ready: %w", err)
+	}
+	utils.LogInfo("Waiting for CLI container to be ready for site '%s'...", projectName)
+	if err := waitForContainerHealthy(ctx, d.executor, d.logs, projectName, "_cli", d.remotePath); err != nil {
+		return fmt.Errorf("CLI container did not become ready: %w", err)
+	}
+	utils.LogInfo("Containers for site '%s' are ready.", projectName)
+	// Give CLI container a moment to be fully ready
+	return sleepContext(ctx, containerStartDelay)
+}
+
+func installWordPress(ctx context.Context, d *deployment) error {
+	site := d.site
+	wpInstallCmd := ComposeCmd(d.remotePath, "exec", "-T", "--user", "www-data", site.ProjectName+"_cli",
+		"wp", "core", "install",
+		"--url="+site.SiteURL,
+		"--title="+site.ProjectName,
+		"--admin_user="+d.adminUsername,
+		"--admin_password="+d.adminPassword,
+		"--admin_email=admin@"+site.ProjectName+".com",
+		"--skip-email", "--debug")
+	utils.LogInfo("Executing WordPress install command for site '%s'", site.ProjectName)
+	d.logs.Infof("Installing WordPress.")
+	stdout, stderr, err := runLogged(ctx, d.executor, d.logs, wpInstallCmd)
+	if err != nil {
+		return fmt.Errorf("failed to install WordPress for site '%s'. Error: %w, stdout: %s, stderr: %s", site.ProjectName, err, stdout, stderr)
+	}
+	utils.LogInfo("WordPress installed successfully for site '%s'.", site.ProjectName)
+	return nil
+}
+
+// installPlugins installs every selected plugin, reporting the failures
+// together.
+func installPlugins(ctx context.Context, d *deployment) error {
+	projectName := d.site.ProjectName
+	utils.LogInfo("Waiting for %s before starting plugin installation...", pluginInstallDelay)
+	if err := sleepContext(ctx, pluginInstallDelay); err != nil {
+		return err
+	}
+	utils.LogInfo("Starting plugin installation for site '%s'.", projectName)
+
+	var installErrors []string
+	for _, plugin := range d.plugins {
+		pluginInstallCmd := ComposeCmd(d.remotePath, "exec", "-T", projectName+"_cli", "wp", "plugin", "install", plugin, "--activate")
+		d.logs.Infof("Installing plugin '%s'.", plugin)
+		stdout, stderr, err := runLogged(ctx, d.executor, d.logs, pluginInstallCmd)
+		if err != nil {
+			errMessage := fmt.Sprintf("failed to install plugin '%s' for site '%s'. Error: %v, stdout: %s, stderr: %s", plugin, projectName, err, stdout, stderr)
+			utils.LogError(errMessage)
+			LogActivityContext(ctx, "error", "plugin.install", fmt.Sprintf("Failed to install plugin '%s' on site '%s'.", plugin, projectName), projectName)
+			installErrors = append(installErrors, errMessage)
+		} else {
+			utils.LogInfo("Plugin '%s' installed successfully on site '%s'.", plugin, projectName)
+			LogActivityContext(ctx, "info", "plugin.install", fmt.Sprintf("Plugin '%s' installed successfully on site '%s'.", plugin, projectName), projectName)
+		}
+	}
+
+	if len(installErrors) > 0 {
+		return fmt.Errorf("encountered errors during plugin installation:\n%s", strings.Join(installErrors, "\n"))
+	}
+	utils.LogInfo("All plugins installed successfully for site '%s'.", projectName)
+	return nil
+}
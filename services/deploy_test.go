@@ -0,0 +1,118 @@
+package services
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+
+	"wordpress-collab-tool/internal/fakes"
+	"wordpress-collab-tool/models"
+)
+
+// commandIndex returns the index of the first command containing substring, or -1.
+func commandIndex(fake *fakes.Executor, substring string) int {
+	return slices.IndexFunc(fake.Commands(), func(command string) bool {
+		return strings.Contains(command, substring)
+	})
+}
+
+func TestDeployWordPressSiteRollsBackOnFailure(t *testing.T) {
+	site := testSite()
+	withSites(t, site)
+	fake := fakes.NewExecutor().On("docker inspect", fakes.Result{Stdout: "unhealthy\n"})
+	useFakeExecutor(t, fake)
+
+	if err := DeployWordPressSite(t.Context(), site, nil, "admin", "adminpass"); err == nil {
+		t.Fatal("expected the deployment to fail")
+	}
+
+	down := commandIndex(fake, "docker-compose.yml down -v")
+	remove := commandIndex(fake, "sudo rm -rf /var/www/blog")
+	if down < 0 || remove < 0 || down > remove {
+		t.Errorf("expected compose down -v and then the directory removal, ran:\n%s", strings.Join(fake.Commands(), "\n"))
+	}
+	if _, err := GetSite("blog"); !errors.Is(err, ErrSiteNotFound) {
+		t.Errorf("expected the site record to be dropped, got %v", err)
+	}
+}
+
+func TestDeployWordPressSiteRollsBackOnlyWhatRan(t *testing.T) {
+	site := testSite()
+	withSites(t, site)
+	fake := healthyFake().On("sudo install -d", fakes.Result{Stderr: "permission denied", ExitCode: 1})
+	useFakeExecutor(t, fake)
+
+	if err := DeployWordPressSite(t.Context(), site, nil, "admin", "adminpass"); err == nil {
+		t.Fatal("expected the deployment to fail")
+	}
+	if !fake.Ran("sudo rm -rf /var/www/blog") {
+		t.Error("half-created directory was not removed")
+	}
+	if fake.Ran("down -v") {
+		t.Error("containers were removed although they were never started")
+	}
+}
+
+func TestDeployWordPressSiteKeepsRecordWhenRollbackFails(t *testing.T) {
+	site := testSite()
+	withSites(t, site)
+	fake := healthyFake().
+		On("wp core install", fakes.Result{Stderr: "database error", ExitCode: 1}).
+		On("sudo rm -rf", fakes.Result{Stderr: "device busy", ExitCode: 1})
+	useFakeExecutor(t, fake)
+
+	if err := DeployWordPressSite(t.Context(), site, nil, "admin", "adminpass"); err == nil {
+		t.Fatal("expected the deployment to fail")
+	}
+	stored, err := GetSite("blog")
+	if err != nil {
+		t.Fatalf("GetSite: %v", err)
+	}
+	if stored.Status != "failed" || stored.Deployment == nil || stored.Deployment.FailedStep != "directory" {
+		t.Errorf("expected a failed site to retry from the start, got status %q and %+v", stored.Status, stored.Deployment)
+	}
+}
+
+func TestRetryDeploymentResumesAtFailedStep(t *testing.T) {
+	site := testSite()
+	site.Plugins = []string{"akismet"}
+	site.Deployment = &models.Deployment{RetainOnFailure: true}
+	withSites(t, site)
+	fake := healthyFake().On("wp core install", fakes.Result{Stderr: "database error", ExitCode: 1})
+	useFakeExecutor(t, fake)
+
+	if err := DeployWordPressSite(t.Context(), site, site.Plugins, "admin", "adminpass"); err == nil {
+		t.Fatal("expected the deployment to fail")
+	}
+	if fake.Ran("rm -rf") || fake.Ran("down -v") {
+		t.Errorf("retained deployment was rolled back:\n%s", strings.Join(fake.Commands(), "\n"))
+	}
+	stored, err := GetSite("blog")
+	if err != nil {
+		t.Fatalf("GetSite: %v", err)
+	}
+	if !Retryable(stored) || stored.Deployment.FailedStep != "install" || stored.Deployment.Attempts != 1 {
+		t.Fatalf("expected a retryable failure at install, got status %q and %+v", stored.Status, stored.Deployment)
+	}
+
+	retry := healthyFake()
+	useFakeExecutor(t, retry)
+	if err := RetryDeployment(t.Context(), "blog"); err != nil {
+		t.Fatalf("RetryDeployment: %v", err)
+	}
+	if retry.Ran("sudo install -d") || retry.Ran("up -d") {
+		t.Errorf("retry repeated steps that had succeeded:\n%s", strings.Join(retry.Commands(), "\n"))
+	}
+	if !retry.Ran("wp core install") || !retry.Ran("wp plugin install akismet") {
+		t.Errorf("retry did not run the remaining steps:\n%s", strings.Join(retry.Commands(), "\n"))
+	}
+	stored, _ = GetSite("blog")
+	if stored.Status != "active" || stored.Deployment != nil {
+		t.Errorf("expected an active site, got status %q and %+v", stored.Status, stored.Deployment)
+	}
+
+	if err := RetryDeployment(t.Context(), "blog"); !errors.Is(err, ErrNotRetryable) {
+		t.Errorf("expected ErrNotRetryable for an active site, got %v", err)
+	}
+}
@@ -0,0 +1,171 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/pkg/sftp"
+	"wordpress-collab-tool/models"
+	"wordpress-collab-tool/utils"
+)
+
+// Executor runs commands and transfers files on a host. Commands still running
+// when ctx is done are signalled and abandoned.
+type Executor interface {
+	// Run executes command and returns its buffered stdout and stderr.
+	Run(ctx context.Context, command string) (stdout, stderr string, err error)
+	// Stream executes command, copying its output to stdout and stderr as it is produced.
+	Stream(ctx context.Context, command string, stdout, stderr io.Writer) error
+	// Upload writes content to remotePath, replacing any existing file.
+	Upload(ctx context.Context, remotePath string, content io.Reader) error
+	// Download copies the file at remotePath into w.
+	Download(ctx context.Context, remotePath string, w io.Writer) error
+	// Close releases the underlying connection.
+	Close() error
+}
+
+// Errors wrapped by the site operations so that callers can tell a host that
+// cannot be reached from a command that failed on it.
+var (
+	ErrConnect       = errors.New("failed to connect to VPS")
+	ErrRemoteCommand = errors.New("remote command failed")
+	ErrInvalidOutput = errors.New("unexpected command output")
+)
+
+// exitStatuser is implemented by the errors of commands that exited with a
+// non-zero status, such as *ssh.ExitError.
+type exitStatuser interface {
+	ExitStatus() int
+}
+
+// ExitCode returns the exit status carried by err, 0 for a nil error and -1
+// when err did not come from a command that ran to completion.
+func ExitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	var exitErr exitStatuser
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitStatus()
+	}
+	return -1
+}
+
+// NewExecutor opens an Executor for a host. It is a variable so that tests
+// can substitute a fake.
+var NewExecutor = func(ctx context.Context, host models.Host) (Executor, error) {
+	client, err := GetSSHClient(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	return &SSHExecutor{client: client}, nil
+}
+
+// ExecutorForSite opens an Executor for the host a site runs on.
+func ExecutorForSite(ctx context.Context, site models.Site) (Executor, models.Host, error) {
+	host, err := HostForSite(site)
+	if err != nil {
+		return nil, models.Host{}, err
+	}
+	exec, err := NewExecutor(ctx, host)
+	if err != nil {
+		return nil, models.Host{}, err
+	}
+	return exec, host, nil
+}
+
+// SSHExecutor runs commands over a pooled SSH connection.
+type SSHExecutor struct {
+	client *PooledClient
+	sftp   *sftp.Client
+}
+
+// Run executes command over SSH.
+func (e *SSHExecutor) Run(ctx context.Context, command string) (string, string, error) {
+	return RunSSHCommand(ctx, e.client, command)
+}
+
+// Stream executes command over SSH, writing output as it arrives.
+func (e *SSHExecutor) Stream(ctx context.Context, command string, stdout, stderr io.Writer) error {
+	session, err := e.client.NewSession()
+	if err != nil {
+		e.client.MarkBroken()
+		utils.LogError("Failed to create SSH session: %v", err)
+		return fmt.Errorf("failed to create SSH session: %w", err)
+	}
+	defer session.Close()
+
+	session.Stdout = stdout
+	session.Stderr = stderr
+
+	utils.LogInfo("Streaming SSH command: %s", redactCommand(command))
+	if err := runSession(ctx, session, command); err != nil {
+		utils.LogError("SSH command failed: %v", err)
+		return fmt.Errorf("SSH command failed: %w", err)
+	}
+	return nil
+}
+
+// Upload writes a file over SFTP.
+func (e *SSHExecutor) Upload(ctx context.Context, remotePath string, content io.Reader) error {
+	sftpClient, err := e.sftpClient()
+	if err != nil {
+		return err
+	}
+	return UploadFile(sftpClient, remotePath, contextReader{ctx, content})
+}
+
+// Download reads a file over SFTP.
+func (e *SSHExecutor) Download(ctx context.Context, remotePath string, w io.Writer) error {
+	sftpClient, err := e.sftpClient()
+	if err != nil {
+		return err
+	}
+	remoteFile, err := sftpClient.Open(remotePath)
+	if err != nil {
+		utils.LogError("Failed to open remote file %s: %v", remotePath, err)
+		return fmt.Errorf("failed to open remote file: %w", err)
+	}
+	defer remoteFile.Close()
+
+	if _, err := io.Copy(w, contextReader{ctx, remoteFile}); err != nil {
+		utils.LogError("Failed to read remote file %s: %v", remotePath, err)
+		return fmt.Errorf("failed to read remote file: %w", err)
+	}
+	return nil
+}
+
+// Close closes the SFTP client, if any, and returns the connection to the pool.
+func (e *SSHExecutor) Close() error {
+	if e.sftp != nil {
+		e.sftp.Close()
+		e.sftp = nil
+	}
+	return e.client.Close()
+}
+
+func (e *SSHExecutor) sftpClient() (*sftp.Client, error) {
+	if e.sftp == nil {
+		client, err := GetSFTPClient(e.client)
+		if err != nil {
+			return nil, err
+		}
+		e.sftp = client
+	}
+	return e.sftp, nil
+}
+
+// contextReader stops a copy with the context's error once ctx is done.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package services
+
+// lockFile is a no-op on platforms without flock; writers within this
+// process are still serialised by the store's mutex.
+func lockFile(path string, exclusive bool) (func(), error) {
+	return func() {}, nil
+}
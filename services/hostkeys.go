@@ -0,0 +1,348 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"wordpress-collab-tool/config"
+	"wordpress-collab-tool/models"
+	"wordpress-collab-tool/utils"
+)
+
+const (
+	// HostKeyModeStrict refuses to connect to hosts whose key is not pinned.
+	HostKeyModeStrict = "strict"
+	// HostKeyModeTOFU pins the key presented on the first connection to a host.
+	HostKeyModeTOFU = "tofu"
+)
+
+var (
+	ErrHostKeyUnknown = errors.New("host key is not trusted")
+	ErrHostKeyChanged = errors.New("host key has changed")
+	ErrHostKeyBlocked = errors.New("host is blocked because its host key changed")
+	ErrNoPendingKey   = errors.New("no pending host key for this host")
+)
+
+// HostKeyStore verifies SSH host keys against a known_hosts file and keeps
+// track of keys waiting for approval and hosts blocked after a key change.
+type HostKeyStore struct {
+	mu       sync.Mutex
+	path     string
+	mode     string
+	callback ssh.HostKeyCallback
+	pending  map[string]pendingKey
+	blocked  map[string]bool
+}
+
+type pendingKey struct {
+	key    ssh.PublicKey
+	seenAt time.Time
+}
+
+// HostKeys is the host key store shared by every SSH connection.
+var HostKeys *HostKeyStore
+
+var (
+	hostKeysInit sync.Once
+	hostKeysErr  error
+)
+
+// InitHostKeys loads the known_hosts store from the configuration. A file
+// that cannot be read or parsed is an error rather than a reason to connect
+// without verifying keys.
+func InitHostKeys(cfg *config.Config) error {
+	hostKeysInit.Do(func() {
+		store, err := NewHostKeyStore(cfg.SSHKnownHostsPath, cfg.SSHHostKeyMode)
+		if err != nil {
+			hostKeysErr = fmt.Errorf("failed to load known hosts from %s: %w", cfg.SSHKnownHostsPath, err)
+			return
+		}
+		HostKeys = store
+	})
+	return hostKeysErr
+}
+
+// NewHostKeyStore opens (creating if needed) the known_hosts file at path.
+func NewHostKeyStore(path, mode string) (*HostKeyStore, error) {
+	if mode != HostKeyModeTOFU {
+		mode = HostKeyModeStrict
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDONLY, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open known hosts file: %w", err)
+	}
+	f.Close()
+
+	s := &HostKeyStore{
+		path:    path,
+		mode:    mode,
+		pending: make(map[string]pendingKey),
+		blocked: make(map[string]bool),
+	}
+	if err := s.reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Mode returns the verification mode, either strict or tofu.
+func (s *HostKeyStore) Mode() string {
+	return s.mode
+}
+
+// HostKeyCallback verifies the key presented by a server during the SSH handshake.
+func (s *HostKeyStore) HostKeyCallback(hostname string, remote net.Addr, key ssh.PublicKey) error {
+	host := knownhosts.Normalize(hostname)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.blocked[host] {
+		return fmt.Errorf("%w: %s", ErrHostKeyBlocked, host)
+	}
+	if s.callback == nil {
+		// No known_hosts file was loaded, so no key can be trusted
+		return fmt.Errorf("%w: %s", ErrHostKeyUnknown, host)
+	}
+
+	err := s.callback(hostname, remote, key)
+	if err == nil {
+		return nil
+	}
+
+	var keyErr *knownhosts.KeyError
+	if !errors.As(err, &keyErr) {
+		// Revoked keys and malformed entries end up here.
+		return err
+	}
+
+	fingerprint := ssh.FingerprintSHA256(key)
+	if len(keyErr.Want) > 0 {
+		s.blocked[host] = true
+		s.pending[host] = pendingKey{key: key, seenAt: time.Now()}
+		utils.LogError("Host key for %s changed to %s; blocking host", host, fingerprint)
+		go LogActivity("security", fmt.Sprintf("Host key for '%s' changed (now %s %s). All operations against this host are blocked until the new key is approved.", host, key.Type(), fingerprint), "")
+		return fmt.Errorf("%w: %s presented %s", ErrHostKeyChanged, host, fingerprint)
+	}
+
+	if s.mode == HostKeyModeTOFU {
+		if err := s.appendKey(host, key); err != nil {
+			return err
+		}
+		go LogActivity("security", fmt.Sprintf("Trusted host key %s %s for '%s' on first use.", key.Type(), fingerprint, host), "")
+		return nil
+	}
+
+	s.pending[host] = pendingKey{key: key, seenAt: time.Now()}
+	go LogActivity("security", fmt.Sprintf("Refused unknown host key %s %s for '%s'. Approve it to allow connections.", key.Type(), fingerprint, host), "")
+	return fmt.Errorf("%w: %s presented %s", ErrHostKeyUnknown, host, fingerprint)
+}
+
+// IsBlocked reports whether operations against host are blocked.
+func (s *HostKeyStore) IsBlocked(hostname string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.blocked[knownhosts.Normalize(hostname)]
+}
+
+// List returns every pinned and pending host key.
+func (s *HostKeyStore) List() ([]models.HostKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries, err := s.readEntries()
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []models.HostKey
+	for _, e := range entries {
+		for _, host := range e.hosts {
+			keys = append(keys, models.HostKey{
+				Host:        host,
+				Type:        e.key.Type(),
+				Fingerprint: ssh.FingerprintSHA256(e.key),
+				Status:      "pinned",
+				Blocked:     s.blocked[host],
+			})
+		}
+	}
+	for host, p := range s.pending {
+		keys = append(keys, models.HostKey{
+			Host:        host,
+			Type:        p.key.Type(),
+			Fingerprint: ssh.FingerprintSHA256(p.key),
+			Status:      "pending",
+			Blocked:     s.blocked[host],
+			SeenAt:      p.seenAt.Format(time.RFC3339),
+		})
+	}
+	sort.SliceStable(keys, func(i, j int) bool { return keys[i].Host < keys[j].Host })
+	return keys, nil
+}
+
+// Approve pins the pending key for host, replacing any previously pinned keys,
+// and unblocks the host. The fingerprint must match the pending key so that a
+// key swapped in after the operator looked at it is not approved by accident.
+func (s *HostKeyStore) Approve(hostname, fingerprint string) (models.HostKey, error) {
+	host := knownhosts.Normalize(hostname)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, ok := s.pending[host]
+	if !ok {
+		return models.HostKey{}, fmt.Errorf("%w: %s", ErrNoPendingKey, host)
+	}
+	if got := ssh.FingerprintSHA256(p.key); fingerprint != got {
+		return models.HostKey{}, fmt.Errorf("fingerprint mismatch: pending key for %s is %s", host, got)
+	}
+
+	if err := s.removeHost(host); err != nil {
+		return models.HostKey{}, err
+	}
+	if err := s.appendKey(host, p.key); err != nil {
+		return models.HostKey{}, err
+	}
+	delete(s.pending, host)
+	delete(s.blocked, host)
+
+	return models.HostKey{Host: host, Type: p.key.Type(), Fingerprint: fingerprint, Status: "pinned"}, nil
+}
+
+// Revoke removes every pinned key for host. The next connection will be
+// treated as a first contact.
+func (s *HostKeyStore) Revoke(hostname string) (int, error) {
+	host := knownhosts.Normalize(hostname)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries, err := s.readEntries()
+	if err != nil {
+		return 0, err
+	}
+	removed := 0
+	for _, e := range entries {
+		for _, h := range e.hosts {
+			if h == host {
+				removed++
+			}
+		}
+	}
+	if err := s.removeHost(host); err != nil {
+		return 0, err
+	}
+	delete(s.pending, host)
+	delete(s.blocked, host)
+	return removed, nil
+}
+
+type knownHostEntry struct {
+	hosts []string
+	key   ssh.PublicKey
+}
+
+// readEntries parses the plain (non-hashed, non-marker) entries of the known_hosts file.
+func (s *HostKeyStore) readEntries() ([]knownHostEntry, error) {
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read known hosts file: %w", err)
+	}
+
+	var entries []knownHostEntry
+	for len(data) > 0 {
+		marker, hosts, key, _, rest, err := ssh.ParseKnownHosts(data)
+		if err != nil {
+			break
+		}
+		data = rest
+		if marker != "" {
+			continue
+		}
+		var plain []string
+		for _, h := range hosts {
+			if !strings.HasPrefix(h, "|") {
+				plain = append(plain, h)
+			}
+		}
+		if len(plain) > 0 {
+			entries = append(entries, knownHostEntry{hosts: plain, key: key})
+		}
+	}
+	return entries, nil
+}
+
+// appendKey pins key for host and reloads the verifier. Callers hold s.mu.
+func (s *HostKeyStore) appendKey(host string, key ssh.PublicKey) error {
+	f, err := os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open known hosts file: %w", err)
+	}
+	if _, err := fmt.Fprintln(f, knownhosts.Line([]string{host}, key)); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write known hosts file: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to write known hosts file: %w", err)
+	}
+	return s.reload()
+}
+
+// removeHost drops host from every plain known_hosts line, deleting lines
+// that no longer name any host. Callers hold s.mu.
+func (s *HostKeyStore) removeHost(host string) error {
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return fmt.Errorf("failed to read known hosts file: %w", err)
+	}
+
+	var out bytes.Buffer
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		fields := strings.Fields(line)
+		if len(fields) < 3 || strings.HasPrefix(fields[0], "#") || strings.HasPrefix(fields[0], "@") {
+			out.WriteString(line + "\n")
+			continue
+		}
+		var kept []string
+		for _, h := range strings.Split(fields[0], ",") {
+			if h != host {
+				kept = append(kept, h)
+			}
+		}
+		if len(kept) == 0 {
+			continue
+		}
+		fields[0] = strings.Join(kept, ",")
+		out.WriteString(strings.Join(fields, " ") + "\n")
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to parse known hosts file: %w", err)
+	}
+
+	if err := os.WriteFile(s.path, out.Bytes(), 0600); err != nil {
+		return fmt.Errorf("failed to write known hosts file: %w", err)
+	}
+	return s.reload()
+}
+
+func (s *HostKeyStore) reload() error {
+	callback, err := knownhosts.New(s.path)
+	if err != nil {
+		return fmt.Errorf("failed to parse known hosts file: %w", err)
+	}
+	s.callback = callback
+	return nil
+}
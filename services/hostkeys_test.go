@@ -0,0 +1,205 @@
+package services
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+var testHostAddr = &net.TCPAddr{IP: net.IPv4(203, 0, 113, 7), Port: 22}
+
+const testHostname = "203.0.113.7:22"
+
+func newHostKey(t *testing.T) ssh.PublicKey {
+	t.Helper()
+	public, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	key, err := ssh.NewPublicKey(public)
+	if err != nil {
+		t.Fatalf("NewPublicKey: %v", err)
+	}
+	return key
+}
+
+func newTestHostKeyStore(t *testing.T, mode string) *HostKeyStore {
+	t.Helper()
+	withActivities(t)
+	store, err := NewHostKeyStore(filepath.Join(t.TempDir(), "known_hosts"), mode)
+	if err != nil {
+		t.Fatalf("NewHostKeyStore: %v", err)
+	}
+	return store
+}
+
+// waitForActivity waits for the security activity the host key store logs
+// in the background, so that it is written before the test's store closes.
+func waitForActivity(t *testing.T, search string) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		activities, _, err := Activities.Query(ActivityQuery{Search: search})
+		if err != nil {
+			t.Fatalf("Query: %v", err)
+		}
+		if len(activities) > 0 {
+			return
+		}
+	}
+	t.Fatalf("no activity mentioning %q", search)
+}
+
+func hostKeyStatus(t *testing.T, store *HostKeyStore, key ssh.PublicKey) string {
+	t.Helper()
+	keys, err := store.List()
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	for _, k := range keys {
+		if k.Host == "203.0.113.7" && k.Fingerprint == ssh.FingerprintSHA256(key) {
+			return k.Status
+		}
+	}
+	return ""
+}
+
+func TestHostKeyStoreStrictRefusesUnknownKeyUntilApproved(t *testing.T) {
+	store := newTestHostKeyStore(t, HostKeyModeStrict)
+	key := newHostKey(t)
+
+	if err := store.HostKeyCallback(testHostname, testHostAddr, key); !errors.Is(err, ErrHostKeyUnknown) {
+		t.Fatalf("expected ErrHostKeyUnknown, got %v", err)
+	}
+	waitForActivity(t, "Refused unknown host key")
+	if status := hostKeyStatus(t, store, key); status != "pending" {
+		t.Errorf("expected the key to be pending, got %q", status)
+	}
+
+	if _, err := store.Approve(testHostname, ssh.FingerprintSHA256(newHostKey(t))); err == nil {
+		t.Error("approved a key with another fingerprint")
+	}
+	approved, err := store.Approve(testHostname, ssh.FingerprintSHA256(key))
+	if err != nil {
+		t.Fatalf("Approve: %v", err)
+	}
+	if approved.Status != "pinned" || hostKeyStatus(t, store, key) != "pinned" {
+		t.Errorf("approved key is not pinned: %+v", approved)
+	}
+	if err := store.HostKeyCallback(testHostname, testHostAddr, key); err != nil {
+		t.Errorf("approved key refused: %v", err)
+	}
+	if _, err := store.Approve(testHostname, ssh.FingerprintSHA256(key)); !errors.Is(err, ErrNoPendingKey) {
+		t.Errorf("expected ErrNoPendingKey, got %v", err)
+	}
+}
+
+func TestHostKeyStoreTOFUPinsFirstKey(t *testing.T) {
+	store := newTestHostKeyStore(t, HostKeyModeTOFU)
+	key := newHostKey(t)
+
+	if err := store.HostKeyCallback(testHostname, testHostAddr, key); err != nil {
+		t.Fatalf("first key refused: %v", err)
+	}
+	waitForActivity(t, "on first use")
+	if status := hostKeyStatus(t, store, key); status != "pinned" {
+		t.Errorf("expected the first key to be pinned, got %q", status)
+	}
+
+	// A new store reads the pinned key back from the file
+	reopened, err := NewHostKeyStore(store.path, HostKeyModeStrict)
+	if err != nil {
+		t.Fatalf("NewHostKeyStore: %v", err)
+	}
+	if err := reopened.HostKeyCallback(testHostname, testHostAddr, key); err != nil {
+		t.Errorf("pinned key refused after reopening: %v", err)
+	}
+}
+
+func TestHostKeyStoreBlocksChangedKey(t *testing.T) {
+	store := newTestHostKeyStore(t, HostKeyModeTOFU)
+	original, replacement := newHostKey(t), newHostKey(t)
+	if err := store.HostKeyCallback(testHostname, testHostAddr, original); err != nil {
+		t.Fatalf("first key refused: %v", err)
+	}
+	waitForActivity(t, "on first use")
+
+	if err := store.HostKeyCallback(testHostname, testHostAddr, replacement); !errors.Is(err, ErrHostKeyChanged) {
+		t.Fatalf("expected ErrHostKeyChanged, got %v", err)
+	}
+	waitForActivity(t, "changed (now")
+	if !store.IsBlocked(testHostname) {
+		t.Fatal("host with a changed key is not blocked")
+	}
+	if err := store.HostKeyCallback(testHostname, testHostAddr, original); !errors.Is(err, ErrHostKeyBlocked) {
+		t.Errorf("blocked host accepted its old key: %v", err)
+	}
+
+	if _, err := store.Approve(testHostname, ssh.FingerprintSHA256(replacement)); err != nil {
+		t.Fatalf("Approve: %v", err)
+	}
+	if store.IsBlocked(testHostname) {
+		t.Error("host still blocked after approval")
+	}
+	if err := store.HostKeyCallback(testHostname, testHostAddr, replacement); err != nil {
+		t.Errorf("approved key refused: %v", err)
+	}
+	if status := hostKeyStatus(t, store, original); status != "" {
+		t.Errorf("replaced key still listed as %q", status)
+	}
+}
+
+func TestHostKeyStoreRevoke(t *testing.T) {
+	store := newTestHostKeyStore(t, HostKeyModeTOFU)
+	key := newHostKey(t)
+	if err := store.HostKeyCallback(testHostname, testHostAddr, key); err != nil {
+		t.Fatalf("first key refused: %v", err)
+	}
+	waitForActivity(t, "on first use")
+
+	removed, err := store.Revoke(testHostname)
+	if err != nil {
+		t.Fatalf("Revoke: %v", err)
+	}
+	if removed != 1 {
+		t.Errorf("expected one key removed, got %d", removed)
+	}
+	keys, err := store.List()
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(keys) != 0 {
+		t.Errorf("keys left after revoking: %+v", keys)
+	}
+
+	strict, err := NewHostKeyStore(store.path, HostKeyModeStrict)
+	if err != nil {
+		t.Fatalf("NewHostKeyStore: %v", err)
+	}
+	if err := strict.HostKeyCallback(testHostname, testHostAddr, key); !errors.Is(err, ErrHostKeyUnknown) {
+		t.Errorf("revoked key still trusted: %v", err)
+	}
+	waitForActivity(t, "Refused unknown host key")
+}
+
+func TestHostKeyStoreRejectsMalformedFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "known_hosts")
+	if err := os.WriteFile(path, []byte("203.0.113.7 ssh-ed25519 not-base64!\n"), 0600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	if _, err := NewHostKeyStore(path, HostKeyModeStrict); err == nil {
+		t.Error("expected an error for a malformed known_hosts file")
+	}
+
+	// A store that never loaded a file refuses every key instead of panicking
+	var empty HostKeyStore
+	if err := empty.HostKeyCallback(testHostname, testHostAddr, newHostKey(t)); !errors.Is(err, ErrHostKeyUnknown) {
+		t.Errorf("expected ErrHostKeyUnknown, got %v", err)
+	}
+}
@@ -0,0 +1,428 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"wordpress-collab-tool/config"
+	"wordpress-collab-tool/models"
+	"wordpress-collab-tool/utils"
+)
+
+const (
+	hostsFilePath = "hosts.json"
+
+	// DefaultHostName is the host used by sites created before hosts were
+	// registered, backed by the SSH_* environment variables.
+	DefaultHostName = "default"
+
+	defaultHostCapacity = 10
+)
+
+var (
+	ErrHostNotFound   = errors.New("host not found")
+	ErrHostExists     = errors.New("host already exists")
+	ErrHostInUse      = errors.New("host still has sites")
+	ErrNoHostCapacity = errors.New("no host has free capacity")
+	ErrInvalidHost    = errors.New("invalid host")
+)
+
+var hostNamePattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+
+var hostsMux sync.Mutex
+
+// defaultHost is the host configured through the SSH_* environment
+// variables, or nil when there is none.
+var defaultHost *models.Host
+
+// InitHosts builds the default host from the SSH_* settings of the
+// configuration.
+func InitHosts(cfg *config.Config) {
+	if !cfg.HasDefaultHost() {
+		defaultHost = nil
+		return
+	}
+	ssh := cfg.SSH()
+	defaultHost = &models.Host{
+		Name:        DefaultHostName,
+		Address:     ssh.Host,
+		Port:        ssh.Port,
+		Credentials: ssh,
+		Capacity:    cfg.DefaultHostCapacity,
+	}
+}
+
+// ReadHosts reads the host registry from hosts.json.
+func ReadHosts() ([]models.Host, error) {
+	var hosts []models.Host
+	if _, err := os.Stat(hostsFilePath); os.IsNotExist(err) {
+		return []models.Host{}, nil
+	}
+
+	data, err := ioutil.ReadFile(hostsFilePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read hosts file: %w", err)
+	}
+
+	if len(data) == 0 {
+		return []models.Host{}, nil
+	}
+
+	if err := json.Unmarshal(data, &hosts); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal hosts data: %w", err)
+	}
+	return hosts, nil
+}
+
+// WriteHosts writes the host registry to hosts.json.
+func WriteHosts(hosts []models.Host) error {
+	data, err := json.MarshalIndent(hosts, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal hosts data: %w", err)
+	}
+
+	// The file holds SSH credentials, so it is only readable by the owner
+	if err := writeFileAtomic(hostsFilePath, data, 0600); err != nil {
+		return fmt.Errorf("failed to write hosts file: %w", err)
+	}
+	return nil
+}
+
+// ListHosts returns every registered host, including the default host from
+// the environment when it is configured and not overridden in the registry.
+func ListHosts() ([]models.Host, error) {
+	hosts, err := ReadHosts()
+	if err != nil {
+		return nil, err
+	}
+
+	if defaultHost, ok := environmentHost(); ok {
+		overridden := false
+		for _, h := range hosts {
+			if h.Name == DefaultHostName {
+				overridden = true
+				break
+			}
+		}
+		if !overridden {
+			hosts = append([]models.Host{defaultHost}, hosts...)
+		}
+	}
+	return hosts, nil
+}
+
+// GetHost looks up a host by name. An empty name refers to the default host.
+func GetHost(name string) (models.Host, error) {
+	if name == "" {
+		name = DefaultHostName
+	}
+
+	hosts, err := ListHosts()
+	if err != nil {
+		return models.Host{}, err
+	}
+	for _, h := range hosts {
+		if h.Name == name {
+			return h, nil
+		}
+	}
+	return models.Host{}, fmt.Errorf("%w: %s", ErrHostNotFound, name)
+}
+
+// HostForSite returns the host a site is deployed on.
+func HostForSite(site models.Site) (models.Host, error) {
+	return GetHost(site.Host)
+}
+
+// CreateHost validates and registers a new host.
+func CreateHost(host models.Host) (models.Host, error) {
+	host = normalizeHost(host)
+	if err := validateHost(host); err != nil {
+		return models.Host{}, fmt.Errorf("%w: %v", ErrInvalidHost, err)
+	}
+
+	hostsMux.Lock()
+	defer hostsMux.Unlock()
+
+	existing, err := ListHosts()
+	if err != nil {
+		return models.Host{}, err
+	}
+	for _, h := range existing {
+		if h.Name == host.Name {
+			return models.Host{}, fmt.Errorf("%w: %s", ErrHostExists, host.Name)
+		}
+	}
+
+	hosts, err := ReadHosts()
+	if err != nil {
+		return models.Host{}, err
+	}
+	hosts = append(hosts, host)
+	if err := WriteHosts(hosts); err != nil {
+		return models.Host{}, err
+	}
+	return host, nil
+}
+
+// UpdateHost replaces the registry entry for name. Updating the default host
+// stores an override in the registry.
+func UpdateHost(name string, host models.Host) (models.Host, error) {
+	hostsMux.Lock()
+	defer hostsMux.Unlock()
+
+	current, err := GetHost(name)
+	if err != nil {
+		return models.Host{}, err
+	}
+
+	// Secrets are never returned by the API, so keep the stored ones when an
+	// update leaves them blank.
+	if host.Credentials.AuthMethod == "" || host.Credentials.AuthMethod == current.Credentials.AuthMethod {
+		if host.Credentials.Password == "" {
+			host.Credentials.Password = current.Credentials.Password
+		}
+		if host.Credentials.Passphrase == "" {
+			host.Credentials.Passphrase = current.Credentials.Passphrase
+		}
+		if host.Credentials.AuthMethod == "" {
+			host.Credentials.AuthMethod = current.Credentials.AuthMethod
+		}
+	}
+
+	host.Name = name
+	host = normalizeHost(host)
+	if err := validateHost(host); err != nil {
+		return models.Host{}, fmt.Errorf("%w: %v", ErrInvalidHost, err)
+	}
+
+	hosts, err := ReadHosts()
+	if err != nil {
+		return models.Host{}, err
+	}
+	replaced := false
+	for i := range hosts {
+		if hosts[i].Name == name {
+			hosts[i] = host
+			replaced = true
+			break
+		}
+	}
+	if !replaced {
+		hosts = append(hosts, host)
+	}
+	if err := WriteHosts(hosts); err != nil {
+		return models.Host{}, err
+	}
+	evictHost(current)
+	return host, nil
+}
+
+// DeleteHost removes a host from the registry. Hosts that still run sites
+// cannot be removed.
+func DeleteHost(name string) error {
+	hostsMux.Lock()
+	defer hostsMux.Unlock()
+
+	sites, err := Sites.List()
+	if err != nil {
+		return err
+	}
+	for _, site := range sites {
+		if siteHostName(site) == name {
+			return fmt.Errorf("%w: %s", ErrHostInUse, name)
+		}
+	}
+
+	hosts, err := ReadHosts()
+	if err != nil {
+		return err
+	}
+	updated := hosts[:0]
+	var removed *models.Host
+	for _, h := range hosts {
+		if h.Name == name {
+			removed = &h
+			continue
+		}
+		updated = append(updated, h)
+	}
+	if removed == nil {
+		return fmt.Errorf("%w: %s", ErrHostNotFound, name)
+	}
+	if err := WriteHosts(updated); err != nil {
+		return err
+	}
+	evictHost(*removed)
+	return nil
+}
+
+// evictHost closes the pooled connections made with the previous settings
+// of host.
+func evictHost(host models.Host) {
+	if Pool != nil {
+		Pool.Evict(host.SSH())
+	}
+}
+
+// CountSitesByHost returns the number of sites placed on each host.
+func CountSitesByHost(sites []models.Site) map[string]int {
+	counts := make(map[string]int)
+	for _, site := range sites {
+		counts[siteHostName(site)]++
+	}
+	return counts
+}
+
+// PickHost chooses the host for a new site. When name is set that host is
+// used as long as it has capacity; otherwise the least-loaded host (lowest
+// sites-to-capacity ratio) is chosen.
+func PickHost(name string, sites []models.Site) (models.Host, error) {
+	counts := CountSitesByHost(sites)
+
+	if name != "" {
+		host, err := GetHost(name)
+		if err != nil {
+			return models.Host{}, err
+		}
+		if counts[host.Name] >= host.Capacity {
+			return models.Host{}, fmt.Errorf("%w: %s is full (%d/%d sites)", ErrNoHostCapacity, host.Name, counts[host.Name], host.Capacity)
+		}
+		return host, nil
+	}
+
+	hosts, err := ListHosts()
+	if err != nil {
+		return models.Host{}, err
+	}
+
+	var candidates []models.Host
+	for _, h := range hosts {
+		if counts[h.Name] < h.Capacity {
+			candidates = append(candidates, h)
+		}
+	}
+	if len(candidates) == 0 {
+		return models.Host{}, ErrNoHostCapacity
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		loadA := float64(counts[a.Name]) / float64(a.Capacity)
+		loadB := float64(counts[b.Name]) / float64(b.Capacity)
+		if loadA != loadB {
+			return loadA < loadB
+		}
+		return a.Name < b.Name
+	})
+	return candidates[0], nil
+}
+
+// siteHostName returns the host name of a site, mapping legacy sites to the default host.
+func siteHostName(site models.Site) string {
+	if site.Host == "" {
+		return DefaultHostName
+	}
+	return site.Host
+}
+
+// environmentHost returns the default host built by InitHosts.
+func environmentHost() (models.Host, bool) {
+	if defaultHost == nil {
+		return models.Host{}, false
+	}
+	return *defaultHost, true
+}
+
+func normalizeHost(host models.Host) models.Host {
+	if host.Port == 0 {
+		host.Port = 22
+	}
+	if host.Capacity == 0 {
+		host.Capacity = defaultHostCapacity
+	}
+	if host.Credentials.AuthMethod == "" {
+		host.Credentials.AuthMethod = models.SSHAuthPassword
+	}
+	host.Credentials.Host = ""
+	host.Credentials.Port = 0
+	return host
+}
+
+func validateHost(host models.Host) error {
+	if !hostNamePattern.MatchString(host.Name) {
+		return fmt.Errorf("invalid host name %q: use lowercase letters, digits and hyphens", host.Name)
+	}
+	if host.Address == "" {
+		return fmt.Errorf("host address is required")
+	}
+	if host.Port < 1 || host.Port > 65535 {
+		return fmt.Errorf("invalid port %d", host.Port)
+	}
+	if host.Capacity < 0 {
+		return fmt.Errorf("capacity must not be negative")
+	}
+	if host.Credentials.User == "" {
+		return fmt.Errorf("credentials.user is required")
+	}
+
+	c := host.Credentials
+	switch c.AuthMethod {
+	case models.SSHAuthPassword:
+		if c.Password == "" {
+			return fmt.Errorf("credentials.password is required for password authentication")
+		}
+	case models.SSHAuthKey:
+		if c.PrivateKeyPath == "" {
+			return fmt.Errorf("credentials.privateKeyPath is required for key authentication")
+		}
+	case models.SSHAuthCertificate:
+		if c.PrivateKeyPath == "" || c.CertificatePath == "" {
+			return fmt.Errorf("credentials.privateKeyPath and credentials.certificatePath are required for certificate authentication")
+		}
+	case models.SSHAuthAgent:
+	default:
+		return fmt.Errorf("unknown auth method %q", c.AuthMethod)
+	}
+	return nil
+}
+
+// GetHostStats returns the CPU and RAM usage percentages of a host.
+func GetHostStats(ctx context.Context, host models.Host) (string, string, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeouts.Command)
+	defer cancel()
+
+	executor, err := NewExecutor(ctx, host)
+	if err != nil {
+		return "", "", fmt.Errorf("%w: %w", ErrConnect, err)
+	}
+	defer executor.Close()
+
+	// Get CPU usage
+	cpuCmd := Pipe(Cmd("top", "-bn1"), Cmd("grep", "%Cpu(s)"), Cmd("awk", "{print $2 + $4}"))
+	stdout, stderr, err := executor.Run(ctx, cpuCmd)
+	if err != nil {
+		utils.LogError("SSH command failed for CPU: %v, stdout: %s, stderr: %s", err, stdout, stderr)
+		return "", "", fmt.Errorf("%w: failed to get CPU stats: %w", ErrRemoteCommand, err)
+	}
+	cpuUsage := strings.TrimSpace(stdout)
+
+	// Get RAM usage
+	ramCmd := Pipe(Cmd("free", "-m"), Cmd("grep", "Mem"), Cmd("awk", "{print $3/$2 * 100.0}"))
+	stdout, stderr, err = executor.Run(ctx, ramCmd)
+	if err != nil {
+		utils.LogError("SSH command failed for RAM: %v, stdout: %s, stderr: %s", err, stdout, stderr)
+		return "", "", fmt.Errorf("%w: failed to get RAM stats: %w", ErrRemoteCommand, err)
+	}
+	ramUsage := strings.TrimSpace(stdout)
+
+	return cpuUsage, ramUsage, nil
+}
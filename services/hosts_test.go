@@ -0,0 +1,233 @@
+package services
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"wordpress-collab-tool/models"
+)
+
+// withHosts starts the test with an empty host registry.
+func withHosts(t *testing.T) {
+	t.Helper()
+	os.Remove(hostsFilePath)
+	t.Cleanup(func() { os.Remove(hostsFilePath) })
+}
+
+func testHost(name string) models.Host {
+	return models.Host{
+		Name:        name,
+		Address:     "203.0.113.10",
+		Credentials: models.SSHConfig{User: "deploy", AuthMethod: models.SSHAuthPassword, Password: "secret"},
+		Capacity:    2,
+	}
+}
+
+func TestCreateHost(t *testing.T) {
+	withHosts(t)
+
+	created, err := CreateHost(testHost("web-1"))
+	if err != nil {
+		t.Fatalf("CreateHost: %v", err)
+	}
+	if created.Port != 22 || created.Credentials.AuthMethod != models.SSHAuthPassword {
+		t.Errorf("defaults not applied: %+v", created)
+	}
+	if _, err := CreateHost(testHost("web-1")); !errors.Is(err, ErrHostExists) {
+		t.Errorf("expected ErrHostExists, got %v", err)
+	}
+	if _, err := CreateHost(testHost(DefaultHostName)); !errors.Is(err, ErrHostExists) {
+		t.Errorf("default host: expected ErrHostExists, got %v", err)
+	}
+	invalid := testHost("Web 1")
+	if _, err := CreateHost(invalid); !errors.Is(err, ErrInvalidHost) {
+		t.Errorf("expected ErrInvalidHost, got %v", err)
+	}
+
+	info, err := os.Stat(hostsFilePath)
+	if err != nil {
+		t.Fatalf("Stat: %v", err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Errorf("hosts file holds credentials but has mode %o", perm)
+	}
+
+	hosts, err := ListHosts()
+	if err != nil {
+		t.Fatalf("ListHosts: %v", err)
+	}
+	if len(hosts) != 2 || hosts[0].Name != DefaultHostName || hosts[1].Name != "web-1" {
+		t.Errorf("expected the default host and web-1, got %+v", hosts)
+	}
+}
+
+func TestUpdateHostKeepsSecrets(t *testing.T) {
+	withHosts(t)
+	if _, err := CreateHost(testHost("web-1")); err != nil {
+		t.Fatalf("CreateHost: %v", err)
+	}
+
+	update := testHost("ignored")
+	update.Capacity = 5
+	update.Credentials.Password = ""
+	updated, err := UpdateHost("web-1", update)
+	if err != nil {
+		t.Fatalf("UpdateHost: %v", err)
+	}
+	stored, err := GetHost("web-1")
+	if err != nil {
+		t.Fatalf("GetHost: %v", err)
+	}
+	if updated.Name != "web-1" || stored.Capacity != 5 || stored.Credentials.Password != "secret" {
+		t.Errorf("unexpected host after update: %+v", stored)
+	}
+
+	if _, err := UpdateHost("ghost", testHost("ghost")); !errors.Is(err, ErrHostNotFound) {
+		t.Errorf("expected ErrHostNotFound, got %v", err)
+	}
+
+	// Updating the default host stores an override in the registry
+	override := testHost(DefaultHostName)
+	override.Address = "203.0.113.20"
+	if _, err := UpdateHost(DefaultHostName, override); err != nil {
+		t.Fatalf("UpdateHost: %v", err)
+	}
+	hosts, err := ListHosts()
+	if err != nil {
+		t.Fatalf("ListHosts: %v", err)
+	}
+	defaults := 0
+	for _, h := range hosts {
+		if h.Name == DefaultHostName {
+			defaults++
+			if h.Address != "203.0.113.20" {
+				t.Errorf("default host not overridden: %+v", h)
+			}
+		}
+	}
+	if defaults != 1 {
+		t.Errorf("expected one default host, got %d in %+v", defaults, hosts)
+	}
+}
+
+func TestDeleteHost(t *testing.T) {
+	withHosts(t)
+	site := testSite()
+	site.Host = "web-1"
+	withSites(t, site)
+	if _, err := CreateHost(testHost("web-1")); err != nil {
+		t.Fatalf("CreateHost: %v", err)
+	}
+
+	if err := DeleteHost("web-1"); !errors.Is(err, ErrHostInUse) {
+		t.Fatalf("expected ErrHostInUse, got %v", err)
+	}
+	if err := Sites.Delete(site.ProjectName); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if err := DeleteHost("web-1"); err != nil {
+		t.Fatalf("DeleteHost: %v", err)
+	}
+	if _, err := GetHost("web-1"); !errors.Is(err, ErrHostNotFound) {
+		t.Errorf("expected ErrHostNotFound after delete, got %v", err)
+	}
+	if err := DeleteHost("web-1"); !errors.Is(err, ErrHostNotFound) {
+		t.Errorf("expected ErrHostNotFound, got %v", err)
+	}
+}
+
+func TestPickHost(t *testing.T) {
+	withHosts(t)
+	small := testHost("small")
+	small.Capacity = 1
+	large := testHost("large")
+	large.Capacity = 4
+	for _, host := range []models.Host{small, large} {
+		if _, err := CreateHost(host); err != nil {
+			t.Fatalf("CreateHost: %v", err)
+		}
+	}
+	sites := []models.Site{{ProjectName: "a", Host: "small"}, {ProjectName: "b", Host: "large"}}
+	for i := range 10 {
+		sites = append(sites, models.Site{ProjectName: string(rune('c' + i))})
+	}
+
+	// The default host is full and small is at capacity
+	host, err := PickHost("", sites)
+	if err != nil {
+		t.Fatalf("PickHost: %v", err)
+	}
+	if host.Name != "large" {
+		t.Errorf("expected the least-loaded host, got %q", host.Name)
+	}
+	if _, err := PickHost("small", sites); !errors.Is(err, ErrNoHostCapacity) {
+		t.Errorf("expected ErrNoHostCapacity for a full host, got %v", err)
+	}
+	for range 3 {
+		sites = append(sites, models.Site{ProjectName: "x", Host: "large"})
+	}
+	if _, err := PickHost("", sites); !errors.Is(err, ErrNoHostCapacity) {
+		t.Errorf("expected ErrNoHostCapacity when every host is full, got %v", err)
+	}
+}
+
+func TestUpdateAndDeleteHostEvictPooledConnections(t *testing.T) {
+	withHosts(t)
+	withSites(t)
+	server := startSSHServer(t)
+	pool := testPool(t, 2)
+	original := Pool
+	Pool = pool
+	t.Cleanup(func() { Pool = original })
+
+	host := testHost("web-1")
+	host.Address = server.SSHConfig().Host
+	host.Port = server.SSHConfig().Port
+	if _, err := CreateHost(host); err != nil {
+		t.Fatalf("CreateHost: %v", err)
+	}
+	host, _ = GetHost("web-1")
+
+	idle, err := pool.Get(t.Context(), host.SSH())
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	held, err := pool.Get(t.Context(), host.SSH())
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	idle.Close()
+
+	update := host
+	update.Credentials.Password = "rotated"
+	if _, err := UpdateHost("web-1", update); err != nil {
+		t.Fatalf("UpdateHost: %v", err)
+	}
+	if isAlive(idle.Client) {
+		t.Error("idle connection with the old credentials was kept open")
+	}
+	held.Close()
+	if isAlive(held.Client) {
+		t.Error("connection checked out during the update was returned to the pool")
+	}
+	if stats := pool.Stats(); len(stats) != 0 {
+		t.Errorf("evicted host still pooled: %+v", stats)
+	}
+
+	fresh, err := pool.Get(t.Context(), host.SSH())
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if fresh.Client == idle.Client || fresh.Client == held.Client {
+		t.Error("checkout after the update reused an old connection")
+	}
+	fresh.Close()
+
+	if err := DeleteHost("web-1"); err != nil {
+		t.Fatalf("DeleteHost: %v", err)
+	}
+	if isAlive(fresh.Client) || len(pool.Stats()) != 0 {
+		t.Error("connections to a deleted host were kept open")
+	}
+}
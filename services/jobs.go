@@ -0,0 +1,395 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"wordpress-collab-tool/config"
+	"wordpress-collab-tool/models"
+	"wordpress-collab-tool/utils"
+)
+
+var (
+	jobsFilePath = "jobs.json"
+	// maxFinishedJobs and jobRetention bound how many finished jobs are
+	// kept in the jobs file, and for how long.
+	maxFinishedJobs = 500
+	jobRetention    = 30 * 24 * time.Hour
+)
+
+// Errors returned by the job functions.
+var (
+	ErrJobNotFound = errors.New("job not found")
+	ErrJobFinished = errors.New("job has already finished")
+)
+
+// interruptedJobError is recorded on jobs that were still running when the server stopped.
+const interruptedJobError = "Interrupted by a server restart."
+
+// InitJobs applies the configured jobs file and retention.
+func InitJobs(cfg *config.Config) {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	jobsFilePath = cfg.JobsFile
+	maxFinishedJobs = cfg.JobsMaxFinished
+	jobRetention = cfg.JobsRetention
+}
+
+var (
+	// jobsMu guards the jobs file and runningJobs.
+	jobsMu      sync.Mutex
+	runningJobs = make(map[string]context.CancelFunc)
+)
+
+// JobFilter selects jobs in ListJobs. Empty fields match every job.
+type JobFilter struct {
+	ProjectName string
+	Type        string
+	State       string
+}
+
+// ReadJobs reads every job from the jobs file.
+func ReadJobs() ([]models.Job, error) {
+	data, err := os.ReadFile(jobsFilePath)
+	if os.IsNotExist(err) {
+		return []models.Job{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read jobs file: %w", err)
+	}
+	if len(data) == 0 {
+		return []models.Job{}, nil
+	}
+
+	var jobs []models.Job
+	if err := json.Unmarshal(data, &jobs); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal jobs data: %w", err)
+	}
+	return jobs, nil
+}
+
+// writeJobs saves jobs to the jobs file, dropping finished jobs older than
+// jobRetention and the oldest ones beyond maxFinishedJobs.
+func writeJobs(jobs []models.Job) error {
+	cutoff := time.Now().Add(-jobRetention)
+	finished := 0
+	for i := len(jobs) - 1; i >= 0; i-- {
+		if !jobs[i].Finished() {
+			continue
+		}
+		finished++
+		expired := jobRetention > 0 && jobs[i].FinishedAt != nil && jobs[i].FinishedAt.Before(cutoff)
+		if expired || finished > maxFinishedJobs {
+			jobs = append(jobs[:i], jobs[i+1:]...)
+			finished--
+		}
+	}
+
+	data, err := json.MarshalIndent(jobs, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal jobs data: %w", err)
+	}
+	// Replace the file in one step so that a crash mid-write, the very case
+	// RecoverInterruptedJobs handles, cannot leave it truncated
+	if err := writeFileAtomic(jobsFilePath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write jobs file: %w", err)
+	}
+	return nil
+}
+
+// ListJobs returns the jobs matching filter, newest first.
+func ListJobs(filter JobFilter) ([]models.Job, error) {
+	jobsMu.Lock()
+	jobs, err := ReadJobs()
+	jobsMu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	matched := []models.Job{}
+	for _, job := range jobs {
+		if (filter.ProjectName == "" || job.ProjectName == filter.ProjectName) &&
+			(filter.Type == "" || job.Type == filter.Type) &&
+			(filter.State == "" || job.State == filter.State) {
+			matched = append(matched, job)
+		}
+	}
+	sort.SliceStable(matched, func(i, j int) bool { return matched[i].CreatedAt.After(matched[j].CreatedAt) })
+	return matched, nil
+}
+
+// GetJob looks up a job by id.
+func GetJob(id string) (models.Job, error) {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	jobs, err := ReadJobs()
+	if err != nil {
+		return models.Job{}, err
+	}
+	for _, job := range jobs {
+		if job.ID == id {
+			return job, nil
+		}
+	}
+	return models.Job{}, fmt.Errorf("%w: '%s'", ErrJobNotFound, id)
+}
+
+// StartJob records a new job of jobType on a site and runs it in the
+// background while holding the site's lock. The job's context keeps the
+// values of ctx, such as its audit information, but not its cancellation. If the site is busy, StartJob
+// returns a *SiteLockedError, unless queue is set, in which case the job stays
+// queued until the lock is free. run receives a context that is cancelled by
+// CancelJob; the job ends as cancelled if run returns after a cancellation,
+// failed if it returns any other error and succeeded otherwise.
+func StartJob(ctx context.Context, jobType, projectName string, queue bool, run func(ctx context.Context) error) (models.Job, error) {
+	job := models.Job{
+		ID:          newJobID(),
+		Type:        jobType,
+		ProjectName: projectName,
+		State:       models.JobQueued,
+		Steps:       []models.JobStep{},
+		CreatedBy:   AuditFrom(ctx).Actor,
+		CreatedAt:   time.Now(),
+	}
+	ctx, cancel := context.WithCancel(context.WithValue(context.WithoutCancel(ctx), jobKey{}, job.ID))
+
+	var unlock func()
+	if !queue {
+		var err error
+		if unlock, err = TryLockSite(projectName, jobType, job.ID); err != nil {
+			cancel()
+			return models.Job{}, err
+		}
+	}
+
+	jobsMu.Lock()
+	jobs, err := ReadJobs()
+	if err == nil {
+		err = writeJobs(append(jobs, job))
+	}
+	if err != nil {
+		jobsMu.Unlock()
+		cancel()
+		if unlock != nil {
+			unlock()
+		}
+		return models.Job{}, err
+	}
+	runningJobs[job.ID] = cancel
+	jobsMu.Unlock()
+
+	go func() {
+		defer cancel()
+		if unlock == nil {
+			var err error
+			if unlock, err = LockSite(ctx, projectName, jobType, job.ID); err != nil {
+				jobsMu.Lock()
+				delete(runningJobs, job.ID)
+				jobsMu.Unlock()
+				finishJob(ctx, job.ID, fmt.Errorf("cancelled while waiting for the site lock: %w", err))
+				return
+			}
+		}
+		defer unlock()
+
+		updateJob(job.ID, func(j *models.Job) {
+			now := time.Now()
+			j.State = models.JobRunning
+			j.StartedAt = &now
+		})
+
+		err := run(ctx)
+
+		jobsMu.Lock()
+		delete(runningJobs, job.ID)
+		jobsMu.Unlock()
+		finishJob(ctx, job.ID, err)
+	}()
+	return job, nil
+}
+
+// CancelJob cancels a queued or running job.
+func CancelJob(id string) (models.Job, error) {
+	job, err := GetJob(id)
+	if err != nil {
+		return models.Job{}, err
+	}
+
+	jobsMu.Lock()
+	cancel, ok := runningJobs[id]
+	jobsMu.Unlock()
+	if !ok || job.Finished() {
+		return job, fmt.Errorf("%w: '%s' is %s", ErrJobFinished, id, job.State)
+	}
+	cancel()
+	return job, nil
+}
+
+// CancelSiteJobs cancels every queued or running job on a site and returns them.
+func CancelSiteJobs(projectName string) ([]models.Job, error) {
+	jobs, err := ListJobs(JobFilter{ProjectName: projectName})
+	if err != nil {
+		return nil, err
+	}
+	cancelled := []models.Job{}
+	for _, job := range jobs {
+		if job.Finished() {
+			continue
+		}
+		if _, err := CancelJob(job.ID); err == nil {
+			cancelled = append(cancelled, job)
+		}
+	}
+	return cancelled, nil
+}
+
+// RecoverInterruptedJobs marks jobs left queued or running by a previous run
+// of the server as failed and returns them. Sites whose deployment or
+// clone was interrupted are marked as failed too, instead of staying
+// "creating".
+func RecoverInterruptedJobs() ([]models.Job, error) {
+	jobsMu.Lock()
+	jobs, err := ReadJobs()
+	if err != nil {
+		jobsMu.Unlock()
+		return nil, err
+	}
+
+	var interrupted []models.Job
+	now := time.Now()
+	for i := range jobs {
+		if jobs[i].Finished() {
+			continue
+		}
+		if _, running := runningJobs[jobs[i].ID]; running {
+			continue
+		}
+		jobs[i].State = models.JobFailed
+		jobs[i].Error = interruptedJobError
+		jobs[i].FinishedAt = &now
+		finishSteps(&jobs[i], models.JobFailed, now)
+		interrupted = append(interrupted, jobs[i])
+	}
+	if len(interrupted) > 0 {
+		err = writeJobs(jobs)
+	}
+	jobsMu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, job := range interrupted {
+		utils.LogError("Job %s (%s on site '%s') was interrupted by a restart", job.ID, job.Type, job.ProjectName)
+		if job.Type == "deploy" || job.Type == "clone" {
+			UpdateSiteStatus(job.ProjectName, "failed")
+		}
+		RecordActivity(context.Background(), models.Activity{
+			Level:       "error",
+			Action:      "job.interrupt",
+			Message:     fmt.Sprintf("The %s of site '%s' was interrupted by a server restart.", job.Type, job.ProjectName),
+			ProjectName: job.ProjectName,
+		})
+	}
+	return interrupted, nil
+}
+
+type jobKey struct{}
+
+// JobStep records that the job running under ctx has moved on to a new step.
+// It does nothing when ctx does not belong to a job.
+func JobStep(ctx context.Context, name string) {
+	id, ok := ctx.Value(jobKey{}).(string)
+	if !ok {
+		return
+	}
+	updateJob(id, func(j *models.Job) {
+		now := time.Now()
+		finishSteps(j, models.JobSucceeded, now)
+		j.Steps = append(j.Steps, models.JobStep{Name: name, State: models.JobRunning, StartedAt: now})
+	})
+}
+
+// currentJobID returns the id of the job running under ctx, or "".
+func currentJobID(ctx context.Context) string {
+	id, _ := ctx.Value(jobKey{}).(string)
+	return id
+}
+
+// FailJobStep records that the current step of the job running under ctx
+// failed, before the job moves on to steps that clean up after it.
+func FailJobStep(ctx context.Context) {
+	id, ok := ctx.Value(jobKey{}).(string)
+	if !ok {
+		return
+	}
+	updateJob(id, func(j *models.Job) {
+		finishSteps(j, models.JobFailed, time.Now())
+	})
+}
+
+// finishJob records the outcome of a job.
+func finishJob(ctx context.Context, id string, err error) {
+	updateJob(id, func(j *models.Job) {
+		now := time.Now()
+		switch {
+		case err == nil:
+			j.State = models.JobSucceeded
+		case ctx.Err() == context.Canceled:
+			j.State = models.JobCancelled
+			j.Error = err.Error()
+		default:
+			j.State = models.JobFailed
+			j.Error = err.Error()
+		}
+		j.FinishedAt = &now
+		finishSteps(j, j.State, now)
+	})
+}
+
+// finishSteps closes the step still running, if any, with state.
+func finishSteps(j *models.Job, state string, at time.Time) {
+	for i := range j.Steps {
+		if j.Steps[i].FinishedAt == nil {
+			j.Steps[i].State = state
+			j.Steps[i].FinishedAt = &at
+		}
+	}
+}
+
+// updateJob applies change to a stored job.
+func updateJob(id string, change func(*models.Job)) {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+
+	jobs, err := ReadJobs()
+	if err != nil {
+		utils.LogError("Failed to read jobs for update: %v", err)
+		return
+	}
+	for i := range jobs {
+		if jobs[i].ID == id {
+			change(&jobs[i])
+			if err := writeJobs(jobs); err != nil {
+				utils.LogError("Failed to write jobs after update: %v", err)
+			}
+			return
+		}
+	}
+}
+
+// newJobID returns a random job identifier.
+func newJobID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
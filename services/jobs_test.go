@@ -0,0 +1,182 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"wordpress-collab-tool/models"
+)
+
+// withJobs replaces jobs.json with jobs for the duration of the test.
+func withJobs(t *testing.T, jobs ...models.Job) {
+	t.Helper()
+	if err := writeJobs(jobs); err != nil {
+		t.Fatalf("writeJobs: %v", err)
+	}
+	t.Cleanup(func() { os.Remove(jobsFilePath) })
+}
+
+// waitForJob polls until the job has finished.
+func waitForJob(t *testing.T, id string) models.Job {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		job, err := GetJob(id)
+		if err != nil {
+			t.Fatalf("GetJob: %v", err)
+		}
+		if job.Finished() {
+			return job
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("job %s did not finish, state %s", id, job.State)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestStartJobRecordsSteps(t *testing.T) {
+	withJobs(t)
+
+	job, err := StartJob(t.Context(), "backup", "blog", false, func(ctx context.Context) error {
+		JobStep(ctx, "Dump database")
+		JobStep(ctx, "Archive wp-content")
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("StartJob: %v", err)
+	}
+	if job.State != models.JobQueued {
+		t.Errorf("new job state = %s, want queued", job.State)
+	}
+
+	job = waitForJob(t, job.ID)
+	if job.State != models.JobSucceeded || job.StartedAt == nil || job.FinishedAt == nil {
+		t.Fatalf("unexpected job: %+v", job)
+	}
+	if len(job.Steps) != 2 || job.Steps[0].Name != "Dump database" || job.Steps[1].State != models.JobSucceeded {
+		t.Errorf("unexpected steps: %+v", job.Steps)
+	}
+}
+
+func TestStartJobRecordsFailure(t *testing.T) {
+	withJobs(t)
+
+	job, _ := StartJob(t.Context(), "restore", "blog", false, func(ctx context.Context) error {
+		JobStep(ctx, "Extract backup")
+		return errors.New("tar: invalid archive")
+	})
+
+	job = waitForJob(t, job.ID)
+	if job.State != models.JobFailed || job.Error != "tar: invalid archive" {
+		t.Fatalf("unexpected job: %+v", job)
+	}
+	if job.Steps[0].State != models.JobFailed {
+		t.Errorf("failing step state = %s, want failed", job.Steps[0].State)
+	}
+}
+
+func TestCancelJob(t *testing.T) {
+	withJobs(t)
+
+	started := make(chan struct{})
+	job, _ := StartJob(t.Context(), "deploy", "blog", false, func(ctx context.Context) error {
+		close(started)
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	<-started
+
+	if _, err := CancelJob(job.ID); err != nil {
+		t.Fatalf("CancelJob: %v", err)
+	}
+	job = waitForJob(t, job.ID)
+	if job.State != models.JobCancelled {
+		t.Fatalf("state = %s, want cancelled", job.State)
+	}
+	if _, err := CancelJob(job.ID); !errors.Is(err, ErrJobFinished) {
+		t.Errorf("cancelling a finished job: expected ErrJobFinished, got %v", err)
+	}
+	if _, err := CancelJob("missing"); !errors.Is(err, ErrJobNotFound) {
+		t.Errorf("expected ErrJobNotFound, got %v", err)
+	}
+}
+
+func TestRecoverInterruptedJobs(t *testing.T) {
+	site := testSite()
+	site.Status = "creating"
+	withSites(t, site)
+	withJobs(t,
+		models.Job{ID: "done", Type: "backup", ProjectName: "blog", State: models.JobSucceeded},
+		models.Job{ID: "cut-short", Type: "deploy", ProjectName: "blog", State: models.JobRunning,
+			Steps: []models.JobStep{{Name: "Start containers", State: models.JobRunning}}},
+	)
+
+	interrupted, err := RecoverInterruptedJobs()
+	if err != nil {
+		t.Fatalf("RecoverInterruptedJobs: %v", err)
+	}
+	if len(interrupted) != 1 || interrupted[0].ID != "cut-short" {
+		t.Fatalf("unexpected interrupted jobs: %+v", interrupted)
+	}
+
+	job, _ := GetJob("cut-short")
+	if job.State != models.JobFailed || job.Error != interruptedJobError || job.Steps[0].State != models.JobFailed {
+		t.Errorf("interrupted job not marked failed: %+v", job)
+	}
+	if got, _ := GetSite("blog"); got.Status != "failed" {
+		t.Errorf("site status = %s, want failed", got.Status)
+	}
+}
+
+func TestRecoverInterruptedCloneJobs(t *testing.T) {
+	source := testSite()
+	source.Status = "active"
+	clone := testClone()
+	clone.Status = "creating"
+	withSites(t, source, clone)
+	withJobs(t, models.Job{ID: "copying", Type: "clone", ProjectName: "copy", State: models.JobRunning,
+		Steps: []models.JobStep{{Name: "Copy database", State: models.JobRunning}}})
+
+	if _, err := RecoverInterruptedJobs(); err != nil {
+		t.Fatalf("RecoverInterruptedJobs: %v", err)
+	}
+	if got, _ := GetSite("copy"); got.Status != "failed" {
+		t.Errorf("clone status = %s, want failed", got.Status)
+	}
+	if got, _ := GetSite("blog"); got.Status != "active" {
+		t.Errorf("source status = %s, want active", got.Status)
+	}
+}
+
+func TestWriteJobsPrunesFinishedJobs(t *testing.T) {
+	originalMax, originalRetention := maxFinishedJobs, jobRetention
+	maxFinishedJobs, jobRetention = 2, time.Hour
+	t.Cleanup(func() { maxFinishedJobs, jobRetention = originalMax, originalRetention })
+
+	old := time.Now().Add(-2 * time.Hour)
+	recent := time.Now()
+	withJobs(t,
+		models.Job{ID: "expired", State: models.JobSucceeded, FinishedAt: &old},
+		models.Job{ID: "oldest", State: models.JobFailed, FinishedAt: &recent},
+		models.Job{ID: "running", State: models.JobRunning},
+		models.Job{ID: "older", State: models.JobSucceeded, FinishedAt: &recent},
+		models.Job{ID: "newest", State: models.JobCancelled, FinishedAt: &recent},
+	)
+
+	jobs, err := ReadJobs()
+	if err != nil {
+		t.Fatalf("ReadJobs: %v", err)
+	}
+	var ids []string
+	for _, job := range jobs {
+		ids = append(ids, job.ID)
+	}
+	if strings.Join(ids, ",") != "running,older,newest" {
+		t.Errorf("kept jobs %v, want running, older and newest", ids)
+	}
+}
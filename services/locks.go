@@ -0,0 +1,150 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"wordpress-collab-tool/config"
+	"wordpress-collab-tool/models"
+)
+
+// ErrSiteLocked is returned when a mutating operation is refused because
+// another one holds the site's lock. The error is a *SiteLockedError.
+var ErrSiteLocked = errors.New("site is locked")
+
+// SiteLockedError reports the operation holding a site's lock.
+type SiteLockedError struct {
+	ProjectName string
+	Holder      models.SiteLock
+}
+
+func (e *SiteLockedError) Error() string {
+	return fmt.Sprintf("%v: '%s' is running %s since %s", ErrSiteLocked, e.ProjectName, e.Holder.Operation, e.Holder.Since.Format(time.RFC3339))
+}
+
+func (e *SiteLockedError) Unwrap() error {
+	return ErrSiteLocked
+}
+
+// queueSiteOperations makes busy sites queue operations instead of rejecting
+// them when a request does not say otherwise.
+var queueSiteOperations = false
+
+// InitSiteLocks applies the configured lock mode.
+func InitSiteLocks(cfg *config.Config) {
+	queueSiteOperations = cfg.SiteLockMode == "queue"
+}
+
+// QueueByDefault reports whether operations on a busy site wait for it by default.
+func QueueByDefault() bool {
+	return queueSiteOperations
+}
+
+// siteLock serialises the mutating operations on one site. The slot channel
+// holds a token while the lock is taken.
+type siteLock struct {
+	projectName string
+	slot        chan struct{}
+	holder      models.SiteLock
+	waiting     int
+}
+
+var (
+	// locksMu guards siteLocks and the holder and waiting fields of each lock.
+	locksMu   sync.Mutex
+	siteLocks = make(map[string]*siteLock)
+)
+
+// lockFor returns the lock of a site. Callers must hold locksMu.
+func lockFor(projectName string) *siteLock {
+	lock, ok := siteLocks[projectName]
+	if !ok {
+		lock = &siteLock{projectName: projectName, slot: make(chan struct{}, 1)}
+		siteLocks[projectName] = lock
+	}
+	return lock
+}
+
+// forget drops the lock from siteLocks once nobody holds or waits for it,
+// so that the locks of deleted sites do not pile up. Callers must hold
+// locksMu.
+func (l *siteLock) forget() {
+	if len(l.slot) == 0 && l.waiting == 0 && siteLocks[l.projectName] == l {
+		delete(siteLocks, l.projectName)
+	}
+}
+
+// TryLockSite takes the lock of a site for operation, or returns a
+// *SiteLockedError describing the holder if it is taken. jobID may be empty
+// for operations that do not run as jobs. Call the returned function to
+// release the lock.
+func TryLockSite(projectName, operation, jobID string) (func(), error) {
+	locksMu.Lock()
+	defer locksMu.Unlock()
+
+	lock := lockFor(projectName)
+	select {
+	case lock.slot <- struct{}{}:
+		return lock.take(operation, jobID), nil
+	default:
+		holder := lock.holder
+		holder.Queued = lock.waiting
+		return nil, &SiteLockedError{ProjectName: projectName, Holder: holder}
+	}
+}
+
+// LockSite waits for the lock of a site and takes it for operation. It
+// returns the context's error if ctx is done first.
+func LockSite(ctx context.Context, projectName, operation, jobID string) (func(), error) {
+	locksMu.Lock()
+	lock := lockFor(projectName)
+	lock.waiting++
+	locksMu.Unlock()
+
+	select {
+	case lock.slot <- struct{}{}:
+		locksMu.Lock()
+		defer locksMu.Unlock()
+		lock.waiting--
+		return lock.take(operation, jobID), nil
+	case <-ctx.Done():
+		locksMu.Lock()
+		lock.waiting--
+		lock.forget()
+		locksMu.Unlock()
+		return nil, ctx.Err()
+	}
+}
+
+// SiteLockState returns the operation holding a site's lock, if any.
+func SiteLockState(projectName string) (models.SiteLock, bool) {
+	locksMu.Lock()
+	defer locksMu.Unlock()
+
+	lock, ok := siteLocks[projectName]
+	if !ok || len(lock.slot) == 0 {
+		return models.SiteLock{}, false
+	}
+	holder := lock.holder
+	holder.Queued = lock.waiting
+	return holder, true
+}
+
+// take records the new holder once the slot is taken and returns the release
+// function. Callers must hold locksMu.
+func (l *siteLock) take(operation, jobID string) func() {
+	l.holder = models.SiteLock{Operation: operation, JobID: jobID, Since: time.Now()}
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			locksMu.Lock()
+			l.holder = models.SiteLock{}
+			<-l.slot
+			l.forget()
+			locksMu.Unlock()
+		})
+	}
+}
@@ -0,0 +1,176 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"wordpress-collab-tool/models"
+)
+
+func TestTryLockSiteReportsHolder(t *testing.T) {
+	unlock, err := TryLockSite("blog", "backup", "job-1")
+	if err != nil {
+		t.Fatalf("TryLockSite: %v", err)
+	}
+
+	_, err = TryLockSite("blog", "restore", "")
+	var locked *SiteLockedError
+	if !errors.As(err, &locked) || !errors.Is(err, ErrSiteLocked) {
+		t.Fatalf("expected a SiteLockedError, got %v", err)
+	}
+	if locked.Holder.Operation != "backup" || locked.Holder.JobID != "job-1" || locked.Holder.Since.IsZero() {
+		t.Errorf("unexpected holder: %+v", locked.Holder)
+	}
+	if holder, ok := SiteLockState("blog"); !ok || holder.Operation != "backup" {
+		t.Errorf("SiteLockState = %+v, %v", holder, ok)
+	}
+
+	// Other sites are not affected
+	other, err := TryLockSite("shop", "restart", "")
+	if err != nil {
+		t.Fatalf("locking another site: %v", err)
+	}
+	other()
+
+	unlock()
+	unlock() // releasing twice is harmless
+	if _, ok := SiteLockState("blog"); ok {
+		t.Error("site still locked after release")
+	}
+	again, err := TryLockSite("blog", "restore", "")
+	if err != nil {
+		t.Fatalf("TryLockSite after release: %v", err)
+	}
+	again()
+}
+
+func TestLockSiteWaitsForRelease(t *testing.T) {
+	unlock, _ := TryLockSite("blog", "deploy", "")
+
+	acquired := make(chan func())
+	go func() {
+		next, err := LockSite(t.Context(), "blog", "restart", "")
+		if err != nil {
+			t.Errorf("LockSite: %v", err)
+		}
+		acquired <- next
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for holder, _ := SiteLockState("blog"); holder.Queued != 1; holder, _ = SiteLockState("blog") {
+		if time.Now().After(deadline) {
+			t.Fatal("waiter was not queued")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	select {
+	case <-acquired:
+		t.Fatal("lock acquired while held")
+	default:
+	}
+
+	unlock()
+	next := <-acquired
+	if holder, _ := SiteLockState("blog"); holder.Operation != "restart" || holder.Queued != 0 {
+		t.Errorf("unexpected holder after hand-over: %+v", holder)
+	}
+	next()
+}
+
+func TestLockSiteStopsWaitingWhenCancelled(t *testing.T) {
+	unlock, _ := TryLockSite("blog", "deploy", "")
+	defer unlock()
+
+	ctx, cancel := context.WithTimeout(t.Context(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := LockSite(ctx, "blog", "restart", ""); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+	}
+	if holder, _ := SiteLockState("blog"); holder.Queued != 0 {
+		t.Errorf("cancelled waiter still counted: %+v", holder)
+	}
+}
+
+func TestReleasedLocksAreForgotten(t *testing.T) {
+	lockCount := func() int {
+		locksMu.Lock()
+		defer locksMu.Unlock()
+		return len(siteLocks)
+	}
+	before := lockCount()
+
+	unlock, _ := TryLockSite("gone", "delete", "")
+	ctx, cancel := context.WithTimeout(t.Context(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := LockSite(ctx, "gone", "restart", ""); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+	}
+	if lockCount() != before+1 {
+		t.Error("held lock was dropped when a waiter gave up")
+	}
+	unlock()
+	if lockCount() != before {
+		t.Errorf("released lock kept: %d locks, want %d", lockCount(), before)
+	}
+	again, err := TryLockSite("gone", "restore", "")
+	if err != nil {
+		t.Fatalf("TryLockSite after release: %v", err)
+	}
+	again()
+}
+
+func TestStartJobRejectsOrQueuesOnBusySite(t *testing.T) {
+	withJobs(t)
+
+	release := make(chan struct{})
+	first, err := StartJob(t.Context(), "backup", "blog", false, func(ctx context.Context) error {
+		<-release
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("StartJob: %v", err)
+	}
+
+	if _, err := StartJob(t.Context(), "restore", "blog", false, func(ctx context.Context) error { return nil }); !errors.Is(err, ErrSiteLocked) {
+		t.Fatalf("expected ErrSiteLocked, got %v", err)
+	}
+
+	queued, err := StartJob(t.Context(), "restore", "blog", true, func(ctx context.Context) error { return nil })
+	if err != nil {
+		t.Fatalf("StartJob with queue: %v", err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if job, _ := GetJob(queued.ID); job.State != models.JobQueued {
+		t.Fatalf("queued job state = %s, want queued", job.State)
+	}
+
+	close(release)
+	if job := waitForJob(t, first.ID); job.State != models.JobSucceeded {
+		t.Errorf("first job state = %s", job.State)
+	}
+	if job := waitForJob(t, queued.ID); job.State != models.JobSucceeded {
+		t.Errorf("queued job state = %s", job.State)
+	}
+}
+
+func TestCancelQueuedJob(t *testing.T) {
+	withJobs(t)
+	unlock, _ := TryLockSite("blog", "restart", "")
+	defer unlock()
+
+	job, err := StartJob(t.Context(), "backup", "blog", true, func(ctx context.Context) error {
+		t.Error("cancelled job ran")
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("StartJob: %v", err)
+	}
+	if _, err := CancelJob(job.ID); err != nil {
+		t.Fatalf("CancelJob: %v", err)
+	}
+	if job := waitForJob(t, job.ID); job.State != models.JobCancelled || job.StartedAt != nil {
+		t.Errorf("unexpected job: %+v", job)
+	}
+}
@@ -0,0 +1,185 @@
+package services
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"wordpress-collab-tool/config"
+	"wordpress-collab-tool/models"
+)
+
+// deployLogLines is how many lines of deployment output each site retains
+// for subscribers that join late.
+var deployLogLines = 500
+
+// subscriberBuffer is how many lines may queue up for a slow subscriber
+// before further lines are dropped for it.
+const subscriberBuffer = 256
+
+// InitLogStreams applies the configured log retention.
+func InitLogStreams(cfg *config.Config) {
+	deployLogLines = cfg.DeployLogLines
+}
+
+// LogStream fans the output of a running operation out to subscribers line by
+// line and keeps the most recent lines for anyone who subscribes later. A nil
+// *LogStream discards everything written to it.
+type LogStream struct {
+	mu          sync.Mutex
+	lines       []models.LogLine
+	limit       int
+	subscribers map[chan models.LogLine]struct{}
+	finished    bool
+	status      string
+}
+
+var (
+	deployLogsMu sync.Mutex
+	deployLogs   = make(map[string]*LogStream)
+)
+
+// NewLogStream returns an empty stream retaining up to limit lines.
+func NewLogStream(limit int) *LogStream {
+	return &LogStream{limit: limit, subscribers: make(map[chan models.LogLine]struct{})}
+}
+
+// StartDeployLog replaces the deployment log of a site with a fresh stream.
+// Subscribers of the previous stream are disconnected.
+func StartDeployLog(projectName string) *LogStream {
+	stream := NewLogStream(deployLogLines)
+	deployLogsMu.Lock()
+	previous := deployLogs[projectName]
+	deployLogs[projectName] = stream
+	deployLogsMu.Unlock()
+
+	if previous != nil {
+		previous.Finish("superseded")
+	}
+	return stream
+}
+
+// DeployLog returns the most recent deployment log of a site, if any.
+func DeployLog(projectName string) (*LogStream, bool) {
+	deployLogsMu.Lock()
+	defer deployLogsMu.Unlock()
+	stream, ok := deployLogs[projectName]
+	return stream, ok
+}
+
+// Infof appends a progress message to the stream.
+func (s *LogStream) Infof(format string, args ...interface{}) {
+	s.append("info", fmt.Sprintf(format, args...))
+}
+
+// Writer returns an io.Writer that appends each complete line written to it
+// as an entry of the given kind ("stdout" or "stderr"). Call Flush on it to
+// emit a trailing line without a newline.
+func (s *LogStream) Writer(kind string) *LineWriter {
+	return &LineWriter{stream: s, kind: kind}
+}
+
+// Subscribe returns the retained lines and a channel receiving every line
+// appended afterwards. The channel is closed when the stream finishes or the
+// returned cancel function is called.
+func (s *LogStream) Subscribe() ([]models.LogLine, <-chan models.LogLine, func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	backlog := append([]models.LogLine(nil), s.lines...)
+	ch := make(chan models.LogLine, subscriberBuffer)
+	if s.finished {
+		close(ch)
+		return backlog, ch, func() {}
+	}
+
+	s.subscribers[ch] = struct{}{}
+	var once sync.Once
+	cancel := func() {
+		once.Do(func() {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			if _, ok := s.subscribers[ch]; ok {
+				delete(s.subscribers, ch)
+				close(ch)
+			}
+		})
+	}
+	return backlog, ch, cancel
+}
+
+// Finish records the final status of the operation and disconnects all subscribers.
+func (s *LogStream) Finish(status string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.finished {
+		return
+	}
+	s.finished = true
+	s.status = status
+	for ch := range s.subscribers {
+		delete(s.subscribers, ch)
+		close(ch)
+	}
+}
+
+// Status returns the final status passed to Finish and whether the stream has finished.
+func (s *LogStream) Status() (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.status, s.finished
+}
+
+func (s *LogStream) append(kind, text string) {
+	if s == nil {
+		return
+	}
+	line := models.LogLine{Time: time.Now(), Stream: kind, Text: text}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.finished {
+		return
+	}
+	s.lines = append(s.lines, line)
+	if over := len(s.lines) - s.limit; over > 0 {
+		s.lines = append(s.lines[:0:0], s.lines[over:]...)
+	}
+	for ch := range s.subscribers {
+		select {
+		case ch <- line:
+		default: // the subscriber is not keeping up; drop the line for it
+		}
+	}
+}
+
+// LineWriter splits written output into lines for a LogStream.
+type LineWriter struct {
+	stream  *LogStream
+	kind    string
+	partial []byte
+}
+
+// Write appends every complete line in p to the stream and buffers the rest.
+func (w *LineWriter) Write(p []byte) (int, error) {
+	w.partial = append(w.partial, p...)
+	for {
+		i := bytes.IndexByte(w.partial, '\n')
+		if i < 0 {
+			break
+		}
+		w.stream.append(w.kind, strings.TrimRight(string(w.partial[:i]), "\r"))
+		w.partial = w.partial[i+1:]
+	}
+	return len(p), nil
+}
+
+// Flush appends any buffered partial line.
+func (w *LineWriter) Flush() {
+	if len(w.partial) > 0 {
+		w.stream.append(w.kind, strings.TrimRight(string(w.partial), "\r"))
+		w.partial = nil
+	}
+}
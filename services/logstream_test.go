@@ -0,0 +1,80 @@
+package services
+
+import (
+	"testing"
+
+	"wordpress-collab-tool/internal/fakes"
+)
+
+func TestLogStreamRetainsLastLines(t *testing.T) {
+	stream := NewLogStream(3)
+	w := stream.Writer("stdout")
+	w.Write([]byte("one\ntwo\nthr"))
+	w.Write([]byte("ee\r\nfour\npartial"))
+	w.Flush()
+
+	backlog, _, unsubscribe := stream.Subscribe()
+	defer unsubscribe()
+
+	var got []string
+	for _, line := range backlog {
+		got = append(got, line.Text)
+	}
+	want := []string{"three", "four", "partial"}
+	if len(got) != len(want) {
+		t.Fatalf("backlog = %q, want %q", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("backlog = %q, want %q", got, want)
+		}
+	}
+}
+
+func TestLogStreamDeliversToSubscribers(t *testing.T) {
+	stream := NewLogStream(10)
+	_, lines, _ := stream.Subscribe()
+
+	stream.Infof("step %d", 1)
+	stream.Finish("succeeded")
+
+	line, ok := <-lines
+	if !ok || line.Text != "step 1" || line.Stream != "info" {
+		t.Fatalf("unexpected line %+v (open: %v)", line, ok)
+	}
+	if _, ok := <-lines; ok {
+		t.Error("channel should be closed once the stream finishes")
+	}
+	if status, done := stream.Status(); !done || status != "succeeded" {
+		t.Errorf("Status() = %q, %v", status, done)
+	}
+}
+
+func TestDeployWordPressSitePublishesOutput(t *testing.T) {
+	site := testSite()
+	withSites(t, site)
+	fake := healthyFake().On("up -d", fakes.Result{Stdout: "Container blog_db  Started\nContainer blog_wordpress  Started\n"})
+	useFakeExecutor(t, fake)
+
+	if err := DeployWordPressSite(t.Context(), site, nil, "admin", "adminpass"); err != nil {
+		t.Fatalf("DeployWordPressSite: %v", err)
+	}
+
+	stream, ok := DeployLog("blog")
+	if !ok {
+		t.Fatal("no deploy log for the site")
+	}
+	backlog, _, _ := stream.Subscribe()
+	found := false
+	for _, line := range backlog {
+		if line.Stream == "stdout" && line.Text == "Container blog_wordpress  Started" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("compose output missing from deploy log: %+v", backlog)
+	}
+	if status, _ := stream.Status(); status != "succeeded" {
+		t.Errorf("status = %q, want succeeded", status)
+	}
+}
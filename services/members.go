@@ -0,0 +1,151 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"wordpress-collab-tool/models"
+)
+
+// Errors returned by the site membership functions.
+var (
+	ErrMemberNotFound = errors.New("user is not a member of the site")
+	ErrAlreadyOwner   = errors.New("user already owns the site")
+)
+
+// SiteRoleOwner is the site role of a site's owner. It grants every
+// permission on the site, within the limits of the owner's global role.
+const SiteRoleOwner = "owner"
+
+// SiteRole returns the role username holds on site: SiteRoleOwner, the
+// role of their membership, or "" when they have none.
+func SiteRole(site models.Site, username string) string {
+	if username == "" {
+		return ""
+	}
+	if site.Owner == username {
+		return SiteRoleOwner
+	}
+	for _, member := range site.Members {
+		if member.Username == username {
+			return member.Role
+		}
+	}
+	return ""
+}
+
+// CanOnSite reports whether a user with the given global role and role on a
+// site may use perm on that site. Admins may act on every site; everyone
+// else needs perm in both their global role and their site role. Members'
+// roles never grant PermSiteMembers, which is left to the owner.
+func CanOnSite(role, siteRole string, perm Permission) bool {
+	if !HasPermission(role, perm) {
+		return false
+	}
+	if role == RoleAdmin || siteRole == SiteRoleOwner {
+		return true
+	}
+	// Only owners manage who else works on their site
+	return perm != PermSiteMembers && HasPermission(siteRole, perm)
+}
+
+// SiteVisible reports whether a user with the given global role can see site.
+func SiteVisible(site models.Site, username, role string) bool {
+	return role == RoleAdmin || SiteRole(site, username) != ""
+}
+
+// ValidateSiteRole checks that role can be given to a site member.
+func ValidateSiteRole(role string) error {
+	switch role {
+	case RoleViewer, RoleEditor, RoleMaintainer:
+		return nil
+	}
+	return fmt.Errorf("%w: site role must be one of %s, %s or %s", ErrInvalidInput, RoleViewer, RoleEditor, RoleMaintainer)
+}
+
+// AddSiteMember gives an existing user a role on a site, replacing the role
+// they had. It returns the site before and after the change.
+func AddSiteMember(projectName, username, role, addedBy string) (before, after models.Site, err error) {
+	if err := ValidateSiteRole(role); err != nil {
+		return models.Site{}, models.Site{}, err
+	}
+	if _, err := Users.Get(username); err != nil {
+		return models.Site{}, models.Site{}, err
+	}
+	before, err = Sites.Get(projectName)
+	if err != nil {
+		return models.Site{}, models.Site{}, err
+	}
+	if before.Owner == username {
+		return models.Site{}, models.Site{}, fmt.Errorf("%w: '%s'", ErrAlreadyOwner, username)
+	}
+
+	after, err = UpdateSite(projectName, func(s *models.Site) {
+		s.Members = slices.DeleteFunc(slices.Clone(s.Members), func(m models.SiteMember) bool { return m.Username == username })
+		s.Members = append(s.Members, models.SiteMember{Username: username, Role: role, AddedBy: addedBy, AddedAt: time.Now().UTC()})
+	})
+	return before, after, err
+}
+
+// RemoveSiteMember takes a user's membership of a site away. It returns
+// the site before and after the change.
+func RemoveSiteMember(projectName, username string) (before, after models.Site, err error) {
+	before, err = Sites.Get(projectName)
+	if err != nil {
+		return models.Site{}, models.Site{}, err
+	}
+	if !slices.ContainsFunc(before.Members, func(m models.SiteMember) bool { return m.Username == username }) {
+		return models.Site{}, models.Site{}, fmt.Errorf("%w: '%s'", ErrMemberNotFound, username)
+	}
+
+	after, err = UpdateSite(projectName, func(s *models.Site) {
+		s.Members = slices.DeleteFunc(slices.Clone(s.Members), func(m models.SiteMember) bool { return m.Username == username })
+	})
+	return before, after, err
+}
+
+// TransferSiteOwnership makes an existing user the owner of a site. The
+// previous owner stays on as a maintainer. It returns the site before and
+// after the change.
+func TransferSiteOwnership(projectName, username, transferredBy string) (before, after models.Site, err error) {
+	if _, err := Users.Get(username); err != nil {
+		return models.Site{}, models.Site{}, err
+	}
+	before, err = Sites.Get(projectName)
+	if err != nil {
+		return models.Site{}, models.Site{}, err
+	}
+	if before.Owner == username {
+		return models.Site{}, models.Site{}, fmt.Errorf("%w: '%s'", ErrAlreadyOwner, username)
+	}
+
+	after, err = UpdateSite(projectName, func(s *models.Site) {
+		members := slices.DeleteFunc(slices.Clone(s.Members), func(m models.SiteMember) bool {
+			return m.Username == username || m.Username == s.Owner
+		})
+		if s.Owner != "" {
+			members = append(members, models.SiteMember{Username: s.Owner, Role: RoleMaintainer, AddedBy: transferredBy, AddedAt: time.Now().UTC()})
+		}
+		s.Owner = username
+		s.Members = members
+	})
+	return before, after, err
+}
+
+// removeUserMemberships takes a deleted user's memberships of sites away.
+func removeUserMemberships(username string, sites []models.Site) error {
+	for _, site := range sites {
+		if SiteRole(site, username) == "" {
+			continue
+		}
+		_, err := UpdateSite(site.ProjectName, func(s *models.Site) {
+			s.Members = slices.DeleteFunc(slices.Clone(s.Members), func(m models.SiteMember) bool { return m.Username == username })
+		})
+		if err != nil && !errors.Is(err, ErrSiteNotFound) {
+			return fmt.Errorf("failed to remove '%s' from site '%s': %w", username, site.ProjectName, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package services
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCanOnSite(t *testing.T) {
+	tests := []struct {
+		role, siteRole string
+		perm           Permission
+		want           bool
+	}{
+		{RoleAdmin, "", PermSitesDelete, true},
+		{RoleMaintainer, SiteRoleOwner, PermBackupsRestore, true},
+		{RoleMaintainer, SiteRoleOwner, PermSitesDelete, false}, // capped by the global role
+		{RoleMaintainer, RoleViewer, PermBackupsRestore, false}, // capped by the site role
+		{RoleMaintainer, RoleMaintainer, PermBackupsRestore, true},
+		{RoleMaintainer, RoleMaintainer, PermSiteMembers, false},
+		{RoleMaintainer, SiteRoleOwner, PermSiteMembers, true},
+		{RoleViewer, RoleMaintainer, PermPluginsWrite, false},
+		{RoleEditor, "", PermSitesRead, false},
+	}
+	for _, tt := range tests {
+		if got := CanOnSite(tt.role, tt.siteRole, tt.perm); got != tt.want {
+			t.Errorf("CanOnSite(%q, %q, %s) = %v, want %v", tt.role, tt.siteRole, tt.perm, got, tt.want)
+		}
+	}
+}
+
+func TestSiteMembershipChanges(t *testing.T) {
+	site := testSite()
+	site.Owner = "olga"
+	withSites(t, site)
+	withUsers(t)
+	for _, name := range []string{"olga", "max"} {
+		if _, err := CreateUser(name, "a long password", RoleMaintainer); err != nil {
+			t.Fatalf("CreateUser: %v", err)
+		}
+	}
+
+	if _, _, err := AddSiteMember(site.ProjectName, "nobody", RoleViewer, "olga"); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("unknown user: expected ErrUserNotFound, got %v", err)
+	}
+	if _, _, err := AddSiteMember(site.ProjectName, "max", RoleAdmin, "olga"); !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("admin site role: expected ErrInvalidInput, got %v", err)
+	}
+	if _, _, err := AddSiteMember(site.ProjectName, "olga", RoleViewer, "olga"); !errors.Is(err, ErrAlreadyOwner) {
+		t.Errorf("owner as member: expected ErrAlreadyOwner, got %v", err)
+	}
+
+	AddSiteMember(site.ProjectName, "max", RoleViewer, "olga")
+	_, after, err := AddSiteMember(site.ProjectName, "max", RoleEditor, "olga")
+	if err != nil || len(after.Members) != 1 || SiteRole(after, "max") != RoleEditor {
+		t.Fatalf("changing a member's role: %+v %v", after.Members, err)
+	}
+
+	_, after, err = TransferSiteOwnership(site.ProjectName, "max", "olga")
+	if err != nil {
+		t.Fatalf("TransferSiteOwnership: %v", err)
+	}
+	if after.Owner != "max" || SiteRole(after, "olga") != RoleMaintainer || len(after.Members) != 1 {
+		t.Errorf("unexpected site after transfer: %q %+v", after.Owner, after.Members)
+	}
+
+	if _, after, err = RemoveSiteMember(site.ProjectName, "olga"); err != nil || len(after.Members) != 0 {
+		t.Errorf("RemoveSiteMember: %+v %v", after.Members, err)
+	}
+	if _, _, err := RemoveSiteMember(site.ProjectName, "olga"); !errors.Is(err, ErrMemberNotFound) {
+		t.Errorf("removing again: expected ErrMemberNotFound, got %v", err)
+	}
+	if SiteVisible(after, "olga", RoleMaintainer) || !SiteVisible(after, "olga", RoleAdmin) {
+		t.Error("unexpected visibility after removal")
+	}
+}
+
+func TestDeleteUserRemovesMemberships(t *testing.T) {
+	site := testSite()
+	site.Owner = "olga"
+	withSites(t, site)
+	withUsers(t)
+	for _, name := range []string{"olga", "max"} {
+		if _, err := CreateUser(name, "a long password", RoleMaintainer); err != nil {
+			t.Fatalf("CreateUser: %v", err)
+		}
+	}
+	if _, _, err := AddSiteMember(site.ProjectName, "max", RoleEditor, "olga"); err != nil {
+		t.Fatalf("AddSiteMember: %v", err)
+	}
+
+	if err := DeleteUser("olga"); !errors.Is(err, ErrUserOwnsSites) {
+		t.Errorf("deleting an owner: expected ErrUserOwnsSites, got %v", err)
+	}
+	if err := DeleteUser("max"); err != nil {
+		t.Fatalf("DeleteUser: %v", err)
+	}
+	if _, err := CreateUser("max", "a long password", RoleMaintainer); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	stored, _ := GetSite(site.ProjectName)
+	if role := SiteRole(stored, "max"); role != "" {
+		t.Errorf("new account inherited the deleted user's role %q", role)
+	}
+}
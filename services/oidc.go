@@ -0,0 +1,618 @@
+package services
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"wordpress-collab-tool/config"
+	"wordpress-collab-tool/models"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// Errors returned by the single sign-on functions.
+var (
+	ErrOIDCDisabled        = errors.New("single sign-on is not configured")
+	ErrInvalidOIDCState    = errors.New("single sign-on request is invalid or expired")
+	ErrInvalidIDToken      = errors.New("invalid ID token")
+	ErrNoMappedRole        = errors.New("none of the user's groups is mapped to a role")
+	ErrOIDCAccountConflict = errors.New("the username belongs to another account")
+)
+
+// ProviderOIDC marks accounts created by single sign-on.
+const ProviderOIDC = "oidc"
+
+const (
+	// oidcLoginTTL is how long a user has to sign in at the provider.
+	oidcLoginTTL = 10 * time.Minute
+	// oidcClockSkew is tolerated between our clock and the provider's when
+	// checking ID token times.
+	oidcClockSkew = time.Minute
+	// jwksRefreshInterval bounds how often the provider's keys are fetched
+	// again because a token names an unknown key.
+	jwksRefreshInterval = time.Minute
+	// oidcResponseLimit bounds the size of provider responses.
+	oidcResponseLimit = 1 << 20
+	// oidcHandoffTTL is how long the frontend has to redeem the code the
+	// callback redirects it with.
+	oidcHandoffTTL = time.Minute
+)
+
+// OIDC is the configured OpenID Connect provider, or nil when single
+// sign-on is disabled.
+var OIDC *OIDCProvider
+
+// OIDCProvider signs users in with the authorization code flow and PKCE.
+// It discovers the provider's endpoints and keys on first use.
+type OIDCProvider struct {
+	issuer        string
+	clientID      string
+	clientSecret  string
+	redirectURL   string
+	scopes        []string
+	usernameClaim string
+	groupsClaim   string
+	groupRoles    map[string]string
+	defaultRole   string
+	postLoginURL  string
+	client        *http.Client
+
+	mu            sync.Mutex
+	discovery     *oidcDiscovery
+	keys          map[string]any
+	keysFetchedAt time.Time
+}
+
+// oidcDiscovery is the part of the provider metadata the flow uses.
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// oidcLogin is a sign-in waiting for the provider to redirect back.
+type oidcLogin struct {
+	verifier  string
+	nonce     string
+	expiresAt time.Time
+}
+
+// oidcLogins holds the pending sign-ins by their state parameter.
+var oidcLogins = struct {
+	sync.Mutex
+	pending map[string]oidcLogin
+}{pending: make(map[string]oidcLogin)}
+
+// oidcHandoffs holds the tokens of finished sign-ins until the frontend
+// redeems them, so that tokens never appear in a redirect URL.
+var oidcHandoffs = struct {
+	sync.Mutex
+	pending map[string]oidcHandoff
+}{pending: make(map[string]oidcHandoff)}
+
+type oidcHandoff struct {
+	tokens    SessionTokens
+	expiresAt time.Time
+}
+
+// InitOIDC sets up single sign-on when OIDC_ISSUER is configured.
+func InitOIDC(cfg *config.Config) error {
+	if cfg.OIDCIssuer == "" {
+		OIDC = nil
+		return nil
+	}
+	if cfg.OIDCClientID == "" || cfg.OIDCRedirectURL == "" {
+		return errors.New("OIDC_CLIENT_ID and OIDC_REDIRECT_URL must be set when OIDC_ISSUER is set")
+	}
+	for group, role := range cfg.OIDCGroupRoles {
+		if err := ValidateRole(role); err != nil {
+			return fmt.Errorf("OIDC_GROUP_ROLES maps '%s' to an unknown role: %w", group, err)
+		}
+	}
+	if cfg.OIDCDefaultRole != "" {
+		if err := ValidateRole(cfg.OIDCDefaultRole); err != nil {
+			return fmt.Errorf("OIDC_DEFAULT_ROLE: %w", err)
+		}
+	}
+	scopes := slices.Clone(cfg.OIDCScopes)
+	if !slices.Contains(scopes, "openid") {
+		scopes = append([]string{"openid"}, scopes...)
+	}
+	OIDC = &OIDCProvider{
+		issuer:        strings.TrimSuffix(cfg.OIDCIssuer, "/"),
+		clientID:      cfg.OIDCClientID,
+		clientSecret:  cfg.OIDCClientSecret,
+		redirectURL:   cfg.OIDCRedirectURL,
+		scopes:        scopes,
+		usernameClaim: cfg.OIDCUsernameClaim,
+		groupsClaim:   cfg.OIDCGroupsClaim,
+		groupRoles:    cfg.OIDCGroupRoles,
+		defaultRole:   cfg.OIDCDefaultRole,
+		postLoginURL:  cfg.OIDCPostLoginURL,
+		client:        &http.Client{Timeout: 15 * time.Second},
+	}
+	return nil
+}
+
+// PostLoginURL returns the frontend page to send the browser back to after
+// signing in, or "" when the callback answers with the tokens itself.
+func (p *OIDCProvider) PostLoginURL() string {
+	return p.postLoginURL
+}
+
+// BeginOIDCLogin returns the provider URL to send the browser to. The
+// state, nonce and PKCE verifier of the sign-in are kept until the
+// provider redirects back to CompleteOIDCLogin.
+func BeginOIDCLogin(ctx context.Context) (string, error) {
+	if OIDC == nil {
+		return "", ErrOIDCDisabled
+	}
+	discovery, err := OIDC.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	var login oidcLogin
+	state, err := randomURLToken()
+	if err == nil {
+		login.nonce, err = randomURLToken()
+	}
+	if err == nil {
+		login.verifier, err = randomURLToken()
+	}
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	login.expiresAt = now.Add(oidcLoginTTL)
+
+	oidcLogins.Lock()
+	for id, l := range oidcLogins.pending {
+		if now.After(l.expiresAt) {
+			delete(oidcLogins.pending, id)
+		}
+	}
+	oidcLogins.pending[state] = login
+	oidcLogins.Unlock()
+
+	challenge := sha256.Sum256([]byte(login.verifier))
+	query := url.Values{}
+	query.Set("response_type", "code")
+	query.Set("client_id", OIDC.clientID)
+	query.Set("redirect_uri", OIDC.redirectURL)
+	query.Set("scope", strings.Join(OIDC.scopes, " "))
+	query.Set("state", state)
+	query.Set("nonce", login.nonce)
+	query.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	query.Set("code_challenge_method", "S256")
+	separator := "?"
+	if strings.Contains(discovery.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return discovery.AuthorizationEndpoint + separator + query.Encode(), nil
+}
+
+// CompleteOIDCLogin exchanges the code the provider redirected back with,
+// validates the ID token and returns the matching account, creating it on
+// first sign-in. The role follows the user's groups at every sign-in.
+func CompleteOIDCLogin(ctx context.Context, state, code string) (models.User, error) {
+	if OIDC == nil {
+		return models.User{}, ErrOIDCDisabled
+	}
+	oidcLogins.Lock()
+	login, ok := oidcLogins.pending[state]
+	delete(oidcLogins.pending, state)
+	oidcLogins.Unlock()
+	if !ok || time.Now().After(login.expiresAt) {
+		return models.User{}, ErrInvalidOIDCState
+	}
+
+	rawIDToken, err := OIDC.exchangeCode(ctx, code, login.verifier)
+	if err != nil {
+		return models.User{}, err
+	}
+	claims, err := OIDC.verifyIDToken(ctx, rawIDToken, login.nonce)
+	if err != nil {
+		return models.User{}, err
+	}
+
+	subject, _ := claims["sub"].(string)
+	username, _ := claims[OIDC.usernameClaim].(string)
+	if subject == "" || username == "" {
+		return models.User{}, fmt.Errorf("%w: missing '%s' claim", ErrInvalidIDToken, OIDC.usernameClaim)
+	}
+	if err := ValidateUsername(username); err != nil {
+		return models.User{}, err
+	}
+	role := OIDC.roleFor(claimStrings(claims[OIDC.groupsClaim]))
+	if role == "" {
+		return models.User{}, ErrNoMappedRole
+	}
+	return provisionOIDCUser(ctx, username, subject, role)
+}
+
+// NewLoginHandoff keeps the tokens of a session started by single sign-on
+// and returns the single-use code that RedeemLoginHandoff exchanges for
+// them.
+func NewLoginHandoff(tokens SessionTokens) (string, error) {
+	code, err := randomURLToken()
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	oidcHandoffs.Lock()
+	defer oidcHandoffs.Unlock()
+	for code, h := range oidcHandoffs.pending {
+		if now.After(h.expiresAt) {
+			delete(oidcHandoffs.pending, code)
+		}
+	}
+	oidcHandoffs.pending[hashToken(code)] = oidcHandoff{tokens: tokens, expiresAt: now.Add(oidcHandoffTTL)}
+	return code, nil
+}
+
+// RedeemLoginHandoff returns the tokens kept by NewLoginHandoff, once.
+func RedeemLoginHandoff(code string) (SessionTokens, error) {
+	oidcHandoffs.Lock()
+	handoff, ok := oidcHandoffs.pending[hashToken(code)]
+	delete(oidcHandoffs.pending, hashToken(code))
+	oidcHandoffs.Unlock()
+	if !ok || time.Now().After(handoff.expiresAt) {
+		return SessionTokens{}, ErrInvalidOIDCState
+	}
+	return handoff.tokens, nil
+}
+
+// roleFor returns the highest role mapped to one of groups, or the default
+// role.
+func (p *OIDCProvider) roleFor(groups []string) string {
+	best := -1
+	for _, group := range groups {
+		if rank := slices.Index(roleOrder, p.groupRoles[group]); rank > best {
+			best = rank
+		}
+	}
+	if best < 0 {
+		return p.defaultRole
+	}
+	return roleOrder[best]
+}
+
+// provisionOIDCUser returns the account of a single sign-on user with its
+// role brought up to date, creating it on first sign-in. Local accounts are
+// never taken over, even when their name matches.
+func provisionOIDCUser(ctx context.Context, username, subject, role string) (models.User, error) {
+	now := time.Now().UTC()
+	user, err := Users.Get(username)
+	if errors.Is(err, ErrUserNotFound) {
+		user, err = Users.Create(models.User{
+			Username:    username,
+			Role:        role,
+			Provider:    ProviderOIDC,
+			Subject:     subject,
+			LastLoginAt: &now,
+			CreatedAt:   now,
+			UpdatedAt:   now,
+		})
+		if err != nil {
+			return models.User{}, err
+		}
+		RecordActivity(ctx, models.Activity{
+			Level:   "security",
+			Action:  "user.create",
+			Message: fmt.Sprintf("User '%s' created by single sign-on.", username),
+			After:   AuditDetails(user),
+		})
+		return user, nil
+	}
+	if err != nil {
+		return models.User{}, err
+	}
+
+	previous := user.Role
+	user, err = UpdateUser(username, func(u *models.User) error {
+		if u.Provider != ProviderOIDC || u.Subject != subject {
+			return ErrOIDCAccountConflict
+		}
+		if u.Disabled {
+			return ErrUserDisabled
+		}
+		u.Role = role
+		u.LastLoginAt = &now
+		return nil
+	})
+	if err != nil {
+		return models.User{}, err
+	}
+	if previous != role {
+		RecordActivity(ctx, models.Activity{
+			Level:   "security",
+			Action:  "user.role",
+			Message: fmt.Sprintf("Role of user '%s' changed from %s to %s by their single sign-on groups.", username, previous, role),
+			Before:  AuditDetails(map[string]string{"role": previous}),
+			After:   AuditDetails(map[string]string{"role": role}),
+		})
+	}
+	return user, nil
+}
+
+// discover fetches the provider metadata, once.
+func (p *OIDCProvider) discover(ctx context.Context) (*oidcDiscovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+	var discovery oidcDiscovery
+	if err := p.getJSON(ctx, p.issuer+"/.well-known/openid-configuration", &discovery); err != nil {
+		return nil, fmt.Errorf("failed to discover the OIDC provider: %w", err)
+	}
+	if strings.TrimSuffix(discovery.Issuer, "/") != p.issuer {
+		return nil, fmt.Errorf("OIDC provider reports issuer %q instead of %q", discovery.Issuer, p.issuer)
+	}
+	if discovery.AuthorizationEndpoint == "" || discovery.TokenEndpoint == "" || discovery.JWKSURI == "" {
+		return nil, errors.New("OIDC provider metadata lacks an endpoint")
+	}
+	p.discovery = &discovery
+	return p.discovery, nil
+}
+
+// exchangeCode redeems an authorization code for the ID token.
+func (p *OIDCProvider) exchangeCode(ctx context.Context, code, verifier string) (string, error) {
+	discovery, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.redirectURL)
+	form.Set("client_id", p.clientID)
+	form.Set("code_verifier", verifier)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, discovery.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.clientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.clientID), url.QueryEscape(p.clientSecret))
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to reach the OIDC token endpoint: %w", err)
+	}
+	defer resp.Body.Close()
+	var token struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, oidcResponseLimit)).Decode(&token); err != nil {
+		return "", fmt.Errorf("invalid OIDC token response (%s): %w", resp.Status, err)
+	}
+	if resp.StatusCode != http.StatusOK || token.Error != "" {
+		return "", fmt.Errorf("%w: the provider refused the code: %s %s", ErrInvalidOIDCState, token.Error, token.ErrorDescription)
+	}
+	if token.IDToken == "" {
+		return "", fmt.Errorf("%w: the token response has no ID token", ErrInvalidIDToken)
+	}
+	return token.IDToken, nil
+}
+
+// verifyIDToken checks the signature of an ID token against the provider's
+// keys, then its issuer, audience, lifetime and nonce.
+func (p *OIDCProvider) verifyIDToken(ctx context.Context, raw, nonce string) (jwt.MapClaims, error) {
+	parser := jwt.Parser{
+		ValidMethods:         []string{"RS256", "RS384", "RS512", "ES256", "ES384", "ES512"},
+		SkipClaimsValidation: true,
+	}
+	claims := jwt.MapClaims{}
+	_, err := parser.ParseWithClaims(raw, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return p.publicKey(ctx, kid)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidIDToken, err)
+	}
+
+	if iss, _ := claims["iss"].(string); strings.TrimSuffix(iss, "/") != p.issuer {
+		return nil, fmt.Errorf("%w: issued by %q", ErrInvalidIDToken, iss)
+	}
+	audience := claimStrings(claims["aud"])
+	if !slices.Contains(audience, p.clientID) {
+		return nil, fmt.Errorf("%w: not issued for this client", ErrInvalidIDToken)
+	}
+	if azp, ok := claims["azp"].(string); ok && azp != p.clientID {
+		return nil, fmt.Errorf("%w: authorized party is %q", ErrInvalidIDToken, azp)
+	}
+	now := time.Now()
+	exp, ok := claims["exp"].(float64)
+	if !ok || now.After(time.Unix(int64(exp), 0).Add(oidcClockSkew)) {
+		return nil, fmt.Errorf("%w: expired", ErrInvalidIDToken)
+	}
+	if iat, ok := claims["iat"].(float64); ok && time.Unix(int64(iat), 0).After(now.Add(oidcClockSkew)) {
+		return nil, fmt.Errorf("%w: issued in the future", ErrInvalidIDToken)
+	}
+	if got, _ := claims["nonce"].(string); got != nonce {
+		return nil, fmt.Errorf("%w: nonce mismatch", ErrInvalidIDToken)
+	}
+	return claims, nil
+}
+
+// publicKey returns the provider key with the given id, fetching the key
+// set again when the id is unknown, as happens after a key rotation.
+func (p *OIDCProvider) publicKey(ctx context.Context, kid string) (any, error) {
+	p.mu.Lock()
+	key, ok := p.lookupKey(kid)
+	stale := time.Since(p.keysFetchedAt) >= jwksRefreshInterval
+	p.mu.Unlock()
+	if ok {
+		return key, nil
+	}
+	if !stale {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+
+	discovery, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := p.getJSON(ctx, discovery.JWKSURI, &set); err != nil {
+		return nil, fmt.Errorf("failed to fetch the OIDC signing keys: %w", err)
+	}
+	keys := make(map[string]any)
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		if key, err := jwk.publicKey(); err == nil {
+			keys[jwk.Kid] = key
+		}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.keys, p.keysFetchedAt = keys, time.Now()
+	if key, ok := p.lookupKey(kid); ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+// lookupKey finds a key by id. A token without a key id is accepted when
+// the provider has a single key. p.mu must be held.
+func (p *OIDCProvider) lookupKey(kid string) (any, bool) {
+	if kid == "" && len(p.keys) == 1 {
+		for _, key := range p.keys {
+			return key, true
+		}
+	}
+	key, ok := p.keys[kid]
+	return key, ok
+}
+
+func (p *OIDCProvider) getJSON(ctx context.Context, url string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s answered %s", url, resp.Status)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, oidcResponseLimit)).Decode(v)
+}
+
+// jsonWebKey is an RSA or elliptic curve public key from a JWKS document
+// (RFC 7517).
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (k jsonWebKey) publicKey() (any, error) {
+	decode := func(s string) (*big.Int, error) {
+		b, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+		if err != nil || len(b) == 0 {
+			return nil, fmt.Errorf("invalid key %q", k.Kid)
+		}
+		return new(big.Int).SetBytes(b), nil
+	}
+	switch k.Kty {
+	case "RSA":
+		n, err := decode(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decode(k.E)
+		if err != nil || !e.IsInt64() || e.Int64() > 1<<31 {
+			return nil, fmt.Errorf("invalid key %q", k.Kid)
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decode(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decode(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, fmt.Errorf("invalid key %q", k.Kid)
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+// claimStrings reads a claim that holds a string or a list of strings.
+func claimStrings(claim any) []string {
+	switch v := claim.(type) {
+	case string:
+		return []string{v}
+	case []any:
+		values := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+// randomURLToken returns 32 random bytes in URL-safe base64, the form of
+// the state, nonce and PKCE verifier.
+func randomURLToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate token: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
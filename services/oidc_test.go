@@ -0,0 +1,146 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"wordpress-collab-tool/config"
+	"wordpress-collab-tool/internal/fakes"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// withOIDC configures single sign-on against a fresh fake provider.
+func withOIDC(t *testing.T) *fakes.OIDCProvider {
+	t.Helper()
+	fake := fakes.NewOIDCProvider("panel")
+	err := InitOIDC(&config.Config{
+		OIDCIssuer:        fake.Issuer(),
+		OIDCClientID:      "panel",
+		OIDCClientSecret:  "client secret",
+		OIDCRedirectURL:   "https://panel.example/api/oidc/callback",
+		OIDCScopes:        []string{"profile", "groups"},
+		OIDCUsernameClaim: "preferred_username",
+		OIDCGroupsClaim:   "groups",
+		OIDCGroupRoles:    map[string]string{"wp-devs": RoleMaintainer, "wp-admins": RoleAdmin, "staff": RoleViewer},
+	})
+	if err != nil {
+		t.Fatalf("InitOIDC: %v", err)
+	}
+	t.Cleanup(func() {
+		OIDC = nil
+		fake.Close()
+	})
+	return fake
+}
+
+// authorize runs the browser's part of the flow and returns the state and
+// code the provider redirected back with.
+func authorize(t *testing.T) (state, code string) {
+	t.Helper()
+	authURL, err := BeginOIDCLogin(context.Background())
+	if err != nil {
+		t.Fatalf("BeginOIDCLogin: %v", err)
+	}
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Get(authURL)
+	if err != nil {
+		t.Fatalf("authorize: %v", err)
+	}
+	resp.Body.Close()
+	location, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil || resp.StatusCode != http.StatusFound {
+		t.Fatalf("authorize: %s %v", resp.Status, err)
+	}
+	return location.Query().Get("state"), location.Query().Get("code")
+}
+
+func TestOIDCLogin(t *testing.T) {
+	withActivities(t)
+	withUsers(t)
+	fake := withOIDC(t)
+	ctx := context.Background()
+
+	fake.SetUser(map[string]any{"sub": "u-1", "preferred_username": "hana", "groups": []string{"staff", "wp-devs"}})
+	state, code := authorize(t)
+	user, err := CompleteOIDCLogin(ctx, state, code)
+	if err != nil || user.Role != RoleMaintainer || user.Provider != ProviderOIDC || user.Subject != "u-1" {
+		t.Fatalf("CompleteOIDCLogin = %+v, %v", user, err)
+	}
+	if _, err := CompleteOIDCLogin(ctx, state, code); !errors.Is(err, ErrInvalidOIDCState) {
+		t.Errorf("replayed state: %v", err)
+	}
+	if _, err := Authenticate(ctx, "hana", ""); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("single sign-on account signed in without a password: %v", err)
+	}
+
+	// The role follows the groups at every sign-in
+	fake.SetUser(map[string]any{"sub": "u-1", "preferred_username": "hana", "groups": "wp-admins"})
+	state, code = authorize(t)
+	if user, err := CompleteOIDCLogin(ctx, state, code); err != nil || user.Role != RoleAdmin {
+		t.Errorf("role after a group change = %+v, %v", user, err)
+	}
+	fake.SetUser(map[string]any{"sub": "u-2", "preferred_username": "ivan", "groups": []string{"sales"}})
+	state, code = authorize(t)
+	if _, err := CompleteOIDCLogin(ctx, state, code); !errors.Is(err, ErrNoMappedRole) {
+		t.Errorf("unmapped groups: %v", err)
+	}
+
+	// Local accounts and other subjects are never taken over
+	CreateUser("jane", "jane's password", RoleViewer)
+	fake.SetUser(map[string]any{"sub": "u-3", "preferred_username": "jane", "groups": []string{"wp-admins"}})
+	state, code = authorize(t)
+	if _, err := CompleteOIDCLogin(ctx, state, code); !errors.Is(err, ErrOIDCAccountConflict) {
+		t.Errorf("local account with the same name: %v", err)
+	}
+	fake.SetUser(map[string]any{"sub": "u-4", "preferred_username": "hana", "groups": []string{"staff"}})
+	state, code = authorize(t)
+	if _, err := CompleteOIDCLogin(ctx, state, code); !errors.Is(err, ErrOIDCAccountConflict) {
+		t.Errorf("another subject with the same name: %v", err)
+	}
+
+	// The code is bound to the PKCE verifier
+	fake.SetUser(map[string]any{"sub": "u-1", "preferred_username": "hana", "groups": []string{"staff"}})
+	_, code = authorize(t)
+	if _, err := OIDC.exchangeCode(ctx, code, "wrong verifier"); err == nil {
+		t.Error("the code was redeemed without its verifier")
+	}
+}
+
+func TestVerifyIDToken(t *testing.T) {
+	fake := withOIDC(t)
+	ctx := context.Background()
+	valid := map[string]any{"sub": "u-1", "nonce": "n"}
+	if _, err := OIDC.verifyIDToken(ctx, fake.SignIDToken(valid), "n"); err != nil {
+		t.Fatalf("valid token: %v", err)
+	}
+
+	for name, claims := range map[string]map[string]any{
+		"wrong nonce":    {"sub": "u-1", "nonce": "other"},
+		"wrong audience": {"sub": "u-1", "nonce": "n", "aud": []string{"someone-else"}},
+		"wrong issuer":   {"sub": "u-1", "nonce": "n", "iss": "https://evil.example"},
+		"expired":        {"sub": "u-1", "nonce": "n", "exp": time.Now().Add(-time.Hour).Unix()},
+		"other party":    {"sub": "u-1", "nonce": "n", "aud": []string{"panel", "other"}, "azp": "other"},
+	} {
+		if _, err := OIDC.verifyIDToken(ctx, fake.SignIDToken(claims), "n"); !errors.Is(err, ErrInvalidIDToken) {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+
+	// Tokens signed with a shared secret are refused, whatever the key
+	hmacToken, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"sub": "u-1", "nonce": "n"}).SignedString([]byte("secret"))
+	if _, err := OIDC.verifyIDToken(ctx, hmacToken, "n"); !errors.Is(err, ErrInvalidIDToken) {
+		t.Errorf("HS256 token: %v", err)
+	}
+
+	// After a key rotation the new key set is fetched
+	fake.RotateKey()
+	OIDC.keysFetchedAt = time.Time{}
+	if _, err := OIDC.verifyIDToken(ctx, fake.SignIDToken(valid), "n"); err != nil {
+		t.Errorf("token signed with a rotated key: %v", err)
+	}
+}
@@ -0,0 +1,70 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"wordpress-collab-tool/models"
+	"wordpress-collab-tool/utils"
+)
+
+// ListPlugins returns the plugins installed on a site as reported by wp-cli.
+func ListPlugins(ctx context.Context, site models.Site) ([]map[string]interface{}, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeouts.Command)
+	defer cancel()
+
+	executor, _, err := ExecutorForSite(ctx, site)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrConnect, err)
+	}
+	defer executor.Close()
+
+	remotePath := fmt.Sprintf("/var/www/%s", site.ProjectName)
+	stdout, stderr, err := executor.Run(ctx, ComposeCmd(remotePath, "exec", "-T", site.ProjectName+"_cli", "wp", "plugin", "list", "--format=json"))
+	if err != nil {
+		utils.LogError("SSH command failed: %v, output: %s", err, stdout+stderr)
+		return nil, fmt.Errorf("%w: %w", ErrRemoteCommand, err)
+	}
+
+	var plugins []map[string]interface{}
+	if err := json.Unmarshal([]byte(stdout), &plugins); err != nil {
+		utils.LogError("Failed to unmarshal plugins: %v. Raw output: %s", err, stdout)
+		return nil, fmt.Errorf("%w: %w", ErrInvalidOutput, err)
+	}
+	return plugins, nil
+}
+
+// RunPluginCommand runs a wp-cli plugin action (install, activate, deactivate
+// or delete) for pluginName on a site.
+func RunPluginCommand(ctx context.Context, site models.Site, action, pluginName string) error {
+	if err := ValidatePluginSlug(pluginName); err != nil {
+		return err
+	}
+
+	wpArgs := []string{"wp", "plugin", action, pluginName}
+	switch action {
+	case "install":
+		wpArgs = append(wpArgs, "--activate")
+	case "activate", "deactivate", "delete":
+	default:
+		return fmt.Errorf("unknown plugin action %q", action)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeouts.Command)
+	defer cancel()
+
+	executor, _, err := ExecutorForSite(ctx, site)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrConnect, err)
+	}
+	defer executor.Close()
+
+	remotePath := fmt.Sprintf("/var/www/%s", site.ProjectName)
+	stdout, stderr, err := executor.Run(ctx, ComposeCmd(remotePath, append([]string{"exec", "-T", site.ProjectName + "_cli"}, wpArgs...)...))
+	if err != nil {
+		utils.LogError("SSH command failed: %v, output: %s", err, stdout+stderr)
+		return fmt.Errorf("%w: %w", ErrRemoteCommand, err)
+	}
+	return nil
+}
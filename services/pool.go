@@ -0,0 +1,348 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"wordpress-collab-tool/config"
+	"wordpress-collab-tool/models"
+	"wordpress-collab-tool/utils"
+)
+
+const (
+	// healthCheckAfter is how long a connection may sit idle before it is
+	// health-checked again on checkout.
+	healthCheckAfter = 5 * time.Second
+	// acquireTimeout bounds how long a caller waits for a free session slot.
+	acquireTimeout = 30 * time.Second
+)
+
+// SSHPool keeps long-lived, authenticated SSH clients per host so that
+// handlers reuse connections instead of dialing the VPS on every request.
+type SSHPool struct {
+	mu          sync.Mutex
+	hosts       map[string]*hostPool
+	maxSessions int
+	keepalive   time.Duration
+	idleTimeout time.Duration
+	dial        func(ctx context.Context, cfg models.SSHConfig) (*ssh.Client, error)
+	stop        chan struct{}
+}
+
+// hostPool holds the connections for a single user@host.
+type hostPool struct {
+	key   string
+	slots chan struct{} // caps the number of clients checked out at once
+	idle  []*pooledConn
+	open  int
+	inUse int
+	dials int
+	// lost counts connections found dead; the next dial is counted as a reconnect.
+	lost       int
+	reconnects int
+	// retired is set once the host's settings changed; its connections are
+	// closed instead of being returned.
+	retired bool
+}
+
+type pooledConn struct {
+	client   *ssh.Client
+	lastUsed time.Time
+}
+
+// PooledClient is an SSH client checked out from the pool. Closing it returns
+// the connection to the pool instead of tearing it down.
+type PooledClient struct {
+	*ssh.Client
+	pool   *SSHPool
+	host   *hostPool
+	conn   *pooledConn
+	once   sync.Once
+	broken bool
+}
+
+// Pool is the connection pool shared by all controllers and services.
+var Pool *SSHPool
+
+var poolInit sync.Once
+
+// InitSSHPool creates the shared SSH connection pool from the configuration.
+func InitSSHPool(cfg *config.Config) {
+	poolInit.Do(func() {
+		Pool = NewSSHPool(cfg.SSHMaxSessions, cfg.SSHKeepaliveInterval, cfg.SSHIdleTimeout)
+	})
+}
+
+// NewSSHPool creates a pool and starts its keepalive loop.
+func NewSSHPool(maxSessions int, keepalive, idleTimeout time.Duration) *SSHPool {
+	p := &SSHPool{
+		hosts:       make(map[string]*hostPool),
+		maxSessions: maxSessions,
+		keepalive:   keepalive,
+		idleTimeout: idleTimeout,
+		dial:        dialSSH,
+		stop:        make(chan struct{}),
+	}
+	go p.keepaliveLoop()
+	return p
+}
+
+// Get checks out a client for the configured host, reusing an idle connection
+// when a healthy one is available and dialing a new one otherwise. Waiting for
+// a free slot and dialing both stop when ctx is done.
+func (p *SSHPool) Get(ctx context.Context, cfg models.SSHConfig) (*PooledClient, error) {
+	hp := p.hostPool(cfg)
+
+	if HostKeys != nil && HostKeys.IsBlocked(cfg.Address()) {
+		p.closeIdle(hp)
+		return nil, fmt.Errorf("%w: %s", ErrHostKeyBlocked, cfg.Address())
+	}
+
+	select {
+	case hp.slots <- struct{}{}:
+	case <-time.After(acquireTimeout):
+		return nil, fmt.Errorf("timed out waiting for a free SSH session to %s", hp.key)
+	case <-ctx.Done():
+		return nil, fmt.Errorf("gave up waiting for a free SSH session to %s: %w", hp.key, ctx.Err())
+	}
+
+	for {
+		p.mu.Lock()
+		if len(hp.idle) == 0 {
+			p.mu.Unlock()
+			break
+		}
+		conn := hp.idle[len(hp.idle)-1]
+		hp.idle = hp.idle[:len(hp.idle)-1]
+		hp.inUse++
+		p.mu.Unlock()
+
+		if time.Since(conn.lastUsed) < healthCheckAfter || isAlive(conn.client) {
+			return &PooledClient{Client: conn.client, pool: p, host: hp, conn: conn}, nil
+		}
+
+		utils.LogInfo("Discarding dead SSH connection to %s", hp.key)
+		conn.client.Close()
+		p.mu.Lock()
+		hp.inUse--
+		hp.open--
+		hp.lost++
+		p.mu.Unlock()
+	}
+
+	client, err := p.dial(ctx, cfg)
+	if err != nil {
+		<-hp.slots
+		return nil, err
+	}
+
+	p.mu.Lock()
+	hp.open++
+	hp.inUse++
+	hp.dials++
+	if hp.lost > 0 {
+		hp.lost--
+		hp.reconnects++
+	}
+	p.mu.Unlock()
+
+	conn := &pooledConn{client: client}
+	return &PooledClient{Client: client, pool: p, host: hp, conn: conn}, nil
+}
+
+// Stats returns a snapshot of the pool, one entry per host.
+func (p *SSHPool) Stats() []models.SSHPoolStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	stats := make([]models.SSHPoolStats, 0, len(p.hosts))
+	for _, hp := range p.hosts {
+		stats = append(stats, models.SSHPoolStats{
+			Host:        hp.key,
+			Open:        hp.open,
+			Idle:        len(hp.idle),
+			InUse:       hp.inUse,
+			MaxSessions: p.maxSessions,
+			Dials:       hp.dials,
+			Reconnects:  hp.reconnects,
+		})
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Host < stats[j].Host })
+	return stats
+}
+
+// Close shuts down the keepalive loop and closes every idle connection.
+func (p *SSHPool) Close() {
+	close(p.stop)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, hp := range p.hosts {
+		for _, conn := range hp.idle {
+			conn.client.Close()
+			hp.open--
+		}
+		hp.idle = nil
+	}
+}
+
+// closeIdle closes every idle connection to a host, e.g. after its key changed.
+func (p *SSHPool) closeIdle(hp *hostPool) {
+	p.mu.Lock()
+	idle := hp.idle
+	hp.idle = nil
+	hp.open -= len(idle)
+	p.mu.Unlock()
+
+	for _, conn := range idle {
+		conn.client.Close()
+	}
+}
+
+// Evict retires the connections to a host, e.g. after its credentials
+// changed or it was removed. Idle connections are closed at once and
+// checked-out ones when they are returned; the next checkout dials afresh.
+func (p *SSHPool) Evict(cfg models.SSHConfig) {
+	key := poolKey(cfg)
+
+	p.mu.Lock()
+	hp, ok := p.hosts[key]
+	if ok {
+		delete(p.hosts, key)
+		hp.retired = true
+	}
+	p.mu.Unlock()
+
+	if ok {
+		utils.LogInfo("Closing SSH connections to %s", key)
+		p.closeIdle(hp)
+	}
+}
+
+func poolKey(cfg models.SSHConfig) string {
+	return fmt.Sprintf("%s@%s", cfg.User, cfg.Address())
+}
+
+func (p *SSHPool) hostPool(cfg models.SSHConfig) *hostPool {
+	key := poolKey(cfg)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	hp, ok := p.hosts[key]
+	if !ok {
+		hp = &hostPool{key: key, slots: make(chan struct{}, p.maxSessions)}
+		p.hosts[key] = hp
+	}
+	return hp
+}
+
+// release hands a checked-out connection back to its host pool.
+func (p *SSHPool) release(c *PooledClient) {
+	p.mu.Lock()
+	c.host.inUse--
+	if c.broken || c.host.retired {
+		c.host.open--
+		if c.broken {
+			c.host.lost++
+		}
+		p.mu.Unlock()
+		c.conn.client.Close()
+	} else {
+		c.conn.lastUsed = time.Now()
+		c.host.idle = append(c.host.idle, c.conn)
+		p.mu.Unlock()
+	}
+	<-c.host.slots
+}
+
+// keepaliveLoop periodically pings idle connections, dropping the ones that
+// are dead or have been idle for longer than the idle timeout.
+func (p *SSHPool) keepaliveLoop() {
+	ticker := time.NewTicker(p.keepalive)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+			p.checkIdle()
+		}
+	}
+}
+
+func (p *SSHPool) checkIdle() {
+	// Snapshot under the lock: release updates lastUsed when a connection
+	// that was checked out meanwhile comes back.
+	type candidate struct {
+		conn     *pooledConn
+		hp       *hostPool
+		lastUsed time.Time
+	}
+	p.mu.Lock()
+	var candidates []candidate
+	for _, hp := range p.hosts {
+		for _, conn := range hp.idle {
+			candidates = append(candidates, candidate{conn: conn, hp: hp, lastUsed: conn.lastUsed})
+		}
+	}
+	p.mu.Unlock()
+
+	for _, candidate := range candidates {
+		conn, hp := candidate.conn, candidate.hp
+		expired := time.Since(candidate.lastUsed) > p.idleTimeout
+		if !expired && isAlive(conn.client) {
+			continue
+		}
+
+		p.mu.Lock()
+		removed := false
+		for i, c := range hp.idle {
+			if c == conn && c.lastUsed.Equal(candidate.lastUsed) {
+				hp.idle = append(hp.idle[:i], hp.idle[i+1:]...)
+				hp.open--
+				if !expired {
+					hp.lost++
+				}
+				removed = true
+				break
+			}
+		}
+		p.mu.Unlock()
+
+		// The connection may have been checked out while we were pinging it,
+		// or used and returned since the snapshot.
+		if removed {
+			if expired {
+				utils.LogInfo("Closing idle SSH connection to %s", hp.key)
+			} else {
+				utils.LogInfo("SSH keepalive failed for %s, dropping connection", hp.key)
+			}
+			conn.client.Close()
+		}
+	}
+}
+
+// isAlive sends an OpenSSH keepalive request and reports whether the server answered.
+func isAlive(client *ssh.Client) bool {
+	_, _, err := client.SendRequest("keepalive@openssh.com", true, nil)
+	return err == nil
+}
+
+// MarkBroken flags the connection so that it is discarded instead of being
+// returned to the pool.
+func (c *PooledClient) MarkBroken() {
+	c.broken = true
+}
+
+// Close returns the client to the pool. It is safe to call more than once.
+func (c *PooledClient) Close() error {
+	c.once.Do(func() {
+		c.pool.release(c)
+	})
+	return nil
+}
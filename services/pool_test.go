@@ -0,0 +1,263 @@
+package services
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"wordpress-collab-tool/models"
+)
+
+// sshTestServer accepts SSH connections without authentication and answers
+// global requests such as keepalives.
+type sshTestServer struct {
+	listener net.Listener
+	mu       sync.Mutex
+	conns    []net.Conn
+}
+
+func startSSHServer(t *testing.T) *sshTestServer {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatalf("NewSignerFromKey: %v", err)
+	}
+	config := &ssh.ServerConfig{NoClientAuth: true}
+	config.AddHostKey(signer)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	s := &sshTestServer{listener: listener}
+	t.Cleanup(func() {
+		listener.Close()
+		s.DropConnections()
+	})
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			s.mu.Lock()
+			s.conns = append(s.conns, conn)
+			s.mu.Unlock()
+			go func() {
+				_, chans, reqs, err := ssh.NewServerConn(conn, config)
+				if err != nil {
+					return
+				}
+				go ssh.DiscardRequests(reqs)
+				for ch := range chans {
+					ch.Reject(ssh.Prohibited, "no sessions")
+				}
+			}()
+		}
+	}()
+	return s
+}
+
+// SSHConfig returns the configuration of a host served by s.
+func (s *sshTestServer) SSHConfig() models.SSHConfig {
+	addr := s.listener.Addr().(*net.TCPAddr)
+	return models.SSHConfig{Host: addr.IP.String(), Port: addr.Port, User: "deploy"}
+}
+
+// DropConnections closes every connection accepted so far.
+func (s *sshTestServer) DropConnections() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, conn := range s.conns {
+		conn.Close()
+	}
+	s.conns = nil
+}
+
+// testPool returns a pool that dials without checking host keys.
+func testPool(t *testing.T, maxSessions int) *SSHPool {
+	t.Helper()
+	p := NewSSHPool(maxSessions, time.Hour, time.Hour)
+	p.dial = func(ctx context.Context, cfg models.SSHConfig) (*ssh.Client, error) {
+		return ssh.Dial("tcp", cfg.Address(), &ssh.ClientConfig{
+			User:            cfg.User,
+			HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+			Timeout:         5 * time.Second,
+		})
+	}
+	t.Cleanup(p.Close)
+	return p
+}
+
+func poolStats(t *testing.T, p *SSHPool) models.SSHPoolStats {
+	t.Helper()
+	stats := p.Stats()
+	if len(stats) != 1 {
+		t.Fatalf("expected stats for one host, got %+v", stats)
+	}
+	return stats[0]
+}
+
+func TestSSHPoolReusesConnections(t *testing.T) {
+	server := startSSHServer(t)
+	p := testPool(t, 2)
+
+	first, err := p.Get(t.Context(), server.SSHConfig())
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	first.Close()
+	first.Close() // a second Close must not release the slot twice
+
+	second, err := p.Get(t.Context(), server.SSHConfig())
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	defer second.Close()
+	if second.Client != first.Client {
+		t.Error("idle connection was not reused")
+	}
+	if stats := poolStats(t, p); stats.Dials != 1 || stats.Open != 1 || stats.InUse != 1 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestSSHPoolReconnectsAfterDeadConnection(t *testing.T) {
+	server := startSSHServer(t)
+	p := testPool(t, 2)
+
+	first, err := p.Get(t.Context(), server.SSHConfig())
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	first.Close()
+	server.DropConnections()
+	// Make the idle connection old enough to be health-checked on checkout
+	p.mu.Lock()
+	for _, hp := range p.hosts {
+		for _, conn := range hp.idle {
+			conn.lastUsed = time.Now().Add(-time.Minute)
+		}
+	}
+	p.mu.Unlock()
+
+	second, err := p.Get(t.Context(), server.SSHConfig())
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	defer second.Close()
+	if second.Client == first.Client {
+		t.Error("dead connection was handed out")
+	}
+	if !isAlive(second.Client) {
+		t.Error("new connection is not usable")
+	}
+	if stats := poolStats(t, p); stats.Dials != 2 || stats.Reconnects != 1 || stats.Open != 1 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestSSHPoolCapsCheckedOutClients(t *testing.T) {
+	server := startSSHServer(t)
+	p := testPool(t, 1)
+
+	held, err := p.Get(t.Context(), server.SSHConfig())
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+
+	got := make(chan *PooledClient)
+	go func() {
+		client, err := p.Get(context.Background(), server.SSHConfig())
+		if err != nil {
+			t.Errorf("Get: %v", err)
+		}
+		got <- client
+	}()
+	select {
+	case <-got:
+		t.Fatal("checkout did not wait for the only slot")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	held.Close()
+	select {
+	case client := <-got:
+		if client != nil {
+			defer client.Close()
+			if client.Client != held.Client {
+				t.Error("released connection was not reused")
+			}
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("checkout still blocked after the slot was released")
+	}
+}
+
+func TestSSHPoolGetStopsWhenContextCancelled(t *testing.T) {
+	server := startSSHServer(t)
+	p := testPool(t, 1)
+
+	held, err := p.Get(t.Context(), server.SSHConfig())
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	defer held.Close()
+
+	ctx, cancel := context.WithCancel(t.Context())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	start := time.Now()
+	if _, err := p.Get(ctx, server.SSHConfig()); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if waited := time.Since(start); waited > 5*time.Second {
+		t.Errorf("Get waited %v after cancellation", waited)
+	}
+	if stats := poolStats(t, p); stats.InUse != 1 || stats.Dials != 1 {
+		t.Errorf("cancelled checkout changed the pool: %+v", stats)
+	}
+}
+
+func TestSSHPoolCheckIdleClosesExpiredConnections(t *testing.T) {
+	server := startSSHServer(t)
+	p := testPool(t, 2)
+	p.idleTimeout = time.Millisecond
+
+	client, err := p.Get(t.Context(), server.SSHConfig())
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	client.Close()
+
+	// Checkouts racing the idle check must not trip the race detector
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for range 20 {
+			if c, err := p.Get(t.Context(), server.SSHConfig()); err == nil {
+				c.Close()
+			}
+		}
+	}()
+	for range 20 {
+		p.checkIdle()
+	}
+	wg.Wait()
+
+	time.Sleep(2 * time.Millisecond)
+	p.checkIdle()
+	if stats := poolStats(t, p); stats.Idle != 0 || stats.Open != 0 {
+		t.Errorf("expired connections were kept: %+v", stats)
+	}
+}
@@ -0,0 +1,83 @@
+package services
+
+import (
+	"fmt"
+	"slices"
+)
+
+// Permission names an action on a kind of resource, e.g. "sites:read".
+type Permission string
+
+// Permissions checked by the API routes.
+const (
+	PermSitesRead       Permission = "sites:read"
+	PermSitesWrite      Permission = "sites:write"
+	PermSitesDelete     Permission = "sites:delete"
+	PermSiteSecretsRead Permission = "sites:secrets"
+	PermSiteMembers     Permission = "sites:members"
+	PermBackupsRead     Permission = "backups:read"
+	PermBackupsWrite    Permission = "backups:write"
+	PermBackupsRestore  Permission = "backups:restore"
+	PermPluginsRead     Permission = "plugins:read"
+	PermPluginsWrite    Permission = "plugins:write"
+	PermJobsRead        Permission = "jobs:read"
+	PermJobsCancel      Permission = "jobs:cancel"
+	PermActivitiesRead  Permission = "activities:read"
+	PermAuditExport     Permission = "audit:export"
+	PermHostsRead       Permission = "hosts:read"
+	PermHostsWrite      Permission = "hosts:write"
+	PermHostKeysWrite   Permission = "hostkeys:write"
+	PermUsersRead       Permission = "users:read"
+	PermUsersWrite      Permission = "users:write"
+)
+
+// Roles, from least to most privileged.
+const (
+	RoleViewer     = "viewer"
+	RoleEditor     = "editor"
+	RoleMaintainer = "maintainer"
+	RoleAdmin      = "admin"
+)
+
+// roleOrder lists the roles from least to most privileged.
+var roleOrder = []string{RoleViewer, RoleEditor, RoleMaintainer, RoleAdmin}
+
+// rolePermissions lists what each role may do. Every role includes the
+// permissions of the roles before it.
+var rolePermissions = func() map[string][]Permission {
+	viewer := []Permission{
+		PermSitesRead, PermBackupsRead, PermPluginsRead, PermJobsRead,
+		PermActivitiesRead, PermHostsRead,
+	}
+	editor := append(slices.Clone(viewer), PermPluginsWrite, PermBackupsWrite)
+	maintainer := append(slices.Clone(editor),
+		PermSitesWrite, PermSiteSecretsRead, PermSiteMembers, PermBackupsRestore, PermJobsCancel)
+	admin := append(slices.Clone(maintainer),
+		PermSitesDelete, PermAuditExport, PermHostsWrite, PermHostKeysWrite,
+		PermUsersRead, PermUsersWrite)
+	return map[string][]Permission{
+		RoleViewer:     viewer,
+		RoleEditor:     editor,
+		RoleMaintainer: maintainer,
+		RoleAdmin:      admin,
+	}
+}()
+
+// RolePermissions returns the permissions granted to role, or nil for an
+// unknown role.
+func RolePermissions(role string) []Permission {
+	return slices.Clone(rolePermissions[role])
+}
+
+// HasPermission reports whether role grants perm.
+func HasPermission(role string, perm Permission) bool {
+	return slices.Contains(rolePermissions[role], perm)
+}
+
+// ValidateRole checks that role is one of the defined roles.
+func ValidateRole(role string) error {
+	if _, ok := rolePermissions[role]; !ok {
+		return fmt.Errorf("%w: role must be one of %s, %s, %s or %s", ErrInvalidInput, RoleViewer, RoleEditor, RoleMaintainer, RoleAdmin)
+	}
+	return nil
+}
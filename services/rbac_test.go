@@ -0,0 +1,44 @@
+package services
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestRolesIncludeLowerRoles(t *testing.T) {
+	order := []string{RoleViewer, RoleEditor, RoleMaintainer, RoleAdmin}
+	for i := 1; i < len(order); i++ {
+		for _, perm := range RolePermissions(order[i-1]) {
+			if !HasPermission(order[i], perm) {
+				t.Errorf("%s lacks %s, which %s has", order[i], perm, order[i-1])
+			}
+		}
+	}
+
+	tests := []struct {
+		role string
+		perm Permission
+		want bool
+	}{
+		{RoleViewer, PermSitesRead, true},
+		{RoleViewer, PermActivitiesRead, true},
+		{RoleViewer, PermPluginsWrite, false},
+		{RoleEditor, PermPluginsWrite, true},
+		{RoleEditor, PermBackupsRestore, false},
+		{RoleMaintainer, PermBackupsRestore, true},
+		{RoleMaintainer, PermSitesDelete, false},
+		{RoleMaintainer, PermHostsWrite, false},
+		{RoleAdmin, PermSitesDelete, true},
+		{RoleAdmin, PermUsersWrite, true},
+		{"", PermSitesRead, false},
+	}
+	for _, tt := range tests {
+		if got := HasPermission(tt.role, tt.perm); got != tt.want {
+			t.Errorf("HasPermission(%q, %s) = %v, want %v", tt.role, tt.perm, got, tt.want)
+		}
+	}
+
+	if err := ValidateRole("owner"); !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("unknown role: expected ErrInvalidInput, got %v", err)
+	}
+}
@@ -0,0 +1,233 @@
+package services
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"wordpress-collab-tool/config"
+	"wordpress-collab-tool/models"
+	"wordpress-collab-tool/utils"
+)
+
+// masterKeySize is the length of master and data keys (AES-256).
+const masterKeySize = 32
+
+// Errors returned when decrypting secrets.
+var (
+	ErrUnknownSecretsKey = errors.New("secrets were sealed with an unknown master key")
+	ErrSecretsCorrupt    = errors.New("secrets could not be decrypted")
+)
+
+// Keyring holds the master key used to seal secrets and any previous master
+// keys still needed to open secrets sealed before a rotation.
+type Keyring struct {
+	current string
+	keys    map[string]cipher.AEAD
+}
+
+// NewKeyring builds a keyring that seals with current and can also open
+// secrets sealed with any of previous.
+func NewKeyring(current []byte, previous ...[]byte) (*Keyring, error) {
+	k := &Keyring{keys: make(map[string]cipher.AEAD)}
+	for i, key := range append([][]byte{current}, previous...) {
+		aead, err := newAEAD(key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid master key: %w", err)
+		}
+		id := keyID(key)
+		if i == 0 {
+			k.current = id
+		}
+		k.keys[id] = aead
+	}
+	return k, nil
+}
+
+// LoadKeyring reads the master keys from SECRETS_KEY and SECRETS_PREVIOUS_KEYS,
+// or else from the key file, which holds one base64 key per line with the
+// current key first. If neither is set, a new key file is generated.
+func LoadKeyring(cfg *config.Config) (*Keyring, error) {
+	var encoded []string
+	if cfg.SecretsKey != "" {
+		encoded = append([]string{cfg.SecretsKey}, cfg.SecretsPreviousKeys...)
+	} else {
+		data, err := os.ReadFile(cfg.SecretsKeyFile)
+		if os.IsNotExist(err) {
+			return generateKeyFile(cfg.SecretsKeyFile)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read secrets key file: %w", err)
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+				encoded = append(encoded, line)
+			}
+		}
+		if len(encoded) == 0 {
+			return nil, fmt.Errorf("secrets key file %s holds no key", cfg.SecretsKeyFile)
+		}
+	}
+
+	keys := make([][]byte, len(encoded))
+	for i, e := range encoded {
+		key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(e))
+		if err != nil {
+			return nil, fmt.Errorf("master key %d is not valid base64: %w", i+1, err)
+		}
+		keys[i] = key
+	}
+	return NewKeyring(keys[0], keys[1:]...)
+}
+
+// generateKeyFile creates a key file holding a new random master key.
+func generateKeyFile(path string) (*Keyring, error) {
+	key := make([]byte, masterKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("failed to generate master key: %w", err)
+	}
+	data := base64.StdEncoding.EncodeToString(key) + "\n"
+	if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+		return nil, fmt.Errorf("failed to write secrets key file: %w", err)
+	}
+	utils.LogInfo("Generated a new secrets master key in %s; back it up, sites cannot be decrypted without it", path)
+	return NewKeyring(key)
+}
+
+// RotateSecrets re-seals every secret stored in plain text or sealed with a
+// previous master key with the current one: the site secrets and the users'
+// TOTP secrets. Once it has run, the previous keys can be dropped.
+func RotateSecrets() error {
+	if sites, ok := Sites.(*EncryptedSiteStore); ok {
+		migrated, err := sites.MigrateSecrets()
+		if err != nil {
+			return err
+		}
+		if migrated > 0 {
+			utils.LogInfo("Encrypted the secrets of %d site(s) with master key %s", migrated, sites.keys.CurrentKeyID())
+		}
+	}
+	rotated, err := rotateTOTPSecrets()
+	if err != nil {
+		return err
+	}
+	if rotated > 0 {
+		utils.LogInfo("Re-encrypted the TOTP secrets of %d user(s) with master key %s", rotated, twoFactor.keys.CurrentKeyID())
+	}
+	return nil
+}
+
+// CurrentKeyID returns the id of the key new secrets are sealed with.
+func (k *Keyring) CurrentKeyID() string {
+	return k.current
+}
+
+// Seal encrypts secrets for the site named projectName under a fresh data key.
+func (k *Keyring) Seal(projectName string, secrets models.SiteSecrets) (*models.SealedSecrets, error) {
+	plaintext, err := json.Marshal(secrets)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal secrets: %w", err)
+	}
+	return k.SealValue(projectName, plaintext)
+}
+
+// Open decrypts secrets sealed for the site named projectName.
+func (k *Keyring) Open(projectName string, sealed *models.SealedSecrets) (models.SiteSecrets, error) {
+	plaintext, err := k.OpenValue(projectName, sealed)
+	if err != nil {
+		return models.SiteSecrets{}, err
+	}
+
+	var secrets models.SiteSecrets
+	if err := json.Unmarshal(plaintext, &secrets); err != nil {
+		return models.SiteSecrets{}, fmt.Errorf("%w: %w", ErrSecretsCorrupt, err)
+	}
+	return secrets, nil
+}
+
+// SealValue encrypts plaintext under a fresh data key. The result can only
+// be opened with the same context, which names what the value belongs to.
+func (k *Keyring) SealValue(context string, plaintext []byte) (*models.SealedSecrets, error) {
+	dataKey := make([]byte, masterKeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, fmt.Errorf("failed to generate data key: %w", err)
+	}
+	dataAEAD, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	ciphertext, err := seal(dataAEAD, plaintext, []byte(context))
+	if err != nil {
+		return nil, err
+	}
+	wrapped, err := seal(k.keys[k.current], dataKey, []byte(k.current))
+	if err != nil {
+		return nil, err
+	}
+	return &models.SealedSecrets{KeyID: k.current, WrappedKey: wrapped, Ciphertext: ciphertext}, nil
+}
+
+// OpenValue decrypts a value sealed with SealValue for context.
+func (k *Keyring) OpenValue(context string, sealed *models.SealedSecrets) ([]byte, error) {
+	master, ok := k.keys[sealed.KeyID]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownSecretsKey, sealed.KeyID)
+	}
+	dataKey, err := open(master, sealed.WrappedKey, []byte(sealed.KeyID))
+	if err != nil {
+		return nil, err
+	}
+	dataAEAD, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrSecretsCorrupt, err)
+	}
+	return open(dataAEAD, sealed.Ciphertext, []byte(context))
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != masterKeySize {
+		return nil, fmt.Errorf("key must be %d bytes, got %d", masterKeySize, len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts plaintext and returns the base64-encoded nonce and ciphertext.
+func seal(aead cipher.AEAD, plaintext, additionalData []byte) (string, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	return base64.StdEncoding.EncodeToString(aead.Seal(nonce, nonce, plaintext, additionalData)), nil
+}
+
+// open reverses seal.
+func open(aead cipher.AEAD, encoded string, additionalData []byte) ([]byte, error) {
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(data) < aead.NonceSize() {
+		return nil, ErrSecretsCorrupt
+	}
+	plaintext, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], additionalData)
+	if err != nil {
+		return nil, ErrSecretsCorrupt
+	}
+	return plaintext, nil
+}
+
+// keyID identifies a master key without revealing it.
+func keyID(key []byte) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:4])
+}
@@ -0,0 +1,160 @@
+package services
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"wordpress-collab-tool/config"
+	"wordpress-collab-tool/models"
+)
+
+func newKey(t *testing.T) []byte {
+	t.Helper()
+	key := make([]byte, masterKeySize)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestKeyringSealsAndOpens(t *testing.T) {
+	keys, err := NewKeyring(newKey(t))
+	if err != nil {
+		t.Fatalf("NewKeyring: %v", err)
+	}
+	secrets := models.SiteSecrets{DBPassword: "db-secret", AdminPassword: "admin-secret"}
+
+	sealed, err := keys.Seal("blog", secrets)
+	if err != nil {
+		t.Fatalf("Seal: %v", err)
+	}
+	if strings.Contains(sealed.Ciphertext+sealed.WrappedKey, "secret") || sealed.KeyID != keys.CurrentKeyID() {
+		t.Fatalf("unexpected sealed secrets: %+v", sealed)
+	}
+	if opened, err := keys.Open("blog", sealed); err != nil || opened != secrets {
+		t.Fatalf("Open = %+v, %v", opened, err)
+	}
+
+	// Secrets are bound to their site and cannot be moved to another one
+	if _, err := keys.Open("shop", sealed); !errors.Is(err, ErrSecretsCorrupt) {
+		t.Errorf("opening under another name: expected ErrSecretsCorrupt, got %v", err)
+	}
+	other, _ := NewKeyring(newKey(t))
+	if _, err := other.Open("blog", sealed); !errors.Is(err, ErrUnknownSecretsKey) {
+		t.Errorf("expected ErrUnknownSecretsKey, got %v", err)
+	}
+	if _, err := NewKeyring([]byte("too short")); err == nil {
+		t.Error("expected an error for a short key")
+	}
+}
+
+func TestMigrateSecretsEncryptsAndRotates(t *testing.T) {
+	oldKey, newKey := newKey(t), newKey(t)
+	oldKeys, _ := NewKeyring(oldKey)
+	raw := NewJSONSiteStore(filepath.Join(t.TempDir(), "sites.json"))
+
+	// One site still in plain text, one sealed under the old key
+	plain := testSite()
+	plain.AdminPassword = "admin-secret"
+	raw.Create(plain)
+	rotated := testSite()
+	rotated.ProjectName = "shop"
+	rotated.DBPassword = "shop-secret"
+	NewEncryptedSiteStore(raw, oldKeys).Create(rotated)
+
+	keys, _ := NewKeyring(newKey, oldKey)
+	store := NewEncryptedSiteStore(raw, keys)
+	migrated, err := store.MigrateSecrets()
+	if err != nil || migrated != 2 {
+		t.Fatalf("MigrateSecrets = %d, %v; want 2", migrated, err)
+	}
+	if again, _ := store.MigrateSecrets(); again != 0 {
+		t.Errorf("second migration rewrote %d sites", again)
+	}
+
+	stored, _ := raw.List()
+	for _, site := range stored {
+		if site.DBPassword != "" || site.AdminPassword != "" || site.Sealed == nil || site.Sealed.KeyID != keys.CurrentKeyID() {
+			t.Errorf("site '%s' is not sealed with the current key: %+v", site.ProjectName, site)
+		}
+	}
+	data, _ := os.ReadFile(raw.path)
+	if bytes.Contains(data, []byte("secret")) {
+		t.Errorf("plain-text secret left in the site file:\n%s", data)
+	}
+
+	// The old key is no longer needed
+	newOnly, _ := NewKeyring(newKey)
+	store = NewEncryptedSiteStore(raw, newOnly)
+	if site, err := store.Get("blog"); err != nil || site.AdminPassword != "admin-secret" || site.DBPassword != testSite().DBPassword {
+		t.Errorf("Get(blog) = %+v, %v", site, err)
+	}
+	if site, err := store.Get("shop"); err != nil || site.DBPassword != "shop-secret" || site.Sealed != nil {
+		t.Errorf("Get(shop) = %+v, %v", site, err)
+	}
+}
+
+func TestRotateSecretsAllowsDroppingOldKey(t *testing.T) {
+	oldKey, newKey := newKey(t), newKey(t)
+	oldKeys, _ := NewKeyring(oldKey)
+	withSites(t)
+	raw := Sites
+	if _, err := NewEncryptedSiteStore(raw, oldKeys).Create(testSite()); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	keys, _ := NewKeyring(newKey, oldKey)
+	Sites = NewEncryptedSiteStore(raw, keys)
+	if err := RotateSecrets(); err != nil {
+		t.Fatalf("RotateSecrets: %v", err)
+	}
+
+	newOnly, _ := NewKeyring(newKey)
+	site, err := NewEncryptedSiteStore(raw, newOnly).Get("blog")
+	if err != nil || site.DBPassword != testSite().DBPassword || site.AdminPassword != testSite().AdminPassword {
+		t.Errorf("site after dropping the old key = %+v, %v", site, err)
+	}
+}
+
+func TestLoadKeyring(t *testing.T) {
+	dir := t.TempDir()
+	cfg := &config.Config{SecretsKeyFile: filepath.Join(dir, "secrets.key")}
+
+	generated, err := LoadKeyring(cfg)
+	if err != nil {
+		t.Fatalf("LoadKeyring: %v", err)
+	}
+	info, err := os.Stat(cfg.SecretsKeyFile)
+	if err != nil || info.Mode().Perm() != 0600 {
+		t.Fatalf("key file not created privately: %v %v", info, err)
+	}
+	reloaded, _ := LoadKeyring(cfg)
+	if reloaded.CurrentKeyID() != generated.CurrentKeyID() {
+		t.Error("reloading the key file produced a different key")
+	}
+
+	// A rotated key file lists the new key first
+	current := newKey(t)
+	data, _ := os.ReadFile(cfg.SecretsKeyFile)
+	rotatedFile := base64.StdEncoding.EncodeToString(current) + "\n" + string(data)
+	os.WriteFile(cfg.SecretsKeyFile, []byte(rotatedFile), 0600)
+	rotated, err := LoadKeyring(cfg)
+	if err != nil {
+		t.Fatalf("LoadKeyring: %v", err)
+	}
+	if rotated.CurrentKeyID() != keyID(current) || rotated.keys[generated.CurrentKeyID()] == nil {
+		t.Error("rotated key file not loaded with the old key kept for decryption")
+	}
+
+	// The environment takes precedence over the file
+	envCfg := &config.Config{SecretsKey: base64.StdEncoding.EncodeToString(current), SecretsKeyFile: cfg.SecretsKeyFile}
+	if env, err := LoadKeyring(envCfg); err != nil || env.CurrentKeyID() != keyID(current) || len(env.keys) != 1 {
+		t.Errorf("LoadKeyring from SECRETS_KEY: %v", err)
+	}
+}
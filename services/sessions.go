@@ -0,0 +1,309 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"wordpress-collab-tool/config"
+	"wordpress-collab-tool/models"
+	"wordpress-collab-tool/utils"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// Errors returned by SessionStore implementations and the session functions.
+var (
+	ErrSessionNotFound    = errors.New("session not found")
+	ErrSessionRevoked     = errors.New("session has ended")
+	ErrRefreshTokenReused = errors.New("refresh token was already used")
+)
+
+// SessionStore persists sessions, the refresh tokens that keep them alive
+// and the revocation list of access tokens.
+type SessionStore interface {
+	// Create stores a new session with the hash of its first refresh token.
+	Create(session models.Session, refreshHash string) error
+	// Get returns a session, or ErrSessionNotFound.
+	Get(id string) (models.Session, error)
+	// List returns the active sessions of a user, most recently used first.
+	List(username string) ([]models.Session, error)
+	// Refresh replaces a refresh token by a new one and extends its session
+	// to expiresAt. It returns ErrSessionNotFound for an unknown token,
+	// ErrSessionRevoked for a session that ended and ErrRefreshTokenReused,
+	// after revoking the session, for a token that was replaced before.
+	Refresh(oldHash, newHash, clientIP, userAgent string, expiresAt time.Time) (models.Session, error)
+	// Revoke ends a session, or returns ErrSessionNotFound.
+	Revoke(id string) error
+	// RevokeUser ends every session of a user and returns how many ended.
+	RevokeUser(username string) (int, error)
+	// RevokeToken refuses an access token id until expiresAt.
+	RevokeToken(jti string, expiresAt time.Time) error
+	// TokenRevoked reports whether an access token id was revoked.
+	TokenRevoked(jti string) (bool, error)
+	// Prune removes ended sessions and expired revocations.
+	Prune() (int, error)
+	Close() error
+}
+
+// Sessions holds the sessions. It is nil until InitSessionStore runs.
+var Sessions SessionStore
+
+// sessionPolicy decides how long tokens last.
+var sessionPolicy = struct {
+	accessTTL  time.Duration
+	refreshTTL time.Duration
+}{accessTTL: 15 * time.Minute, refreshTTL: 30 * 24 * time.Hour}
+
+// sessionPruneInterval is how often ended sessions are removed.
+const sessionPruneInterval = time.Hour
+
+// SessionTokens are the tokens issued when a session starts or is refreshed.
+type SessionTokens struct {
+	SessionID    string    `json:"sessionId"`
+	Token        string    `json:"token"`
+	ExpiresAt    time.Time `json:"expiresAt"`
+	RefreshToken string    `json:"refreshToken"`
+	// RefreshExpiresAt is when the session ends unless it is refreshed.
+	RefreshExpiresAt time.Time `json:"refreshExpiresAt"`
+}
+
+// InitSessionStore opens the sessions in the SQLite database and starts
+// removing the ones that ended.
+func InitSessionStore(cfg *config.Config) error {
+	store, err := OpenSQLiteSessionStore(cfg.DatabasePath)
+	if err != nil {
+		return err
+	}
+	Sessions = store
+	sessionPolicy.accessTTL = cfg.AccessTokenTTL
+	sessionPolicy.refreshTTL = cfg.RefreshTokenTTL
+
+	go func() {
+		for {
+			if _, err := store.Prune(); err != nil {
+				utils.LogError("Failed to prune sessions: %v", err)
+			}
+			time.Sleep(sessionPruneInterval)
+		}
+	}()
+	return nil
+}
+
+// StartSession signs user in on the client named in ctx's audit information
+// and returns the session's first tokens.
+func StartSession(ctx context.Context, user models.User) (SessionTokens, error) {
+	refreshToken, refreshHash, err := newRefreshToken()
+	if err != nil {
+		return SessionTokens{}, err
+	}
+	id, err := randomID()
+	if err != nil {
+		return SessionTokens{}, err
+	}
+	info := AuditFrom(ctx)
+	now := time.Now().UTC()
+	session := models.Session{
+		ID:             id,
+		Username:       user.Username,
+		ClientIP:       info.ClientIP,
+		UserAgent:      info.UserAgent,
+		CreatedAt:      now,
+		LastUsedAt:     now,
+		ExpiresAt:      now.Add(sessionPolicy.refreshTTL),
+		SessionVersion: user.SessionVersion,
+	}
+	if err := Sessions.Create(session, refreshHash); err != nil {
+		return SessionTokens{}, err
+	}
+	return issueTokens(user, session, refreshToken)
+}
+
+// RefreshSession exchanges a refresh token for new tokens of its session.
+// Refresh tokens can be used once; presenting one again ends its session,
+// since it means the token was copied. Sessions of users that were disabled
+// or changed their password since the session started end too.
+func RefreshSession(ctx context.Context, refreshToken string) (SessionTokens, models.Session, error) {
+	newToken, newHash, err := newRefreshToken()
+	if err != nil {
+		return SessionTokens{}, models.Session{}, err
+	}
+	info := AuditFrom(ctx)
+	session, err := Sessions.Refresh(hashToken(refreshToken), newHash, info.ClientIP, info.UserAgent,
+		time.Now().Add(sessionPolicy.refreshTTL))
+	if err != nil {
+		return SessionTokens{}, session, err
+	}
+
+	user, err := Users.Get(session.Username)
+	if err != nil && !errors.Is(err, ErrUserNotFound) {
+		return SessionTokens{}, session, err
+	}
+	if err != nil || user.Disabled || user.SessionVersion != session.SessionVersion {
+		if err := Sessions.Revoke(session.ID); err != nil {
+			return SessionTokens{}, session, err
+		}
+		return SessionTokens{}, session, fmt.Errorf("%w: '%s'", ErrSessionRevoked, session.ID)
+	}
+	tokens, err := issueTokens(user, session, newToken)
+	return tokens, session, err
+}
+
+// CheckSession verifies that the access token with claims was not revoked
+// and that its session is still active. It returns ErrSessionRevoked when
+// either ended.
+func CheckSession(claims *models.Claims) error {
+	if claims.Id == "" || claims.SessionID == "" {
+		return fmt.Errorf("%w: token has no session", ErrSessionRevoked)
+	}
+	revoked, err := Sessions.TokenRevoked(claims.Id)
+	if err != nil {
+		return err
+	}
+	if revoked {
+		return fmt.Errorf("%w: token '%s' was revoked", ErrSessionRevoked, claims.Id)
+	}
+	session, err := Sessions.Get(claims.SessionID)
+	if errors.Is(err, ErrSessionNotFound) {
+		return fmt.Errorf("%w: '%s'", ErrSessionRevoked, claims.SessionID)
+	}
+	if err != nil {
+		return err
+	}
+	if session.Username != claims.Username || session.RevokedAt != nil || !time.Now().Before(session.ExpiresAt) {
+		return fmt.Errorf("%w: '%s'", ErrSessionRevoked, claims.SessionID)
+	}
+	return nil
+}
+
+// EndSession signs out the session of an access token and revokes the
+// token itself, so that it stops working at once.
+func EndSession(claims *models.Claims) error {
+	if err := Sessions.RevokeToken(claims.Id, time.Unix(claims.ExpiresAt, 0)); err != nil {
+		return err
+	}
+	if err := Sessions.Revoke(claims.SessionID); err != nil && !errors.Is(err, ErrSessionNotFound) {
+		return err
+	}
+	return nil
+}
+
+// EndSessionByRefreshToken signs out the session a refresh token belongs
+// to, for clients whose access token already expired.
+func EndSessionByRefreshToken(refreshToken string) (models.Session, error) {
+	// Exchanging the token proves it is current; the new one is discarded
+	_, newHash, err := newRefreshToken()
+	if err != nil {
+		return models.Session{}, err
+	}
+	session, err := Sessions.Refresh(hashToken(refreshToken), newHash, "", "", time.Now())
+	if err != nil {
+		return session, err
+	}
+	return session, Sessions.Revoke(session.ID)
+}
+
+// ListSessions returns the active sessions of a user.
+func ListSessions(username string) ([]models.Session, error) {
+	return Sessions.List(username)
+}
+
+// RevokeSession ends one of username's sessions. Sessions of other users
+// are reported as not found.
+func RevokeSession(username, id string) (models.Session, error) {
+	session, err := Sessions.Get(id)
+	if err != nil {
+		return models.Session{}, err
+	}
+	if session.Username != username || session.RevokedAt != nil {
+		return models.Session{}, fmt.Errorf("%w: '%s'", ErrSessionNotFound, id)
+	}
+	return session, Sessions.Revoke(id)
+}
+
+// EndAllSessions signs a user out everywhere: every session ends and every
+// access token issued before stops working. It returns how many sessions
+// ended.
+func EndAllSessions(username string) (int, error) {
+	if _, err := UpdateUser(username, func(u *models.User) error {
+		u.SessionVersion++
+		return nil
+	}); err != nil {
+		return 0, err
+	}
+	return Sessions.RevokeUser(username)
+}
+
+// revokeUserSessions ends the sessions of a user whose session version
+// changed. It does nothing before InitSessionStore runs.
+func revokeUserSessions(username string) error {
+	if Sessions == nil {
+		return nil
+	}
+	_, err := Sessions.RevokeUser(username)
+	return err
+}
+
+// issueTokens signs an access token for session and pairs it with
+// refreshToken.
+func issueTokens(user models.User, session models.Session, refreshToken string) (SessionTokens, error) {
+	jti, err := randomID()
+	if err != nil {
+		return SessionTokens{}, err
+	}
+	now := time.Now()
+	expiresAt := now.Add(sessionPolicy.accessTTL)
+	claims := &models.Claims{
+		Username:       user.Username,
+		Role:           user.Role,
+		SessionVersion: user.SessionVersion,
+		SessionID:      session.ID,
+		StandardClaims: jwt.StandardClaims{
+			Id:        jti,
+			IssuedAt:  now.Unix(),
+			ExpiresAt: expiresAt.Unix(),
+		},
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(JWTKey)
+	if err != nil {
+		return SessionTokens{}, fmt.Errorf("failed to sign token: %w", err)
+	}
+	return SessionTokens{
+		SessionID:        session.ID,
+		Token:            token,
+		ExpiresAt:        time.Unix(expiresAt.Unix(), 0).UTC(),
+		RefreshToken:     refreshToken,
+		RefreshExpiresAt: session.ExpiresAt,
+	}, nil
+}
+
+// newRefreshToken returns a random refresh token and the hash it is
+// stored under.
+func newRefreshToken() (token, hash string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", fmt.Errorf("failed to generate refresh token: %w", err)
+	}
+	token = base64.RawURLEncoding.EncodeToString(b)
+	return token, hashToken(token), nil
+}
+
+// hashToken hashes a refresh or access token for storage. The tokens are
+// random, so a plain SHA-256 is enough to keep a database copy useless.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate id: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,266 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"wordpress-collab-tool/models"
+)
+
+// SQLiteSessionStore keeps sessions, their refresh tokens and revoked
+// access tokens in an embedded SQLite database. Only hashes of refresh
+// tokens are stored.
+type SQLiteSessionStore struct {
+	db *sql.DB
+}
+
+var sessionMigrations = []migration{
+	{
+		name: "create sessions",
+		statements: []string{
+			`CREATE TABLE sessions (
+				id TEXT PRIMARY KEY,
+				username TEXT NOT NULL,
+				client_ip TEXT NOT NULL DEFAULT '',
+				user_agent TEXT NOT NULL DEFAULT '',
+				session_version INTEGER NOT NULL,
+				created_at INTEGER NOT NULL,
+				last_used_at INTEGER NOT NULL,
+				expires_at INTEGER NOT NULL,
+				revoked_at INTEGER
+			)`,
+			`CREATE INDEX sessions_username ON sessions (username)`,
+			`CREATE TABLE refresh_tokens (
+				token_hash TEXT PRIMARY KEY,
+				session_id TEXT NOT NULL REFERENCES sessions (id) ON DELETE CASCADE,
+				issued_at INTEGER NOT NULL,
+				used_at INTEGER
+			)`,
+			`CREATE INDEX refresh_tokens_session ON refresh_tokens (session_id)`,
+			`CREATE TABLE revoked_tokens (
+				jti TEXT PRIMARY KEY,
+				expires_at INTEGER NOT NULL
+			)`,
+		},
+	},
+}
+
+// OpenSQLiteSessionStore opens the sessions in the database at path.
+func OpenSQLiteSessionStore(path string) (*SQLiteSessionStore, error) {
+	db, err := openSQLite(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := migrate(db, sessionMigrations); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &SQLiteSessionStore{db: db}, nil
+}
+
+const sessionColumns = `id, username, client_ip, user_agent, session_version, created_at, last_used_at, expires_at, revoked_at`
+
+// Create stores a new session and its first refresh token.
+func (s *SQLiteSessionStore) Create(session models.Session, refreshHash string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to create session: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`INSERT INTO sessions (`+sessionColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, NULL)`,
+		session.ID, session.Username, session.ClientIP, session.UserAgent, session.SessionVersion,
+		session.CreatedAt.UnixMilli(), session.LastUsedAt.UnixMilli(), session.ExpiresAt.UnixMilli()); err != nil {
+		return fmt.Errorf("failed to create session: %w", err)
+	}
+	if _, err := tx.Exec(`INSERT INTO refresh_tokens (token_hash, session_id, issued_at) VALUES (?, ?, ?)`,
+		refreshHash, session.ID, session.CreatedAt.UnixMilli()); err != nil {
+		return fmt.Errorf("failed to create session: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to create session: %w", err)
+	}
+	return nil
+}
+
+// Get returns a session, or ErrSessionNotFound.
+func (s *SQLiteSessionStore) Get(id string) (models.Session, error) {
+	session, err := scanSession(s.db.QueryRow(`SELECT `+sessionColumns+` FROM sessions WHERE id = ?`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.Session{}, fmt.Errorf("%w: '%s'", ErrSessionNotFound, id)
+	}
+	return session, err
+}
+
+// List returns the sessions of a user that are neither revoked nor
+// expired, most recently used first.
+func (s *SQLiteSessionStore) List(username string) ([]models.Session, error) {
+	rows, err := s.db.Query(`SELECT `+sessionColumns+` FROM sessions
+		WHERE username = ? AND revoked_at IS NULL AND expires_at > ?
+		ORDER BY last_used_at DESC`, username, time.Now().UnixMilli())
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sessions: %w", err)
+	}
+	defer rows.Close()
+
+	sessions := []models.Session{}
+	for rows.Next() {
+		session, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list sessions: %w", err)
+	}
+	return sessions, nil
+}
+
+// Refresh exchanges the refresh token with hash oldHash for one with hash
+// newHash, extends the session to expiresAt and records where it was used
+// from. A refresh token that was already exchanged revokes its session and
+// returns ErrRefreshTokenReused.
+func (s *SQLiteSessionStore) Refresh(oldHash, newHash, clientIP, userAgent string, expiresAt time.Time) (models.Session, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return models.Session{}, fmt.Errorf("failed to refresh session: %w", err)
+	}
+	defer tx.Rollback()
+
+	var sessionID string
+	var usedAt sql.NullInt64
+	err = tx.QueryRow(`SELECT session_id, used_at FROM refresh_tokens WHERE token_hash = ?`, oldHash).Scan(&sessionID, &usedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.Session{}, ErrSessionNotFound
+	}
+	if err != nil {
+		return models.Session{}, fmt.Errorf("failed to refresh session: %w", err)
+	}
+	session, err := scanSession(tx.QueryRow(`SELECT `+sessionColumns+` FROM sessions WHERE id = ?`, sessionID))
+	if err != nil {
+		return models.Session{}, fmt.Errorf("failed to refresh session: %w", err)
+	}
+
+	now := time.Now()
+	if usedAt.Valid {
+		// Someone kept a copy of an old token: end the session for everyone
+		if session.RevokedAt == nil {
+			if _, err := tx.Exec(`UPDATE sessions SET revoked_at = ? WHERE id = ?`, now.UnixMilli(), sessionID); err != nil {
+				return models.Session{}, fmt.Errorf("failed to revoke session: %w", err)
+			}
+			if err := tx.Commit(); err != nil {
+				return models.Session{}, fmt.Errorf("failed to revoke session: %w", err)
+			}
+		}
+		return session, fmt.Errorf("%w: session '%s'", ErrRefreshTokenReused, sessionID)
+	}
+	if session.RevokedAt != nil || !now.Before(session.ExpiresAt) {
+		return session, fmt.Errorf("%w: '%s'", ErrSessionRevoked, sessionID)
+	}
+
+	if _, err := tx.Exec(`UPDATE refresh_tokens SET used_at = ? WHERE token_hash = ?`, now.UnixMilli(), oldHash); err != nil {
+		return models.Session{}, fmt.Errorf("failed to refresh session: %w", err)
+	}
+	if _, err := tx.Exec(`INSERT INTO refresh_tokens (token_hash, session_id, issued_at) VALUES (?, ?, ?)`,
+		newHash, sessionID, now.UnixMilli()); err != nil {
+		return models.Session{}, fmt.Errorf("failed to refresh session: %w", err)
+	}
+	if _, err := tx.Exec(`UPDATE sessions SET client_ip = ?, user_agent = ?, last_used_at = ?, expires_at = ? WHERE id = ?`,
+		clientIP, userAgent, now.UnixMilli(), expiresAt.UnixMilli(), sessionID); err != nil {
+		return models.Session{}, fmt.Errorf("failed to refresh session: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return models.Session{}, fmt.Errorf("failed to refresh session: %w", err)
+	}
+
+	session.ClientIP, session.UserAgent = clientIP, userAgent
+	session.LastUsedAt = time.UnixMilli(now.UnixMilli()).UTC()
+	session.ExpiresAt = time.UnixMilli(expiresAt.UnixMilli()).UTC()
+	return session, nil
+}
+
+// Revoke ends a session, or returns ErrSessionNotFound.
+func (s *SQLiteSessionStore) Revoke(id string) error {
+	result, err := s.db.Exec(`UPDATE sessions SET revoked_at = COALESCE(revoked_at, ?) WHERE id = ?`, time.Now().UnixMilli(), id)
+	if err != nil {
+		return fmt.Errorf("failed to revoke session: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("%w: '%s'", ErrSessionNotFound, id)
+	}
+	return nil
+}
+
+// RevokeUser ends every active session of a user and returns how many
+// there were.
+func (s *SQLiteSessionStore) RevokeUser(username string) (int, error) {
+	result, err := s.db.Exec(`UPDATE sessions SET revoked_at = ? WHERE username = ? AND revoked_at IS NULL`, time.Now().UnixMilli(), username)
+	if err != nil {
+		return 0, fmt.Errorf("failed to revoke sessions: %w", err)
+	}
+	n, _ := result.RowsAffected()
+	return int(n), nil
+}
+
+// RevokeToken adds an access token id to the revocation list until the
+// token expires.
+func (s *SQLiteSessionStore) RevokeToken(jti string, expiresAt time.Time) error {
+	if _, err := s.db.Exec(`INSERT INTO revoked_tokens (jti, expires_at) VALUES (?, ?) ON CONFLICT (jti) DO NOTHING`,
+		jti, expiresAt.UnixMilli()); err != nil {
+		return fmt.Errorf("failed to revoke token: %w", err)
+	}
+	return nil
+}
+
+// TokenRevoked reports whether an access token id is on the revocation list.
+func (s *SQLiteSessionStore) TokenRevoked(jti string) (bool, error) {
+	var n int
+	if err := s.db.QueryRow(`SELECT COUNT(*) FROM revoked_tokens WHERE jti = ?`, jti).Scan(&n); err != nil {
+		return false, fmt.Errorf("failed to check token: %w", err)
+	}
+	return n > 0, nil
+}
+
+// Prune removes expired and revoked sessions, together with their refresh
+// tokens, and revoked access tokens that have expired anyway.
+func (s *SQLiteSessionStore) Prune() (int, error) {
+	now := time.Now().UnixMilli()
+	result, err := s.db.Exec(`DELETE FROM sessions WHERE expires_at <= ? OR revoked_at IS NOT NULL`, now)
+	if err != nil {
+		return 0, fmt.Errorf("failed to prune sessions: %w", err)
+	}
+	pruned, _ := result.RowsAffected()
+	if _, err := s.db.Exec(`DELETE FROM revoked_tokens WHERE expires_at <= ?`, now); err != nil {
+		return int(pruned), fmt.Errorf("failed to prune revoked tokens: %w", err)
+	}
+	return int(pruned), nil
+}
+
+// Close closes the database.
+func (s *SQLiteSessionStore) Close() error {
+	return s.db.Close()
+}
+
+func scanSession(row rowScanner) (models.Session, error) {
+	var session models.Session
+	var createdAt, lastUsedAt, expiresAt int64
+	var revokedAt sql.NullInt64
+	if err := row.Scan(&session.ID, &session.Username, &session.ClientIP, &session.UserAgent, &session.SessionVersion,
+		&createdAt, &lastUsedAt, &expiresAt, &revokedAt); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.Session{}, err
+		}
+		return models.Session{}, fmt.Errorf("failed to read session: %w", err)
+	}
+	session.CreatedAt = time.UnixMilli(createdAt).UTC()
+	session.LastUsedAt = time.UnixMilli(lastUsedAt).UTC()
+	session.ExpiresAt = time.UnixMilli(expiresAt).UTC()
+	if revokedAt.Valid {
+		t := time.UnixMilli(revokedAt.Int64).UTC()
+		session.RevokedAt = &t
+	}
+	return session, nil
+}
@@ -0,0 +1,130 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"wordpress-collab-tool/models"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// withSessions points the session store at a fresh database.
+func withSessions(t *testing.T) {
+	t.Helper()
+	store, err := OpenSQLiteSessionStore(filepath.Join(t.TempDir(), "sessions.db"))
+	if err != nil {
+		t.Fatalf("OpenSQLiteSessionStore: %v", err)
+	}
+	original, originalKey := Sessions, JWTKey
+	Sessions, JWTKey = store, []byte("test signing key")
+	t.Cleanup(func() {
+		Sessions, JWTKey = original, originalKey
+		store.Close()
+	})
+}
+
+func claimsOf(t *testing.T, token string) *models.Claims {
+	t.Helper()
+	claims := &models.Claims{}
+	if _, err := jwt.ParseWithClaims(token, claims, func(*jwt.Token) (interface{}, error) { return JWTKey, nil }); err != nil {
+		t.Fatalf("parse token: %v", err)
+	}
+	return claims
+}
+
+func TestRefreshTokensRotate(t *testing.T) {
+	withUsers(t)
+	withSessions(t)
+	user, err := CreateUser("alice", "alice's password", RoleEditor)
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+
+	ctx := WithAudit(context.Background(), AuditInfo{ClientIP: "203.0.113.7", UserAgent: "laptop"})
+	first, err := StartSession(ctx, user)
+	if err != nil {
+		t.Fatalf("StartSession: %v", err)
+	}
+	claims := claimsOf(t, first.Token)
+	if claims.Id == "" || claims.SessionID != first.SessionID {
+		t.Fatalf("token lacks its ids: %+v", claims)
+	}
+
+	second, _, err := RefreshSession(WithAudit(context.Background(), AuditInfo{ClientIP: "198.51.100.1", UserAgent: "phone"}), first.RefreshToken)
+	if err != nil {
+		t.Fatalf("RefreshSession: %v", err)
+	}
+	if second.SessionID != first.SessionID || second.RefreshToken == first.RefreshToken {
+		t.Fatalf("refresh did not rotate the token within the session: %+v", second)
+	}
+	sessions, err := ListSessions("alice")
+	if err != nil || len(sessions) != 1 || sessions[0].ClientIP != "198.51.100.1" || sessions[0].UserAgent != "phone" {
+		t.Fatalf("ListSessions = %+v, %v; want the last client", sessions, err)
+	}
+
+	// A used refresh token ends the session, for the thief and the owner alike
+	if _, _, err := RefreshSession(ctx, first.RefreshToken); !errors.Is(err, ErrRefreshTokenReused) {
+		t.Fatalf("reusing a refresh token: %v, want ErrRefreshTokenReused", err)
+	}
+	if _, _, err := RefreshSession(ctx, second.RefreshToken); !errors.Is(err, ErrSessionRevoked) {
+		t.Errorf("refresh after reuse: %v, want ErrSessionRevoked", err)
+	}
+	if err := CheckSession(claimsOf(t, second.Token)); !errors.Is(err, ErrSessionRevoked) {
+		t.Errorf("access token after reuse: %v, want ErrSessionRevoked", err)
+	}
+	if _, _, err := RefreshSession(ctx, "made up"); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("unknown refresh token: %v, want ErrSessionNotFound", err)
+	}
+}
+
+func TestEndingSessions(t *testing.T) {
+	withUsers(t)
+	withSessions(t)
+	user, err := CreateUser("alice", "alice's password", RoleEditor)
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	laptop, _ := StartSession(context.Background(), user)
+	phone, _ := StartSession(context.Background(), user)
+
+	// Logging out revokes the token itself and its session
+	claims := claimsOf(t, laptop.Token)
+	if err := EndSession(claims); err != nil {
+		t.Fatalf("EndSession: %v", err)
+	}
+	if revoked, _ := Sessions.TokenRevoked(claims.Id); !revoked {
+		t.Error("token is not on the revocation list")
+	}
+	if err := CheckSession(claims); !errors.Is(err, ErrSessionRevoked) {
+		t.Errorf("CheckSession after logout: %v", err)
+	}
+	if err := CheckSession(claimsOf(t, phone.Token)); err != nil {
+		t.Errorf("other session ended too: %v", err)
+	}
+
+	// Users can only revoke their own sessions
+	if _, err := RevokeSession("bob", phone.SessionID); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("revoking another user's session: %v", err)
+	}
+
+	// A password change ends every session
+	if _, err := SetPassword("alice", "alice's new password"); err != nil {
+		t.Fatalf("SetPassword: %v", err)
+	}
+	if sessions, _ := ListSessions("alice"); len(sessions) != 0 {
+		t.Errorf("sessions survived a password change: %+v", sessions)
+	}
+
+	user, _ = Users.Get("alice")
+	tablet, _ := StartSession(context.Background(), user)
+	ended, err := EndAllSessions("alice")
+	if err != nil || ended != 1 {
+		t.Fatalf("EndAllSessions = %d, %v; want 1", ended, err)
+	}
+	if _, _, err := RefreshSession(context.Background(), tablet.RefreshToken); !errors.Is(err, ErrSessionRevoked) {
+		t.Errorf("refresh after logging out everywhere: %v", err)
+	}
+}
@@ -0,0 +1,218 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Remote commands are built from the helpers below instead of fmt.Sprintf so
+// that every argument is passed to the remote shell as exactly one word.
+
+// safeShellWord matches words that need no quoting. Keeping them bare leaves
+// the logged commands readable.
+var safeShellWord = regexp.MustCompile(`^[A-Za-z0-9_@%+=:,./-]+$`)
+
+// ShellQuote quotes s so that a POSIX shell reads it as a single literal word.
+func ShellQuote(s string) string {
+	if s == "" {
+		return "''"
+	}
+	if safeShellWord.MatchString(s) {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'"'"'`) + "'"
+}
+
+// Cmd builds a simple command, quoting the program name and every argument.
+func Cmd(name string, args ...string) string {
+	words := make([]string, 0, len(args)+1)
+	words = append(words, ShellQuote(name))
+	for _, arg := range args {
+		words = append(words, ShellQuote(arg))
+	}
+	return strings.Join(words, " ")
+}
+
+// And chains commands so that each runs only if the previous one succeeded.
+func And(commands ...string) string {
+	return strings.Join(commands, " && ")
+}
+
+// Pipe connects the stdout of each command to the stdin of the next.
+func Pipe(commands ...string) string {
+	return strings.Join(commands, " | ")
+}
+
+// PipeFail is Pipe run by bash with pipefail, so that the pipeline fails
+// when any of its commands does and not only when the last one does.
+func PipeFail(commands ...string) string {
+	return Cmd("bash", "-o", "pipefail", "-c", Pipe(commands...))
+}
+
+// RedirectTo sends the stdout of command to path.
+func RedirectTo(command, path string) string {
+	return command + " > " + ShellQuote(path)
+}
+
+// Compose builds a docker compose command for the project in remotePath. It
+// does not change directory; use ComposeCmd unless the command is part of a pipe.
+func Compose(remotePath string, args ...string) string {
+	return Cmd("docker", append([]string{"compose", "-f", remotePath + "/docker-compose.yml"}, args...)...)
+}
+
+// ComposeCmd runs a docker compose command from inside remotePath.
+func ComposeCmd(remotePath string, args ...string) string {
+	return And(Cmd("cd", remotePath), Compose(remotePath, args...))
+}
+
+// secretArguments are the prefixes of arguments that carry a password.
+var secretArguments = []string{"MYSQL_PWD=", "--admin_password="}
+
+// redactCommand masks the passwords in command so that it can be logged. It
+// also looks inside quoted scripts, such as the one PipeFail hands to bash.
+func redactCommand(command string) string {
+	var b strings.Builder
+	for i := 0; i < len(command); {
+		if isShellSpace(command[i]) {
+			b.WriteByte(command[i])
+			i++
+			continue
+		}
+		end, word := shellWord(command, i)
+		if redacted, ok := redactArgument(word); ok {
+			b.WriteString(ShellQuote(redacted))
+		} else {
+			b.WriteString(command[i:end])
+		}
+		i = end
+	}
+	return b.String()
+}
+
+// redactArgument masks the password in the unquoted word, reporting whether
+// it had one.
+func redactArgument(word string) (string, bool) {
+	for _, prefix := range secretArguments {
+		at := strings.Index(word, prefix)
+		if at < 0 {
+			continue
+		}
+		if at > 0 && strings.ContainsAny(word, " \t\n") {
+			// A script passed as one argument
+			return redactCommand(word), true
+		}
+		return word[:at] + prefix + "***", true
+	}
+	return "", false
+}
+
+// shellWord reads the word of command that starts at start. It returns
+// where the word ends and its value once unquoted.
+func shellWord(command string, start int) (int, string) {
+	var value strings.Builder
+	i := start
+	for i < len(command) && !isShellSpace(command[i]) {
+		switch command[i] {
+		case '\'':
+			end := strings.IndexByte(command[i+1:], '\'')
+			if end < 0 {
+				end = len(command) - i - 1
+			}
+			value.WriteString(command[i+1 : i+1+end])
+			i += end + 2
+		case '"':
+			for i++; i < len(command) && command[i] != '"'; i++ {
+				if command[i] == '\\' && i+1 < len(command) {
+					i++
+				}
+				value.WriteByte(command[i])
+			}
+			i++
+		case '\\':
+			if i+1 < len(command) {
+				value.WriteByte(command[i+1])
+			}
+			i += 2
+		default:
+			value.WriteByte(command[i])
+			i++
+		}
+	}
+	return min(i, len(command)), value.String()
+}
+
+func isShellSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n'
+}
+
+// ErrInvalidInput is wrapped by the validation errors below.
+var ErrInvalidInput = errors.New("invalid input")
+
+var (
+	// projectNamePattern accepts DNS labels: lowercase letters, digits and
+	// inner hyphens, at most 63 characters.
+	projectNamePattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+	// pluginSlugPattern accepts wordpress.org plugin slugs.
+	pluginSlugPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,199}$`)
+	// backupFilePattern accepts the archive names produced by CreateBackup.
+	backupFilePattern = regexp.MustCompile(`^backup-\d{4}-\d{2}-\d{2}-\d{2}-\d{2}-\d{2}\.tar\.gz$`)
+	// tableNamePattern accepts unquoted MariaDB table names.
+	tableNamePattern = regexp.MustCompile(`^[A-Za-z0-9_$]{1,64}$`)
+	// adminUsernamePattern mirrors WordPress' strict sanitize_user rules.
+	adminUsernamePattern = regexp.MustCompile(`^[A-Za-z0-9_.@][A-Za-z0-9 _.@-]{0,59}$`)
+)
+
+// ValidateProjectName checks that name is a DNS-label-safe project name.
+func ValidateProjectName(name string) error {
+	if !projectNamePattern.MatchString(name) {
+		return fmt.Errorf("%w: project name must be 1-63 lowercase letters, digits or hyphens and must not start or end with a hyphen", ErrInvalidInput)
+	}
+	return nil
+}
+
+// ValidatePluginSlug checks that slug is a wordpress.org plugin slug.
+func ValidatePluginSlug(slug string) error {
+	if !pluginSlugPattern.MatchString(slug) {
+		return fmt.Errorf("%w: plugin name must be a plugin slug made of lowercase letters, digits, hyphens and underscores", ErrInvalidInput)
+	}
+	return nil
+}
+
+// ValidateBackupFile checks that file is a backup archive name created by CreateBackup.
+func ValidateBackupFile(file string) error {
+	if !backupFilePattern.MatchString(file) {
+		return fmt.Errorf("%w: backup file must look like backup-YYYY-MM-DD-HH-MM-SS.tar.gz", ErrInvalidInput)
+	}
+	return nil
+}
+
+// ValidateTableName checks that table is a plain database table name.
+func ValidateTableName(table string) error {
+	if !tableNamePattern.MatchString(table) {
+		return fmt.Errorf("%w: table name must be 1-64 letters, digits, underscores or dollar signs", ErrInvalidInput)
+	}
+	return nil
+}
+
+// ValidateAdminUsername checks that username is a valid WordPress login.
+func ValidateAdminUsername(username string) error {
+	if !adminUsernamePattern.MatchString(username) {
+		return fmt.Errorf("%w: admin username may only contain letters, digits, spaces and _ . - @", ErrInvalidInput)
+	}
+	return nil
+}
+
+// ValidateAdminPassword checks that password is usable as a WordPress password.
+func ValidateAdminPassword(password string) error {
+	if password == "" || len(password) > 128 {
+		return fmt.Errorf("%w: admin password must be between 1 and 128 characters", ErrInvalidInput)
+	}
+	for _, r := range password {
+		if r < 0x20 || r == 0x7f {
+			return fmt.Errorf("%w: admin password must not contain control characters", ErrInvalidInput)
+		}
+	}
+	return nil
+}
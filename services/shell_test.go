@@ -0,0 +1,140 @@
+package services
+
+import (
+	"errors"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+// FuzzShellQuote checks that a quoted string always reaches the shell as
+// exactly one argument with its original value.
+func FuzzShellQuote(f *testing.F) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		f.Skip("sh is not available")
+	}
+	seeds := []string{
+		"",
+		"plain",
+		"with space",
+		"'; touch /tmp/pwned; '",
+		"$(id)",
+		"`id`",
+		"a\nb",
+		`"double" and 'single'`,
+		"back\\slash",
+		"--admin_password=x' --debug '",
+		"*",
+		"~root",
+		"$HOME",
+		"a;b|c&d>e<f",
+		"-n",
+	}
+	for _, seed := range seeds {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, s string) {
+		if strings.ContainsRune(s, 0) {
+			t.Skip("shell arguments cannot contain NUL")
+		}
+		script := "set -- " + ShellQuote(s) + `; printf '%d\n%s' "$#" "$1"`
+		out, err := exec.Command("sh", "-c", script).Output()
+		if err != nil {
+			t.Fatalf("sh failed for %q (quoted as %s): %v", s, ShellQuote(s), err)
+		}
+		if want := "1\n" + s; string(out) != want {
+			t.Fatalf("quoted %q as %s, shell saw %q", s, ShellQuote(s), out)
+		}
+	})
+}
+
+func TestCmdQuotesArguments(t *testing.T) {
+	got := Cmd("tar", "-xzf", "/var/www/backups/x; rm -rf /", "-C", "/tmp")
+	want := `tar -xzf '/var/www/backups/x; rm -rf /' -C /tmp`
+	if got != want {
+		t.Errorf("Cmd = %s, want %s", got, want)
+	}
+}
+
+func TestPipeFailFailsOnEarlierCommands(t *testing.T) {
+	if _, err := exec.LookPath("bash"); err != nil {
+		t.Skip("bash is not available")
+	}
+	if err := exec.Command("sh", "-c", PipeFail(Cmd("false"), Cmd("cat"))).Run(); err == nil {
+		t.Error("pipeline succeeded although its first command failed")
+	}
+	if err := exec.Command("sh", "-c", PipeFail(Cmd("echo", "it's fine"), Cmd("cat"))).Run(); err != nil {
+		t.Errorf("pipeline failed: %v", err)
+	}
+}
+
+func TestValidators(t *testing.T) {
+	tests := []struct {
+		name     string
+		validate func(string) error
+		value    string
+		valid    bool
+	}{
+		{"project", ValidateProjectName, "blog", true},
+		{"project with hyphen", ValidateProjectName, "my-blog-2", true},
+		{"project uppercase", ValidateProjectName, "Blog", false},
+		{"project leading hyphen", ValidateProjectName, "-blog", false},
+		{"project trailing hyphen", ValidateProjectName, "blog-", false},
+		{"project injection", ValidateProjectName, "blog;id", false},
+		{"project too long", ValidateProjectName, strings.Repeat("a", 64), false},
+		{"plugin", ValidatePluginSlug, "contact-form-7", true},
+		{"plugin underscore", ValidatePluginSlug, "wp_mail_smtp", true},
+		{"plugin injection", ValidatePluginSlug, "akismet --activate; id", false},
+		{"plugin path", ValidatePluginSlug, "../akismet", false},
+		{"backup", ValidateBackupFile, "backup-2025-09-15-10-00-00.tar.gz", true},
+		{"backup traversal", ValidateBackupFile, "../../etc/passwd", false},
+		{"backup injection", ValidateBackupFile, "backup-2025-09-15-10-00-00.tar.gz; id", false},
+		{"username", ValidateAdminUsername, "site admin", true},
+		{"username quote", ValidateAdminUsername, "admin'", false},
+		{"password", ValidateAdminPassword, `p@ss 'w"ord$`, true},
+		{"password empty", ValidateAdminPassword, "", false},
+		{"password newline", ValidateAdminPassword, "pass\nword", false},
+	}
+	for _, tt := range tests {
+		err := tt.validate(tt.value)
+		if tt.valid && err != nil {
+			t.Errorf("%s: %q rejected: %v", tt.name, tt.value, err)
+		}
+		if !tt.valid && !errors.Is(err, ErrInvalidInput) {
+			t.Errorf("%s: %q accepted, want ErrInvalidInput", tt.name, tt.value)
+		}
+	}
+}
+
+func TestRedactCommandMasksPasswords(t *testing.T) {
+	const password = `a'b $c! --debug`
+	cases := []struct{ command, want string }{
+		{
+			ComposeCmd("/var/www/blog", "exec", "-T", "-e", "MYSQL_PWD=dbsecret", "blog_db", "mariadb-dump", "-u", "root", "blog_db"),
+			`exec -T -e 'MYSQL_PWD=***' blog_db mariadb-dump`,
+		},
+		{
+			ComposeCmd("/var/www/blog", "exec", "-T", "blog_cli", "wp", "core", "install", "--admin_user=admin", "--admin_password="+password),
+			`--admin_user=admin '--admin_password=***'`,
+		},
+		{
+			And(Cmd("cd", "/var/www/blog"), PipeFail(Cmd("cat", "dump.sql"), Compose("/var/www/blog", "exec", "-T", "-e", "MYSQL_PWD="+password, "blog_db", "mariadb", "-u", "root", "blog_db"))),
+			`MYSQL_PWD=***'"'"' blog_db mariadb -u root blog_db'`,
+		},
+	}
+	for _, c := range cases {
+		redacted := redactCommand(c.command)
+		if strings.Contains(redacted, "dbsecret") || strings.Contains(redacted, "$c!") || strings.Contains(redacted, "--debug") {
+			t.Errorf("password left in %s", redacted)
+		}
+		if !strings.Contains(redacted, c.want) {
+			t.Errorf("redacted command %s does not contain %s", redacted, c.want)
+		}
+	}
+
+	plain := Cmd("tar", "-xzf", "/var/www/backups/x; rm -rf /", "-C", "/tmp")
+	if got := redactCommand(plain); got != plain {
+		t.Errorf("redactCommand changed a command without passwords: %s", got)
+	}
+}
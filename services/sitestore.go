@@ -0,0 +1,132 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"wordpress-collab-tool/config"
+	"wordpress-collab-tool/models"
+	"wordpress-collab-tool/utils"
+)
+
+// Errors returned by SiteStore implementations, besides ErrSiteNotFound.
+var (
+	ErrSiteExists          = errors.New("site already exists")
+	ErrSiteVersionConflict = errors.New("site was modified concurrently")
+)
+
+// SiteStore persists sites. Every stored site carries a version that is
+// incremented on each update; Update only succeeds when the site passed in
+// still has the stored version, so concurrent writers cannot silently
+// overwrite each other.
+type SiteStore interface {
+	// Get returns a site, or ErrSiteNotFound.
+	Get(projectName string) (models.Site, error)
+	// List returns every site, ordered by project name.
+	List() ([]models.Site, error)
+	// Create stores a new site with version 1, or returns ErrSiteExists.
+	Create(site models.Site) (models.Site, error)
+	// Update replaces a site and returns it with its new version. It returns
+	// ErrSiteVersionConflict if the site changed since site.Version was read.
+	Update(site models.Site) (models.Site, error)
+	// Delete removes a site, or returns ErrSiteNotFound.
+	Delete(projectName string) error
+	Close() error
+}
+
+// Site store backends.
+const (
+	SiteStoreSQLite = "sqlite"
+	SiteStoreJSON   = "json"
+)
+
+// siteUpdateAttempts bounds how often UpdateSite retries after a conflict.
+const siteUpdateAttempts = 5
+
+// Sites is the store every site lookup and change goes through. Until
+// InitSiteStore runs it is the JSON file in the working directory.
+var Sites SiteStore = NewJSONSiteStore(sitesFilePath)
+
+// InitSiteStore opens the configured site store. The first time the SQLite
+// store is opened, sites are imported from an existing sites.json. Site
+// secrets are sealed with the configured master key; RotateSecrets
+// re-encrypts those stored in plain text or under a previous master key.
+func InitSiteStore(cfg *config.Config) error {
+	keys, err := LoadKeyring(cfg)
+	if err != nil {
+		return err
+	}
+
+	var store SiteStore
+	switch cfg.SiteStore {
+	case SiteStoreJSON:
+		store = NewJSONSiteStore(cfg.SitesFile)
+	case SiteStoreSQLite:
+		db, err := OpenSQLiteSiteStore(cfg.DatabasePath)
+		if err != nil {
+			return err
+		}
+		imported, err := db.ImportJSON(cfg.SitesFile)
+		if err != nil {
+			db.Close()
+			return err
+		}
+		if imported > 0 {
+			utils.LogInfo("Imported %d site(s) from %s into %s", imported, cfg.SitesFile, cfg.DatabasePath)
+		}
+		store = db
+	default:
+		return fmt.Errorf("unknown site store %q (expected %s or %s)", cfg.SiteStore, SiteStoreSQLite, SiteStoreJSON)
+	}
+
+	if _, err := os.Stat(cfg.SitesFile + importedSuffix); err == nil {
+		utils.LogInfo("%s still holds site secrets in plain text; delete it once the import has been verified", cfg.SitesFile+importedSuffix)
+	}
+	Sites = NewEncryptedSiteStore(store, keys)
+	return nil
+}
+
+// UpdateSite applies change to the stored site and saves it, reloading and
+// retrying if another writer got there first.
+func UpdateSite(projectName string, change func(*models.Site)) (models.Site, error) {
+	var err error
+	for attempt := 0; attempt < siteUpdateAttempts; attempt++ {
+		var site models.Site
+		site, err = Sites.Get(projectName)
+		if err != nil {
+			return models.Site{}, err
+		}
+		change(&site)
+		site, err = Sites.Update(site)
+		if !errors.Is(err, ErrSiteVersionConflict) {
+			return site, err
+		}
+	}
+	return models.Site{}, err
+}
+
+// ListSitesOrEmpty lists every site, returning an empty slice on error.
+func ListSitesOrEmpty() []models.Site {
+	sites, err := Sites.List()
+	if err != nil {
+		utils.LogError("Error listing sites, returning empty slice: %v", err)
+		return []models.Site{}
+	}
+	return sites
+}
+
+// importedSuffix is appended to sites.json once its sites have been imported.
+const importedSuffix = ".imported"
+
+// readSitesFile reads a sites.json file. A missing file holds no sites.
+func readSitesFile(path string) ([]models.Site, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return []models.Site{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read sites file: %w", err)
+	}
+	return decodeSites(data)
+}
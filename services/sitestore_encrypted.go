@@ -0,0 +1,139 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+
+	"wordpress-collab-tool/models"
+)
+
+// EncryptedSiteStore seals the secret fields of sites before handing them to
+// another SiteStore and opens them again on the way out, so secrets are only
+// ever stored encrypted.
+type EncryptedSiteStore struct {
+	store SiteStore
+	keys  *Keyring
+}
+
+// NewEncryptedSiteStore wraps store so that site secrets are sealed with keys.
+func NewEncryptedSiteStore(store SiteStore, keys *Keyring) *EncryptedSiteStore {
+	return &EncryptedSiteStore{store: store, keys: keys}
+}
+
+// Get returns a site with its secrets decrypted.
+func (s *EncryptedSiteStore) Get(projectName string) (models.Site, error) {
+	site, err := s.store.Get(projectName)
+	if err != nil {
+		return models.Site{}, err
+	}
+	return s.open(site)
+}
+
+// List returns every site with its secrets decrypted.
+func (s *EncryptedSiteStore) List() ([]models.Site, error) {
+	sites, err := s.store.List()
+	if err != nil {
+		return nil, err
+	}
+	for i := range sites {
+		if sites[i], err = s.open(sites[i]); err != nil {
+			return nil, err
+		}
+	}
+	return sites, nil
+}
+
+// Create stores a new site with its secrets sealed.
+func (s *EncryptedSiteStore) Create(site models.Site) (models.Site, error) {
+	sealed, err := s.seal(site)
+	if err != nil {
+		return models.Site{}, err
+	}
+	created, err := s.store.Create(sealed)
+	if err != nil {
+		return models.Site{}, err
+	}
+	site.Version = created.Version
+	return site, nil
+}
+
+// Update replaces a site, sealing its secrets under the current master key.
+func (s *EncryptedSiteStore) Update(site models.Site) (models.Site, error) {
+	sealed, err := s.seal(site)
+	if err != nil {
+		return models.Site{}, err
+	}
+	updated, err := s.store.Update(sealed)
+	if err != nil {
+		return models.Site{}, err
+	}
+	site.Version = updated.Version
+	return site, nil
+}
+
+// Delete removes a site.
+func (s *EncryptedSiteStore) Delete(projectName string) error {
+	return s.store.Delete(projectName)
+}
+
+// Close closes the underlying store.
+func (s *EncryptedSiteStore) Close() error {
+	return s.store.Close()
+}
+
+// MigrateSecrets seals the secrets of sites still stored in plain text and
+// re-encrypts those sealed under a previous master key with the current one.
+// It returns how many sites were rewritten.
+func (s *EncryptedSiteStore) MigrateSecrets() (int, error) {
+	sites, err := s.store.List()
+	if err != nil {
+		return 0, err
+	}
+	migrated := 0
+	for _, stored := range sites {
+		if stored.Sealed != nil && stored.Sealed.KeyID == s.keys.CurrentKeyID() && stored.DBPassword == "" && stored.AdminPassword == "" {
+			continue
+		}
+		site, err := s.open(stored)
+		if err != nil {
+			return migrated, err
+		}
+		_, err = s.Update(site)
+		if errors.Is(err, ErrSiteVersionConflict) {
+			continue // changed meanwhile, and sealed with the current key by that change
+		}
+		if err != nil {
+			return migrated, fmt.Errorf("failed to migrate secrets of site '%s': %w", site.ProjectName, err)
+		}
+		migrated++
+	}
+	return migrated, nil
+}
+
+// seal returns the stored form of site: secrets sealed, plain fields cleared.
+func (s *EncryptedSiteStore) seal(site models.Site) (models.Site, error) {
+	sealed, err := s.keys.Seal(site.ProjectName, models.SiteSecrets{DBPassword: site.DBPassword, AdminPassword: site.AdminPassword})
+	if err != nil {
+		return models.Site{}, fmt.Errorf("failed to seal secrets of site '%s': %w", site.ProjectName, err)
+	}
+	site.DBPassword = ""
+	site.AdminPassword = ""
+	site.Sealed = sealed
+	return site, nil
+}
+
+// open returns site with its secrets decrypted. Sites without sealed secrets
+// have not been migrated yet and are returned as stored.
+func (s *EncryptedSiteStore) open(site models.Site) (models.Site, error) {
+	if site.Sealed == nil {
+		return site, nil
+	}
+	secrets, err := s.keys.Open(site.ProjectName, site.Sealed)
+	if err != nil {
+		return models.Site{}, fmt.Errorf("failed to open secrets of site '%s': %w", site.ProjectName, err)
+	}
+	site.DBPassword = secrets.DBPassword
+	site.AdminPassword = secrets.AdminPassword
+	site.Sealed = nil
+	return site, nil
+}
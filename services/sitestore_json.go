@@ -0,0 +1,190 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"wordpress-collab-tool/models"
+)
+
+// JSONSiteStore keeps sites in a JSON file. Each change holds an exclusive
+// lock on a companion .lock file while it reads and rewrites the sites, and
+// the new contents are written to a temporary file that is renamed over the
+// old one, so readers never see a partial file.
+type JSONSiteStore struct {
+	mu   sync.Mutex
+	path string
+}
+
+// NewJSONSiteStore returns a store backed by the JSON file at path. The file
+// is created on the first write.
+func NewJSONSiteStore(path string) *JSONSiteStore {
+	return &JSONSiteStore{path: path}
+}
+
+// Get returns a site, or ErrSiteNotFound.
+func (s *JSONSiteStore) Get(projectName string) (models.Site, error) {
+	sites, err := s.read()
+	if err != nil {
+		return models.Site{}, err
+	}
+	for _, site := range sites {
+		if site.ProjectName == projectName {
+			return site, nil
+		}
+	}
+	return models.Site{}, fmt.Errorf("%w: '%s'", ErrSiteNotFound, projectName)
+}
+
+// List returns every site, ordered by project name.
+func (s *JSONSiteStore) List() ([]models.Site, error) {
+	sites, err := s.read()
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(sites, func(i, j int) bool { return sites[i].ProjectName < sites[j].ProjectName })
+	return sites, nil
+}
+
+// Create stores a new site with version 1.
+func (s *JSONSiteStore) Create(site models.Site) (models.Site, error) {
+	err := s.modify(func(sites []models.Site) ([]models.Site, error) {
+		for _, existing := range sites {
+			if existing.ProjectName == site.ProjectName {
+				return nil, fmt.Errorf("%w: '%s'", ErrSiteExists, site.ProjectName)
+			}
+		}
+		site.Version = 1
+		return append(sites, site), nil
+	})
+	if err != nil {
+		return models.Site{}, err
+	}
+	return site, nil
+}
+
+// Update replaces a site if its version still matches the stored one.
+func (s *JSONSiteStore) Update(site models.Site) (models.Site, error) {
+	err := s.modify(func(sites []models.Site) ([]models.Site, error) {
+		for i := range sites {
+			if sites[i].ProjectName != site.ProjectName {
+				continue
+			}
+			if sites[i].Version != site.Version {
+				return nil, fmt.Errorf("%w: '%s' is at version %d, not %d", ErrSiteVersionConflict, site.ProjectName, sites[i].Version, site.Version)
+			}
+			site.Version++
+			sites[i] = site
+			return sites, nil
+		}
+		return nil, fmt.Errorf("%w: '%s'", ErrSiteNotFound, site.ProjectName)
+	})
+	if err != nil {
+		return models.Site{}, err
+	}
+	return site, nil
+}
+
+// Delete removes a site.
+func (s *JSONSiteStore) Delete(projectName string) error {
+	return s.modify(func(sites []models.Site) ([]models.Site, error) {
+		for i := range sites {
+			if sites[i].ProjectName == projectName {
+				return append(sites[:i], sites[i+1:]...), nil
+			}
+		}
+		return nil, fmt.Errorf("%w: '%s'", ErrSiteNotFound, projectName)
+	})
+}
+
+// Close does nothing; the file is only open during each call.
+func (s *JSONSiteStore) Close() error {
+	return nil
+}
+
+// read loads the sites under a shared lock.
+func (s *JSONSiteStore) read() ([]models.Site, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	unlock, err := lockFile(s.path+".lock", false)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	return readSitesFile(s.path)
+}
+
+// modify rewrites the sites with change under an exclusive lock. Nothing is
+// written if change returns an error.
+func (s *JSONSiteStore) modify(change func([]models.Site) ([]models.Site, error)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	unlock, err := lockFile(s.path+".lock", true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	sites, err := readSitesFile(s.path)
+	if err != nil {
+		return err
+	}
+	sites, err = change(sites)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(sites, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal sites data: %w", err)
+	}
+	return writeFileAtomic(s.path, data, 0644)
+}
+
+// decodeSites parses the contents of a sites.json file.
+func decodeSites(data []byte) ([]models.Site, error) {
+	if len(data) == 0 {
+		return []models.Site{}, nil
+	}
+	var sites []models.Site
+	if err := json.Unmarshal(data, &sites); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal sites data: %w", err)
+	}
+	return sites, nil
+}
+
+// writeFileAtomic replaces the file at path with data by writing a temporary
+// file in the same directory, syncing it and renaming it into place.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	defer os.Remove(tmp.Name()) // fails harmlessly once renamed
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write temporary file: %w", err)
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to set file permissions: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to sync temporary file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close temporary file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to replace %s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,238 @@
+package services
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"wordpress-collab-tool/models"
+	"wordpress-collab-tool/utils"
+)
+
+// SQLiteSiteStore keeps sites in an embedded SQLite database. Each site is
+// stored as JSON next to the columns used for lookups and versioning.
+type SQLiteSiteStore struct {
+	db *sql.DB
+}
+
+var siteMigrations = []migration{
+	{
+		name: "create sites",
+		statements: []string{
+			`CREATE TABLE sites (
+				project_name TEXT PRIMARY KEY,
+				host TEXT NOT NULL DEFAULT '',
+				version INTEGER NOT NULL,
+				data TEXT NOT NULL,
+				created_at TEXT NOT NULL,
+				updated_at TEXT NOT NULL
+			)`,
+			`CREATE INDEX sites_host ON sites (host)`,
+		},
+	},
+}
+
+// OpenSQLiteSiteStore opens the site database at path, creating it if needed.
+func OpenSQLiteSiteStore(path string) (*SQLiteSiteStore, error) {
+	db, err := openSQLite(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := migrate(db, siteMigrations); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &SQLiteSiteStore{db: db}, nil
+}
+
+// Get returns a site, or ErrSiteNotFound.
+func (s *SQLiteSiteStore) Get(projectName string) (models.Site, error) {
+	var data string
+	var version int64
+	err := s.db.QueryRow(`SELECT data, version FROM sites WHERE project_name = ?`, projectName).Scan(&data, &version)
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.Site{}, fmt.Errorf("%w: '%s'", ErrSiteNotFound, projectName)
+	}
+	if err != nil {
+		return models.Site{}, fmt.Errorf("failed to read site: %w", err)
+	}
+	return decodeSite(data, version)
+}
+
+// List returns every site, ordered by project name.
+func (s *SQLiteSiteStore) List() ([]models.Site, error) {
+	rows, err := s.db.Query(`SELECT data, version FROM sites ORDER BY project_name`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sites: %w", err)
+	}
+	defer rows.Close()
+
+	sites := []models.Site{}
+	for rows.Next() {
+		var data string
+		var version int64
+		if err := rows.Scan(&data, &version); err != nil {
+			return nil, fmt.Errorf("failed to list sites: %w", err)
+		}
+		site, err := decodeSite(data, version)
+		if err != nil {
+			return nil, err
+		}
+		sites = append(sites, site)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list sites: %w", err)
+	}
+	return sites, nil
+}
+
+// Create stores a new site with version 1.
+func (s *SQLiteSiteStore) Create(site models.Site) (models.Site, error) {
+	site.Version = 1
+	inserted, err := s.insert(s.db, site)
+	if err != nil {
+		return models.Site{}, err
+	}
+	if !inserted {
+		return models.Site{}, fmt.Errorf("%w: '%s'", ErrSiteExists, site.ProjectName)
+	}
+	return site, nil
+}
+
+// Update replaces a site if its version still matches the stored one.
+func (s *SQLiteSiteStore) Update(site models.Site) (models.Site, error) {
+	expected := site.Version
+	site.Version++
+	data, err := encodeSite(site)
+	if err != nil {
+		return models.Site{}, err
+	}
+
+	result, err := s.db.Exec(`UPDATE sites SET host = ?, version = ?, data = ?, updated_at = ?
+		WHERE project_name = ? AND version = ?`,
+		site.Host, site.Version, data, dbTimestamp(), site.ProjectName, expected)
+	if err != nil {
+		return models.Site{}, fmt.Errorf("failed to update site: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 1 {
+		return site, nil
+	}
+
+	current, err := s.Get(site.ProjectName)
+	if err != nil {
+		return models.Site{}, err
+	}
+	return models.Site{}, fmt.Errorf("%w: '%s' is at version %d, not %d", ErrSiteVersionConflict, site.ProjectName, current.Version, expected)
+}
+
+// Delete removes a site.
+func (s *SQLiteSiteStore) Delete(projectName string) error {
+	result, err := s.db.Exec(`DELETE FROM sites WHERE project_name = ?`, projectName)
+	if err != nil {
+		return fmt.Errorf("failed to delete site: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("%w: '%s'", ErrSiteNotFound, projectName)
+	}
+	return nil
+}
+
+// Close closes the database.
+func (s *SQLiteSiteStore) Close() error {
+	return s.db.Close()
+}
+
+// ImportJSON copies the sites of a sites.json file into the database and
+// renames the file with an ".imported" suffix so that the import happens
+// only once. Sites that already exist in the database are left alone. It
+// returns how many sites were imported.
+func (s *SQLiteSiteStore) ImportJSON(path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to read sites file: %w", err)
+	}
+	sites, err := decodeSites(data)
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to import sites: %w", err)
+	}
+	imported := 0
+	for _, site := range sites {
+		if site.Version == 0 {
+			site.Version = 1
+		}
+		inserted, err := s.insert(tx, site)
+		if err != nil {
+			tx.Rollback()
+			return 0, err
+		}
+		if inserted {
+			imported++
+		} else {
+			utils.LogInfo("Skipping import of site '%s': it already exists in the database", site.ProjectName)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to import sites: %w", err)
+	}
+
+	if err := os.Rename(path, path+importedSuffix); err != nil {
+		utils.LogError("Imported sites from %s but failed to rename it: %v", path, err)
+	}
+	return imported, nil
+}
+
+// execer is satisfied by *sql.DB and *sql.Tx.
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+// insert adds a site unless one with the same name exists, and reports whether it did.
+func (s *SQLiteSiteStore) insert(db execer, site models.Site) (bool, error) {
+	data, err := encodeSite(site)
+	if err != nil {
+		return false, err
+	}
+	ts := dbTimestamp()
+	result, err := db.Exec(`INSERT INTO sites (project_name, host, version, data, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?) ON CONFLICT (project_name) DO NOTHING`,
+		site.ProjectName, site.Host, site.Version, data, ts, ts)
+	if err != nil {
+		return false, fmt.Errorf("failed to create site: %w", err)
+	}
+	n, _ := result.RowsAffected()
+	return n == 1, nil
+}
+
+func encodeSite(site models.Site) (string, error) {
+	data, err := json.Marshal(site)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal site: %w", err)
+	}
+	return string(data), nil
+}
+
+// decodeSite parses a stored site. The version column is authoritative.
+func decodeSite(data string, version int64) (models.Site, error) {
+	var site models.Site
+	if err := json.Unmarshal([]byte(data), &site); err != nil {
+		return models.Site{}, fmt.Errorf("failed to unmarshal site: %w", err)
+	}
+	site.Version = version
+	return site, nil
+}
+
+// dbTimestamp returns the current time as stored in the database.
+func dbTimestamp() string {
+	return time.Now().UTC().Format(time.RFC3339Nano)
+}
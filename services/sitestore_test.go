@@ -0,0 +1,218 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"wordpress-collab-tool/models"
+)
+
+// siteStores opens each SiteStore implementation in a fresh directory.
+func siteStores(t *testing.T) map[string]func() SiteStore {
+	return map[string]func() SiteStore{
+		"json": func() SiteStore {
+			return NewJSONSiteStore(filepath.Join(t.TempDir(), "sites.json"))
+		},
+		"sqlite": func() SiteStore {
+			store, err := OpenSQLiteSiteStore(filepath.Join(t.TempDir(), "data.db"))
+			if err != nil {
+				t.Fatalf("OpenSQLiteSiteStore: %v", err)
+			}
+			t.Cleanup(func() { store.Close() })
+			return store
+		},
+	}
+}
+
+func TestSiteStoreVersioning(t *testing.T) {
+	for name, open := range siteStores(t) {
+		t.Run(name, func(t *testing.T) {
+			store := open()
+
+			created, err := store.Create(testSite())
+			if err != nil {
+				t.Fatalf("Create: %v", err)
+			}
+			if created.Version != 1 {
+				t.Errorf("created version = %d, want 1", created.Version)
+			}
+			if _, err := store.Create(testSite()); !errors.Is(err, ErrSiteExists) {
+				t.Errorf("duplicate Create: expected ErrSiteExists, got %v", err)
+			}
+
+			first, _ := store.Get("blog")
+			second, _ := store.Get("blog")
+			first.Status = "stopped"
+			updated, err := store.Update(first)
+			if err != nil {
+				t.Fatalf("Update: %v", err)
+			}
+			if updated.Version != 2 {
+				t.Errorf("updated version = %d, want 2", updated.Version)
+			}
+
+			// second was read before the update and must not overwrite it
+			second.Status = "active"
+			if _, err := store.Update(second); !errors.Is(err, ErrSiteVersionConflict) {
+				t.Fatalf("stale Update: expected ErrSiteVersionConflict, got %v", err)
+			}
+			if got, _ := store.Get("blog"); got.Status != "stopped" || got.Version != 2 {
+				t.Errorf("stored site = %+v", got)
+			}
+
+			if _, err := store.Update(models.Site{ProjectName: "missing"}); !errors.Is(err, ErrSiteNotFound) {
+				t.Errorf("Update of missing site: expected ErrSiteNotFound, got %v", err)
+			}
+		})
+	}
+}
+
+func TestSiteStoreListAndDelete(t *testing.T) {
+	for name, open := range siteStores(t) {
+		t.Run(name, func(t *testing.T) {
+			store := open()
+			for _, project := range []string{"shop", "blog", "docs"} {
+				site := testSite()
+				site.ProjectName = project
+				if _, err := store.Create(site); err != nil {
+					t.Fatalf("Create: %v", err)
+				}
+			}
+
+			if err := store.Delete("docs"); err != nil {
+				t.Fatalf("Delete: %v", err)
+			}
+			if err := store.Delete("docs"); !errors.Is(err, ErrSiteNotFound) {
+				t.Errorf("second Delete: expected ErrSiteNotFound, got %v", err)
+			}
+			if _, err := store.Get("docs"); !errors.Is(err, ErrSiteNotFound) {
+				t.Errorf("Get after Delete: expected ErrSiteNotFound, got %v", err)
+			}
+
+			sites, err := store.List()
+			if err != nil {
+				t.Fatalf("List: %v", err)
+			}
+			if len(sites) != 2 || sites[0].ProjectName != "blog" || sites[1].ProjectName != "shop" {
+				t.Errorf("unexpected sites: %+v", sites)
+			}
+		})
+	}
+}
+
+func TestUpdateSiteLosesNoConcurrentChanges(t *testing.T) {
+	for name, open := range siteStores(t) {
+		t.Run(name, func(t *testing.T) {
+			original := Sites
+			Sites = open()
+			t.Cleanup(func() { Sites = original })
+
+			site := testSite()
+			site.Plugins = nil
+			Sites.Create(site)
+
+			const writers = 8
+			var wg sync.WaitGroup
+			for i := 0; i < writers; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					for {
+						_, err := UpdateSite("blog", func(s *models.Site) {
+							s.Plugins = append(s.Plugins, fmt.Sprintf("plugin-%d", i))
+						})
+						if !errors.Is(err, ErrSiteVersionConflict) {
+							if err != nil {
+								t.Errorf("UpdateSite: %v", err)
+							}
+							return
+						}
+					}
+				}(i)
+			}
+			wg.Wait()
+
+			got, _ := Sites.Get("blog")
+			if len(got.Plugins) != writers || got.Version != writers+1 {
+				t.Errorf("expected %d plugins at version %d, got %d at version %d", writers, writers+1, len(got.Plugins), got.Version)
+			}
+		})
+	}
+}
+
+func TestJSONSiteStoreSharesFileBetweenInstances(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sites.json")
+	a, b := NewJSONSiteStore(path), NewJSONSiteStore(path)
+
+	a.Create(testSite())
+	site, err := b.Get("blog")
+	if err != nil {
+		t.Fatalf("Get through second instance: %v", err)
+	}
+	if _, err := a.Update(site); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if _, err := b.Update(site); !errors.Is(err, ErrSiteVersionConflict) {
+		t.Errorf("expected ErrSiteVersionConflict across instances, got %v", err)
+	}
+
+	entries, _ := os.ReadDir(filepath.Dir(path))
+	for _, entry := range entries {
+		if entry.Name() != "sites.json" && entry.Name() != "sites.json.lock" {
+			t.Errorf("unexpected file left behind: %s", entry.Name())
+		}
+	}
+}
+
+func TestSQLiteSiteStoreImportsJSONOnce(t *testing.T) {
+	dir := t.TempDir()
+	jsonPath := filepath.Join(dir, "sites.json")
+	legacy := []byte(`[
+  {"projectName": "blog", "wpPort": 8200, "dbName": "blog_db", "status": "active", "plugins": ["akismet"]},
+  {"projectName": "shop", "wpPort": 8201, "dbName": "shop_db", "status": "failed", "host": "eu-1"}
+]`)
+	if err := os.WriteFile(jsonPath, legacy, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	dbPath := filepath.Join(dir, "data.db")
+	store, err := OpenSQLiteSiteStore(dbPath)
+	if err != nil {
+		t.Fatalf("OpenSQLiteSiteStore: %v", err)
+	}
+	imported, err := store.ImportJSON(jsonPath)
+	if err != nil || imported != 2 {
+		t.Fatalf("ImportJSON = %d, %v; want 2 sites", imported, err)
+	}
+	if _, err := os.Stat(jsonPath); !os.IsNotExist(err) {
+		t.Errorf("sites.json was not renamed after import")
+	}
+	if _, err := os.Stat(jsonPath + importedSuffix); err != nil {
+		t.Errorf("imported copy missing: %v", err)
+	}
+	if imported, _ := store.ImportJSON(jsonPath); imported != 0 {
+		t.Errorf("second import added %d sites", imported)
+	}
+	store.Close()
+
+	// The sites survive reopening the database
+	store, err = OpenSQLiteSiteStore(dbPath)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer store.Close()
+	shop, err := store.Get("shop")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if shop.Host != "eu-1" || shop.Status != "failed" || shop.Version != 1 {
+		t.Errorf("unexpected imported site: %+v", shop)
+	}
+	if blog, _ := store.Get("blog"); len(blog.Plugins) != 1 || blog.Plugins[0] != "akismet" {
+		t.Errorf("unexpected imported site: %+v", blog)
+	}
+}
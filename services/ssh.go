@@ -1,41 +1,225 @@
 package services
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
+	"net"
+	"os"
 	"strings"
 	"time"
 
 	"github.com/pkg/sftp"
 	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
 	"wordpress-collab-tool/config" // Import the config package
-	"wordpress-collab-tool/utils"  // Import the utils package for logging
+	"wordpress-collab-tool/models"
+	"wordpress-collab-tool/utils" // Import the utils package for logging
 )
 
-// GetSSHClient establishes an SSH connection to the remote server.
-func GetSSHClient(cfg *config.Config) (*ssh.Client, error) {
+// GetSSHClient checks out a pooled SSH connection to a host.
+// Callers must Close the client to return it to the pool.
+func GetSSHClient(ctx context.Context, host models.Host) (*PooledClient, error) {
+	if Pool == nil || HostKeys == nil {
+		cfg := config.LoadConfig()
+		if err := InitHostKeys(cfg); err != nil {
+			return nil, err
+		}
+		InitSSHPool(cfg)
+	}
+	return Pool.Get(ctx, host.SSH())
+}
+
+// GetSSHClientForSite checks out a pooled SSH connection to the host a site runs on.
+func GetSSHClientForSite(ctx context.Context, site models.Site) (*PooledClient, models.Host, error) {
+	host, err := HostForSite(site)
+	if err != nil {
+		return nil, models.Host{}, err
+	}
+	client, err := GetSSHClient(ctx, host)
+	if err != nil {
+		return nil, models.Host{}, err
+	}
+	return client, host, nil
+}
+
+// dialSSH establishes a new SSH connection to the remote server. The dial and
+// handshake are bounded by the configured dial timeout and by ctx.
+func dialSSH(ctx context.Context, cfg models.SSHConfig) (*ssh.Client, error) {
+	if HostKeys == nil {
+		return nil, fmt.Errorf("failed to dial SSH: host key store is not initialized")
+	}
+
+	auth, cleanup, err := sshAuthMethods(cfg)
+	if err != nil {
+		utils.LogError("Failed to prepare SSH authentication for %s: %v", cfg.Host, err)
+		return nil, fmt.Errorf("failed to prepare SSH authentication: %w", err)
+	}
+	defer cleanup()
+
 	sshConfig := &ssh.ClientConfig{
-		User: cfg.SSHUser,
-		Auth: []ssh.AuthMethod{
-			ssh.Password(cfg.SSHPassword),
-		},
-		    HostKeyCallback: ssh.InsecureIgnoreHostKey(), // TODO: Implement proper host key verification
-		Timeout:         5 * time.Minute,
+		User:            cfg.User,
+		Auth:            auth,
+		HostKeyCallback: HostKeys.HostKeyCallback,
+		Timeout:         timeouts.Dial,
 	}
 
-	client, err := ssh.Dial("tcp", fmt.Sprintf("%s:22", cfg.SSHHost), sshConfig)
+	ctx, cancel := context.WithTimeout(ctx, timeouts.Dial)
+	defer cancel()
+
+	dialer := net.Dialer{}
+	conn, err := dialer.DialContext(ctx, "tcp", cfg.Address())
 	if err != nil {
 		utils.LogError("Failed to dial SSH: %v", err)
 		return nil, fmt.Errorf("failed to dial SSH: %w", err)
 	}
-	utils.LogInfo("SSH connection established to %s", cfg.SSHHost)
+
+	// Abort the handshake if ctx ends before it completes.
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	sshConn, chans, reqs, err := ssh.NewClientConn(conn, cfg.Address(), sshConfig)
+	if !stop() || err != nil {
+		conn.Close()
+		if err == nil {
+			err = ctx.Err()
+		}
+		utils.LogError("Failed to dial SSH: %v", err)
+		return nil, fmt.Errorf("failed to dial SSH: %w", err)
+	}
+	conn.SetDeadline(time.Time{})
+	client := ssh.NewClient(sshConn, chans, reqs)
+	utils.LogInfo("SSH connection established to %s using %s authentication", cfg.Address(), cfg.AuthMethod)
 	return client, nil
 }
 
+// sshAuthMethods builds the SSH authentication methods for cfg. The returned
+// cleanup function releases resources (such as the agent socket) once the
+// handshake has completed.
+func sshAuthMethods(cfg models.SSHConfig) ([]ssh.AuthMethod, func(), error) {
+	noop := func() {}
+
+	switch cfg.AuthMethod {
+	case models.SSHAuthPassword, "":
+		if cfg.Password == "" {
+			return nil, noop, fmt.Errorf("password authentication requires a password")
+		}
+		return []ssh.AuthMethod{ssh.Password(cfg.Password)}, noop, nil
+
+	case models.SSHAuthKey:
+		signer, err := loadPrivateKey(cfg.PrivateKeyPath, cfg.Passphrase)
+		if err != nil {
+			return nil, noop, err
+		}
+		return []ssh.AuthMethod{ssh.PublicKeys(signer)}, noop, nil
+
+	case models.SSHAuthCertificate:
+		signer, err := loadPrivateKey(cfg.PrivateKeyPath, cfg.Passphrase)
+		if err != nil {
+			return nil, noop, err
+		}
+		certSigner, err := loadCertificateSigner(cfg.CertificatePath, signer)
+		if err != nil {
+			return nil, noop, err
+		}
+		return []ssh.AuthMethod{ssh.PublicKeys(certSigner)}, noop, nil
+
+	case models.SSHAuthAgent:
+		socket := os.Getenv("SSH_AUTH_SOCK")
+		if socket == "" {
+			return nil, noop, fmt.Errorf("agent authentication requires SSH_AUTH_SOCK to be set")
+		}
+		conn, err := net.Dial("unix", socket)
+		if err != nil {
+			return nil, noop, fmt.Errorf("failed to connect to ssh-agent: %w", err)
+		}
+		agentClient := agent.NewClient(conn)
+		return []ssh.AuthMethod{ssh.PublicKeysCallback(agentClient.Signers)}, func() { conn.Close() }, nil
+
+	default:
+		return nil, noop, fmt.Errorf("unknown SSH auth method %q", cfg.AuthMethod)
+	}
+}
+
+// loadPrivateKey reads a PEM/OpenSSH private key, decrypting it with passphrase if it is set.
+func loadPrivateKey(path, passphrase string) (ssh.Signer, error) {
+	if path == "" {
+		return nil, fmt.Errorf("no private key file configured")
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read private key: %w", err)
+	}
+
+	var signer ssh.Signer
+	if passphrase != "" {
+		signer, err = ssh.ParsePrivateKeyWithPassphrase(data, []byte(passphrase))
+	} else {
+		signer, err = ssh.ParsePrivateKey(data)
+	}
+	if err != nil {
+		var missing *ssh.PassphraseMissingError
+		if errors.As(err, &missing) {
+			return nil, fmt.Errorf("private key %s is encrypted but no passphrase is configured", path)
+		}
+		return nil, fmt.Errorf("failed to parse private key: %w", err)
+	}
+	return signer, nil
+}
+
+// loadCertificateSigner pairs signer with the OpenSSH certificate stored at path.
+func loadCertificateSigner(path string, signer ssh.Signer) (ssh.Signer, error) {
+	if path == "" {
+		return nil, fmt.Errorf("no certificate file configured")
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read certificate: %w", err)
+	}
+	pub, _, _, _, err := ssh.ParseAuthorizedKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse certificate: %w", err)
+	}
+	cert, ok := pub.(*ssh.Certificate)
+	if !ok {
+		return nil, fmt.Errorf("%s is not an SSH certificate", path)
+	}
+	certSigner, err := ssh.NewCertSigner(cert, signer)
+	if err != nil {
+		return nil, fmt.Errorf("certificate does not match private key: %w", err)
+	}
+	return certSigner, nil
+}
+
+// runSession runs command on session. If ctx is done first, the remote process
+// is sent SIGTERM, the session is closed and the context's error is returned.
+func runSession(ctx context.Context, session *ssh.Session, command string) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("SSH command cancelled: %w", err)
+	}
+	if err := session.Start(command); err != nil {
+		return err
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- session.Wait() }()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		session.Signal(ssh.SIGTERM)
+		session.Close()
+		return fmt.Errorf("SSH command cancelled: %w", ctx.Err())
+	}
+}
+
 // RunSSHCommand executes a command on the remote server via SSH.
-func RunSSHCommand(client *ssh.Client, command string) (string, string, error) {
+func RunSSHCommand(ctx context.Context, client *PooledClient, command string) (string, string, error) {
 	session, err := client.NewSession()
 	if err != nil {
+		client.MarkBroken()
 		utils.LogError("Failed to create SSH session: %v", err)
 		return "", "", fmt.Errorf("failed to create SSH session: %w", err)
 	}
@@ -48,10 +232,10 @@ func RunSSHCommand(client *ssh.Client, command string) (string, string, error) {
 	isSilent := strings.Contains(command, "top -bn1") || strings.Contains(command, "free -m")
 
 	if !isSilent {
-		utils.LogInfo("Executing SSH command: %s", command)
+		utils.LogInfo("Executing SSH command: %s", redactCommand(command))
 	}
 
-	err = session.Run(command)
+	err = runSession(ctx, session, command)
 	if err != nil {
 		if !isSilent {
 			utils.LogError("SSH command failed: %v, stdout: %s, stderr: %s", err, stdoutBuf.String(), stderrBuf.String())
@@ -66,8 +250,8 @@ func RunSSHCommand(client *ssh.Client, command string) (string, string, error) {
 }
 
 // GetSFTPClient creates an SFTP client from an SSH client.
-func GetSFTPClient(client *ssh.Client) (*sftp.Client, error) {
-	sftpClient, err := sftp.NewClient(client)
+func GetSFTPClient(client *PooledClient) (*sftp.Client, error) {
+	sftpClient, err := sftp.NewClient(client.Client)
 	if err != nil {
 		utils.LogError("Failed to create SFTP client: %v", err)
 		return nil, fmt.Errorf("failed to create SFTP client: %w", err)
@@ -92,4 +276,4 @@ func UploadFile(sftpClient *sftp.Client, remotePath string, content io.Reader) e
 	}
 	utils.LogInfo("File uploaded to %s", remotePath)
 	return nil
-}
\ No newline at end of file
+}
@@ -0,0 +1,245 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"wordpress-collab-tool/models"
+	"wordpress-collab-tool/utils"
+)
+
+// Modes of a staging push.
+const (
+	PushFiles    = "files"
+	PushDatabase = "db"
+	PushBoth     = "both"
+)
+
+// Errors returned by the staging functions.
+var (
+	ErrNoStaging       = errors.New("site has no staging site")
+	ErrNothingToRevert = errors.New("no push to revert")
+	// ErrPushReverted is returned when a push failed and the live site was
+	// restored from the backup taken before it.
+	ErrPushReverted = errors.New("push reverted")
+	// ErrPushRestoreFailed is returned when a push failed and restoring the
+	// live site from its backup failed too.
+	ErrPushRestoreFailed = errors.New("push failed and its backup could not be restored")
+)
+
+// PushOptions choose what PushStaging copies from the staging site to the
+// live site. IncludeTables limits a database push to those tables, and
+// ExcludeTables keeps those tables of the live site, such as the orders of
+// a shop, as they are.
+type PushOptions struct {
+	Mode          string   `json:"mode"`
+	IncludeTables []string `json:"includeTables"`
+	ExcludeTables []string `json:"excludeTables"`
+}
+
+// Validate checks the mode and table names.
+func (o PushOptions) Validate() error {
+	if !slices.Contains([]string{PushFiles, PushDatabase, PushBoth}, o.Mode) {
+		return fmt.Errorf("%w: mode must be %s, %s or %s", ErrInvalidInput, PushFiles, PushDatabase, PushBoth)
+	}
+	if o.Mode == PushFiles && len(o.IncludeTables)+len(o.ExcludeTables) > 0 {
+		return fmt.Errorf("%w: tables can only be chosen when pushing the database", ErrInvalidInput)
+	}
+	for _, table := range append(slices.Clone(o.IncludeTables), o.ExcludeTables...) {
+		if err := ValidateTableName(table); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (o PushOptions) files() bool    { return o.Mode == PushFiles || o.Mode == PushBoth }
+func (o PushOptions) database() bool { return o.Mode == PushDatabase || o.Mode == PushBoth }
+
+// CreateStaging clones live into staging, a new site already recorded as
+// its staging site, and links live to it once the copy is done.
+func CreateStaging(ctx context.Context, live, staging models.Site) error {
+	if err := CloneSite(ctx, live, staging); err != nil {
+		return err
+	}
+	_, err := UpdateSite(live.ProjectName, func(site *models.Site) {
+		site.Staging = staging.ProjectName
+	})
+	return err
+}
+
+// UnlinkStaging drops the links to a deleted site: its live site no longer
+// has a staging site, and its staging site becomes a site of its own.
+func UnlinkStaging(site models.Site) {
+	unlink := func(projectName string, change func(*models.Site)) {
+		if projectName == "" {
+			return
+		}
+		if _, err := UpdateSite(projectName, change); err != nil && !errors.Is(err, ErrSiteNotFound) {
+			utils.LogError("Failed to unlink site '%s' from '%s': %v", projectName, site.ProjectName, err)
+		}
+	}
+	unlink(site.StagingOf, func(live *models.Site) {
+		if live.Staging == site.ProjectName {
+			live.Staging = ""
+		}
+	})
+	unlink(site.Staging, func(staging *models.Site) {
+		if staging.StagingOf == site.ProjectName {
+			staging.StagingOf = ""
+		}
+	})
+}
+
+// PushStaging copies the staging site of a live site onto it: wp-content,
+// the database or both. A backup of the live site is taken first; if the
+// push fails the live site is restored from it, and ErrPushReverted or
+// ErrPushRestoreFailed tells how that went; RevertPush restores it later
+// on. The live site's URL replaces the staging URL in pushed tables.
+func PushStaging(ctx context.Context, liveName string, opts PushOptions) (models.StagingPush, error) {
+	if err := opts.Validate(); err != nil {
+		return models.StagingPush{}, err
+	}
+	live, err := GetSite(liveName)
+	if err != nil {
+		return models.StagingPush{}, err
+	}
+	if live.Staging == "" {
+		return models.StagingPush{}, fmt.Errorf("%w: '%s'", ErrNoStaging, liveName)
+	}
+	staging, err := GetSite(live.Staging)
+	if err != nil {
+		return models.StagingPush{}, err
+	}
+
+	// Hold the staging site still while it is copied
+	unlock, err := LockSite(ctx, staging.ProjectName, "push", currentJobID(ctx))
+	if err != nil {
+		return models.StagingPush{}, err
+	}
+	defer unlock()
+
+	backupFile, err := createBackup(ctx, liveName)
+	if err != nil {
+		return models.StagingPush{}, fmt.Errorf("failed to back up site '%s' before the push: %w", liveName, err)
+	}
+	push := models.StagingPush{
+		Mode:          opts.Mode,
+		IncludeTables: opts.IncludeTables,
+		ExcludeTables: opts.ExcludeTables,
+		Backup:        backupFile,
+		PushedBy:      AuditFrom(ctx).Actor,
+		PushedAt:      time.Now().UTC(),
+	}
+
+	if err := pushStaging(ctx, live, staging, opts); err != nil {
+		FailJobStep(ctx)
+		JobStep(ctx, "Revert push")
+		utils.LogError("Push of '%s' to '%s' failed, restoring backup '%s': %v", staging.ProjectName, liveName, backupFile, err)
+		backupPath := filepath.Join(fmt.Sprintf("/var/www/backups/%s", liveName), backupFile)
+		if restoreErr := restoreBackup(context.WithoutCancel(ctx), liveName, backupPath); restoreErr != nil {
+			return push, fmt.Errorf("%w: %w; restoring backup '%s' failed too: %w", ErrPushRestoreFailed, err, backupFile, restoreErr)
+		}
+		return push, fmt.Errorf("%w from backup '%s': %w", ErrPushReverted, backupFile, err)
+	}
+
+	_, err = UpdateSite(liveName, func(site *models.Site) {
+		site.LastPush = &push
+	})
+	if err != nil {
+		return push, fmt.Errorf("failed to record the push: %w", err)
+	}
+	return push, nil
+}
+
+// pushStaging copies the chosen parts of staging onto live.
+func pushStaging(ctx context.Context, live, staging models.Site, opts PushOptions) error {
+	ctx, cancel := context.WithTimeout(ctx, timeouts.Restore)
+	defer cancel()
+
+	executor, _, err := ExecutorForSite(ctx, live)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrConnect, err)
+	}
+	defer executor.Close()
+
+	livePath := fmt.Sprintf("/var/www/%s", live.ProjectName)
+	stagingPath := fmt.Sprintf("/var/www/%s", staging.ProjectName)
+
+	if opts.files() {
+		JobStep(ctx, "Push wp-content")
+		utils.LogInfo("Pushing wp-content of '%s' to '%s'", staging.ProjectName, live.ProjectName)
+		liveWordPress := live.ProjectName + "_wordpress"
+		tmpDir := "/tmp/push_wp_content"
+		for _, command := range []string{
+			ComposeCmd(livePath, "exec", "-T", liveWordPress, "rm", "-rf", tmpDir),
+			ComposeCmd(livePath, "exec", "-T", liveWordPress, "mkdir", "-p", tmpDir),
+			// Without pipefail a failing staging tar would go unnoticed
+			And(Cmd("cd", livePath), PipeFail(
+				Compose(stagingPath, "exec", "-T", staging.ProjectName+"_wordpress", "tar", "-czf", "-", "-C", "/var/www/html", "wp-content"),
+				Compose(livePath, "exec", "-T", liveWordPress, "tar", "-xzf", "-", "-C", tmpDir),
+			)),
+			ComposeCmd(livePath, "exec", "-T", liveWordPress, "rm", "-rf", "/var/www/html/wp-content"),
+			ComposeCmd(livePath, "exec", "-T", liveWordPress, "mv", tmpDir+"/wp-content", "/var/www/html/wp-content"),
+			ComposeCmd(livePath, "exec", "-T", liveWordPress, "rm", "-rf", tmpDir),
+		} {
+			if _, stderr, err := executor.Run(ctx, command); err != nil {
+				return fmt.Errorf("%w: failed to push wp-content: %w, stderr: %s", ErrRemoteCommand, err, stderr)
+			}
+		}
+	}
+
+	if opts.database() {
+		JobStep(ctx, "Push database")
+		utils.LogInfo("Pushing the database of '%s' to '%s'", staging.ProjectName, live.ProjectName)
+		// Dump to a file first so that a failed dump never reaches live
+		dumpPath := filepath.Join(stagingPath, "push_db.sql")
+		dump := []string{"exec", "-T", "-e", "MYSQL_PWD=" + staging.DBPassword, staging.ProjectName + "_db", "mariadb-dump", "-u", "root"}
+		for _, table := range opts.ExcludeTables {
+			dump = append(dump, "--ignore-table="+staging.DBName+"."+table)
+		}
+		dump = append(append(dump, staging.DBName), opts.IncludeTables...)
+		defer executor.Run(context.WithoutCancel(ctx), Cmd("rm", "-f", dumpPath))
+
+		if _, stderr, err := executor.Run(ctx, RedirectTo(ComposeCmd(stagingPath, dump...), dumpPath)); err != nil {
+			return fmt.Errorf("%w: failed to dump the staging database: %w, stderr: %s", ErrRemoteCommand, err, stderr)
+		}
+		importCmd := And(Cmd("cd", livePath), PipeFail(Cmd("cat", dumpPath), Compose(livePath, "exec", "-T", "-e", "MYSQL_PWD="+live.DBPassword, live.ProjectName+"_db", "mariadb", "-u", "root", live.DBName)))
+		if _, stderr, err := executor.Run(ctx, importCmd); err != nil {
+			return fmt.Errorf("%w: failed to import the staging database: %w, stderr: %s", ErrRemoteCommand, err, stderr)
+		}
+		if err := ReplaceSiteURL(ctx, live, staging.SiteURL, live.SiteURL); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RevertPush restores a live site from the backup taken before the last
+// push of its staging site.
+func RevertPush(ctx context.Context, liveName string) (models.StagingPush, error) {
+	live, err := GetSite(liveName)
+	if err != nil {
+		return models.StagingPush{}, err
+	}
+	if live.LastPush == nil || live.LastPush.RevertedAt != nil {
+		return models.StagingPush{}, fmt.Errorf("%w: '%s'", ErrNothingToRevert, liveName)
+	}
+	push := *live.LastPush
+	if err := RestoreBackup(ctx, liveName, push.Backup); err != nil {
+		return push, err
+	}
+
+	now := time.Now().UTC()
+	push.RevertedAt = &now
+	_, err = UpdateSite(liveName, func(site *models.Site) {
+		if site.LastPush != nil && site.LastPush.Backup == push.Backup {
+			site.LastPush.RevertedAt = &now
+		}
+	})
+	return push, err
+}
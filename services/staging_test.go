@@ -0,0 +1,158 @@
+package services
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"wordpress-collab-tool/internal/fakes"
+	"wordpress-collab-tool/models"
+)
+
+// stagingSites returns an active live site and its active staging site.
+func stagingSites() (models.Site, models.Site) {
+	live := testSite()
+	live.Status = "active"
+	live.Staging = "copy"
+	staging := testClone()
+	staging.Status = "active"
+	staging.Origin = nil
+	staging.StagingOf = "blog"
+	return live, staging
+}
+
+func TestPushStagingDatabase(t *testing.T) {
+	live, staging := stagingSites()
+	withSites(t, live, staging)
+	fake := cloneFake()
+	useFakeExecutor(t, fake)
+
+	opts := PushOptions{Mode: PushDatabase, ExcludeTables: []string{"wp_wc_orders", "wp_woocommerce_order_items"}}
+	push, err := PushStaging(t.Context(), "blog", opts)
+	if err != nil {
+		t.Fatalf("PushStaging: %v", err)
+	}
+
+	backup := commandIndex(fake, "mariadb-dump -u root blog_db")
+	dump := commandIndex(fake, "mariadb-dump -u root --ignore-table=copy_db.wp_wc_orders --ignore-table=copy_db.wp_woocommerce_order_items copy_db")
+	load := commandIndex(fake, "mariadb -u root blog_db")
+	if backup < 0 || dump < backup || load < dump {
+		t.Errorf("expected backup, filtered dump and import in order:\n%s", strings.Join(fake.Commands(), "\n"))
+	}
+	if !fake.Ran("wp search-replace http://vps.test:8300 http://vps.test:8200") {
+		t.Errorf("staging URL not rewritten:\n%s", strings.Join(fake.Commands(), "\n"))
+	}
+	if fake.Ran("tar -xzf - -C") {
+		t.Error("files pushed in database mode")
+	}
+
+	stored, err := GetSite("blog")
+	if err != nil {
+		t.Fatalf("GetSite: %v", err)
+	}
+	if stored.LastPush == nil || stored.LastPush.Backup != push.Backup || !strings.HasPrefix(push.Backup, "backup-") {
+		t.Errorf("push not recorded: %+v", stored.LastPush)
+	}
+}
+
+func TestPushStagingIncludedTables(t *testing.T) {
+	live, staging := stagingSites()
+	withSites(t, live, staging)
+	fake := cloneFake()
+	useFakeExecutor(t, fake)
+
+	if _, err := PushStaging(t.Context(), "blog", PushOptions{Mode: PushFiles, IncludeTables: []string{"wp_posts"}}); !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("tables with files mode: expected ErrInvalidInput, got %v", err)
+	}
+	if _, err := PushStaging(t.Context(), "blog", PushOptions{Mode: PushDatabase, IncludeTables: []string{"wp_posts; drop"}}); !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("bad table name: expected ErrInvalidInput, got %v", err)
+	}
+	if len(fake.Commands()) != 0 {
+		t.Fatalf("invalid push ran commands:\n%s", strings.Join(fake.Commands(), "\n"))
+	}
+
+	if _, err := PushStaging(t.Context(), "blog", PushOptions{Mode: PushBoth, IncludeTables: []string{"wp_posts", "wp_postmeta"}}); err != nil {
+		t.Fatalf("PushStaging: %v", err)
+	}
+	for _, want := range []string{
+		"copy_wordpress tar -czf - -C /var/www/html wp-content | docker compose -f /var/www/blog/docker-compose.yml exec -T blog_wordpress tar -xzf -",
+		"mariadb-dump -u root copy_db wp_posts wp_postmeta",
+	} {
+		if !fake.Ran(want) {
+			t.Errorf("expected a command containing %q, ran:\n%s", want, strings.Join(fake.Commands(), "\n"))
+		}
+	}
+}
+
+func TestPushStagingRevertsOnFailure(t *testing.T) {
+	live, staging := stagingSites()
+	withSites(t, live, staging)
+	fake := cloneFake().On("mariadb-dump -u root copy_db", fakes.Result{Stderr: "mysqldump: Got error", ExitCode: 1})
+	useFakeExecutor(t, fake)
+
+	push, err := PushStaging(t.Context(), "blog", PushOptions{Mode: PushDatabase})
+	if !errors.Is(err, ErrPushReverted) || errors.Is(err, ErrPushRestoreFailed) {
+		t.Fatalf("expected ErrPushReverted, got %v", err)
+	}
+	if push.Backup == "" || !fake.Ran("tar -xzf /var/www/backups/blog/"+push.Backup) {
+		t.Errorf("live site not restored from the pre-push backup %q:\n%s", push.Backup, strings.Join(fake.Commands(), "\n"))
+	}
+	if stored, _ := GetSite("blog"); stored.LastPush != nil {
+		t.Errorf("failed push recorded: %+v", stored.LastPush)
+	}
+}
+
+func TestPushStagingReportsFailedRestore(t *testing.T) {
+	live, staging := stagingSites()
+	withSites(t, live, staging)
+	fake := cloneFake().
+		On("copy_wordpress tar -czf", fakes.Result{Stderr: "tar: wp-content: Cannot open", ExitCode: 2}).
+		On("tar -xzf /var/www/backups/blog/", fakes.Result{Stderr: "gzip: unexpected end of file", ExitCode: 1})
+	useFakeExecutor(t, fake)
+
+	_, err := PushStaging(t.Context(), "blog", PushOptions{Mode: PushFiles})
+	if !errors.Is(err, ErrPushRestoreFailed) || errors.Is(err, ErrPushReverted) {
+		t.Fatalf("expected ErrPushRestoreFailed, got %v", err)
+	}
+	// The staging side of the pipe can only fail the push with pipefail
+	if !fake.Ran("bash -o pipefail -c") {
+		t.Errorf("wp-content piped without pipefail:\n%s", strings.Join(fake.Commands(), "\n"))
+	}
+}
+
+func TestRevertPush(t *testing.T) {
+	live, staging := stagingSites()
+	withSites(t, live, staging)
+	fake := cloneFake()
+	useFakeExecutor(t, fake)
+
+	if _, err := RevertPush(t.Context(), "blog"); !errors.Is(err, ErrNothingToRevert) {
+		t.Errorf("expected ErrNothingToRevert before any push, got %v", err)
+	}
+	push, err := PushStaging(t.Context(), "blog", PushOptions{Mode: PushFiles})
+	if err != nil {
+		t.Fatalf("PushStaging: %v", err)
+	}
+	if _, err := RevertPush(t.Context(), "blog"); err != nil {
+		t.Fatalf("RevertPush: %v", err)
+	}
+	if !fake.Ran("tar -xzf /var/www/backups/blog/" + push.Backup) {
+		t.Errorf("backup %q not restored:\n%s", push.Backup, strings.Join(fake.Commands(), "\n"))
+	}
+	if stored, _ := GetSite("blog"); stored.LastPush == nil || stored.LastPush.RevertedAt == nil {
+		t.Errorf("revert not recorded: %+v", stored.LastPush)
+	}
+	if _, err := RevertPush(t.Context(), "blog"); !errors.Is(err, ErrNothingToRevert) {
+		t.Errorf("expected ErrNothingToRevert after the revert, got %v", err)
+	}
+}
+
+func TestUnlinkStaging(t *testing.T) {
+	live, staging := stagingSites()
+	withSites(t, live, staging)
+
+	UnlinkStaging(staging)
+	if stored, _ := GetSite("blog"); stored.Staging != "" {
+		t.Errorf("live site still links deleted staging site %q", stored.Staging)
+	}
+}
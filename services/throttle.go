@@ -0,0 +1,237 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"wordpress-collab-tool/config"
+	"wordpress-collab-tool/models"
+)
+
+// LoginThrottledError is returned when sign-ins from a client or for an
+// account keep failing and must wait before the next attempt.
+type LoginThrottledError struct {
+	Until time.Time
+}
+
+func (e *LoginThrottledError) Error() string {
+	return fmt.Sprintf("too many failed sign-ins; retry after %s", e.Until.Format(time.RFC3339))
+}
+
+// Kinds of login throttles.
+const (
+	ThrottleIP       = "ip"
+	ThrottleUsername = "username"
+)
+
+// LoginThrottle describes the failed sign-ins counted against one client IP
+// or username.
+type LoginThrottle struct {
+	Kind         string    `json:"kind"`
+	Key          string    `json:"key"`
+	Failures     int       `json:"failures"`
+	LastFailure  time.Time `json:"lastFailure"`
+	BlockedUntil time.Time `json:"blockedUntil"`
+}
+
+// loginThrottles counts failed sign-ins in memory. Unlike the account
+// lockout they also cover unknown usernames and a single client trying
+// many accounts. A restart forgets them.
+var loginThrottles = struct {
+	sync.Mutex
+	entries      map[string]*LoginThrottle
+	ipAttempts   int
+	userAttempts int
+	base         time.Duration
+	max          time.Duration
+	window       time.Duration
+	lastPrune    time.Time
+}{
+	entries:      make(map[string]*LoginThrottle),
+	ipAttempts:   20,
+	userAttempts: 3,
+	base:         time.Second,
+	max:          15 * time.Minute,
+	window:       time.Hour,
+}
+
+// InitLoginThrottle applies the configured sign-in rate limits and forgets
+// the failures counted so far.
+func InitLoginThrottle(cfg *config.Config) {
+	loginThrottles.Lock()
+	defer loginThrottles.Unlock()
+	loginThrottles.entries = make(map[string]*LoginThrottle)
+	loginThrottles.ipAttempts = cfg.LoginIPAttempts
+	loginThrottles.userAttempts = cfg.LoginUserAttempts
+	loginThrottles.base = cfg.LoginBackoffBase
+	loginThrottles.max = cfg.LoginBackoffMax
+	loginThrottles.window = cfg.LoginThrottleWindow
+}
+
+func throttleKey(kind, key string) string {
+	if kind == ThrottleUsername {
+		key = strings.ToLower(key)
+	}
+	return kind + ":" + key
+}
+
+// checkLoginThrottle returns a LoginThrottledError while the client IP of
+// ctx or the username has to wait after failed sign-ins.
+func checkLoginThrottle(ctx context.Context, username string) error {
+	ip := AuditFrom(ctx).ClientIP
+	now := time.Now()
+	loginThrottles.Lock()
+	defer loginThrottles.Unlock()
+	var until time.Time
+	for _, key := range []string{throttleKey(ThrottleIP, ip), throttleKey(ThrottleUsername, username)} {
+		if entry, ok := loginThrottles.entries[key]; ok && entry.BlockedUntil.After(until) {
+			until = entry.BlockedUntil
+		}
+	}
+	if until.After(now) {
+		return &LoginThrottledError{Until: until.UTC()}
+	}
+	return nil
+}
+
+// recordThrottledFailure counts a failed sign-in against the client IP of
+// ctx and the username. Each failure past the free attempts doubles the
+// wait before the next one. The first wait of a client or username is
+// logged.
+func recordThrottledFailure(ctx context.Context, username string) {
+	ip := AuditFrom(ctx).ClientIP
+	now := time.Now()
+	var started []LoginThrottle
+
+	loginThrottles.Lock()
+	pruneLoginThrottles(now)
+	for _, t := range []struct {
+		kind, key string
+		free      int
+	}{
+		{ThrottleIP, ip, loginThrottles.ipAttempts},
+		{ThrottleUsername, username, loginThrottles.userAttempts},
+	} {
+		if t.key == "" || t.free <= 0 {
+			continue
+		}
+		id := throttleKey(t.kind, t.key)
+		entry, ok := loginThrottles.entries[id]
+		if !ok {
+			entry = &LoginThrottle{Kind: t.kind, Key: t.key}
+			loginThrottles.entries[id] = entry
+		}
+		entry.Failures++
+		entry.LastFailure = now.UTC()
+		if excess := entry.Failures - t.free; excess > 0 {
+			entry.BlockedUntil = now.Add(backoff(excess)).UTC()
+			if excess == 1 {
+				started = append(started, *entry)
+			}
+		}
+	}
+	loginThrottles.Unlock()
+
+	for _, t := range started {
+		LogActivityContext(ctx, "security", "login.throttle", fmt.Sprintf("Sign-ins for %s '%s' are slowed down after %d failures.", t.Kind, t.Key, t.Failures), "")
+	}
+}
+
+// backoff returns the wait after the nth failure past the free attempts.
+// loginThrottles must be locked.
+func backoff(n int) time.Duration {
+	wait := loginThrottles.base
+	for i := 1; i < n && wait < loginThrottles.max; i++ {
+		wait *= 2
+	}
+	return min(wait, loginThrottles.max)
+}
+
+// pruneLoginThrottles forgets the failures of clients and usernames that
+// had none for the throttle window. loginThrottles must be locked.
+func pruneLoginThrottles(now time.Time) {
+	if now.Sub(loginThrottles.lastPrune) < time.Minute {
+		return
+	}
+	loginThrottles.lastPrune = now
+	for id, entry := range loginThrottles.entries {
+		if now.Sub(entry.LastFailure) > loginThrottles.window && now.After(entry.BlockedUntil) {
+			delete(loginThrottles.entries, id)
+		}
+	}
+}
+
+// clearLoginThrottle forgets the failures counted against a username.
+func clearLoginThrottle(username string) {
+	loginThrottles.Lock()
+	defer loginThrottles.Unlock()
+	delete(loginThrottles.entries, throttleKey(ThrottleUsername, username))
+}
+
+// ListLoginThrottles returns the client IPs and usernames with failed
+// sign-ins in the throttle window, those made to wait first.
+func ListLoginThrottles() []LoginThrottle {
+	now := time.Now()
+	loginThrottles.Lock()
+	throttles := make([]LoginThrottle, 0, len(loginThrottles.entries))
+	for _, entry := range loginThrottles.entries {
+		if now.Sub(entry.LastFailure) <= loginThrottles.window || now.Before(entry.BlockedUntil) {
+			throttles = append(throttles, *entry)
+		}
+	}
+	loginThrottles.Unlock()
+	slices.SortFunc(throttles, func(a, b LoginThrottle) int {
+		if c := b.BlockedUntil.Compare(a.BlockedUntil); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Kind+a.Key, b.Kind+b.Key)
+	})
+	return throttles
+}
+
+// ClearIPThrottle forgets the failed sign-ins of a client IP. It reports
+// whether there were any.
+func ClearIPThrottle(ip string) bool {
+	loginThrottles.Lock()
+	defer loginThrottles.Unlock()
+	id := throttleKey(ThrottleIP, ip)
+	_, ok := loginThrottles.entries[id]
+	delete(loginThrottles.entries, id)
+	return ok
+}
+
+// ListLockedUsers returns the accounts currently locked after failed
+// sign-ins.
+func ListLockedUsers() ([]models.User, error) {
+	users, err := Users.List()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	locked := users[:0]
+	for _, user := range users {
+		if user.LockedUntil != nil && now.Before(*user.LockedUntil) {
+			locked = append(locked, user)
+		}
+	}
+	return locked, nil
+}
+
+// UnlockUser lifts the lockout of an account and forgets the failed
+// sign-ins counted against its name.
+func UnlockUser(username string) (models.User, error) {
+	user, err := UpdateUser(username, func(u *models.User) error {
+		u.FailedLogins = 0
+		u.LockedUntil = nil
+		return nil
+	})
+	if err != nil {
+		return models.User{}, err
+	}
+	clearLoginThrottle(username)
+	return user, nil
+}
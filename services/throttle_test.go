@@ -0,0 +1,47 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"wordpress-collab-tool/config"
+)
+
+func TestLoginThrottleBackoff(t *testing.T) {
+	withActivities(t)
+	InitLoginThrottle(&config.Config{LoginIPAttempts: 10, LoginUserAttempts: 2, LoginBackoffBase: time.Second, LoginBackoffMax: 5 * time.Second, LoginThrottleWindow: time.Hour})
+	t.Cleanup(func() { InitLoginThrottle(config.LoadConfig()) })
+	ctx := WithAudit(context.Background(), AuditInfo{ClientIP: "198.51.100.7"})
+
+	// The wait doubles with each failure past the free ones, up to the maximum
+	var waits []time.Duration
+	for i := 0; i < 6; i++ {
+		recordThrottledFailure(ctx, "Mallory")
+		var throttled *LoginThrottledError
+		if err := checkLoginThrottle(ctx, "mallory"); errors.As(err, &throttled) {
+			waits = append(waits, time.Until(throttled.Until).Round(time.Second))
+		} else {
+			waits = append(waits, 0)
+		}
+	}
+	want := []time.Duration{0, 0, time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second}
+	for i := range want {
+		if waits[i] != want[i] {
+			t.Fatalf("waits = %v, want %v", waits, want)
+		}
+	}
+
+	// Other usernames are free until the client runs out of attempts
+	if err := checkLoginThrottle(WithAudit(context.Background(), AuditInfo{ClientIP: "203.0.113.9"}), "trent"); err != nil {
+		t.Errorf("another client and username: %v", err)
+	}
+	if err := checkLoginThrottle(ctx, "trent"); err != nil {
+		t.Errorf("same client below its limit: %v", err)
+	}
+	clearLoginThrottle("mallory")
+	if err := checkLoginThrottle(ctx, "MALLORY"); err != nil {
+		t.Errorf("after clearing: %v", err)
+	}
+}
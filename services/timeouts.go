@@ -0,0 +1,37 @@
+package services
+
+import (
+	"context"
+	"time"
+
+	"wordpress-collab-tool/config"
+)
+
+// timeouts bounds every remote operation. Tests shorten individual entries.
+var timeouts = config.DefaultTimeouts()
+
+// InitTimeouts applies the configured operation timeouts.
+func InitTimeouts(cfg *config.Config) {
+	timeouts = cfg.Timeouts
+}
+
+// sleepContext pauses for d, returning early with the context's error if ctx is done.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// cleanupContext returns a context for undoing work after ctx was cancelled
+// or timed out, so that cleanup commands still get a chance to run.
+func cleanupContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.WithoutCancel(ctx), timeouts.Command)
+}
@@ -0,0 +1,51 @@
+package services
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// The SHA-1 test vectors of RFC 6238, appendix B, truncated to six digits.
+func TestTOTPCode(t *testing.T) {
+	secret := totpEncoding.EncodeToString([]byte("12345678901234567890"))
+	for unix, want := range map[int64]string{
+		59:          "287082",
+		1111111109:  "081804",
+		1111111111:  "050471",
+		1234567890:  "005924",
+		2000000000:  "279037",
+		20000000000: "353130",
+	} {
+		got, err := totpCode(secret, totpStep(time.Unix(unix, 0)))
+		if err != nil || got != want {
+			t.Errorf("code at %d = %q, %v; want %q", unix, got, err, want)
+		}
+	}
+}
+
+func TestVerifyTOTP(t *testing.T) {
+	secret, err := newTOTPSecret()
+	if err != nil {
+		t.Fatalf("newTOTPSecret: %v", err)
+	}
+	now := time.Now()
+	current := totpStep(now)
+	previous, _ := totpCode(secret, current-1)
+	stale, _ := totpCode(secret, current-3)
+
+	if step, ok := verifyTOTP(secret, previous, now, 0); !ok || step != current-1 {
+		t.Errorf("code of the previous step was refused")
+	}
+	if _, ok := verifyTOTP(secret, previous, now, current-1); ok {
+		t.Error("a used code was accepted again")
+	}
+	if _, ok := verifyTOTP(secret, stale, now, 0); ok {
+		t.Error("a stale code was accepted")
+	}
+
+	uri := totpURI("WP Tool", "alice", secret)
+	if !strings.HasPrefix(uri, "otpauth://totp/WP%20Tool:alice?") || !strings.Contains(uri, "secret="+secret) {
+		t.Errorf("unexpected URI %s", uri)
+	}
+}
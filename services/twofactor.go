@@ -0,0 +1,427 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"wordpress-collab-tool/config"
+	"wordpress-collab-tool/models"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Errors returned by the two-factor functions.
+var (
+	ErrTwoFactorEnabled    = errors.New("two-factor authentication is already enabled")
+	ErrTwoFactorNotEnabled = errors.New("two-factor authentication is not enabled")
+	ErrNoPendingEnrollment = errors.New("no two-factor enrolment is pending")
+	ErrInvalidCode         = errors.New("invalid two-factor code")
+	ErrInvalidChallenge    = errors.New("login challenge is invalid or expired")
+)
+
+const (
+	// recoveryCodeCount is how many recovery codes are issued at a time.
+	recoveryCodeCount = 10
+	// loginChallengeTTL is how long the second step of a sign-in may take.
+	loginChallengeTTL = 5 * time.Minute
+	// loginChallengeAttempts bounds the codes tried against one challenge.
+	// Every wrong code also counts towards the account lockout.
+	loginChallengeAttempts = 5
+)
+
+// twoFactor holds the keys that seal TOTP secrets and the issuer shown in
+// authenticator apps. InitTwoFactor loads them.
+var twoFactor = struct {
+	keys   *Keyring
+	issuer string
+}{issuer: "WordPress Collaboration Tool"}
+
+// InitTwoFactor loads the master key that seals TOTP secrets, which is the
+// one that seals site secrets.
+func InitTwoFactor(cfg *config.Config) error {
+	keys, err := LoadKeyring(cfg)
+	if err != nil {
+		return err
+	}
+	twoFactor.keys = keys
+	twoFactor.issuer = cfg.TOTPIssuer
+	return nil
+}
+
+// TOTPEnrollment is what an authenticator app needs to add an account.
+// URI is the otpauth:// payload to show as a QR code; Secret is for
+// typing in by hand.
+type TOTPEnrollment struct {
+	Secret    string `json:"secret"`
+	URI       string `json:"otpauthUri"`
+	Issuer    string `json:"issuer"`
+	Account   string `json:"account"`
+	Algorithm string `json:"algorithm"`
+	Digits    int    `json:"digits"`
+	Period    int    `json:"period"`
+}
+
+// BeginTOTPEnrollment checks a user's password and gives them a new TOTP
+// secret. It takes effect once ConfirmTOTPEnrollment receives a code for it.
+func BeginTOTPEnrollment(username, password string) (TOTPEnrollment, error) {
+	user, err := Users.Get(username)
+	if err != nil {
+		return TOTPEnrollment{}, err
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)) != nil {
+		return TOTPEnrollment{}, ErrInvalidCredentials
+	}
+	if user.TwoFactorEnabled {
+		return TOTPEnrollment{}, ErrTwoFactorEnabled
+	}
+
+	secret, err := newTOTPSecret()
+	if err != nil {
+		return TOTPEnrollment{}, err
+	}
+	sealed, err := twoFactor.keys.SealValue(totpContext(username), []byte(secret))
+	if err != nil {
+		return TOTPEnrollment{}, err
+	}
+	if _, err := UpdateUser(username, func(u *models.User) error {
+		if u.TwoFactorEnabled {
+			return ErrTwoFactorEnabled
+		}
+		u.TwoFactor.PendingSecret = sealed
+		return nil
+	}); err != nil {
+		return TOTPEnrollment{}, err
+	}
+
+	return TOTPEnrollment{
+		Secret:    secret,
+		URI:       totpURI(twoFactor.issuer, username, secret),
+		Issuer:    twoFactor.issuer,
+		Account:   username,
+		Algorithm: "SHA1",
+		Digits:    totpDigits,
+		Period:    int(totpPeriod / time.Second),
+	}, nil
+}
+
+// ConfirmTOTPEnrollment enables two-factor authentication once the user
+// proves their app holds the pending secret, and returns their recovery
+// codes. The codes are shown only here; their hashes are stored.
+func ConfirmTOTPEnrollment(username, code string) ([]string, error) {
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	_, err = UpdateUser(username, func(u *models.User) error {
+		if u.TwoFactorEnabled {
+			return ErrTwoFactorEnabled
+		}
+		if u.TwoFactor.PendingSecret == nil {
+			return ErrNoPendingEnrollment
+		}
+		secret, err := openTOTPSecret(u.Username, u.TwoFactor.PendingSecret)
+		if err != nil {
+			return err
+		}
+		step, ok := verifyTOTP(secret, code, time.Now(), 0)
+		if !ok {
+			return ErrInvalidCode
+		}
+		u.TwoFactorEnabled = true
+		u.TwoFactor = models.TwoFactor{Secret: u.TwoFactor.PendingSecret, RecoveryCodes: hashes, LastStep: step}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// DisableTwoFactor turns two-factor authentication off for a user who
+// gives their password and a current code or a recovery code.
+func DisableTwoFactor(username, password, code string) (models.User, error) {
+	user, err := Users.Get(username)
+	if err != nil {
+		return models.User{}, err
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)) != nil {
+		return models.User{}, ErrInvalidCredentials
+	}
+	return UpdateUser(username, func(u *models.User) error {
+		if _, err := useSecondFactor(u, code); err != nil {
+			return err
+		}
+		u.TwoFactorEnabled = false
+		u.TwoFactor = models.TwoFactor{}
+		return nil
+	})
+}
+
+// RegenerateRecoveryCodes replaces a user's recovery codes after checking
+// a current code, and returns the new ones.
+func RegenerateRecoveryCodes(username, code string) ([]string, error) {
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	_, err = UpdateUser(username, func(u *models.User) error {
+		if _, err := useSecondFactor(u, code); err != nil {
+			return err
+		}
+		u.TwoFactor.RecoveryCodes = hashes
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// ResetTwoFactor turns two-factor authentication off for a user who lost
+// their device and recovery codes. They can enrol again after signing in.
+func ResetTwoFactor(username string) (models.User, error) {
+	return UpdateUser(username, func(u *models.User) error {
+		if !u.TwoFactorEnabled && u.TwoFactor.PendingSecret == nil {
+			return ErrTwoFactorNotEnabled
+		}
+		u.TwoFactorEnabled = false
+		u.TwoFactor = models.TwoFactor{}
+		return nil
+	})
+}
+
+// LoginChallenge is the second step of signing in to an account with
+// two-factor authentication.
+type LoginChallenge struct {
+	ID        string    `json:"challenge"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+type loginChallenge struct {
+	username       string
+	sessionVersion int
+	expiresAt      time.Time
+	attempts       int
+}
+
+// loginChallenges holds the challenges of sign-ins waiting for a code.
+// They only live for minutes, so they are kept in memory.
+var loginChallenges = struct {
+	sync.Mutex
+	pending map[string]*loginChallenge
+}{pending: make(map[string]*loginChallenge)}
+
+// StartLoginChallenge is called once user gave the right password and
+// returns the challenge to complete with a code.
+func StartLoginChallenge(user models.User) (LoginChallenge, error) {
+	id, err := randomID()
+	if err != nil {
+		return LoginChallenge{}, err
+	}
+	now := time.Now()
+	challenge := &loginChallenge{username: user.Username, sessionVersion: user.SessionVersion, expiresAt: now.Add(loginChallengeTTL)}
+
+	loginChallenges.Lock()
+	defer loginChallenges.Unlock()
+	for id, c := range loginChallenges.pending {
+		if now.After(c.expiresAt) {
+			delete(loginChallenges.pending, id)
+		}
+	}
+	loginChallenges.pending[id] = challenge
+	return LoginChallenge{ID: id, ExpiresAt: challenge.expiresAt.UTC()}, nil
+}
+
+// CompleteLoginChallenge finishes a sign-in with a TOTP code or a recovery
+// code and returns the user, and whether a recovery code was used. Wrong
+// codes count towards the account lockout like wrong passwords do.
+func CompleteLoginChallenge(ctx context.Context, id, code string) (models.User, bool, error) {
+	var pending loginChallenge
+	loginChallenges.Lock()
+	challenge, ok := loginChallenges.pending[id]
+	if ok {
+		challenge.attempts++
+		pending = *challenge
+		// The last attempt uses the challenge up
+		if pending.attempts >= loginChallengeAttempts || time.Now().After(pending.expiresAt) {
+			delete(loginChallenges.pending, id)
+		}
+	}
+	loginChallenges.Unlock()
+	if !ok || time.Now().After(pending.expiresAt) {
+		return models.User{}, false, ErrInvalidChallenge
+	}
+
+	if err := checkLoginThrottle(ctx, pending.username); err != nil {
+		return models.User{}, false, err
+	}
+	user, err := Users.Get(pending.username)
+	if err != nil {
+		return models.User{}, false, err
+	}
+	now := time.Now().UTC()
+	if user.LockedUntil != nil && now.Before(*user.LockedUntil) {
+		return models.User{}, false, &UserLockedError{Username: user.Username, Until: *user.LockedUntil}
+	}
+	if user.Disabled {
+		return models.User{}, false, ErrUserDisabled
+	}
+	if user.SessionVersion != pending.sessionVersion {
+		// The password changed since the first step
+		return models.User{}, false, ErrInvalidChallenge
+	}
+
+	usedRecovery := false
+	user, err = UpdateUser(user.Username, func(u *models.User) error {
+		var err error
+		if usedRecovery, err = useSecondFactor(u, code); err != nil {
+			return err
+		}
+		u.FailedLogins = 0
+		u.LockedUntil = nil
+		u.LastLoginAt = &now
+		return nil
+	})
+	if errors.Is(err, ErrInvalidCode) {
+		info := AuditFrom(ctx)
+		info.Actor = pending.username
+		ctx = WithAudit(ctx, info)
+		LogActivityContext(ctx, "warning", "user.login", fmt.Sprintf("Wrong two-factor code for '%s'.", pending.username), "")
+		recordThrottledFailure(ctx, pending.username)
+		recordFailedLogin(ctx, pending.username)
+		return models.User{}, false, err
+	}
+	if errors.Is(err, ErrTwoFactorNotEnabled) {
+		// Reset by an admin since the first step
+		return models.User{}, false, ErrInvalidChallenge
+	}
+	if err != nil {
+		return models.User{}, false, err
+	}
+
+	loginChallenges.Lock()
+	delete(loginChallenges.pending, id)
+	loginChallenges.Unlock()
+	clearLoginThrottle(user.Username)
+	return user, usedRecovery, nil
+}
+
+// useSecondFactor checks a TOTP code or recovery code against u, marking
+// the code used. It reports whether it was a recovery code.
+func useSecondFactor(u *models.User, code string) (bool, error) {
+	if !u.TwoFactorEnabled || u.TwoFactor.Secret == nil {
+		return false, ErrTwoFactorNotEnabled
+	}
+	code = strings.TrimSpace(code)
+	if len(code) == totpDigits {
+		secret, err := openTOTPSecret(u.Username, u.TwoFactor.Secret)
+		if err != nil {
+			return false, err
+		}
+		step, ok := verifyTOTP(secret, code, time.Now(), u.TwoFactor.LastStep)
+		if !ok {
+			return false, ErrInvalidCode
+		}
+		u.TwoFactor.LastStep = step
+		return false, nil
+	}
+
+	hash := hashToken(normalizeRecoveryCode(code))
+	for i, stored := range u.TwoFactor.RecoveryCodes {
+		if subtle.ConstantTimeCompare([]byte(stored), []byte(hash)) == 1 {
+			u.TwoFactor.RecoveryCodes = slices.Delete(slices.Clone(u.TwoFactor.RecoveryCodes), i, i+1)
+			return true, nil
+		}
+	}
+	return false, ErrInvalidCode
+}
+
+// newRecoveryCodes returns fresh recovery codes, formatted for reading,
+// and the hashes they are stored under.
+func newRecoveryCodes() (codes, hashes []string, err error) {
+	for range recoveryCodeCount {
+		b := make([]byte, 10)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, fmt.Errorf("failed to generate recovery code: %w", err)
+		}
+		raw := strings.ToLower(totpEncoding.EncodeToString(b))
+		codes = append(codes, raw[:4]+"-"+raw[4:8]+"-"+raw[8:12]+"-"+raw[12:])
+		hashes = append(hashes, hashToken(raw))
+	}
+	return codes, hashes, nil
+}
+
+// normalizeRecoveryCode ignores case, spaces and dashes, which people add
+// or drop when typing a code.
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+}
+
+// totpContext binds a sealed TOTP secret to its user, so that it cannot be
+// copied to another account.
+func totpContext(username string) string {
+	return "totp:" + username
+}
+
+// rotateTOTPSecrets re-seals the TOTP secrets, pending ones included, that
+// were sealed with a previous master key. It returns how many users were
+// rewritten.
+func rotateTOTPSecrets() (int, error) {
+	if twoFactor.keys == nil || Users == nil {
+		return 0, nil
+	}
+	users, err := Users.List()
+	if err != nil {
+		return 0, err
+	}
+	rotated := 0
+	for _, user := range users {
+		if !staleTOTPSecret(user.TwoFactor.Secret) && !staleTOTPSecret(user.TwoFactor.PendingSecret) {
+			continue
+		}
+		if _, err := UpdateUser(user.Username, func(u *models.User) error {
+			var err error
+			if u.TwoFactor.Secret, err = resealTOTPSecret(u.Username, u.TwoFactor.Secret); err != nil {
+				return err
+			}
+			u.TwoFactor.PendingSecret, err = resealTOTPSecret(u.Username, u.TwoFactor.PendingSecret)
+			return err
+		}); err != nil {
+			return rotated, fmt.Errorf("failed to re-encrypt the TOTP secret of '%s': %w", user.Username, err)
+		}
+		rotated++
+	}
+	return rotated, nil
+}
+
+func staleTOTPSecret(sealed *models.SealedSecrets) bool {
+	return sealed != nil && sealed.KeyID != twoFactor.keys.CurrentKeyID()
+}
+
+// resealTOTPSecret seals a user's TOTP secret with the current master key,
+// unless it already is.
+func resealTOTPSecret(username string, sealed *models.SealedSecrets) (*models.SealedSecrets, error) {
+	if !staleTOTPSecret(sealed) {
+		return sealed, nil
+	}
+	secret, err := openTOTPSecret(username, sealed)
+	if err != nil {
+		return nil, err
+	}
+	return twoFactor.keys.SealValue(totpContext(username), []byte(secret))
+}
+
+func openTOTPSecret(username string, sealed *models.SealedSecrets) (string, error) {
+	secret, err := twoFactor.keys.OpenValue(totpContext(username), sealed)
+	if err != nil {
+		return "", fmt.Errorf("failed to open TOTP secret of '%s': %w", username, err)
+	}
+	return string(secret), nil
+}
@@ -0,0 +1,172 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// withTwoFactor gives the two-factor functions a fresh master key.
+func withTwoFactor(t *testing.T) {
+	t.Helper()
+	keys, err := NewKeyring(newKey(t))
+	if err != nil {
+		t.Fatalf("NewKeyring: %v", err)
+	}
+	original := twoFactor.keys
+	twoFactor.keys = keys
+	t.Cleanup(func() { twoFactor.keys = original })
+}
+
+func TestTwoFactorEnrollment(t *testing.T) {
+	withActivities(t)
+	withUsers(t)
+	withTwoFactor(t)
+	if _, err := CreateUser("erin", "erin's password", RoleEditor); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+
+	if _, err := BeginTOTPEnrollment("erin", "wrong"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("wrong password: %v", err)
+	}
+	enrollment, err := BeginTOTPEnrollment("erin", "erin's password")
+	if err != nil {
+		t.Fatalf("BeginTOTPEnrollment: %v", err)
+	}
+	if _, err := ConfirmTOTPEnrollment("erin", "000000"); !errors.Is(err, ErrInvalidCode) {
+		t.Errorf("wrong code: %v", err)
+	}
+	step := totpStep(time.Now())
+	code, _ := totpCode(enrollment.Secret, step)
+	recovery, err := ConfirmTOTPEnrollment("erin", code)
+	if err != nil || len(recovery) != recoveryCodeCount {
+		t.Fatalf("ConfirmTOTPEnrollment = %v, %v", recovery, err)
+	}
+
+	// The secret is sealed, never stored in clear
+	user, _ := Users.Get("erin")
+	if !user.TwoFactorEnabled || user.TwoFactor.Secret == nil || user.TwoFactor.PendingSecret != nil {
+		t.Fatalf("unexpected settings %+v", user.TwoFactor)
+	}
+	if _, err := twoFactor.keys.OpenValue(totpContext("mallory"), user.TwoFactor.Secret); err == nil {
+		t.Error("the secret opened for another user")
+	}
+
+	// The password alone no longer starts a session, and codes are single use
+	if user, err := Authenticate(context.Background(), "erin", "erin's password"); err != nil || !user.TwoFactorEnabled {
+		t.Fatalf("Authenticate = %+v, %v", user, err)
+	}
+	challenge, _ := StartLoginChallenge(user)
+	if _, _, err := CompleteLoginChallenge(context.Background(), challenge.ID, code); !errors.Is(err, ErrInvalidCode) {
+		t.Errorf("replayed code: %v", err)
+	}
+	next, _ := totpCode(enrollment.Secret, step+1)
+	if _, used, err := CompleteLoginChallenge(context.Background(), challenge.ID, next); err != nil || used {
+		t.Fatalf("CompleteLoginChallenge = %v, %v", used, err)
+	}
+	if _, _, err := CompleteLoginChallenge(context.Background(), challenge.ID, next); !errors.Is(err, ErrInvalidChallenge) {
+		t.Errorf("completed challenge reused: %v", err)
+	}
+
+	// Recovery codes work once, whatever their formatting
+	challenge, _ = StartLoginChallenge(user)
+	if _, used, err := CompleteLoginChallenge(context.Background(), challenge.ID, " "+recovery[0][:9]+recovery[0][10:]+" "); err != nil || !used {
+		t.Fatalf("recovery code: %v, %v", used, err)
+	}
+	challenge, _ = StartLoginChallenge(user)
+	if _, _, err := CompleteLoginChallenge(context.Background(), challenge.ID, recovery[0]); !errors.Is(err, ErrInvalidCode) {
+		t.Errorf("used recovery code: %v", err)
+	}
+	if user, _ := Users.Get("erin"); len(user.TwoFactor.RecoveryCodes) != recoveryCodeCount-1 {
+		t.Errorf("%d recovery codes left", len(user.TwoFactor.RecoveryCodes))
+	}
+
+	if _, err := DisableTwoFactor("erin", "erin's password", "123"); !errors.Is(err, ErrInvalidCode) {
+		t.Errorf("disable with a wrong code: %v", err)
+	}
+	if _, err := ResetTwoFactor("erin"); err != nil {
+		t.Fatalf("ResetTwoFactor: %v", err)
+	}
+	if _, err := ResetTwoFactor("erin"); !errors.Is(err, ErrTwoFactorNotEnabled) {
+		t.Errorf("second reset: %v", err)
+	}
+}
+
+func TestLoginChallengeAttempts(t *testing.T) {
+	withActivities(t)
+	withUsers(t)
+	withTwoFactor(t)
+	loginPolicy.maxAttempts, loginPolicy.lockout = 3, time.Hour
+	t.Cleanup(func() { loginPolicy.maxAttempts, loginPolicy.lockout = 5, 15*time.Minute })
+	CreateUser("frank", "frank's password", RoleViewer)
+	enrollment, _ := BeginTOTPEnrollment("frank", "frank's password")
+	code, _ := totpCode(enrollment.Secret, totpStep(time.Now()))
+	if _, err := ConfirmTOTPEnrollment("frank", code); err != nil {
+		t.Fatalf("ConfirmTOTPEnrollment: %v", err)
+	}
+
+	// Wrong codes count towards the lockout like wrong passwords
+	user, _ := Users.Get("frank")
+	challenge, _ := StartLoginChallenge(user)
+	for i := 0; i < 3; i++ {
+		if _, _, err := CompleteLoginChallenge(context.Background(), challenge.ID, "000000"); !errors.Is(err, ErrInvalidCode) {
+			t.Fatalf("attempt %d: %v", i+1, err)
+		}
+	}
+	var locked *UserLockedError
+	next, _ := totpCode(enrollment.Secret, totpStep(time.Now())+1)
+	if _, _, err := CompleteLoginChallenge(context.Background(), challenge.ID, next); !errors.As(err, &locked) {
+		t.Fatalf("expected the account to be locked, got %v", err)
+	}
+
+	// A challenge only takes a few codes, even without a lockout
+	loginPolicy.maxAttempts = 0
+	challenge, _ = StartLoginChallenge(user)
+	for i := 0; i < loginChallengeAttempts; i++ {
+		CompleteLoginChallenge(context.Background(), challenge.ID, "000000")
+	}
+	if _, _, err := CompleteLoginChallenge(context.Background(), challenge.ID, next); !errors.Is(err, ErrInvalidChallenge) {
+		t.Errorf("challenge after %d attempts: %v", loginChallengeAttempts, err)
+	}
+}
+
+func TestRotateSecretsReSealsTOTPSecrets(t *testing.T) {
+	withSites(t)
+	withUsers(t)
+	withTwoFactor(t)
+	oldKey, newKey := newKey(t), newKey(t)
+	twoFactor.keys, _ = NewKeyring(oldKey)
+
+	// erin has two-factor enabled, frank has only started enrolling
+	secrets := map[string]string{}
+	for _, name := range []string{"erin", "frank"} {
+		if _, err := CreateUser(name, name+"'s password", RoleEditor); err != nil {
+			t.Fatalf("CreateUser: %v", err)
+		}
+		enrollment, err := BeginTOTPEnrollment(name, name+"'s password")
+		if err != nil {
+			t.Fatalf("BeginTOTPEnrollment: %v", err)
+		}
+		secrets[name] = enrollment.Secret
+	}
+	code, _ := totpCode(secrets["erin"], totpStep(time.Now()))
+	if _, err := ConfirmTOTPEnrollment("erin", code); err != nil {
+		t.Fatalf("ConfirmTOTPEnrollment: %v", err)
+	}
+
+	twoFactor.keys, _ = NewKeyring(newKey, oldKey)
+	if err := RotateSecrets(); err != nil {
+		t.Fatalf("RotateSecrets: %v", err)
+	}
+
+	twoFactor.keys, _ = NewKeyring(newKey)
+	erin, _ := Users.Get("erin")
+	if secret, err := openTOTPSecret("erin", erin.TwoFactor.Secret); err != nil || secret != secrets["erin"] {
+		t.Errorf("erin's secret after dropping the old key = %q, %v", secret, err)
+	}
+	frank, _ := Users.Get("frank")
+	if secret, err := openTOTPSecret("frank", frank.TwoFactor.PendingSecret); err != nil || secret != secrets["frank"] {
+		t.Errorf("frank's pending secret after dropping the old key = %q, %v", secret, err)
+	}
+}
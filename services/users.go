@@ -0,0 +1,429 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"wordpress-collab-tool/config"
+	"wordpress-collab-tool/models"
+	"wordpress-collab-tool/utils"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Errors returned by UserStore implementations and the account functions.
+var (
+	ErrUserNotFound        = errors.New("user not found")
+	ErrUserExists          = errors.New("user already exists")
+	ErrUserVersionConflict = errors.New("user was modified concurrently")
+	ErrInvalidCredentials  = errors.New("invalid username or password")
+	ErrUserDisabled        = errors.New("user is disabled")
+	ErrUserOwnsSites       = errors.New("user still owns sites")
+)
+
+// UserLockedError is returned when signing in to an account that is locked
+// after too many failed attempts.
+type UserLockedError struct {
+	Username string
+	Until    time.Time
+}
+
+func (e *UserLockedError) Error() string {
+	return fmt.Sprintf("user '%s' is locked until %s", e.Username, e.Until.Format(time.RFC3339))
+}
+
+// UserStore persists user accounts. Like sites, users carry a version and
+// Update only succeeds when the user passed in still has the stored version.
+type UserStore interface {
+	// Get returns a user, or ErrUserNotFound.
+	Get(username string) (models.User, error)
+	// List returns every user, ordered by username.
+	List() ([]models.User, error)
+	// Create stores a new user with version 1, or returns ErrUserExists.
+	Create(user models.User) (models.User, error)
+	// Update replaces a user and returns it with its new version. It returns
+	// ErrUserVersionConflict if the user changed since user.Version was read.
+	Update(user models.User) (models.User, error)
+	// Delete removes a user, or returns ErrUserNotFound.
+	Delete(username string) error
+	Close() error
+}
+
+// Users holds the user accounts. It is nil until InitUserStore runs.
+var Users UserStore
+
+// loginPolicy decides when failed sign-ins lock an account.
+var loginPolicy = struct {
+	maxAttempts int
+	lockout     time.Duration
+}{maxAttempts: 5, lockout: 15 * time.Minute}
+
+// userUpdateAttempts bounds how often UpdateUser retries after a conflict.
+const userUpdateAttempts = 5
+
+// bcryptCost is the work factor of stored password hashes.
+var bcryptCost = bcrypt.DefaultCost
+
+var (
+	usernamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._@-]{0,63}$`)
+
+	// dummyHash is compared against when a username does not exist, so that
+	// the response time does not reveal which usernames do.
+	dummyHash     []byte
+	dummyHashOnce sync.Once
+)
+
+// minPasswordLength and maxPasswordLength bound account passwords. bcrypt
+// ignores everything past 72 bytes.
+const (
+	minPasswordLength = 8
+	maxPasswordLength = 72
+)
+
+// InitUserStore opens the user accounts in the SQLite database and creates
+// the first admin from the configuration when there are no users yet.
+func InitUserStore(cfg *config.Config) error {
+	store, err := OpenSQLiteUserStore(cfg.DatabasePath)
+	if err != nil {
+		return err
+	}
+	Users = store
+	loginPolicy.maxAttempts = cfg.LoginMaxAttempts
+	loginPolicy.lockout = cfg.LoginLockout
+	return bootstrapAdmin(cfg.AdminUsername, cfg.AdminPassword)
+}
+
+// bootstrapAdmin creates the first account when the store is empty.
+func bootstrapAdmin(username, password string) error {
+	users, err := Users.List()
+	if err != nil {
+		return err
+	}
+	if len(users) > 0 {
+		return nil
+	}
+	if password == "" {
+		utils.LogInfo("No user accounts exist; set ADMIN_PASSWORD to create the first admin '%s'", username)
+		return nil
+	}
+	if _, err := CreateUser(username, password, RoleAdmin); err != nil {
+		return fmt.Errorf("failed to create the first admin: %w", err)
+	}
+	LogActivity("security", fmt.Sprintf("Initial admin account '%s' created from the configuration.", username), "")
+	return nil
+}
+
+// ValidateUsername checks that username is usable as an account name.
+func ValidateUsername(username string) error {
+	if !usernamePattern.MatchString(username) {
+		return fmt.Errorf("%w: username must be 1-64 letters, digits or . _ @ - and start with a letter or digit", ErrInvalidInput)
+	}
+	return nil
+}
+
+// ValidatePassword checks that password is acceptable for an account.
+func ValidatePassword(password string) error {
+	if len(password) < minPasswordLength || len(password) > maxPasswordLength {
+		return fmt.Errorf("%w: password must be between %d and %d bytes", ErrInvalidInput, minPasswordLength, maxPasswordLength)
+	}
+	for _, r := range password {
+		if r < 0x20 || r == 0x7f {
+			return fmt.Errorf("%w: password must not contain control characters", ErrInvalidInput)
+		}
+	}
+	return nil
+}
+
+func hashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcryptCost)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash password: %w", err)
+	}
+	return string(hash), nil
+}
+
+// CreateUser validates and stores a new account with the given role.
+func CreateUser(username, password, role string) (models.User, error) {
+	if err := ValidateUsername(username); err != nil {
+		return models.User{}, err
+	}
+	if err := ValidateRole(role); err != nil {
+		return models.User{}, err
+	}
+	if err := ValidatePassword(password); err != nil {
+		return models.User{}, err
+	}
+	hash, err := hashPassword(password)
+	if err != nil {
+		return models.User{}, err
+	}
+	now := time.Now().UTC()
+	return Users.Create(models.User{
+		Username:          username,
+		PasswordHash:      hash,
+		Role:              role,
+		PasswordChangedAt: now,
+		CreatedAt:         now,
+		UpdatedAt:         now,
+	})
+}
+
+// UpdateUser applies change to the current version of a user and stores
+// it, retrying when another update got there first. An error returned by
+// change aborts the update.
+func UpdateUser(username string, change func(*models.User) error) (models.User, error) {
+	var err error
+	for attempt := 0; attempt < userUpdateAttempts; attempt++ {
+		var user models.User
+		user, err = Users.Get(username)
+		if err != nil {
+			return models.User{}, err
+		}
+		if err := change(&user); err != nil {
+			return models.User{}, err
+		}
+		user.UpdatedAt = time.Now().UTC()
+		user, err = Users.Update(user)
+		if !errors.Is(err, ErrUserVersionConflict) {
+			return user, err
+		}
+	}
+	return models.User{}, err
+}
+
+// SetPassword replaces a user's password, unlocks the account and ends
+// its sessions.
+func SetPassword(username, password string) (models.User, error) {
+	if err := ValidatePassword(password); err != nil {
+		return models.User{}, err
+	}
+	hash, err := hashPassword(password)
+	if err != nil {
+		return models.User{}, err
+	}
+	user, err := UpdateUser(username, func(u *models.User) error {
+		u.PasswordHash = hash
+		u.PasswordChangedAt = time.Now().UTC()
+		u.SessionVersion++
+		u.FailedLogins = 0
+		u.LockedUntil = nil
+		return nil
+	})
+	if err != nil {
+		return models.User{}, err
+	}
+	clearLoginThrottle(username)
+	return user, revokeUserSessions(username)
+}
+
+// ChangePassword replaces a user's password after checking the current one.
+func ChangePassword(username, current, password string) (models.User, error) {
+	user, err := Users.Get(username)
+	if err != nil {
+		return models.User{}, err
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(current)) != nil {
+		return models.User{}, ErrInvalidCredentials
+	}
+	return SetPassword(username, password)
+}
+
+// SetUserRole changes the role of an account. It takes effect on the
+// user's next request.
+func SetUserRole(username, role string) (models.User, error) {
+	if err := ValidateRole(role); err != nil {
+		return models.User{}, err
+	}
+	return UpdateUser(username, func(u *models.User) error {
+		u.Role = role
+		return nil
+	})
+}
+
+// SetUserDisabled disables or re-enables an account. Disabling it ends
+// its sessions.
+func SetUserDisabled(username string, disabled bool) (models.User, error) {
+	user, err := UpdateUser(username, func(u *models.User) error {
+		if disabled && !u.Disabled {
+			u.SessionVersion++
+		}
+		u.Disabled = disabled
+		return nil
+	})
+	if err != nil || !disabled {
+		return user, err
+	}
+	return user, revokeUserSessions(username)
+}
+
+// DeleteUser removes an account, ends its sessions, deletes its personal
+// access tokens and takes away its site memberships, so that a later
+// account of the same name starts without them. Users who still own sites
+// cannot be deleted until the sites are transferred.
+func DeleteUser(username string) error {
+	sites, err := Sites.List()
+	if err != nil {
+		return err
+	}
+	var owned []string
+	for _, site := range sites {
+		if site.Owner == username {
+			owned = append(owned, site.ProjectName)
+		}
+	}
+	if len(owned) > 0 {
+		return fmt.Errorf("%w: '%s' owns %s", ErrUserOwnsSites, username, strings.Join(owned, ", "))
+	}
+
+	if err := Users.Delete(username); err != nil {
+		return err
+	}
+	if err := revokeUserSessions(username); err != nil {
+		return err
+	}
+	if err := deleteUserAPITokens(username); err != nil {
+		return err
+	}
+	return removeUserMemberships(username, sites)
+}
+
+// Authenticate checks a username and password. Failures slow down further
+// attempts from the same client and for the same username, and
+// consecutive ones lock the account for the configured duration. The same
+// error is returned for an unknown user and a wrong password, and unknown,
+// locked and other accounts all take as long to check. Users with two-factor authentication must then
+// complete a login challenge; see StartLoginChallenge.
+func Authenticate(ctx context.Context, username, password string) (models.User, error) {
+	if err := checkLoginThrottle(ctx, username); err != nil {
+		return models.User{}, err
+	}
+	user, err := Users.Get(username)
+	if errors.Is(err, ErrUserNotFound) {
+		bcrypt.CompareHashAndPassword(unknownUserHash(), []byte(password))
+		recordThrottledFailure(ctx, username)
+		return models.User{}, ErrInvalidCredentials
+	}
+	if err != nil {
+		return models.User{}, err
+	}
+
+	// The hash is compared before the lock is looked at, so that answering
+	// for a locked account takes as long as for any other
+	passwordOK := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)) == nil
+	now := time.Now().UTC()
+	if user.LockedUntil != nil && now.Before(*user.LockedUntil) {
+		if !passwordOK {
+			recordThrottledFailure(ctx, username)
+		}
+		return models.User{}, &UserLockedError{Username: username, Until: *user.LockedUntil}
+	}
+
+	if !passwordOK {
+		recordThrottledFailure(ctx, username)
+		recordFailedLogin(ctx, username)
+		return models.User{}, ErrInvalidCredentials
+	}
+
+	// Only reveal that an account is disabled to someone who knows its password
+	if user.Disabled {
+		return models.User{}, ErrUserDisabled
+	}
+
+	// The failures count on until the second factor is given as well, so
+	// that signing in again does not buy more guesses at the code
+	if user.TwoFactorEnabled {
+		return user, nil
+	}
+	return recordLogin(username)
+}
+
+// recordFailedLogin counts a failed sign-in and locks the account when
+// there were too many in a row.
+func recordFailedLogin(ctx context.Context, username string) {
+	locked := false
+	_, err := UpdateUser(username, func(u *models.User) error {
+		u.FailedLogins++
+		if loginPolicy.maxAttempts > 0 && u.FailedLogins >= loginPolicy.maxAttempts {
+			until := time.Now().UTC().Add(loginPolicy.lockout)
+			u.LockedUntil = &until
+			u.FailedLogins = 0
+			locked = true
+		}
+		return nil
+	})
+	if err != nil {
+		utils.LogError("Failed to record failed sign-in of '%s': %v", username, err)
+	}
+	if locked {
+		LogActivityContext(ctx, "security", "user.lock", fmt.Sprintf("User '%s' was locked for %s after %d failed sign-ins.", username, loginPolicy.lockout, loginPolicy.maxAttempts), "")
+	}
+}
+
+// recordLogin resets the failed sign-ins of a user who signed in.
+func recordLogin(username string) (models.User, error) {
+	clearLoginThrottle(username)
+	now := time.Now().UTC()
+	return UpdateUser(username, func(u *models.User) error {
+		u.FailedLogins = 0
+		u.LockedUntil = nil
+		u.LastLoginAt = &now
+		return nil
+	})
+}
+
+func unknownUserHash() []byte {
+	dummyHashOnce.Do(func() {
+		dummyHash, _ = bcrypt.GenerateFromPassword([]byte("unknown user"), bcryptCost)
+	})
+	return dummyHash
+}
+
+// jwtKeySize is the size of a generated token signing key.
+const jwtKeySize = 32
+
+// JWTKey signs and verifies API tokens. InitJWTKey loads it from the
+// configuration.
+var JWTKey []byte
+
+// InitJWTKey loads the token signing key from JWT_SECRET or the key file,
+// generating the file on first run so that tokens survive restarts.
+func InitJWTKey(cfg *config.Config) error {
+	if cfg.JWTSecret != "" {
+		if len(cfg.JWTSecret) < jwtKeySize {
+			utils.LogInfo("JWT_SECRET is shorter than %d bytes; use a longer random value", jwtKeySize)
+		}
+		JWTKey = []byte(cfg.JWTSecret)
+		return nil
+	}
+
+	data, err := os.ReadFile(cfg.JWTKeyFile)
+	if os.IsNotExist(err) {
+		key := make([]byte, jwtKeySize)
+		if _, err := rand.Read(key); err != nil {
+			return fmt.Errorf("failed to generate JWT key: %w", err)
+		}
+		if err := os.WriteFile(cfg.JWTKeyFile, []byte(base64.StdEncoding.EncodeToString(key)+"\n"), 0600); err != nil {
+			return fmt.Errorf("failed to write JWT key file: %w", err)
+		}
+		utils.LogInfo("Generated a new JWT signing key in %s", cfg.JWTKeyFile)
+		JWTKey = key
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read JWT key file: %w", err)
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(key) == 0 {
+		return fmt.Errorf("JWT key file %s does not hold a base64 key", cfg.JWTKeyFile)
+	}
+	JWTKey = key
+	return nil
+}
@@ -0,0 +1,197 @@
+package services
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"wordpress-collab-tool/models"
+)
+
+// SQLiteUserStore keeps user accounts in an embedded SQLite database. Each
+// user is stored as JSON, with the password hash and the two-factor
+// settings in columns of their own.
+type SQLiteUserStore struct {
+	db *sql.DB
+}
+
+var userMigrations = []migration{
+	{
+		name: "create users",
+		statements: []string{
+			`CREATE TABLE users (
+				username TEXT PRIMARY KEY,
+				password_hash TEXT NOT NULL,
+				session_version INTEGER NOT NULL DEFAULT 0,
+				version INTEGER NOT NULL,
+				data TEXT NOT NULL,
+				created_at TEXT NOT NULL,
+				updated_at TEXT NOT NULL
+			)`,
+		},
+	},
+	{
+		// Every account had full access before roles existed
+		name: "add user roles",
+		statements: []string{
+			`UPDATE users SET data = json_set(data, '$.role', 'admin') WHERE json_extract(data, '$.role') IS NULL`,
+		},
+	},
+	{
+		name: "add user two-factor settings",
+		statements: []string{
+			`ALTER TABLE users ADD COLUMN two_factor TEXT NOT NULL DEFAULT '{}'`,
+		},
+	},
+}
+
+// OpenSQLiteUserStore opens the user accounts in the database at path.
+func OpenSQLiteUserStore(path string) (*SQLiteUserStore, error) {
+	db, err := openSQLite(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := migrate(db, userMigrations); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &SQLiteUserStore{db: db}, nil
+}
+
+const userColumns = `data, password_hash, two_factor, session_version, version`
+
+// Get returns a user, or ErrUserNotFound.
+func (s *SQLiteUserStore) Get(username string) (models.User, error) {
+	user, err := scanUser(s.db.QueryRow(`SELECT `+userColumns+` FROM users WHERE username = ?`, username))
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.User{}, fmt.Errorf("%w: '%s'", ErrUserNotFound, username)
+	}
+	return user, err
+}
+
+// List returns every user, ordered by username.
+func (s *SQLiteUserStore) List() ([]models.User, error) {
+	rows, err := s.db.Query(`SELECT ` + userColumns + ` FROM users ORDER BY username`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list users: %w", err)
+	}
+	defer rows.Close()
+
+	users := []models.User{}
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list users: %w", err)
+	}
+	return users, nil
+}
+
+// Create stores a new user with version 1.
+func (s *SQLiteUserStore) Create(user models.User) (models.User, error) {
+	user.Version = 1
+	data, twoFactor, err := encodeUser(user)
+	if err != nil {
+		return models.User{}, err
+	}
+	result, err := s.db.Exec(`INSERT INTO users (username, password_hash, two_factor, session_version, version, data, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?) ON CONFLICT (username) DO NOTHING`,
+		user.Username, user.PasswordHash, twoFactor, user.SessionVersion, user.Version, data, dbTimestamp(), dbTimestamp())
+	if err != nil {
+		return models.User{}, fmt.Errorf("failed to create user: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return models.User{}, fmt.Errorf("%w: '%s'", ErrUserExists, user.Username)
+	}
+	return user, nil
+}
+
+// Update replaces a user if its version still matches the stored one.
+func (s *SQLiteUserStore) Update(user models.User) (models.User, error) {
+	expected := user.Version
+	user.Version++
+	data, twoFactor, err := encodeUser(user)
+	if err != nil {
+		return models.User{}, err
+	}
+
+	result, err := s.db.Exec(`UPDATE users SET password_hash = ?, two_factor = ?, session_version = ?, version = ?, data = ?, updated_at = ?
+		WHERE username = ? AND version = ?`,
+		user.PasswordHash, twoFactor, user.SessionVersion, user.Version, data, dbTimestamp(), user.Username, expected)
+	if err != nil {
+		return models.User{}, fmt.Errorf("failed to update user: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 1 {
+		return user, nil
+	}
+
+	current, err := s.Get(user.Username)
+	if err != nil {
+		return models.User{}, err
+	}
+	return models.User{}, fmt.Errorf("%w: '%s' is at version %d, not %d", ErrUserVersionConflict, user.Username, current.Version, expected)
+}
+
+// Delete removes a user.
+func (s *SQLiteUserStore) Delete(username string) error {
+	result, err := s.db.Exec(`DELETE FROM users WHERE username = ?`, username)
+	if err != nil {
+		return fmt.Errorf("failed to delete user: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("%w: '%s'", ErrUserNotFound, username)
+	}
+	return nil
+}
+
+// Close closes the database.
+func (s *SQLiteUserStore) Close() error {
+	return s.db.Close()
+}
+
+// rowScanner is satisfied by *sql.Row and *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// encodeUser returns the JSON of user and of its two-factor settings.
+func encodeUser(user models.User) (data, twoFactor string, err error) {
+	d, err := json.Marshal(user)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to encode user: %w", err)
+	}
+	tf, err := json.Marshal(user.TwoFactor)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to encode user: %w", err)
+	}
+	return string(d), string(tf), nil
+}
+
+func scanUser(row rowScanner) (models.User, error) {
+	var data, twoFactor string
+	var user models.User
+	var hash string
+	var sessionVersion int
+	var version int64
+	if err := row.Scan(&data, &hash, &twoFactor, &sessionVersion, &version); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.User{}, err
+		}
+		return models.User{}, fmt.Errorf("failed to read user: %w", err)
+	}
+	if err := json.Unmarshal([]byte(data), &user); err != nil {
+		return models.User{}, fmt.Errorf("failed to decode user: %w", err)
+	}
+	if err := json.Unmarshal([]byte(twoFactor), &user.TwoFactor); err != nil {
+		return models.User{}, fmt.Errorf("failed to decode user: %w", err)
+	}
+	user.PasswordHash = hash
+	user.SessionVersion = sessionVersion
+	user.Version = version
+	return user, nil
+}
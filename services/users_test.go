@@ -0,0 +1,116 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"wordpress-collab-tool/config"
+	"wordpress-collab-tool/models"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// withUsers points the user store at a fresh database.
+func withUsers(t *testing.T) {
+	t.Helper()
+	store, err := OpenSQLiteUserStore(filepath.Join(t.TempDir(), "users.db"))
+	if err != nil {
+		t.Fatalf("OpenSQLiteUserStore: %v", err)
+	}
+	original, originalCost := Users, bcryptCost
+	Users, bcryptCost = store, bcrypt.MinCost
+	t.Cleanup(func() {
+		Users, bcryptCost = original, originalCost
+		store.Close()
+	})
+}
+
+func TestBootstrapAdmin(t *testing.T) {
+	withActivities(t)
+	withUsers(t)
+
+	if err := bootstrapAdmin("admin", ""); err != nil {
+		t.Fatalf("bootstrapAdmin without password: %v", err)
+	}
+	if users, _ := Users.List(); len(users) != 0 {
+		t.Fatalf("created a user without a password: %+v", users)
+	}
+
+	if err := bootstrapAdmin("admin", "first admin password"); err != nil {
+		t.Fatalf("bootstrapAdmin: %v", err)
+	}
+	admin, err := Users.Get("admin")
+	if err != nil || !strings.HasPrefix(admin.PasswordHash, "$2a$") {
+		t.Fatalf("admin was not stored with a bcrypt hash: %+v %v", admin, err)
+	}
+
+	// Later starts leave the existing accounts alone
+	if err := bootstrapAdmin("admin", "another password"); err != nil {
+		t.Fatalf("bootstrapAdmin again: %v", err)
+	}
+	if _, err := Authenticate(context.Background(), "admin", "first admin password"); err != nil {
+		t.Errorf("bootstrap changed the admin password: %v", err)
+	}
+}
+
+func TestAuthenticateLocksAfterFailures(t *testing.T) {
+	withActivities(t)
+	withUsers(t)
+	loginPolicy.maxAttempts, loginPolicy.lockout = 3, time.Hour
+	t.Cleanup(func() { loginPolicy.maxAttempts, loginPolicy.lockout = 5, 15*time.Minute })
+
+	if _, err := CreateUser("dave", "dave's password", RoleViewer); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	if _, err := CreateUser("dave", "dave's password", RoleViewer); !errors.Is(err, ErrUserExists) {
+		t.Errorf("duplicate user: expected ErrUserExists, got %v", err)
+	}
+	if _, err := Authenticate(context.Background(), "nobody", "dave's password"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("unknown user: expected ErrInvalidCredentials, got %v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, err := Authenticate(context.Background(), "dave", "wrong"); !errors.Is(err, ErrInvalidCredentials) {
+			t.Fatalf("attempt %d: expected ErrInvalidCredentials, got %v", i+1, err)
+		}
+	}
+	var locked *UserLockedError
+	if _, err := Authenticate(context.Background(), "dave", "dave's password"); !errors.As(err, &locked) {
+		t.Fatalf("expected the account to be locked, got %v", err)
+	}
+
+	// The lock expires on its own
+	UpdateUser("dave", func(u *models.User) error {
+		past := time.Now().Add(-time.Minute)
+		u.LockedUntil = &past
+		return nil
+	})
+	user, err := Authenticate(context.Background(), "dave", "dave's password")
+	if err != nil || user.LastLoginAt == nil || user.FailedLogins != 0 {
+		t.Errorf("expected a successful sign-in after the lock expired: %+v %v", user, err)
+	}
+}
+
+func TestInitJWTKeyPersistsGeneratedKey(t *testing.T) {
+	cfg := &config.Config{JWTKeyFile: filepath.Join(t.TempDir(), "jwt.key")}
+	if err := InitJWTKey(cfg); err != nil {
+		t.Fatalf("InitJWTKey: %v", err)
+	}
+	first := string(JWTKey)
+	if info, err := os.Stat(cfg.JWTKeyFile); err != nil || info.Mode().Perm() != 0600 {
+		t.Fatalf("key file was not written privately: %v %v", info, err)
+	}
+	if err := InitJWTKey(cfg); err != nil || string(JWTKey) != first || len(JWTKey) != jwtKeySize {
+		t.Errorf("key changed across restarts: %v", err)
+	}
+
+	cfg.JWTSecret = "configured secret"
+	if err := InitJWTKey(cfg); err != nil || string(JWTKey) != "configured secret" {
+		t.Errorf("configured secret was not used: %v", err)
+	}
+}
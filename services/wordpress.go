@@ -1,28 +1,27 @@
 package services
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"math/rand"
 	"net/http" // Added for GetSiteStatus
-	"os"
 	"path/filepath"
 	"strings"
-	"sync"
-	"text/template"
 	"time"
 
-	"golang.org/x/crypto/ssh"
-	"wordpress-collab-tool/config"
 	"wordpress-collab-tool/models"
 	"wordpress-collab-tool/utils"
 )
 
-const (
-	sitesFilePath    = "sites.json"
-	activitiesFilePath = "activities.json"
+const sitesFilePath = "sites.json"
+
+// Pauses used while waiting for containers to come up. Tests shorten them.
+var (
+	containerStartDelay = 5 * time.Second
+	pluginInstallDelay  = 10 * time.Second
+	healthCheckInterval = 5 * time.Second
 )
 
 // GenerateRandomPassword generates a random string of specified length.
@@ -36,291 +35,111 @@ func GenerateRandomPassword(length int) string {
 	return string(b)
 }
 
-// ReadSites reads the list of sites from sites.json.
-func ReadSites() ([]models.Site, error) {
-	var sites []models.Site
-	if _, err := os.Stat(sitesFilePath); os.IsNotExist(err) {
-		return []models.Site{}, nil // Return empty slice if file doesn't exist
-	}
-
-	data, err := ioutil.ReadFile(sitesFilePath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read sites file: %w", err)
-	}
-
-	if len(data) == 0 {
-		return []models.Site{}, nil // Return empty slice if file is empty
-	}
-
-	err = json.Unmarshal(data, &sites)
-	if err != nil {
-		return nil, fmt.Errorf("failed to unmarshal sites data: %w", err)
-	}
-	return sites, nil
-}
-
-// ReadSitesOrEmpty reads the list of sites from sites.json, returning an empty slice on error.
-func ReadSitesOrEmpty() []models.Site {
-	sites, err := ReadSites()
-	if err != nil {
-		utils.LogError("Error reading sites file, returning empty slice: %v", err)
-		return []models.Site{}
-	}
-	return sites
-}
-
-// WriteSites writes the list of sites to sites.json.
-func WriteSites(sites []models.Site) error {
-	data, err := json.MarshalIndent(sites, "", "  ")
-	if err != nil {
-		return fmt.Errorf("failed to marshal sites data: %w", err)
-	}
+// ErrSiteNotFound is returned when a project name does not match any site.
+var ErrSiteNotFound = errors.New("site not found")
 
-	err = ioutil.WriteFile(sitesFilePath, data, 0644)
-	if err != nil {
-		return fmt.Errorf("failed to write sites file: %w", err)
-	}
-	return nil
-}
-
-var activityMux sync.Mutex
-
-// LogActivity logs an activity to activities.json in a thread-safe manner.
-func LogActivity(level, message, projectName string) {
-	activityMux.Lock()
-	defer activityMux.Unlock()
-
-	activities, err := ReadActivities()
-	if err != nil {
-		activities = []models.Activity{}
-	}
-
-	newActivity := models.Activity{
-		Level:       level,
-		Message:     message,
-		ProjectName: projectName,
-		Timestamp:   time.Now().Format(time.RFC3339),
-	}
-	activities = append(activities, newActivity)
-
-	data, err := json.MarshalIndent(activities, "", "  ")
-	if err != nil {
-		utils.LogError("Failed to marshal activities data: %v", err)
-		return
-	}
-
-	err = ioutil.WriteFile(activitiesFilePath, data, 0644)
-	if err != nil {
-		utils.LogError("Failed to write activities file: %v", err)
-	}
-}
-
-// ReadActivities reads the list of activities from activities.json.
-func ReadActivities() ([]models.Activity, error) {
-	var activities []models.Activity
-	if _, err := os.Stat(activitiesFilePath); os.IsNotExist(err) {
-		return []models.Activity{}, nil // Return empty slice if file doesn't exist
-	}
-
-	data, err := ioutil.ReadFile(activitiesFilePath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read activities file: %w", err)
-	}
-
-	if len(data) == 0 {
-		return []models.Activity{}, nil // Return empty slice if file is empty
-	}
-
-	err = json.Unmarshal(data, &activities)
-	if err != nil {
-		return nil, fmt.Errorf("failed to unmarshal activities data: %w", err)
-	}
-
-	// Reverse the order of activities to show newest first
-	for i, j := 0, len(activities)-1; i < j; i, j = i+1, j-1 {
-		activities[i], activities[j] = activities[j], activities[i]
-	}
-
-	return activities, nil
+// GetSite looks up a site by project name.
+func GetSite(projectName string) (models.Site, error) {
+	return Sites.Get(projectName)
 }
 
 // UpdateSiteStatus updates the status of a site.
 func UpdateSiteStatus(projectName, status string) {
-	sites, err := ReadSites()
+	_, err := UpdateSite(projectName, func(site *models.Site) {
+		site.Status = status
+		site.LastChecked = time.Now().Format(time.RFC3339)
+	})
 	if err != nil {
-		utils.LogError("Failed to read sites for status update: %v", err)
-		return
-	}
-
-	for i := range sites {
-		if sites[i].ProjectName == projectName {
-			sites[i].Status = status
-			sites[i].LastChecked = time.Now().Format(time.RFC3339)
-			break
-		}
-	}
-
-	if err := WriteSites(sites); err != nil {
-		utils.LogError("Failed to write sites after status update: %v", err)
+		utils.LogError("Failed to update status of site '%s': %v", projectName, err)
 	}
 }
 
-// CleanupSite cleans up resources if site creation fails.
-func CleanupSite(client *ssh.Client, projectName string, wpPort int) {
-	utils.LogInfo("Cleaning up resources for failed site: %s", projectName)
-	if client != nil {
-		remotePath := fmt.Sprintf("/var/www/%s", projectName)
-		// Stop and remove docker containers
-				RunSSHCommand(client, fmt.Sprintf("cd %s && docker compose -f %s/docker-compose.yml down", remotePath, remotePath))
-		// Remove remote directory
-		RunSSHCommand(client, fmt.Sprintf("sudo rm -rf %s", remotePath))
-	}
-	UpdateSiteStatus(projectName, "failed")
-	LogActivity("error", fmt.Sprintf("Site '%s' creation failed and resources cleaned up.", projectName), projectName)
+// RecordSiteStatus stores the status observed on a site that was read with
+// its current version. If the site changed in the meantime the observation
+// is dropped, since it may be older than the change.
+func RecordSiteStatus(site models.Site, status string) (models.Site, error) {
+	site.Status = status
+	site.LastChecked = time.Now().Format(time.RFC3339)
+	updated, err := Sites.Update(site)
+	if errors.Is(err, ErrSiteVersionConflict) || errors.Is(err, ErrSiteNotFound) {
+		return site, nil
+	}
+	return updated, err
 }
 
-// DeployWordPressSite handles the full deployment process of a WordPress site.
-func DeployWordPressSite(site models.Site, selectedPlugins []string, adminUsername, adminPassword string) error {
-	cfg := config.LoadConfig()
-
-	sshClient, err := GetSSHClient(cfg)
-	if err != nil {
-		return fmt.Errorf("failed to connect to VPS: %w", err)
-	}
-	defer sshClient.Close()
-
-	// Generate the docker-compose.yml file on the local machine
-	tmpl, err := template.ParseFiles("templates/template.yml")
-	if err != nil {
-		return fmt.Errorf("failed to parse template: %w", err)
-	}
-
-	var tpl bytes.Buffer
-	templateConfig := models.Config{
-		ProjectName: site.ProjectName,
-		WPPort:      site.WPPort,
-		DBName:      site.DBName,
-		DBPassword:  site.DBPassword,
-	}
-	if err := tmpl.Execute(&tpl, templateConfig); err != nil {
-		return fmt.Errorf("failed to execute template: %w", err)
-	}
-
-	remotePath := fmt.Sprintf("/var/www/%s", site.ProjectName)
-
-	// Create the remote directory with sudo and change ownership
-	utils.LogInfo("Creating remote directory: %s", remotePath)
-	stdout, stderr, err := RunSSHCommand(sshClient, fmt.Sprintf("sudo install -d -o %s -g %s %s", cfg.SSHUser, cfg.SSHUser, remotePath))
-	if err != nil {
-		return fmt.Errorf("failed to create and set ownership of remote directory: %w, stdout: %s, stderr: %s", err, stdout, stderr)
-	}
-	utils.LogInfo("Remote directory created successfully.")
-
-	sftpClient, err := GetSFTPClient(sshClient)
-	if err != nil {
-		return fmt.Errorf("failed to create SFTP client: %w", err)
-	}
-	defer sftpClient.Close()
-
-	// Upload the docker-compose.yml file
-	utils.LogInfo("Uploading docker-compose.yml to %s", remotePath)
-	err = UploadFile(sftpClient, filepath.Join(remotePath, "docker-compose.yml"), &tpl)
-	if err != nil {
-		return fmt.Errorf("failed to upload docker-compose.yml: %w", err)
-	}
-	utils.LogInfo("docker-compose.yml uploaded successfully.")
+// runLogged runs command, copying its output line by line to logs while also
+// returning it buffered for error reporting.
+func runLogged(ctx context.Context, executor Executor, logs *LogStream, command string) (string, string, error) {
+	var stdout, stderr strings.Builder
+	stdoutLines, stderrLines := logs.Writer("stdout"), logs.Writer("stderr")
+	err := executor.Stream(ctx, command, io.MultiWriter(&stdout, stdoutLines), io.MultiWriter(&stderr, stderrLines))
+	stdoutLines.Flush()
+	stderrLines.Flush()
+	return stdout.String(), stderr.String(), err
+}
 
-	// Check if the file exists and has the correct permissions
-	utils.LogInfo("Checking for docker-compose.yml in %s", remotePath)
-	stdout, stderr, err = RunSSHCommand(sshClient, fmt.Sprintf("ls -l %s", remotePath))
-	if err != nil {
-		utils.LogError("Failed to list files in remote directory: %v, stdout: %s, stderr: %s", err, stdout, stderr)
-	} else {
-		utils.LogInfo("Files in remote directory: %s", stdout)
+// statusOrUnknown returns the trimmed container status reported by docker inspect.
+func statusOrUnknown(output string) string {
+	if status := strings.TrimSpace(output); status != "" {
+		return status
 	}
+	return "unknown"
+}
 
-	// Run docker compose up -d
-	utils.LogInfo("Executing command: cd %s && docker compose up -d", remotePath)
-	stdout, stderr, err = RunSSHCommand(sshClient, fmt.Sprintf("cd %s && docker compose -f %s/docker-compose.yml up -d", remotePath, remotePath))
-	if err != nil {
-		return fmt.Errorf("failed to run docker compose up: %w, stdout: %s, stderr: %s", err, stdout, stderr)
+// validateDeployInput rejects values that must never reach a remote command
+// unchecked, even though every argument is quoted.
+func validateDeployInput(site models.Site, plugins []string, adminUsername, adminPassword string) error {
+	if err := ValidateProjectName(site.ProjectName); err != nil {
+		return err
 	}
-	utils.LogInfo("docker compose up -d command executed. stdout: %s, stderr: %s", stdout, stderr)
-	time.Sleep(5 * time.Second) // Give docker-compose a moment to start
-
-	// Wait for the WordPress container to be ready
-	utils.LogInfo("Waiting for WordPress container to be ready for site '%s'வுகளை...", site.ProjectName)
-	if err := waitForContainerHealthy(sshClient, site.ProjectName, "_wordpress", remotePath); err != nil {
-		return fmt.Errorf("WordPress container did not become ready: %w", err)
+	if err := ValidateAdminUsername(adminUsername); err != nil {
+		return err
 	}
-	utils.LogInfo("WordPress container for site '%s' is ready.", site.ProjectName)
-
-	// Wait for the CLI container to be ready
-	utils.LogInfo("Waiting for CLI container to be ready for site '%s'வுகளை...", site.ProjectName)
-	if err := waitForContainerHealthy(sshClient, site.ProjectName, "_cli", remotePath); err != nil {
-		return fmt.Errorf("CLI container did not become ready: %w", err)
+	if err := ValidateAdminPassword(adminPassword); err != nil {
+		return err
 	}
-	utils.LogInfo("CLI container for site '%s' is ready.", site.ProjectName)
-	time.Sleep(5 * time.Second) // Give CLI container a moment to be fully ready
-
-	// Install WordPress
-	wpInstallCmd := fmt.Sprintf("cd %s && docker compose -f %s/docker-compose.yml exec -T --user www-data %s_cli wp core install --url=%s --title='%s' --admin_user='%s' --admin_password='%s' --admin_email='admin@%s.com' --skip-email --debug", remotePath, remotePath, site.ProjectName, site.SiteURL, site.ProjectName, adminUsername, adminPassword, site.ProjectName)
-	utils.LogInfo("Executing WordPress install command: %s", wpInstallCmd)
-	stdout, stderr, err = RunSSHCommand(sshClient, wpInstallCmd)
-	if err != nil {
-		return fmt.Errorf("failed to install WordPress for site '%s'. Command: '%s', Error: %w, stdout: %s, stderr: %s", site.ProjectName, wpInstallCmd, err, stdout, stderr)
-	}
-	utils.LogInfo("WordPress installed successfully for site '%s'.", site.ProjectName)
-
-	// Install selected plugins
-	if len(selectedPlugins) > 0 {
-		utils.LogInfo("Waiting for 10 seconds before starting plugin installation...")
-		time.Sleep(10 * time.Second)
-		utils.LogInfo("Starting plugin installation for site '%s'.", site.ProjectName)
-
-		var installErrors []string
-		for _, plugin := range selectedPlugins {
-			pluginInstallCmd := fmt.Sprintf("cd %s && docker compose -f %s/docker-compose.yml exec -T %s_cli wp plugin install %s --activate", remotePath, remotePath, site.ProjectName, plugin)
-			stdout, stderr, err := RunSSHCommand(sshClient, pluginInstallCmd)
-			if err != nil {
-				errMessage := fmt.Sprintf("failed to install plugin '%s' for site '%s'. Error: %v, stdout: %s, stderr: %s", plugin, site.ProjectName, err, stdout, stderr)
-				utils.LogError(errMessage)
-				LogActivity("error", fmt.Sprintf("Failed to install plugin '%s' on site '%s'.", plugin, site.ProjectName), site.ProjectName)
-				installErrors = append(installErrors, errMessage)
-			} else {
-				utils.LogInfo("Plugin '%s' installed successfully on site '%s'.", plugin, site.ProjectName)
-				LogActivity("info", fmt.Sprintf("Plugin '%s' installed successfully on site '%s'.", plugin, site.ProjectName), site.ProjectName)
-			}
-		}
-
-		if len(installErrors) > 0 {
-			return fmt.Errorf("encountered errors during plugin installation:\n%s", strings.Join(installErrors, "\n"))
+	for _, plugin := range plugins {
+		if err := ValidatePluginSlug(plugin); err != nil {
+			return err
 		}
-
-		utils.LogInfo("All plugins installed successfully for site '%s'.", site.ProjectName)
 	}
-
 	return nil
 }
 
-// waitForContainerHealthy waits for a specific container to report a "healthy" status.
-func waitForContainerHealthy(client *ssh.Client, projectName, suffix, remotePath string) error {
+// waitForContainerHealthy waits for a specific container to report a "healthy"
+// status, polling until the health check timeout expires or ctx is done.
+func waitForContainerHealthy(ctx context.Context, executor Executor, logs *LogStream, projectName, suffix, remotePath string) error {
 	containerName := fmt.Sprintf("%s%s", projectName, suffix)
-	for i := 0; i < 24; i++ { // 2 minutes timeout (24 * 5 seconds)
-		cmd := fmt.Sprintf("docker inspect --format='{{.State.Health.Status}}' %s", containerName)
-		stdout, stderr, err := RunSSHCommand(client, cmd)
+	waitCtx, cancel := context.WithTimeout(ctx, timeouts.HealthCheck)
+	defer cancel()
+
+	for attempt := 1; ; attempt++ {
+		cmd := Cmd("docker", "inspect", "--format={{.State.Health.Status}}", containerName)
+		stdout, stderr, err := executor.Run(waitCtx, cmd)
 		if err == nil && strings.TrimSpace(stdout) == "healthy" {
 			return nil
 		}
-		utils.LogInfo("Waiting for container %s to be healthy... attempt %d/24, status: %s, stderr: %s, err: %v", containerName, i+1, strings.TrimSpace(stdout), stderr, err)
-		time.Sleep(5 * time.Second)
+		utils.LogInfo("Waiting for container %s to be healthy... attempt %d, status: %s, stderr: %s, err: %v", containerName, attempt, strings.TrimSpace(stdout), stderr, err)
+		logs.Infof("Waiting for container %s to be healthy (attempt %d, status: %s).", containerName, attempt, statusOrUnknown(stdout))
+		if err := sleepContext(waitCtx, healthCheckInterval); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return fmt.Errorf("container %s did not become healthy within %s", containerName, timeouts.HealthCheck)
+		}
 	}
-	return fmt.Errorf("container %s did not become healthy in time", containerName)
 }
 
 // GetSiteStatus checks the HTTP status of a WordPress site.
-func GetSiteStatus(site models.Site) string {
-	resp, err := http.Get(site.SiteURL)
+func GetSiteStatus(ctx context.Context, site models.Site) string {
+	ctx, cancel := context.WithTimeout(ctx, timeouts.StatusCheck)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, site.SiteURL, nil)
+	if err != nil {
+		return "down"
+	}
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		return "down"
 	}
@@ -333,36 +152,31 @@ func GetSiteStatus(site models.Site) string {
 	return "error"
 }
 
-func CreateBackup(projectName string) error {
-	cfg := config.LoadConfig()
+// CreateBackup backs up the database and wp-content of a site into a single
+// archive in its backup directory.
+func CreateBackup(ctx context.Context, projectName string) error {
+	_, err := createBackup(ctx, projectName)
+	return err
+}
 
-	sshClient, err := GetSSHClient(cfg)
-	if err != nil {
-		return fmt.Errorf("failed to connect to VPS: %w", err)
-	}
-	defer sshClient.Close()
+// createBackup is CreateBackup, returning the name of the archive.
+func createBackup(ctx context.Context, projectName string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeouts.Backup)
+	defer cancel()
 
-	// Find the site details to get DB credentials
-	sites, err := ReadSites()
+	// Find the site details to get DB credentials and its host
+	site, err := GetSite(projectName)
 	if err != nil {
-		return fmt.Errorf("failed to read sites: %w", err)
-	}
-
-	var site models.Site
-	found := false
-	for _, s := range sites {
-		if s.ProjectName == projectName {
-			site = s
-			found = true
-			break
-		}
+		return "", err
 	}
 
-	if !found {
-		return fmt.Errorf("site '%s' not found", projectName)
+	executor, host, err := ExecutorForSite(ctx, site)
+	if err != nil {
+		return "", fmt.Errorf("failed to connect to VPS: %w", err)
 	}
+	defer executor.Close()
 
-	LogActivity("info", fmt.Sprintf("Backup initiated for site '%s'.", projectName), projectName)
+	LogActivityContext(ctx, "info", "backup.create", fmt.Sprintf("Backup initiated for site '%s'.", projectName), projectName)
 
 	remotePath := fmt.Sprintf("/var/www/%s", projectName)
 	backupDir := fmt.Sprintf("/var/www/backups/%s", projectName)
@@ -376,70 +190,85 @@ func CreateBackup(projectName string) error {
 	filesBackupPath := filepath.Join(backupDir, filesBackupFile)
 
 	// 1. Ensure backup directory exists
+	JobStep(ctx, "Prepare backup directory")
 	utils.LogInfo("Ensuring backup directory exists: %s", backupDir)
-	_, _, err = RunSSHCommand(sshClient, fmt.Sprintf("sudo install -d -o %s -g %s %s", cfg.SSHUser, cfg.SSHUser, backupDir))
+	_, _, err = executor.Run(ctx, Cmd("sudo", "install", "-d", "-o", host.Credentials.User, "-g", host.Credentials.User, backupDir))
 	if err != nil {
-		return fmt.Errorf("failed to create backup directory: %w", err)
+		return "", fmt.Errorf("failed to create backup directory: %w", err)
 	}
 
 	// 2. Dump the database directly into the backup directory
+	JobStep(ctx, "Dump database")
 	utils.LogInfo("Dumping database for site '%s'வுகளை...", projectName)
-	dbDumpCmd := fmt.Sprintf("cd %s && docker compose -f docker-compose.yml exec -T -e MYSQL_PWD='%s' %s_db mariadb-dump -u root %s > %s", remotePath, site.DBPassword, projectName, site.DBName, dbBackupPath)
-	_, _, err = RunSSHCommand(sshClient, dbDumpCmd)
+	dbDumpCmd := RedirectTo(ComposeCmd(remotePath, "exec", "-T", "-e", "MYSQL_PWD="+site.DBPassword, projectName+"_db", "mariadb-dump", "-u", "root", site.DBName), dbBackupPath)
+	_, _, err = executor.Run(ctx, dbDumpCmd)
 	if err != nil {
-		LogActivity("error", fmt.Sprintf("Failed to dump database for site '%s'.", projectName), projectName)
-		return fmt.Errorf("failed to dump database: %w", err)
+		LogActivityContext(ctx, "error", "backup.create", fmt.Sprintf("Failed to dump database for site '%s'.", projectName), projectName)
+		return "", fmt.Errorf("failed to dump database: %w", err)
 	}
 
 	// 3. Archive the wp-content directory directly into the backup directory
+	JobStep(ctx, "Archive wp-content")
 	utils.LogInfo("Archiving wp-content for site '%s'வுகளை...", projectName)
-	filesArchiveCmd := fmt.Sprintf("cd %s && docker compose -f docker-compose.yml exec -T %s_wordpress tar -czf - -C /var/www/html wp-content > %s", remotePath, projectName, filesBackupPath)
-	_, _, err = RunSSHCommand(sshClient, filesArchiveCmd)
+	filesArchiveCmd := RedirectTo(ComposeCmd(remotePath, "exec", "-T", projectName+"_wordpress", "tar", "-czf", "-", "-C", "/var/www/html", "wp-content"), filesBackupPath)
+	_, _, err = executor.Run(ctx, filesArchiveCmd)
 	if err != nil {
-		LogActivity("error", fmt.Sprintf("Failed to archive files for site '%s'.", projectName), projectName)
-		return fmt.Errorf("failed to archive files: %w", err)
+		LogActivityContext(ctx, "error", "backup.create", fmt.Sprintf("Failed to archive files for site '%s'.", projectName), projectName)
+		return "", fmt.Errorf("failed to archive files: %w", err)
 	}
 
 	// 4. Bundle database and files into a single archive in the backup directory
+	JobStep(ctx, "Bundle backup")
 	utils.LogInfo("Bundling backup for site '%s'வுகளை...", projectName)
-	bundleCmd := fmt.Sprintf("cd %s && tar -czf %s %s %s", backupDir, finalBackupFile, dbBackupFile, filesBackupFile)
-	_, _, err = RunSSHCommand(sshClient, bundleCmd)
+	bundleCmd := And(Cmd("cd", backupDir), Cmd("tar", "-czf", finalBackupFile, dbBackupFile, filesBackupFile))
+	_, _, err = executor.Run(ctx, bundleCmd)
 	if err != nil {
-		LogActivity("error", fmt.Sprintf("Failed to bundle backup for site '%s'.", projectName), projectName)
-		return fmt.Errorf("failed to bundle backup: %w", err)
+		LogActivityContext(ctx, "error", "backup.create", fmt.Sprintf("Failed to bundle backup for site '%s'.", projectName), projectName)
+		return "", fmt.Errorf("failed to bundle backup: %w", err)
 	}
 
 	// 5. Clean up temporary files from the backup directory
+	JobStep(ctx, "Clean up")
 	utils.LogInfo("Cleaning up temporary files for site '%s'வுகளை...", projectName)
-	cleanupCmd := fmt.Sprintf("rm %s %s", dbBackupPath, filesBackupPath)
-	_, _, err = RunSSHCommand(sshClient, cleanupCmd)
+	cleanupCmd := Cmd("rm", dbBackupPath, filesBackupPath)
+	_, _, err = executor.Run(ctx, cleanupCmd)
 	if err != nil {
 		// This is not a fatal error, so just log it
 		utils.LogError("Failed to clean up temporary backup files: %v", err)
 	}
 
-	LogActivity("info", fmt.Sprintf("Backup created successfully for site '%s'.", projectName), projectName)
+	LogActivityContext(ctx, "info", "backup.create", fmt.Sprintf("Backup created successfully for site '%s'.", projectName), projectName)
 	utils.LogInfo("Backup for site '%s' completed successfully.", projectName)
 
-	return nil
+	return finalBackupFile, nil
 }
 
 // ListBackups lists the backups for a given site.
-func ListBackups(projectName string) ([]string, error) {
-	cfg := config.LoadConfig()
+func ListBackups(ctx context.Context, projectName string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeouts.Command)
+	defer cancel()
 
-	sshClient, err := GetSSHClient(cfg)
+	site, err := GetSite(projectName)
+	if err != nil {
+		return nil, err
+	}
+
+	executor, _, err := ExecutorForSite(ctx, site)
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to VPS: %w", err)
 	}
-	defer sshClient.Close()
+	defer executor.Close()
 
 	backupDir := fmt.Sprintf("/var/www/backups/%s", projectName)
 
 	// List files in the backup directory
 	// The `find ... -printf` command is used to get just the filenames, sorted by time, newest first.
-	listCmd := fmt.Sprintf("find %s -maxdepth 1 -type f -printf '%%T@ %%f\n' | sort -nr | cut -d' ' -f2-", backupDir)
-	stdout, stderr, err := RunSSHCommand(sshClient, listCmd)
+	listCmd := Pipe(
+		Cmd("find", backupDir, "-maxdepth", "1", "-type", "f", "-printf", `%T@ %f\n`),
+		Cmd("sort", "-nr"),
+		Cmd("cut", "-d", " ", "-f2-"),
+	)
+	stdout, stderr, err := executor.Run(ctx, listCmd)
 	if err != nil {
 		// If the directory doesn't exist, ls will error. We can treat this as an empty list.
 		if strings.Contains(stderr, "No such file or directory") {
@@ -456,82 +285,79 @@ func ListBackups(projectName string) ([]string, error) {
 	return backups, nil
 }
 
+// RestoreBackup restores a WordPress site from a backup.
+func RestoreBackup(ctx context.Context, projectName, backupFile string) error {
+	if err := ValidateBackupFile(backupFile); err != nil {
+		return err
+	}
 
+	backupPath := filepath.Join(fmt.Sprintf("/var/www/backups/%s", projectName), backupFile)
+	return restoreBackup(ctx, projectName, backupPath)
+}
 
-// RestoreBackup restores a WordPress site from a backup.
-func RestoreBackup(projectName, backupFile string) error {
-	cfg := config.LoadConfig()
+// restoreBackup restores the database and wp-content of a site from the
+// archive at backupPath, which may belong to another site on the same host.
+func restoreBackup(ctx context.Context, projectName, backupPath string) error {
+	backupFile := filepath.Base(backupPath)
+	ctx, cancel := context.WithTimeout(ctx, timeouts.Restore)
+	defer cancel()
 
-	sshClient, err := GetSSHClient(cfg)
+	// Find the site details to get DB credentials and its host
+	site, err := GetSite(projectName)
 	if err != nil {
-		return fmt.Errorf("failed to connect to VPS: %w", err)
+		return err
 	}
-	defer sshClient.Close()
 
-	// Find the site details to get DB credentials
-	sites, err := ReadSites()
+	executor, _, err := ExecutorForSite(ctx, site)
 	if err != nil {
-		return fmt.Errorf("failed to read sites: %w", err)
-	}
-
-	var site models.Site
-	found := false
-	for _, s := range sites {
-		if s.ProjectName == projectName {
-			site = s
-			found = true
-			break
-		}
-	}
-
-	if !found {
-		return fmt.Errorf("site '%s' not found", projectName)
+		return fmt.Errorf("failed to connect to VPS: %w", err)
 	}
+	defer executor.Close()
 
-	LogActivity("info", fmt.Sprintf("Restore initiated for site '%s' from backup '%s'.", projectName, backupFile), projectName)
+	LogActivityContext(ctx, "info", "backup.restore", fmt.Sprintf("Restore initiated for site '%s' from backup '%s'.", projectName, backupFile), projectName)
 
 	remotePath := fmt.Sprintf("/var/www/%s", projectName)
-	backupDir := fmt.Sprintf("/var/www/backups/%s", projectName)
-	backupPath := filepath.Join(backupDir, backupFile)
 	restoreTempDir := filepath.Join(remotePath, "restore_temp")
 
 	// 1. Create a temporary directory for restore
 	utils.LogInfo("Creating temporary directory for restore: %s", restoreTempDir)
-	_, _, err = RunSSHCommand(sshClient, fmt.Sprintf("mkdir -p %s", restoreTempDir))
+	_, _, err = executor.Run(ctx, Cmd("mkdir", "-p", restoreTempDir))
 	if err != nil {
 		return fmt.Errorf("failed to create temporary restore directory: %w", err)
 	}
 	// Defer cleanup of the temporary directory
 	defer func() {
 		utils.LogInfo("Cleaning up temporary restore directory: %s", restoreTempDir)
-		RunSSHCommand(sshClient, fmt.Sprintf("rm -rf %s", restoreTempDir))
+		executor.Run(ctx, Cmd("rm", "-rf", restoreTempDir))
 	}()
 
 	// 2. Copy backup to temp directory and extract it
+	JobStep(ctx, "Extract backup")
 	utils.LogInfo("Extracting backup file: %s", backupPath)
-	extractCmd := fmt.Sprintf("tar -xzf %s -C %s", backupPath, restoreTempDir)
-	_, _, err = RunSSHCommand(sshClient, extractCmd)
+	extractCmd := Cmd("tar", "-xzf", backupPath, "-C", restoreTempDir)
+	_, _, err = executor.Run(ctx, extractCmd)
 	if err != nil {
 		return fmt.Errorf("failed to extract backup file: %w", err)
 	}
 
 	// Find the extracted files
-	dbBackupFile, _, err := RunSSHCommand(sshClient, fmt.Sprintf("find %s -name '*_db_backup_*.sql' -print -quit", restoreTempDir))
+	dbBackupFile, _, err := executor.Run(ctx, Cmd("find", restoreTempDir, "-name", "*_db_backup_*.sql", "-print", "-quit"))
 	if err != nil || dbBackupFile == "" {
 		return fmt.Errorf("could not find database backup file in extracted archive: %w", err)
 	}
 	dbBackupFile = strings.TrimSpace(dbBackupFile)
 
-	filesBackupFile, _, err := RunSSHCommand(sshClient, fmt.Sprintf("find %s -name '*_files_backup_*.tar.gz' -print -quit", restoreTempDir))
+	filesBackupFile, _, err := executor.Run(ctx, Cmd("find", restoreTempDir, "-name", "*_files_backup_*.tar.gz", "-print", "-quit"))
 	if err != nil || filesBackupFile == "" {
 		return fmt.Errorf("could not find files backup file in extracted archive: %w", err)
 	}
 	filesBackupFile = strings.TrimSpace(filesBackupFile)
 
 	// 3. Stop the site
+	JobStep(ctx, "Stop site")
 	utils.LogInfo("Stopping site '%s' for restore...", projectName)
-	stopCmd := fmt.Sprintf("cd %s && docker compose -f docker-compose.yml stop", remotePath)
-	stdout, stderr, err := RunSSHCommand(sshClient, stopCmd)
+	stopCmd := ComposeCmd(remotePath, "stop")
+	stdout, stderr, err := executor.Run(ctx, stopCmd)
 	if err != nil {
 		utils.LogError("Failed to stop site '%s': %v. Stdout: %s, Stderr: %s", projectName, err, stdout, stderr)
 		return fmt.Errorf("failed to stop site: %w", err)
@@ -539,9 +365,10 @@ func RestoreBackup(projectName, backupFile string) error {
 	utils.LogInfo("Site '%s' stopped successfully.", projectName)
 
 	// 4. Start db service
+	JobStep(ctx, "Restore database")
 	utils.LogInfo("Starting db service for site '%s'வுகளை...", projectName)
-	startDbCmd := fmt.Sprintf("cd %s && docker compose -f docker-compose.yml start %s_db", remotePath, projectName)
-	stdout, stderr, err = RunSSHCommand(sshClient, startDbCmd)
+	startDbCmd := ComposeCmd(remotePath, "start", projectName+"_db")
+	stdout, stderr, err = executor.Run(ctx, startDbCmd)
 	if err != nil {
 		utils.LogError("Failed to start db service for site '%s': %v. Stdout: %s, Stderr: %s", projectName, err, stdout, stderr)
 		return fmt.Errorf("failed to start db service: %w", err)
@@ -550,15 +377,15 @@ func RestoreBackup(projectName, backupFile string) error {
 
 	// Wait for the container to be healthy
 	utils.LogInfo("Waiting for db container to be healthy for site '%s'வுகளை...", projectName)
-	if err := waitForContainerHealthy(sshClient, projectName, "_db", remotePath); err != nil {
+	if err := waitForContainerHealthy(ctx, executor, nil, projectName, "_db", remotePath); err != nil {
 		return fmt.Errorf("db container did not become ready: %w", err)
 	}
 	utils.LogInfo("db container for site '%s' is ready.", projectName)
 
 	// 5. Restore the database
 	utils.LogInfo("Restoring database for site '%s'வுகளை...", projectName)
-	dbRestoreCmd := fmt.Sprintf("cd %s && cat %s | docker compose -f docker-compose.yml exec -T -e MYSQL_PWD='%s' %s_db mariadb -u root %s", remotePath, dbBackupFile, site.DBPassword, projectName, site.DBName)
-	stdout, stderr, err = RunSSHCommand(sshClient, dbRestoreCmd)
+	dbRestoreCmd := And(Cmd("cd", remotePath), Pipe(Cmd("cat", dbBackupFile), Compose(remotePath, "exec", "-T", "-e", "MYSQL_PWD="+site.DBPassword, projectName+"_db", "mariadb", "-u", "root", site.DBName)))
+	stdout, stderr, err = executor.Run(ctx, dbRestoreCmd)
 	if err != nil {
 		utils.LogError("Failed to restore database for site '%s': %v. Stdout: %s, Stderr: %s", projectName, err, stdout, stderr)
 		return fmt.Errorf("failed to restore database: %w, stderr: %s", err, stderr)
@@ -566,9 +393,10 @@ func RestoreBackup(projectName, backupFile string) error {
 	utils.LogInfo("Database for site '%s' restored successfully.", projectName)
 
 	// 6. Start wordpress service
+	JobStep(ctx, "Restore wp-content")
 	utils.LogInfo("Starting wordpress service for site '%s'வுகளை...", projectName)
-	startWpCmd := fmt.Sprintf("cd %s && docker compose -f docker-compose.yml start %s_wordpress", remotePath, projectName)
-	stdout, stderr, err = RunSSHCommand(sshClient, startWpCmd)
+	startWpCmd := ComposeCmd(remotePath, "start", projectName+"_wordpress")
+	stdout, stderr, err = executor.Run(ctx, startWpCmd)
 	if err != nil {
 		utils.LogError("Failed to start wordpress service for site '%s': %v. Stdout: %s, Stderr: %s", projectName, err, stdout, stderr)
 		return fmt.Errorf("failed to start wordpress service: %w", err)
@@ -579,58 +407,107 @@ func RestoreBackup(projectName, backupFile string) error {
 	utils.LogInfo("Restoring wp-content for site '%s'வுகளை...", projectName)
 	// Create a temporary directory inside the container
 	tmpRestoreDir := "/tmp/restore_wp_content"
-	mkdirCmd := fmt.Sprintf("cd %s && docker compose -f docker-compose.yml exec -T %s_wordpress mkdir -p %s", remotePath, projectName, tmpRestoreDir)
-	_, _, err = RunSSHCommand(sshClient, mkdirCmd)
+	mkdirCmd := ComposeCmd(remotePath, "exec", "-T", projectName+"_wordpress", "mkdir", "-p", tmpRestoreDir)
+	_, _, err = executor.Run(ctx, mkdirCmd)
 	if err != nil {
 		return fmt.Errorf("failed to create temporary directory inside container: %w", err)
 	}
 
 	// Extract files to the temporary directory
-	extractCmd = fmt.Sprintf("cd %s && cat %s | docker compose -f docker-compose.yml exec -T %s_wordpress tar -xzf - -C %s", remotePath, filesBackupFile, projectName, tmpRestoreDir)
-	_, stderr, err = RunSSHCommand(sshClient, extractCmd)
+	extractCmd = And(Cmd("cd", remotePath), Pipe(Cmd("cat", filesBackupFile), Compose(remotePath, "exec", "-T", projectName+"_wordpress", "tar", "-xzf", "-", "-C", tmpRestoreDir)))
+	_, stderr, err = executor.Run(ctx, extractCmd)
 	if err != nil {
 		return fmt.Errorf("failed to extract files to temporary directory: %w, stderr: %s", err, stderr)
 	}
 
 	// Remove old wp-content
-	rmCmd := fmt.Sprintf("cd %s && docker compose -f docker-compose.yml exec -T %s_wordpress rm -rf /var/www/html/wp-content", remotePath, projectName)
-	_, stderr, err = RunSSHCommand(sshClient, rmCmd)
+	rmCmd := ComposeCmd(remotePath, "exec", "-T", projectName+"_wordpress", "rm", "-rf", "/var/www/html/wp-content")
+	_, stderr, err = executor.Run(ctx, rmCmd)
 	if err != nil {
 		return fmt.Errorf("failed to remove old wp-content: %w, stderr: %s", err, stderr)
 	}
 
 	// Move the restored wp-content to the correct location
-	moveCmd := fmt.Sprintf("cd %s && docker compose -f docker-compose.yml exec -T %s_wordpress mv %s/var/www/html/wp-content /var/www/html/wp-content", remotePath, projectName, tmpRestoreDir)
-	_, stderr, err = RunSSHCommand(sshClient, moveCmd)
+	moveCmd := ComposeCmd(remotePath, "exec", "-T", projectName+"_wordpress", "mv", tmpRestoreDir+"/var/www/html/wp-content", "/var/www/html/wp-content")
+	_, stderr, err = executor.Run(ctx, moveCmd)
 	if err != nil {
 		// This is for old backups. New backups will not have the full path.
 		// If the move fails, it means it's a new backup, so we try to move the content of the directory.
-		moveCmd = fmt.Sprintf("cd %s && docker compose -f docker-compose.yml exec -T %s_wordpress mv %s/wp-content /var/www/html/wp-content", remotePath, projectName, tmpRestoreDir)
-		_, stderr, err = RunSSHCommand(sshClient, moveCmd)
+		moveCmd = ComposeCmd(remotePath, "exec", "-T", projectName+"_wordpress", "mv", tmpRestoreDir+"/wp-content", "/var/www/html/wp-content")
+		_, stderr, err = executor.Run(ctx, moveCmd)
 		if err != nil {
 			return fmt.Errorf("failed to move restored wp-content: %w, stderr: %s", err, stderr)
 		}
 	}
 
 	// Cleanup the temporary directory
-	rmTmpDirCmd := fmt.Sprintf("cd %s && docker compose -f docker-compose.yml exec -T %s_wordpress rm -rf %s", remotePath, projectName, tmpRestoreDir)
-	_, _, err = RunSSHCommand(sshClient, rmTmpDirCmd)
+	rmTmpDirCmd := ComposeCmd(remotePath, "exec", "-T", projectName+"_wordpress", "rm", "-rf", tmpRestoreDir)
+	_, _, err = executor.Run(ctx, rmTmpDirCmd)
 	if err != nil {
 		utils.LogError("Failed to cleanup temporary restore directory inside container: %v", err)
 	}
 
 	// 8. Start all services
+	JobStep(ctx, "Start site")
 	utils.LogInfo("Starting all services for site '%s' after restore...", projectName)
-	startAllCmd := fmt.Sprintf("cd %s && docker compose -f docker-compose.yml start", remotePath)
-	stdout, stderr, err = RunSSHCommand(sshClient, startAllCmd)
+	startAllCmd := ComposeCmd(remotePath, "start")
+	stdout, stderr, err = executor.Run(ctx, startAllCmd)
 	if err != nil {
 		utils.LogError("Failed to start all services for site '%s': %v. Stdout: %s, Stderr: %s", projectName, err, stdout, stderr)
 		return fmt.Errorf("failed to start all services after restore: %w", err)
 	}
 	utils.LogInfo("All services for site '%s' started successfully.", projectName)
 
-	LogActivity("info", fmt.Sprintf("Site '%s' restored successfully from backup '%s'.", projectName, backupFile), projectName)
+	LogActivityContext(ctx, "info", "backup.restore", fmt.Sprintf("Site '%s' restored successfully from backup '%s'.", projectName, backupFile), projectName)
 	utils.LogInfo("Site '%s' restored successfully.", projectName)
 
 	return nil
-}
\ No newline at end of file
+}
+
+// DeleteSite removes a site from its host: first its containers with their
+// volumes, then its directory. It stops at the first part that cannot be
+// removed, so that the compose file is kept for a retry, and returns the
+// parts that were removed. The caller drops the record.
+func DeleteSite(ctx context.Context, site models.Site) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeouts.Delete)
+	defer cancel()
+
+	executor, host, err := ExecutorForSite(ctx, site)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrConnect, err)
+	}
+	defer executor.Close()
+
+	utils.LogInfo("Removing site '%s' from host '%s'", site.ProjectName, host.Name)
+	remotePath := fmt.Sprintf("/var/www/%s", site.ProjectName)
+	parts := []struct{ name, command string }{
+		{"containers", ComposeCmd(remotePath, "down", "-v", "--remove-orphans")},
+		{"directory", Cmd("sudo", "rm", "-rf", remotePath)},
+	}
+	removed := []string{}
+	for _, part := range parts {
+		if _, stderr, err := executor.Run(ctx, part.command); err != nil {
+			return removed, fmt.Errorf("%w: failed to remove the %s of site '%s': %w, stderr: %s", ErrRemoteCommand, part.name, site.ProjectName, err, stderr)
+		}
+		removed = append(removed, part.name)
+	}
+	return removed, nil
+}
+
+// RestartSite restarts a site's containers.
+func RestartSite(ctx context.Context, site models.Site) error {
+	ctx, cancel := context.WithTimeout(ctx, timeouts.Command)
+	defer cancel()
+
+	executor, _, err := ExecutorForSite(ctx, site)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrConnect, err)
+	}
+	defer executor.Close()
+
+	remotePath := fmt.Sprintf("/var/www/%s", site.ProjectName)
+	if _, _, err := executor.Run(ctx, And(Cmd("cd", remotePath), Cmd("docker-compose", "restart"))); err != nil {
+		return fmt.Errorf("%w: %w", ErrRemoteCommand, err)
+	}
+	return nil
+}
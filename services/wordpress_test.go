@@ -0,0 +1,495 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"wordpress-collab-tool/config"
+	"wordpress-collab-tool/internal/fakes"
+	"wordpress-collab-tool/models"
+)
+
+// TestMain runs the tests inside a scratch directory holding a copy of the
+// compose template, so that sites.json and activities.json never touch the repo.
+func TestMain(m *testing.M) {
+	// Resolve the template from this file's location rather than the working
+	// directory: fuzz workers start inside the scratch directory below.
+	_, file, _, _ := runtime.Caller(0)
+	template, err := os.ReadFile(filepath.Join(filepath.Dir(file), "..", "templates", "template.yml"))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "failed to read template:", err)
+		os.Exit(1)
+	}
+
+	dir, err := os.MkdirTemp("", "services-test")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "failed to create temp dir:", err)
+		os.Exit(1)
+	}
+	os.MkdirAll(filepath.Join(dir, "templates"), 0755)
+	os.WriteFile(filepath.Join(dir, "templates", "template.yml"), template, 0644)
+	os.Chdir(dir)
+
+	os.Setenv("SSH_HOST", "vps.test")
+	os.Setenv("SSH_USER", "deploy")
+	os.Setenv("SSH_PASSWORD", "secret")
+	InitHosts(config.LoadConfig())
+
+	containerStartDelay = 0
+	pluginInstallDelay = 0
+	healthCheckInterval = time.Millisecond
+	timeouts.HealthCheck = 100 * time.Millisecond
+
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// useFakeExecutor routes every executor request to fake for the duration of the test.
+func useFakeExecutor(t *testing.T, fake *fakes.Executor) {
+	t.Helper()
+	original := NewExecutor
+	NewExecutor = func(ctx context.Context, host models.Host) (Executor, error) {
+		return fake, nil
+	}
+	t.Cleanup(func() { NewExecutor = original })
+}
+
+// withActivities gives the test an empty activity log.
+func withActivities(t *testing.T) {
+	t.Helper()
+	store, err := OpenSQLiteActivityStore(filepath.Join(t.TempDir(), "activities.db"), time.Minute)
+	if err != nil {
+		t.Fatalf("OpenSQLiteActivityStore: %v", err)
+	}
+	original := Activities
+	Activities = store
+	t.Cleanup(func() {
+		Activities = original
+		store.Close()
+	})
+}
+
+// withSites replaces the site store with one holding sites for the duration of the test.
+func withSites(t *testing.T, sites ...models.Site) {
+	t.Helper()
+	original := Sites
+	Sites = NewJSONSiteStore(filepath.Join(t.TempDir(), "sites.json"))
+	for _, site := range sites {
+		if _, err := Sites.Create(site); err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+	}
+	withActivities(t)
+	t.Cleanup(func() { Sites = original })
+}
+
+func testSite() models.Site {
+	return models.Site{
+		ProjectName:   "blog",
+		WPPort:        8200,
+		DBName:        "blog_db",
+		DBPassword:    "dbsecret",
+		SiteURL:       "http://vps.test:8200",
+		Status:        "creating",
+		AdminUsername: "admin",
+		AdminPassword: "adminpass",
+	}
+}
+
+func healthyFake() *fakes.Executor {
+	return fakes.NewExecutor().On("docker inspect", fakes.Result{Stdout: "healthy\n"})
+}
+
+func TestDeployWordPressSite(t *testing.T) {
+	site := testSite()
+	withSites(t, site)
+	fake := healthyFake()
+	useFakeExecutor(t, fake)
+
+	if err := DeployWordPressSite(t.Context(), site, []string{"akismet"}, "admin", "adminpass"); err != nil {
+		t.Fatalf("DeployWordPressSite: %v", err)
+	}
+
+	compose, ok := fake.File("/var/www/blog/docker-compose.yml")
+	if !ok {
+		t.Fatal("docker-compose.yml was not uploaded")
+	}
+	if !strings.Contains(string(compose), "blog_wordpress") || !strings.Contains(string(compose), "8200:80") {
+		t.Errorf("compose file not rendered for the site:\n%s", compose)
+	}
+
+	for _, want := range []string{
+		"sudo install -d -o deploy -g deploy /var/www/blog",
+		"docker compose -f /var/www/blog/docker-compose.yml up -d",
+		"wp core install --url=http://vps.test:8200",
+		"wp plugin install akismet --activate",
+	} {
+		if !fake.Ran(want) {
+			t.Errorf("expected a command containing %q, ran:\n%s", want, strings.Join(fake.Commands(), "\n"))
+		}
+	}
+	if !fake.Closed() {
+		t.Error("executor was not closed")
+	}
+}
+
+func TestDeployWordPressSiteWaitsForHealthyContainers(t *testing.T) {
+	site := testSite()
+	withSites(t, site)
+	fake := fakes.NewExecutor().On("docker inspect",
+		fakes.Result{Stdout: "starting\n"},
+		fakes.Result{Stdout: "starting\n"},
+		fakes.Result{Stdout: "healthy\n"},
+	)
+	useFakeExecutor(t, fake)
+
+	if err := DeployWordPressSite(t.Context(), site, nil, "admin", "adminpass"); err != nil {
+		t.Fatalf("DeployWordPressSite: %v", err)
+	}
+	if got := len(fake.CommandsMatching("docker inspect")); got != 4 {
+		t.Errorf("expected 4 health checks (3 for wordpress, 1 for cli), got %d", got)
+	}
+}
+
+func TestDeployWordPressSiteFailsWhenComposeFails(t *testing.T) {
+	site := testSite()
+	withSites(t, site)
+	fake := healthyFake().On("up -d", fakes.Result{Stderr: "port is already allocated", ExitCode: 1})
+	useFakeExecutor(t, fake)
+
+	err := DeployWordPressSite(t.Context(), site, nil, "admin", "adminpass")
+	if err == nil || !strings.Contains(err.Error(), "port is already allocated") {
+		t.Fatalf("expected compose failure, got %v", err)
+	}
+	if fake.Ran("wp core install") {
+		t.Error("WordPress install ran after compose failed")
+	}
+}
+
+func TestDeployWordPressSiteFailsWhenContainerNeverHealthy(t *testing.T) {
+	site := testSite()
+	withSites(t, site)
+	fake := fakes.NewExecutor().On("docker inspect", fakes.Result{Stdout: "unhealthy\n"})
+	useFakeExecutor(t, fake)
+
+	err := DeployWordPressSite(t.Context(), site, nil, "admin", "adminpass")
+	if err == nil || !strings.Contains(err.Error(), "did not become ready") {
+		t.Fatalf("expected health check failure, got %v", err)
+	}
+}
+
+func TestDeployWordPressSiteReportsPluginFailures(t *testing.T) {
+	site := testSite()
+	withSites(t, site)
+	fake := healthyFake().On("wp plugin install broken", fakes.Result{Stderr: "plugin not found", ExitCode: 1})
+	useFakeExecutor(t, fake)
+
+	err := DeployWordPressSite(t.Context(), site, []string{"akismet", "broken"}, "admin", "adminpass")
+	if !errors.Is(err, ErrPluginInstall) || !strings.Contains(err.Error(), "broken") {
+		t.Fatalf("expected plugin failure, got %v", err)
+	}
+	if !fake.Ran("wp plugin install akismet") {
+		t.Error("remaining plugins were not installed")
+	}
+	// A plugin that cannot be installed does not undo a working site
+	if fake.Ran("down -v") || fake.Ran("sudo rm -rf /var/www/blog") {
+		t.Errorf("site was rolled back over a plugin failure:\n%s", strings.Join(fake.Commands(), "\n"))
+	}
+	if stored, err := GetSite("blog"); err != nil || stored.Status != "active" {
+		t.Errorf("expected the site to stay active, got %+v %v", stored, err)
+	}
+}
+
+func TestCreateBackup(t *testing.T) {
+	withSites(t, testSite())
+	fake := fakes.NewExecutor()
+	useFakeExecutor(t, fake)
+
+	if err := CreateBackup(t.Context(), "blog"); err != nil {
+		t.Fatalf("CreateBackup: %v", err)
+	}
+
+	steps := []string{
+		"sudo install -d -o deploy -g deploy /var/www/backups/blog",
+		"mariadb-dump -u root blog_db > /var/www/backups/blog/blog_db_backup_",
+		"blog_wordpress tar -czf - -C /var/www/html wp-content",
+		"cd /var/www/backups/blog && tar -czf backup-",
+		"rm /var/www/backups/blog/blog_db_backup_",
+	}
+	commands := fake.Commands()
+	if len(commands) != len(steps) {
+		t.Fatalf("expected %d commands, got %d:\n%s", len(steps), len(commands), strings.Join(commands, "\n"))
+	}
+	for i, want := range steps {
+		if !strings.Contains(commands[i], want) {
+			t.Errorf("step %d: expected %q in %q", i+1, want, commands[i])
+		}
+	}
+
+	activities, _, _ := Activities.Query(ActivityQuery{})
+	if len(activities) == 0 || !strings.Contains(activities[0].Message, "Backup created successfully") {
+		t.Errorf("expected a success activity, got %+v", activities)
+	}
+}
+
+func TestCreateBackupStopsWhenDumpFails(t *testing.T) {
+	withSites(t, testSite())
+	fake := fakes.NewExecutor().On("mariadb-dump", fakes.Result{ExitCode: 2})
+	useFakeExecutor(t, fake)
+
+	if err := CreateBackup(t.Context(), "blog"); err == nil {
+		t.Fatal("expected an error")
+	}
+	if fake.Ran("tar -czf backup-") {
+		t.Error("backup was bundled after the database dump failed")
+	}
+}
+
+func TestCreateBackupUnknownSite(t *testing.T) {
+	withSites(t)
+	useFakeExecutor(t, fakes.NewExecutor())
+
+	if err := CreateBackup(t.Context(), "missing"); !errors.Is(err, ErrSiteNotFound) {
+		t.Fatalf("expected ErrSiteNotFound, got %v", err)
+	}
+}
+
+func TestListBackups(t *testing.T) {
+	withSites(t, testSite())
+	fake := fakes.NewExecutor().On("find /var/www/backups/blog", fakes.Result{
+		Stdout: "backup-2025-09-15-10-00-00.tar.gz\nbackup-2025-09-14-10-00-00.tar.gz\n",
+	})
+	useFakeExecutor(t, fake)
+
+	backups, err := ListBackups(t.Context(), "blog")
+	if err != nil {
+		t.Fatalf("ListBackups: %v", err)
+	}
+	want := []string{"backup-2025-09-15-10-00-00.tar.gz", "backup-2025-09-14-10-00-00.tar.gz"}
+	if strings.Join(backups, ",") != strings.Join(want, ",") {
+		t.Errorf("got %v, want %v", backups, want)
+	}
+}
+
+func TestListBackupsMissingDirectory(t *testing.T) {
+	withSites(t, testSite())
+	fake := fakes.NewExecutor().On("find /var/www/backups/blog", fakes.Result{
+		Stderr:   "find: '/var/www/backups/blog': No such file or directory",
+		ExitCode: 1,
+	})
+	useFakeExecutor(t, fake)
+
+	backups, err := ListBackups(t.Context(), "blog")
+	if err != nil {
+		t.Fatalf("ListBackups: %v", err)
+	}
+	if len(backups) != 0 {
+		t.Errorf("expected no backups, got %v", backups)
+	}
+}
+
+func restoreFake() *fakes.Executor {
+	return healthyFake().
+		On("-name '*_db_backup_*.sql'", fakes.Result{Stdout: "/var/www/blog/restore_temp/blog_db_backup_x.sql\n"}).
+		On("-name '*_files_backup_*.tar.gz'", fakes.Result{Stdout: "/var/www/blog/restore_temp/blog_files_backup_x.tar.gz\n"})
+}
+
+func TestRestoreBackup(t *testing.T) {
+	withSites(t, testSite())
+	fake := restoreFake()
+	useFakeExecutor(t, fake)
+
+	if err := RestoreBackup(t.Context(), "blog", "backup-2025-09-15-10-00-00.tar.gz"); err != nil {
+		t.Fatalf("RestoreBackup: %v", err)
+	}
+
+	for _, want := range []string{
+		"tar -xzf /var/www/backups/blog/backup-2025-09-15-10-00-00.tar.gz -C /var/www/blog/restore_temp",
+		"docker compose -f /var/www/blog/docker-compose.yml stop",
+		"start blog_db",
+		"cat /var/www/blog/restore_temp/blog_db_backup_x.sql | docker compose -f /var/www/blog/docker-compose.yml exec -T -e MYSQL_PWD=dbsecret blog_db mariadb -u root blog_db",
+		"rm -rf /var/www/html/wp-content",
+		"docker compose -f /var/www/blog/docker-compose.yml start",
+		"rm -rf /var/www/blog/restore_temp",
+	} {
+		if !fake.Ran(want) {
+			t.Errorf("expected a command containing %q, ran:\n%s", want, strings.Join(fake.Commands(), "\n"))
+		}
+	}
+}
+
+func TestRestoreBackupCleansUpWhenDatabaseRestoreFails(t *testing.T) {
+	withSites(t, testSite())
+	fake := restoreFake().On("mariadb -u root", fakes.Result{Stderr: "syntax error", ExitCode: 1})
+	useFakeExecutor(t, fake)
+
+	err := RestoreBackup(t.Context(), "blog", "backup-2025-09-15-10-00-00.tar.gz")
+	if err == nil || !strings.Contains(err.Error(), "syntax error") {
+		t.Fatalf("expected database restore failure, got %v", err)
+	}
+	if fake.Ran("rm -rf /var/www/html/wp-content") {
+		t.Error("wp-content was removed after the database restore failed")
+	}
+	if !fake.Ran("rm -rf /var/www/blog/restore_temp") {
+		t.Error("temporary restore directory was not cleaned up")
+	}
+}
+
+func TestDeleteSite(t *testing.T) {
+	site := testSite()
+	site.Status = "active"
+	withSites(t, site)
+	fake := fakes.NewExecutor()
+	useFakeExecutor(t, fake)
+
+	removed, err := DeleteSite(t.Context(), site)
+	if err != nil {
+		t.Fatalf("DeleteSite: %v", err)
+	}
+	if strings.Join(removed, ",") != "containers,directory" {
+		t.Errorf("removed %v, want containers and directory", removed)
+	}
+	down := commandIndex(fake, "docker compose -f /var/www/blog/docker-compose.yml down -v --remove-orphans")
+	remove := commandIndex(fake, "sudo rm -rf /var/www/blog")
+	if down < 0 || remove < down {
+		t.Errorf("expected the containers and volumes, then the directory to be removed:\n%s", strings.Join(fake.Commands(), "\n"))
+	}
+	stored, err := GetSite("blog")
+	if err != nil {
+		t.Fatalf("GetSite: %v", err)
+	}
+	if stored.Status != "active" {
+		t.Errorf("deleting marked the site %q", stored.Status)
+	}
+	entries, _, err := Activities.Query(ActivityQuery{Search: "creation failed"})
+	if err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("delete logged as a failed creation: %+v", entries)
+	}
+}
+
+func TestDeleteSiteReportsFailedRemoval(t *testing.T) {
+	site := testSite()
+	withSites(t, site)
+	fake := fakes.NewExecutor().On("down -v --remove-orphans", fakes.Result{Stderr: "permission denied", ExitCode: 1})
+	useFakeExecutor(t, fake)
+
+	removed, err := DeleteSite(t.Context(), site)
+	if !errors.Is(err, ErrRemoteCommand) {
+		t.Fatalf("expected ErrRemoteCommand, got %v", err)
+	}
+	if len(removed) != 0 {
+		t.Errorf("reported %v as removed", removed)
+	}
+	// The compose file is kept so that a retry can still stop the containers
+	if fake.Ran("sudo rm -rf /var/www/blog") {
+		t.Error("site directory was removed while its containers were left running")
+	}
+}
+
+func TestRunPluginCommand(t *testing.T) {
+	site := testSite()
+	withSites(t, site)
+	fake := fakes.NewExecutor().On("wp plugin deactivate hello", fakes.Result{ExitCode: 1})
+	useFakeExecutor(t, fake)
+
+	if err := RunPluginCommand(t.Context(), site, "activate", "akismet"); err != nil {
+		t.Fatalf("activate: %v", err)
+	}
+	if !fake.Ran("exec -T blog_cli wp plugin activate akismet") {
+		t.Errorf("unexpected commands: %v", fake.Commands())
+	}
+	if err := RunPluginCommand(t.Context(), site, "deactivate", "hello"); !errors.Is(err, ErrRemoteCommand) {
+		t.Errorf("expected ErrRemoteCommand, got %v", err)
+	}
+}
+
+func TestListPluginsRejectsInvalidOutput(t *testing.T) {
+	site := testSite()
+	withSites(t, site)
+	useFakeExecutor(t, fakes.NewExecutor().On("wp plugin list", fakes.Result{Stdout: "PHP Warning: something"}))
+
+	if _, err := ListPlugins(t.Context(), site); !errors.Is(err, ErrInvalidOutput) {
+		t.Fatalf("expected ErrInvalidOutput, got %v", err)
+	}
+}
+
+func TestDeployWordPressSiteQuotesAdminPassword(t *testing.T) {
+	site := testSite()
+	withSites(t, site)
+	fake := healthyFake()
+	useFakeExecutor(t, fake)
+
+	if err := DeployWordPressSite(t.Context(), site, nil, "admin", "x' --debug; id '"); err != nil {
+		t.Fatalf("DeployWordPressSite: %v", err)
+	}
+	if !fake.Ran(`'--admin_password=x'"'"' --debug; id '"'"''`) {
+		t.Errorf("admin password was not passed as a single argument:\n%s", strings.Join(fake.CommandsMatching("wp core install"), "\n"))
+	}
+}
+
+func TestRejectsInvalidInputBeforeConnecting(t *testing.T) {
+	site := testSite()
+	withSites(t, site)
+	fake := healthyFake()
+	useFakeExecutor(t, fake)
+
+	if err := RunPluginCommand(t.Context(), site, "install", "akismet;id"); !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("RunPluginCommand: expected ErrInvalidInput, got %v", err)
+	}
+	if err := RestoreBackup(t.Context(), "blog", "../../etc/passwd"); !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("RestoreBackup: expected ErrInvalidInput, got %v", err)
+	}
+	if err := DeployWordPressSite(t.Context(), site, []string{"$(id)"}, "admin", "adminpass"); !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("DeployWordPressSite: expected ErrInvalidInput, got %v", err)
+	}
+	if len(fake.Commands()) != 0 {
+		t.Errorf("no command should run for invalid input, ran %v", fake.Commands())
+	}
+}
+
+func TestDeployWordPressSiteStopsWhenCancelled(t *testing.T) {
+	site := testSite()
+	withSites(t, site)
+	fake := healthyFake().On("up -d", fakes.Result{Delay: time.Minute})
+	useFakeExecutor(t, fake)
+
+	ctx, cancel := context.WithCancel(t.Context())
+	time.AfterFunc(20*time.Millisecond, cancel)
+
+	start := time.Now()
+	err := DeployWordPressSite(ctx, site, nil, "admin", "adminpass")
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("deployment took %s to stop after cancellation", elapsed)
+	}
+	if fake.Ran("wp core install") {
+		t.Error("deployment continued after cancellation")
+	}
+}
+
+func TestRestartSiteTimesOut(t *testing.T) {
+	site := testSite()
+	withSites(t, site)
+	useFakeExecutor(t, fakes.NewExecutor().On("restart", fakes.Result{Delay: time.Minute}))
+
+	original := timeouts.Command
+	timeouts.Command = 20 * time.Millisecond
+	t.Cleanup(func() { timeouts.Command = original })
+
+	if err := RestartSite(t.Context(), site); !errors.Is(err, context.DeadlineExceeded) || !errors.Is(err, ErrRemoteCommand) {
+		t.Fatalf("expected a timed out remote command, got %v", err)
+	}
+}